@@ -3,10 +3,16 @@
 //
 // # Command-line flags
 // -a, --a string   endpoint HTTP-server addr (default "localhost:8080")
+// -c, --c string   codec of the batches: zstd, br, gzip, deflate or identity (default "zstd")
+// -H, --H string   algorithm of the signatures: hmac-sha256 or hmac-sha512 (default "hmac-sha256")
+// -i, --i string   ID of the key in the keyring of the server (default "")
 // -k, --k string   key for hash (default "")
+// -T, --T string   secret of the api token of the agent (default "")
 // -l, --l int      rate limit (default 10)
 // -p, --p int      PollInterval value (default 2)
 // -r, --r int      PollInterval value (default 10)
+// -t, --t int      size in bytes a batch is compressed above (default 1024)
+// -z, --z int      compression level, 0 for the default of the codec, -1 for the fastest (default 0)
 //
 // Author rAch-kaplin
 // Version 1.0.0
@@ -27,6 +33,7 @@ import (
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
 	agCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/agent"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/agent"
@@ -45,6 +52,12 @@ var (
 	reportInterval int
 	rateLimit      int
 	key            string
+	keyID          string
+	hashAlgorithm  string
+	apiToken       string
+	compress       string
+	compressLevel  int
+	compressThresh int
 	opts           *agCfg.Options
 )
 
@@ -64,7 +77,17 @@ func init() {
 	rootCmd.Flags().IntVarP(&pollInterval, "p", "p", agCfg.DefaultPollInterval, "PollInterval value")
 	rootCmd.Flags().IntVarP(&reportInterval, "r", "r", agCfg.DefaultReportInterval, "PollInterval value")
 	rootCmd.Flags().StringVarP(&key, "k", "k", agCfg.DefaultKey, "key for hash")
+	rootCmd.Flags().StringVarP(&keyID, "i", "i", agCfg.DefaultKeyID, "ID of the key in the keyring of the server")
+	rootCmd.Flags().StringVarP(&hashAlgorithm, "H", "H", agCfg.DefaultHashAlgorithm,
+		"algorithm of the signatures: hmac-sha256 or hmac-sha512")
+	rootCmd.Flags().StringVarP(&apiToken, "T", "T", agCfg.DefaultAPIToken, "secret of the api token of the agent")
 	rootCmd.Flags().IntVarP(&rateLimit, "l", "l", agCfg.DefaultRateLimit, "rate limit")
+	rootCmd.Flags().StringVarP(&compress, "c", "c", agCfg.DefaultCompress,
+		"codec of the batches: zstd, br, gzip, deflate or identity")
+	rootCmd.Flags().IntVarP(&compressLevel, "z", "z", agCfg.DefaultCompressLevel,
+		"compression level, 0 for the default of the codec, -1 for the fastest")
+	rootCmd.Flags().IntVarP(&compressThresh, "t", "t", agCfg.DefaultCompressThreshold,
+		"size in bytes a batch is compressed above")
 }
 
 func preRunE(cmd *cobra.Command, args []string) error {
@@ -75,7 +98,17 @@ func preRunE(cmd *cobra.Command, args []string) error {
 		PollInterval:   pollInterval,
 		ReportInterval: reportInterval,
 		Key:            key,
-		RateLimit:      rateLimit})
+		KeyID:          keyID,
+		HashAlgorithm:  hashAlgorithm,
+		APIToken:       apiToken,
+		RateLimit:      rateLimit,
+
+		Compress:          compress,
+		CompressLevel:     compressLevel,
+		CompressThreshold: compressThresh})
+	if err != nil {
+		return err
+	}
 
 	opts = agCfg.NewAgentOptions(
 		agCfg.WithAddress(opts.HTTPAddress),
@@ -84,9 +117,12 @@ func preRunE(cmd *cobra.Command, args []string) error {
 		agCfg.WithReportInterval(opts.ReportInterval),
 		agCfg.WithRateLimit(opts.RateLimit),
 		agCfg.WithKey(opts.Key),
+		agCfg.WithSigning(opts.KeyID, opts.HashAlgorithm),
+		agCfg.WithAPIToken(opts.APIToken),
+		agCfg.WithCompress(opts.Compress, opts.CompressLevel, opts.CompressThreshold),
 	)
 
-	return err
+	return nil
 }
 
 func runE(cmd *cobra.Command, args []string) error {
@@ -128,12 +164,24 @@ func startAgent(ctx context.Context) {
 	// Create a memory storage for the agent.
 	metricStorage := repo.NewMemStorage()
 	// Create a use case for the agent.
-	agentUsecase := agent.NewAgent(auc.NewAgentUsecase(metricStorage, metricStorage))
+	agentUsecase := agent.NewAgent(auc.NewAgentUsecase(metricStorage, metricStorage)).
+		WithCompression(agent.Compression{
+			Codec:     opts.Compress,
+			Level:     opts.CompressLevel,
+			Threshold: opts.CompressThreshold,
+		}).
+		WithSigning(agent.Signing{
+			KeyID:     opts.KeyID,
+			Algorithm: opts.HashAlgorithm,
+		})
 
 	// Create a http client for the agent.
 	client := resty.New().
 		SetTimeout(5 * time.Second).
 		SetBaseURL("http://" + opts.HTTPAddress)
+	if opts.APIToken != "" {
+		client.SetAuthToken(opts.APIToken)
+	}
 
 	// Create a worker pool for the agent.
 	wp := workerpool.New(opts.RateLimit)
@@ -158,7 +206,9 @@ func startAgent(ctx context.Context) {
 	}()
 
 	// Create a connection to the GRPC server.
-	conn, err := grpc.NewClient(opts.GRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(opts.GRPCAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(withAPIToken(opts.APIToken)))
 	if err != nil {
 		log.Error().Err(err).Msg("failed to connect to GRPC server")
 	}
@@ -183,3 +233,16 @@ func startAgent(ctx context.Context) {
 
 	log.Info().Msg("Agent stopped gracefully.")
 }
+
+// withAPIToken returns a gRPC client interceptor sending the api token in
+// the "authorization" metadata, as the HTTP client sends it in the header.
+func withAPIToken(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -7,7 +7,28 @@
 // -r, --r bool     restore metrics from file on start (default true)
 // -d, --d string   database dsn (default "")
 // -k, --k string   key for hash (default "")
+// -K, --K string   JSON file of the keys accepted besides -k, re-read on SIGHUP (default "")
 // -t, --t string   trusted subnet (default "")
+// -s, --s int      in-memory storage shards (0 = single lock) (default 0)
+// -w, --w int      write-behind cache flush interval for database in seconds (0 = disabled) (default 0)
+// -b, --b int      write-behind cache flush batch size (default 1000)
+// -n, --n string   tenants "tenant:secret,..." (default "")
+// -H, --H string   trusted header with the tenant id (default "")
+// -N, --N int      largest number of tenants (0 = unlimited) (default 100)
+// -A, --A string   bearer token of the admin endpoints (default "")
+// -l, --l float    requests per second of every client (0 = unlimited) (default 0)
+// -L, --L float    metrics per second every client can store (0 = unlimited) (default 0)
+// -R, --R string   client identity of the rate limits: "ip", "agent" or "tenant" (default "ip")
+// -z, --z int      largest request body in bytes, as sent (default 33554432)
+// -Z, --Z int      largest request body in bytes, decompressed (default 67108864)
+// -M, --M int      largest number of metrics in a batch update (default 10000)
+// -Q, --Q int      requests served at once, the others get 503 (0 = unlimited) (default 0)
+// -x, --x int      clock skew window of request signatures in seconds (default 300)
+// -X, --X bool     require a canonical signature of requests changing metrics (default false)
+// -y, --y bool     require an api token on metric requests (default false)
+//
+// # Subcommands
+// migrate-storage  copy all metrics between storage backends (see migrate.go)
 //
 // Author rAch-kaplin
 // Version 1.0.0
@@ -37,11 +58,23 @@ import (
 	srvCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/server"
 	rest "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/REST"
 	gRPC "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/gRPC"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/graphite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/statsd"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/router"
+	graphiteUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/graphite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/influx"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/otlp"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/ping"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/remotewrite"
 	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/stream"
+	statsdUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/statsd"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
 	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 )
 
 // Variables for the server configuration
@@ -53,7 +86,33 @@ var (
 	restoreOnStart  bool
 	dataBaseDSN     string
 	key             string
+	keyringFile     string
 	trustedSubnet   string
+	memShards       int
+	writeBehind     int
+	writeBehindSize int
+	tenants         string
+	tenantHeader    string
+	maxTenants      int
+	adminToken      string
+	rwCounters      string
+	influxIntegers  string
+	influxTags      string
+	statsdAddress   string
+	statsdFlush     int
+	graphiteAddress string
+	graphitePickle  string
+	graphiteTmpl    string
+	rateLimit       float64
+	metricRateLimit float64
+	rateLimitKey    string
+	maxBodySize     int64
+	maxDecompressed int64
+	maxBatchSize    int
+	maxInFlight     int
+	signatureSkew   int
+	requireSig      bool
+	requireToken    bool
 	opts            *srvCfg.Options
 )
 
@@ -75,21 +134,83 @@ func init() {
 	rootCmd.Flags().BoolVarP(&restoreOnStart, "r", "r", srvCfg.DefaultRestoreOnStart, "restore metrics from file on start")
 	rootCmd.Flags().StringVarP(&dataBaseDSN, "d", "d", srvCfg.DefaultDataBaseDSN, "database dsn")
 	rootCmd.Flags().StringVarP(&key, "k", "k", srvCfg.DefaultKey, "key for hash")
+	rootCmd.Flags().StringVarP(&keyringFile, "K", "K", srvCfg.DefaultKeyringFile, "JSON file of the keys accepted besides -k, re-read on SIGHUP")
 	rootCmd.Flags().StringVarP(&trustedSubnet, "t", "t", srvCfg.DefaultTrustedSubnet, "trusted subnet")
+	rootCmd.Flags().IntVarP(&memShards, "s", "s", srvCfg.DefaultMemShards, "in-memory storage shards (0 = single lock)")
+	rootCmd.Flags().IntVarP(&writeBehind, "w", "w", srvCfg.DefaultWriteBehind, "write-behind cache flush interval for database in seconds (0 = disabled)")
+	rootCmd.Flags().IntVarP(&writeBehindSize, "b", "b", srvCfg.DefaultWriteBehindSize, "write-behind cache flush batch size")
+	rootCmd.Flags().StringVarP(&tenants, "n", "n", srvCfg.DefaultTenants, "tenants \"tenant:secret,...\"")
+	rootCmd.Flags().StringVarP(&tenantHeader, "H", "H", srvCfg.DefaultTenantHeader, "trusted header with the tenant id")
+	rootCmd.Flags().IntVarP(&maxTenants, "N", "N", srvCfg.DefaultMaxTenants, "largest number of tenants (0 = unlimited)")
+	rootCmd.Flags().StringVarP(&adminToken, "A", "A", srvCfg.DefaultAdminToken, "bearer token of the admin endpoints")
+	rootCmd.Flags().StringVarP(&rwCounters, "c", "c", srvCfg.DefaultRemoteWriteCounters, "name suffixes of remote write series stored as counters")
+	rootCmd.Flags().StringVarP(&influxIntegers, "I", "I", srvCfg.DefaultInfluxIntegers, "line protocol integer fields stored as \"gauge\" or \"counter\"")
+	rootCmd.Flags().StringVarP(&influxTags, "T", "T", srvCfg.DefaultInfluxTags, "line protocol tags stored as \"labels\" or name \"prefix\"")
+	rootCmd.Flags().StringVarP(&statsdAddress, "S", "S", srvCfg.DefaultStatsDAddress, "udp and tcp address of the statsd listener")
+	rootCmd.Flags().IntVarP(&statsdFlush, "F", "F", srvCfg.DefaultStatsDFlush, "statsd flush interval in seconds")
+	rootCmd.Flags().StringVarP(&graphiteAddress, "G", "G", srvCfg.DefaultGraphiteAddress, "tcp address of the graphite plaintext listener")
+	rootCmd.Flags().StringVarP(&graphitePickle, "P", "P", srvCfg.DefaultGraphitePickle, "tcp address of the graphite pickle listener")
+	rootCmd.Flags().StringVarP(&graphiteTmpl, "m", "m", srvCfg.DefaultGraphiteTemplates, "graphite templates \"[filter ]template;...\"")
+	rootCmd.Flags().Float64VarP(&rateLimit, "l", "l", srvCfg.DefaultRateLimit, "requests per second of every client (0 = unlimited)")
+	rootCmd.Flags().Float64VarP(&metricRateLimit, "L", "L", srvCfg.DefaultMetricRateLimit, "metrics per second every client can store (0 = unlimited)")
+	rootCmd.Flags().StringVarP(&rateLimitKey, "R", "R", srvCfg.DefaultRateLimitKey, "client identity of the rate limits: \"ip\", \"agent\" or \"tenant\"")
+	rootCmd.Flags().Int64VarP(&maxBodySize, "z", "z", srvCfg.DefaultMaxBodySize, "largest request body in bytes, as sent")
+	rootCmd.Flags().Int64VarP(&maxDecompressed, "Z", "Z", srvCfg.DefaultMaxDecompressedSize, "largest request body in bytes, decompressed")
+	rootCmd.Flags().IntVarP(&maxBatchSize, "M", "M", srvCfg.DefaultMaxBatchSize, "largest number of metrics in a batch update")
+	rootCmd.Flags().IntVarP(&maxInFlight, "Q", "Q", srvCfg.DefaultMaxInFlight, "requests served at once, the others get 503 (0 = unlimited)")
+	rootCmd.Flags().IntVarP(&signatureSkew, "x", "x", srvCfg.DefaultSignatureSkew, "clock skew window of request signatures in seconds")
+	rootCmd.Flags().BoolVarP(&requireSig, "X", "X", srvCfg.DefaultRequireSignature, "require a canonical signature of requests changing metrics")
+	rootCmd.Flags().BoolVarP(&requireToken, "y", "y", srvCfg.DefaultRequireToken, "require an api token on metric requests")
 }
 
 func preRunE(cmd *cobra.Command, args []string) error {
 	var err error
 	opts, err = srvCfg.ParseOptionsFromCmdAndEnvs(cmd, &srvCfg.Options{
-		HTTPAddress:     httpAddress,
-		GRPCAddress:     grpcAddress,
-		StoreInterval:   storeInterval,
-		FileStoragePath: fileStoragePath,
-		RestoreOnStart:  restoreOnStart,
-		DataBaseDSN:     dataBaseDSN,
-		Key:             key,
-		TrustedSubnet:   trustedSubnet,
+		HTTPAddress:         httpAddress,
+		GRPCAddress:         grpcAddress,
+		StoreInterval:       storeInterval,
+		FileStoragePath:     fileStoragePath,
+		RestoreOnStart:      restoreOnStart,
+		DataBaseDSN:         dataBaseDSN,
+		Key:                 key,
+		KeyringFile:         keyringFile,
+		TrustedSubnet:       trustedSubnet,
+		MemShards:           memShards,
+		WriteBehind:         writeBehind,
+		WriteBehindSize:     writeBehindSize,
+		Tenants:             tenants,
+		TenantHeader:        tenantHeader,
+		MaxTenants:          maxTenants,
+		AdminToken:          adminToken,
+		RemoteWriteCounters: rwCounters,
+		InfluxIntegers:      influxIntegers,
+		InfluxTags:          influxTags,
+		StatsDAddress:       statsdAddress,
+		StatsDFlush:         statsdFlush,
+		GraphiteAddress:     graphiteAddress,
+		GraphitePickle:      graphitePickle,
+		GraphiteTemplates:   graphiteTmpl,
+		RateLimit:           rateLimit,
+		MetricRateLimit:     metricRateLimit,
+		RateLimitKey:        rateLimitKey,
+		MaxBodySize:         maxBodySize,
+		MaxDecompressedSize: maxDecompressed,
+		MaxBatchSize:        maxBatchSize,
+		MaxInFlight:         maxInFlight,
+		SignatureSkew:       signatureSkew,
+		RequireSignature:    requireSig,
+		RequireToken:        requireToken,
 	})
+	if err != nil {
+		return err
+	}
+
+	// The keyring is shared by the HTTP and gRPC servers, so that SIGHUP
+	// reloads it for both.
+	keys, err := opts.Keyring()
+	if err != nil {
+		return err
+	}
 
 	opts = srvCfg.NewServerOptions(
 		srvCfg.WithAddress(opts.HTTPAddress),
@@ -99,10 +220,25 @@ func preRunE(cmd *cobra.Command, args []string) error {
 		srvCfg.WithRestoreOnStart(opts.RestoreOnStart),
 		srvCfg.WithDataBaseDSN(opts.DataBaseDSN),
 		srvCfg.WithKey(opts.Key),
+		srvCfg.WithKeyringFile(opts.KeyringFile),
+		srvCfg.WithKeys(keys),
 		srvCfg.WithTrustedSubnet(opts.TrustedSubnet),
+		srvCfg.WithMemShards(opts.MemShards),
+		srvCfg.WithWriteBehind(opts.WriteBehind, opts.WriteBehindSize),
+		srvCfg.WithTenants(opts.Tenants, opts.TenantHeader),
+		srvCfg.WithMaxTenants(opts.MaxTenants),
+		srvCfg.WithAdminToken(opts.AdminToken),
+		srvCfg.WithRemoteWriteCounters(opts.RemoteWriteCounters),
+		srvCfg.WithInflux(opts.InfluxIntegers, opts.InfluxTags),
+		srvCfg.WithStatsD(opts.StatsDAddress, opts.StatsDFlush),
+		srvCfg.WithGraphite(opts.GraphiteAddress, opts.GraphitePickle, opts.GraphiteTemplates),
+		srvCfg.WithRateLimit(opts.RateLimit, opts.MetricRateLimit, opts.RateLimitKey),
+		srvCfg.WithLimits(opts.MaxBodySize, opts.MaxDecompressedSize, opts.MaxBatchSize, opts.MaxInFlight),
+		srvCfg.WithSignature(opts.SignatureSkew, opts.RequireSignature),
+		srvCfg.WithRequireToken(opts.RequireToken),
 	)
 
-	return err
+	return nil
 }
 
 func runE(cmd *cobra.Command, args []string) error {
@@ -126,21 +262,47 @@ func runE(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	// Create a collector for metrics depending on which type of storage is used (file, database, memory).
-	collector, err := colcfg.NewCollector(&colcfg.Params{
-		Ctx:  ctx,
+	// A multi-tenant server gets an isolated collector of the same type for every tenant.
+	var (
+		collector srvUsecase.Collector
+		tenants   *repo.TenantStorage
+	)
+	// The storage outlives the shutdown signal: the listeners flush their
+	// aggregates into it once stopped, and only then closer.Close below
+	// saves or flushes it for the last time.
+	params := &colcfg.Params{
+		Ctx:  context.WithoutCancel(ctx),
 		Opts: opts,
-	})
+	}
+
+	if opts.MultiTenant() {
+		tenants, err = colcfg.NewTenantCollector(params)
+		if err == nil {
+			collector, err = tenants.ForTenant(tenant.Default)
+		}
+	} else {
+		collector, err = colcfg.NewCollector(params)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create collector: %w", err)
 	}
+
+	closer := srvUsecase.Closer(collector)
+	if tenants != nil {
+		closer = tenants
+	}
+	// Runs once g.Wait has returned, so after the listeners' final flush.
 	defer func() {
-		if err := collector.Close(); err != nil {
+		if err := closer.Close(); err != nil {
 			log.Error().Err(err).Msg("Failed to close collector")
 		}
 	}()
 
 	// Create a use case for metrics, business logic for metrics.
 	metricUsecase := srvUsecase.NewMetricUsecase(collector, collector, collector)
+	if tenants != nil {
+		metricUsecase.WithTenants(tenants)
+	}
 
 	// Create a use case for ping if the collector implements the Pinger interface.
 	var pingUsecase *ping.PingUsecase
@@ -150,11 +312,39 @@ func runE(cmd *cobra.Command, args []string) error {
 		pingUsecase = nil
 	}
 
+	// Create a use case for API tokens if the collector can store them.
+	var tokenUsecase *token.TokenUsecase
+	if store, ok := collector.(token.Store); ok {
+		tokenUsecase = token.NewTokenUsecase(store)
+	}
+	if opts.RequireToken && tokenUsecase == nil {
+		return fmt.Errorf("api tokens are required, but the storage doesn't support them")
+	}
+
+	// Create the hub of the HTTP metric stream. It is the update hook of the
+	// metric use case, so the stream shows the updates of every ingestion
+	// path, the gRPC, StatsD and Graphite servers included.
+	streamHub := stream.NewHub()
+	metricUsecase.WithUpdateHook(streamHub)
+
+	// Create a use case for OTLP exports, shared by the HTTP and gRPC
+	// servers, so the cumulative sums of a series are tracked once.
+	otlpUsecase := otlp.NewOTLPUsecase(metricUsecase)
+
 	// Create a channel for the shutdown signal.
 	stop := make(chan os.Signal, 1)
 	// Notify the server about the shutdown signal.
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
+	// Reload the keyring file on SIGHUP.
+	if opts.KeyringFile != "" {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		defer signal.Stop(reload)
+
+		go reloadKeyring(ctx, opts, reload)
+	}
+
 	// Create a context for the server.
 	g, gCtx := errgroup.WithContext(ctx)
 
@@ -173,17 +363,48 @@ func runE(cmd *cobra.Command, args []string) error {
 
 	// Create a goroutine for the HTTP server.
 	g.Go(func() error {
-		return startHTTPServer(gCtx, opts, metricUsecase, pingUsecase)
+		return startHTTPServer(gCtx, opts, metricUsecase, pingUsecase, otlpUsecase, tokenUsecase, streamHub)
 	})
 
 	// Create a goroutine for the GRPC server.
 	g.Go(func() error {
-		return startGRPCServer(gCtx, opts, metricUsecase, pingUsecase)
+		return startGRPCServer(gCtx, opts, metricUsecase, pingUsecase, otlpUsecase, tokenUsecase)
 	})
 
+	// Create a goroutine for the StatsD listener if it is enabled.
+	if opts.StatsDAddress != "" {
+		g.Go(func() error {
+			return startStatsDServer(gCtx, opts, metricUsecase)
+		})
+	}
+
+	// Create a goroutine for the Graphite listeners if they are enabled.
+	if opts.GraphiteAddress != "" || opts.GraphitePickle != "" {
+		g.Go(func() error {
+			return startGraphiteServer(gCtx, opts, metricUsecase)
+		})
+	}
+
 	return g.Wait()
 }
 
+// reloadKeyring re-reads the keyring file of opts on every signal from
+// reload until ctx is done. An invalid file keeps the previous keys.
+func reloadKeyring(ctx context.Context, opts *srvCfg.Options, reload <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			if err := opts.Keys.Reload(); err != nil {
+				log.Error().Err(err).Str("file", opts.KeyringFile).Msg("Failed to reload keyring")
+				continue
+			}
+			log.Info().Int("keys", opts.Keys.Len()).Msg("Keyring reloaded")
+		}
+	}
+}
+
 func extractPort(addr string) uint64 {
 	_, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -201,19 +422,44 @@ func extractPort(addr string) uint64 {
 func startGRPCServer(ctx context.Context,
 	opts *srvCfg.Options,
 	metricUsecase *srvUsecase.MetricUsecase,
-	pingUsecase *ping.PingUsecase) error {
+	pingUsecase *ping.PingUsecase,
+	otlpUsecase *otlp.OTLPUsecase,
+	tokenUsecase *token.TokenUsecase) error {
 
 	log.Info().
 		Str("address", opts.GRPCAddress).
 		Msg("Server configuration")
 
+	// The tenants and the hashing interceptor share the nonces and the
+	// signing keys seen.
+	verifier := opts.SignatureVerifier()
+
 	interceptor := []grpc.UnaryServerInterceptor{
 		gRPC.WithLogging,
+		gRPC.WithLoadShedding(opts.MaxInFlight),
 		gRPC.WithTrustedSubnet(opts.TrustedSubnet),
 	}
 
-	if opts.Key != "" {
-		interceptor = append(interceptor, gRPC.WithHashing([]byte(opts.Key)))
+	if opts.MultiTenant() {
+		withTenant, err := gRPC.WithTenant(opts.Tenants, opts.TenantHeader, opts.TrustedSubnet, verifier)
+		if err != nil {
+			return fmt.Errorf("invalid tenants configuration: %w", err)
+		}
+		interceptor = append(interceptor, withTenant)
+	}
+
+	interceptor = append(interceptor, gRPC.WithToken(tokenUsecase, opts.RequireToken))
+
+	if opts.RateLimit > 0 || opts.MetricRateLimit > 0 {
+		interceptor = append(interceptor, gRPC.WithRateLimit(opts.RateLimit, opts.MetricRateLimit, opts.RateLimitKey, opts.TrustedSubnet))
+	}
+
+	keys, err := opts.Keyring()
+	if err != nil {
+		return fmt.Errorf("failed to load keyring: %w", err)
+	}
+	if keys != nil || opts.Tenants != "" {
+		interceptor = append(interceptor, gRPC.WithHashing(keys, verifier, opts.RequireSignature))
 	}
 
 	grpcEntry := rkgrpc.RegisterGrpcEntry(
@@ -221,11 +467,15 @@ func startGRPCServer(ctx context.Context,
 		rkgrpc.WithPort(extractPort(opts.GRPCAddress)),
 		rkgrpc.WithServerOptions(
 			grpc.ChainUnaryInterceptor(interceptor...),
+			// gRPC checks the size of a compressed message after it is
+			// decompressed too.
+			grpc.MaxRecvMsgSize(int(opts.MaxDecompressedSize)),
 		),
 	)
 
 	grpcEntry.AddRegFuncGrpc(func(server *grpc.Server) {
-        pb.RegisterMetricsServiceServer(server, &pb.UnimplementedMetricsServiceServer{})
+		pb.RegisterMetricsServiceServer(server, gRPC.NewServer(metricUsecase, pingUsecase).WithLimits(opts.Limits()))
+		otlppb.RegisterMetricsServiceServer(server, gRPC.NewOTLPServer(otlpUsecase))
     })
 
 	go grpcEntry.Bootstrap(context.Background())
@@ -236,21 +486,75 @@ func startGRPCServer(ctx context.Context,
     return nil
 }
 
+func startStatsDServer(ctx context.Context,
+	opts *srvCfg.Options,
+	metricUsecase *srvUsecase.MetricUsecase) error {
+
+	log.Info().
+		Str("address", opts.StatsDAddress).
+		Int("flush_interval", opts.StatsDFlush).
+		Msg("StatsD configuration")
+
+	srv := statsd.NewServer(opts.StatsDAddress,
+		statsdUsecase.NewStatsDUsecase(metricUsecase),
+		time.Duration(opts.StatsDFlush)*time.Second)
+
+	log.Info().Msg("Starting StatsD server...")
+	return srv.ListenAndServe(ctx)
+}
+
+func startGraphiteServer(ctx context.Context,
+	opts *srvCfg.Options,
+	metricUsecase *srvUsecase.MetricUsecase) error {
+
+	log.Info().
+		Str("address", opts.GraphiteAddress).
+		Str("pickle_address", opts.GraphitePickle).
+		Str("templates", opts.GraphiteTemplates).
+		Msg("Graphite configuration")
+
+	templates, err := graphiteUsecase.ParseTemplates(opts.GraphiteTemplates)
+	if err != nil {
+		return err
+	}
+
+	srv := graphite.NewServer(opts.GraphiteAddress, opts.GraphitePickle,
+		graphiteUsecase.NewGraphiteUsecase(metricUsecase, templates),
+		graphite.DefaultFlushInterval)
+
+	log.Info().Msg("Starting Graphite server...")
+	return srv.ListenAndServe(ctx)
+}
+
 func startHTTPServer(ctx context.Context,
 	opts *srvCfg.Options,
 	metricUsecase *srvUsecase.MetricUsecase,
-	pingUsecase *ping.PingUsecase) error {
+	pingUsecase *ping.PingUsecase,
+	otlpUsecase *otlp.OTLPUsecase,
+	tokenUsecase *token.TokenUsecase,
+	streamHub *stream.Hub) error {
 
 	log.Info().
 		Str("address", opts.HTTPAddress).
 		Msg("Server configuration")
 
-	r := router.NewRouter(rest.NewServer(metricUsecase, pingUsecase), opts)
+	remoteWrite := remotewrite.NewRemoteWriteUsecase(metricUsecase, remotewrite.ParseConvention(opts.RemoteWriteCounters))
+	influxWrite := influx.NewInfluxUsecase(metricUsecase, influx.Convention{Integers: opts.InfluxIntegers, Tags: opts.InfluxTags})
+	handlers := rest.NewServer(metricUsecase, pingUsecase).
+		WithRemoteWrite(remoteWrite).
+		WithInflux(influxWrite).
+		WithOTLP(otlpUsecase).
+		WithTokens(tokenUsecase).
+		WithStream(streamHub).
+		WithLimits(opts.Limits())
+	r := router.NewRouter(handlers, opts)
 
 	srv := &http.Server{
 		Addr:    opts.HTTPAddress,
 		Handler: r,
 	}
+	// Shutdown waits for the open streams, so end them first.
+	srv.RegisterOnShutdown(streamHub.Close)
 
 	srvErrCh := make(chan error, 1)
 
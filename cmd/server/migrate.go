@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	colcfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/collector"
+	srvCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/migrate"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// maxPrintedMismatches limits the mismatches printed after a failed verification.
+const maxPrintedMismatches = 20
+
+// fileSaveInterval is the store interval of a destination file, in seconds.
+// It is long enough for the file to be saved once, when the storage is
+// closed, rather than after every batch.
+const fileSaveInterval = 24 * 60 * 60
+
+// Variables for the migrate-storage configuration
+var (
+	fromDSN          string
+	fromFile         string
+	toDSN            string
+	toFile           string
+	migrateBatchSize int
+	dryRun           bool
+	overwrite        bool
+)
+
+// migrateCmd copies all metrics from one storage backend to another.
+//
+// Usage:
+//
+//	server migrate-storage --from-file /tmp/metrics-db.json --to-dsn postgres://...
+//	server migrate-storage --from-dsn postgres://... --to-file ./debug.json --dry-run
+//	server migrate-storage --from-file /tmp/metrics-db.json --to-dsn postgres://... --overwrite
+var migrateCmd = &cobra.Command{
+	Use:   "migrate-storage",
+	Short: "Copy all metrics between storage backends",
+	Long: "Copy all metrics from a source storage (database DSN or file) into a destination storage " +
+		"and verify the destination afterwards. Metrics are written with the source values, " +
+		"so a rerun changes nothing. A non-empty destination is refused unless --overwrite is given.",
+	Args: cobra.NoArgs,
+	RunE: runMigrate,
+}
+
+func init() {
+	migrateCmd.Flags().StringVar(&fromDSN, "from-dsn", "", "source database dsn")
+	migrateCmd.Flags().StringVar(&fromFile, "from-file", "", "source metrics file")
+	migrateCmd.Flags().StringVar(&toDSN, "to-dsn", "", "destination database dsn")
+	migrateCmd.Flags().StringVar(&toFile, "to-file", "", "destination metrics file")
+	migrateCmd.Flags().IntVar(&migrateBatchSize, "batch-size", migrate.DefaultBatchSize, "metrics written per batch")
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "read both storages and report, without writing")
+	migrateCmd.Flags().BoolVar(&overwrite, "overwrite", false,
+		"migrate into a non-empty destination, overwriting the metrics present in the source")
+
+	migrateCmd.MarkFlagsMutuallyExclusive("from-dsn", "from-file")
+	migrateCmd.MarkFlagsOneRequired("from-dsn", "from-file")
+	migrateCmd.MarkFlagsMutuallyExclusive("to-dsn", "to-file")
+	migrateCmd.MarkFlagsOneRequired("to-dsn", "to-file")
+
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// newStorage creates a collector for a database DSN or a metrics file.
+//
+// A file storage saves itself every storeInterval seconds and when it is
+// closed, or after every write if storeInterval is 0.
+func newStorage(ctx context.Context, dsn, file string, storeInterval int) (server.Collector, error) {
+	return colcfg.NewCollector(&colcfg.Params{
+		Ctx: ctx,
+		Opts: srvCfg.NewServerOptions(
+			srvCfg.WithDataBaseDSN(dsn),
+			srvCfg.WithFileStoragePath(file),
+			srvCfg.WithStoreInterval(storeInterval),
+			srvCfg.WithRestoreOnStart(true),
+		),
+	})
+}
+
+func runMigrate(cmd *cobra.Command, args []string) (err error) {
+	// An empty --to-file or --from-file would select the memory storage,
+	// which is gone when the command exits.
+	if (fromDSN == "" && fromFile == "") || (toDSN == "" && toFile == "") {
+		return errors.New("source and destination must be a database dsn or a metrics file, " +
+			"memory storage is not supported")
+	}
+
+	if (fromDSN != "" && fromDSN == toDSN) || (fromFile != "" && fromFile == toFile) {
+		return errors.New("source and destination must be different storages")
+	}
+
+	if fromFile != "" {
+		if _, err := os.Stat(fromFile); err != nil {
+			return fmt.Errorf("source file: %w", err)
+		}
+	}
+
+	logFile, err := log.InitLogger("logFileMigrate.log")
+	if err != nil {
+		return fmt.Errorf("logger init error: %w", err)
+	}
+
+	defer func() {
+		if err := logFile.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close log file")
+		}
+	}()
+
+	ctx := cmd.Context()
+
+	src, err := newStorage(ctx, fromDSN, fromFile, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open source storage: %w", err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close source storage")
+		}
+	}()
+
+	// A dry run must not save the destination file, not even on close.
+	saveInterval := fileSaveInterval
+	if dryRun {
+		saveInterval = 0
+	}
+
+	dst, err := newStorage(ctx, toDSN, toFile, saveInterval)
+	if err != nil {
+		return fmt.Errorf("failed to open destination storage: %w", err)
+	}
+	// A destination file is only saved on close, so its error fails the migration.
+	defer func() {
+		if closeErr := dst.Close(); closeErr != nil {
+			log.Error().Err(closeErr).Msg("Failed to close destination storage")
+			if err == nil {
+				err = fmt.Errorf("failed to save destination storage: %w", closeErr)
+			}
+		}
+	}()
+
+	source, ok := src.(migrate.MetricRanger)
+	if !ok {
+		return errors.New("source storage doesn't support streaming its metrics")
+	}
+	destination, ok := dst.(migrate.Destination)
+	if !ok {
+		return errors.New("destination storage doesn't support streamed migration")
+	}
+
+	report, err := migrate.NewMigrateUsecase(source, destination).Run(ctx, migrate.Options{
+		BatchSize: migrateBatchSize,
+		DryRun:    dryRun,
+		Overwrite: overwrite,
+	})
+	if report != nil {
+		printReport(cmd, report)
+	}
+	if errors.Is(err, migrate.ErrDestinationNotEmpty) {
+		err = fmt.Errorf("%w, pass --overwrite to migrate into it", err)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("migration failed")
+		return err
+	}
+
+	log.Info().
+		Int("metrics", report.Total()).
+		Bool("dry_run", report.DryRun).
+		Msg("migration finished")
+
+	return nil
+}
+
+func printReport(cmd *cobra.Command, report *migrate.Report) {
+	out := cmd.OutOrStdout()
+
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was written.")
+	}
+
+	fmt.Fprintf(out, "Source metrics:      %d (gauges: %d, counters: %d)\n",
+		report.Total(), report.Gauges, report.Counters)
+	fmt.Fprintf(out, "Destination before:  %d\n", report.Existing)
+	fmt.Fprintf(out, "Batches:             %d\n", report.Batches)
+
+	if report.DryRun {
+		return
+	}
+
+	fmt.Fprintf(out, "Written:             %d\n", report.Written)
+	fmt.Fprintf(out, "Verified:            %d\n", report.Verified)
+
+	for i, m := range report.Mismatches {
+		if i == maxPrintedMismatches {
+			fmt.Fprintf(out, "... and %d more mismatches\n", len(report.Mismatches)-maxPrintedMismatches)
+			break
+		}
+		fmt.Fprintf(out, "Mismatch %s %s: expected %v, got %v\n", m.Type, m.Name, m.Expected, m.Actual)
+	}
+}
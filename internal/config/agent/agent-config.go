@@ -6,6 +6,10 @@ import (
 
 	"github.com/caarlos0/env/v6"
 	"github.com/spf13/cobra"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
 )
 
 const (
@@ -14,7 +18,14 @@ const (
 	DefaultPollInterval   = 2
 	DefaultReportInterval = 10
 	DefaultKey            = ""
+	DefaultKeyID          = ""
+	DefaultHashAlgorithm  = hash.HMACSHA256
+	DefaultAPIToken       = ""
 	DefaultRateLimit      = 10
+	// DefaultCompress is the codec of the batches, zstd for large batches.
+	DefaultCompress          = compression.Zstd
+	DefaultCompressLevel     = compression.DefaultLevel
+	DefaultCompressThreshold = 1024
 )
 
 type Options struct {
@@ -23,7 +34,20 @@ type Options struct {
 	PollInterval   int
 	ReportInterval int
 	Key            string
-	RateLimit      int
+	// KeyID is the ID of Key in the keyring of the server.
+	KeyID string
+	// HashAlgorithm is the algorithm of the canonical signatures.
+	HashAlgorithm string
+	// APIToken is the secret of the API token of the agent on the server.
+	APIToken  string
+	RateLimit int
+	// Compress is the codec of the batches, or identity not to compress.
+	Compress string
+	// CompressLevel is the level of the codec, 0 for its default and -1
+	// for its fastest.
+	CompressLevel int
+	// CompressThreshold is the size in bytes a batch is compressed above.
+	CompressThreshold int
 }
 
 type EnvConfig struct {
@@ -32,7 +56,15 @@ type EnvConfig struct {
 	PollInterval   int    `env:"POLL_INTERVAL"`
 	ReportInterval int    `env:"REPORT_INTERVAL"`
 	Key            string `env:"KEY"`
+	KeyID          string `env:"KEY_ID"`
+	HashAlgorithm  string `env:"HASH_ALGORITHM"`
+	APIToken       string `env:"API_TOKEN"`
 	RateLimit      int    `env:"RATE_LIMIT"`
+	Compress       string `env:"COMPRESS"`
+	// CompressLevel and CompressThreshold are pointers, since 0 is a level
+	// and a threshold.
+	CompressLevel     *int `env:"COMPRESS_LEVEL"`
+	CompressThreshold *int `env:"COMPRESS_THRESHOLD"`
 }
 
 type Option func(*Options)
@@ -44,7 +76,14 @@ func NewAgentOptions(options ...Option) *Options {
 		PollInterval:   DefaultPollInterval,
 		ReportInterval: DefaultReportInterval,
 		Key:            DefaultKey,
+		KeyID:          DefaultKeyID,
+		HashAlgorithm:  DefaultHashAlgorithm,
+		APIToken:       DefaultAPIToken,
 		RateLimit:      DefaultRateLimit,
+
+		Compress:          DefaultCompress,
+		CompressLevel:     DefaultCompressLevel,
+		CompressThreshold: DefaultCompressThreshold,
 	}
 
 	for _, opt := range options {
@@ -72,6 +111,19 @@ func WithKey(key string) Option {
 	}
 }
 
+func WithSigning(keyID, algorithm string) Option {
+	return func(o *Options) {
+		o.KeyID = keyID
+		o.HashAlgorithm = algorithm
+	}
+}
+
+func WithAPIToken(token string) Option {
+	return func(o *Options) {
+		o.APIToken = token
+	}
+}
+
 func WithPollInterval(pollInterval int) Option {
 	return func(o *Options) {
 		o.PollInterval = pollInterval
@@ -90,6 +142,14 @@ func WithRateLimit(rateLimit int) Option {
 	}
 }
 
+func WithCompress(codec string, level, threshold int) Option {
+	return func(o *Options) {
+		o.Compress = codec
+		o.CompressLevel = level
+		o.CompressThreshold = threshold
+	}
+}
+
 func ParseOptionsFromCmdAndEnvs(cmd *cobra.Command, src *Options) (*Options, error) {
 	opts, err := ParseFlags(cmd, src)
 	if err != nil {
@@ -104,6 +164,20 @@ func ParseOptionsFromCmdAndEnvs(cmd *cobra.Command, src *Options) (*Options, err
 		return nil, fmt.Errorf("invalid address %s: %w", opts.HTTPAddress, err)
 	}
 
+	if err := hash.ValidateAlgorithm(opts.HashAlgorithm); err != nil {
+		return nil, fmt.Errorf("invalid hash algorithm: %w", err)
+	}
+
+	if opts.APIToken != "" && !apitoken.IsSecret(opts.APIToken) {
+		return nil, fmt.Errorf("invalid api token: must start with %q", apitoken.SecretPrefix)
+	}
+
+	if opts.Compress != compression.Identity {
+		if err := compression.ValidateLevel(opts.Compress, opts.CompressLevel); err != nil {
+			return nil, fmt.Errorf("invalid compression: %w", err)
+		}
+	}
+
 	return opts, nil
 }
 
@@ -148,6 +222,22 @@ func ParseFlags(cmd *cobra.Command, src *Options) (*Options, error) {
 		}
 	}
 
+	if cmd.Flags().Changed("i") {
+		opts.KeyID = src.KeyID
+	}
+
+	if cmd.Flags().Changed("H") {
+		opts.HashAlgorithm = src.HashAlgorithm
+	}
+
+	if cmd.Flags().Changed("T") {
+		opts.APIToken = src.APIToken
+	}
+
+	if cmd.Flags().Changed("t") && src.CompressThreshold < 0 {
+		return nil, fmt.Errorf("compressThreshold need >= 0")
+	}
+
 	return &opts, nil
 }
 
@@ -178,6 +268,33 @@ func ParseEnvs(cmd *cobra.Command, opts *Options) error {
 		opts.Key = cfg.Key
 	}
 
+	if cfg.KeyID != "" {
+		opts.KeyID = cfg.KeyID
+	}
+
+	if cfg.HashAlgorithm != "" {
+		opts.HashAlgorithm = cfg.HashAlgorithm
+	}
+
+	if cfg.APIToken != "" {
+		opts.APIToken = cfg.APIToken
+	}
+
+	if cfg.Compress != "" {
+		opts.Compress = cfg.Compress
+	}
+
+	if cfg.CompressLevel != nil {
+		opts.CompressLevel = *cfg.CompressLevel
+	}
+
+	if cfg.CompressThreshold != nil {
+		if *cfg.CompressThreshold < 0 {
+			return fmt.Errorf("compress threshold must be >= 0")
+		}
+		opts.CompressThreshold = *cfg.CompressThreshold
+	}
+
 	if cfg.RateLimit > 0 {
 		opts.RateLimit = cfg.RateLimit
 	} else {
@@ -2,11 +2,23 @@
 //
 // The storage is chosen in the following order:
 //   1. Database storage – used if DataBaseDSN is set.
+//      If WriteBehind is set, the database is wrapped into the write-behind
+//      cache: reads are served from memory and writes are flushed in batches.
 //   2. File storage – used if FileStoragePath is set.
 //   3. In-memory storage – used by default if nothing else is configured.
+//      If MemShards is set, the sharded in-memory storage is used instead
+//      of the single-lock one.
 //
 // NewCollector reads options from Params and returns the correct storage.
 //
+// NewTenantCollector returns the storages of all tenants of a multi-tenant
+// server. Every tenant gets a storage of the same kind, isolated from the others:
+//   - database: its own table "collector_<tenant>" in the same database;
+//   - file: its own file next to FileStoragePath ("metrics.json" -> "metrics.<tenant>.json");
+//   - memory: its own in-memory storage.
+//
+// The default tenant uses the same storage as NewCollector.
+//
 // Author rAch-kaplin
 // Version 1.0.0
 // Since 2025-07-29
@@ -16,6 +28,9 @@ package collector
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
 	srvCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/server"
 	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
@@ -29,6 +44,42 @@ type Params struct {
 }
 
 func NewCollector(params *Params) (server.Collector, error) {
+	return newCollector(params, "", nil)
+}
+
+func NewTenantCollector(params *Params) (*repo.TenantStorage, error) {
+	var db *repo.Database
+	if params.Opts.DataBaseDSN != "" {
+		var err error
+		if db, err = repo.NewDatabase(params.Ctx, params.Opts.DataBaseDSN); err != nil {
+			return nil, fmt.Errorf("DB connection failed: %w", err)
+		}
+	}
+
+	base, err := newCollector(params, "", db)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.NewTenantStorage(base, func(tenantID string) (server.Collector, error) {
+		return newCollector(params, tenantID, db)
+	}).WithLimit(params.Opts.MaxTenants), nil
+}
+
+// TenantFilePath returns the metrics file of a tenant.
+func TenantFilePath(path, tenantID string) string {
+	if tenantID == "" {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "." + tenantID + ext
+}
+
+// newCollector creates the storage of a tenant. db is the database shared
+// by all tenants; if it is nil, a new connection is opened.
+func newCollector(params *Params, tenantID string, db *repo.Database) (server.Collector, error) {
 	var (
 		collector server.Collector
 		err       error
@@ -36,19 +87,45 @@ func NewCollector(params *Params) (server.Collector, error) {
 
 	switch {
 	case params.Opts.DataBaseDSN != "":
-		collector, err = repo.NewDatabase(params.Ctx, params.Opts.DataBaseDSN)
+		switch {
+		case db == nil:
+			db, err = repo.NewDatabase(params.Ctx, params.Opts.DataBaseDSN)
+		case tenantID != "":
+			db, err = db.ForTenant(params.Ctx, tenantID)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("DB connection failed: %w", err)
 		}
+		collector = db
+
+		if params.Opts.WriteBehind > 0 {
+			collector, err = repo.NewWriteBehindStorage(params.Ctx, db, &repo.WriteBehindParams{
+				FlushInterval: time.Duration(params.Opts.WriteBehind) * time.Second,
+				BatchSize:     params.Opts.WriteBehindSize,
+			})
+			if err != nil {
+				if err := db.Close(); err != nil {
+					log.Error().Err(err).Msg("failed to close database")
+				}
+				return nil, fmt.Errorf("write-behind cache failed: %w", err)
+			}
+
+			log.Debug().Msg("chose database storage with write-behind cache")
+		}
 
 	case params.Opts.FileStoragePath != "":
 		collector, err = repo.NewFileStorage(params.Ctx, &repo.FileParams{
-			FileStoragePath: params.Opts.FileStoragePath,
+			FileStoragePath: TenantFilePath(params.Opts.FileStoragePath, tenantID),
 			RestoreOnStart:  params.Opts.RestoreOnStart,
 			StoreInterval:   params.Opts.StoreInterval})
 
 		log.Debug().Msg("chose file storage")
 
+	case params.Opts.MemShards > 0:
+		collector = repo.NewShardedMemStorage(params.Opts.MemShards)
+
+		log.Debug().Int("shards", params.Opts.MemShards).Msg("chose sharded memory storage")
+
 	default:
 		collector = repo.NewMemStorage()
 	}
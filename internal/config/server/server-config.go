@@ -4,20 +4,55 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/spf13/cobra"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/graphite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/influx"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/remotewrite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 )
 
 const (
-	DefaultHTTPAddress     = "localhost:8080"
-	DefaultGRPCAddress     = "localhost:8081"
-	DefaultStoreInterval   = 300
-	DefaultFileStoragePath = ""
-	DefaultRestoreOnStart  = true
-	DefaultDataBaseDSN     = ""
-	DefaultKey             = ""
-	DefaultTrustedSubnet   = ""
+	DefaultHTTPAddress         = "localhost:8080"
+	DefaultGRPCAddress         = "localhost:8081"
+	DefaultStoreInterval       = 300
+	DefaultFileStoragePath     = ""
+	DefaultRestoreOnStart      = true
+	DefaultDataBaseDSN         = ""
+	DefaultKey                 = ""
+	DefaultKeyringFile         = ""
+	DefaultTrustedSubnet       = ""
+	DefaultMemShards           = 0
+	DefaultWriteBehind         = 0
+	DefaultWriteBehindSize     = 1000
+	DefaultTenants             = ""
+	DefaultTenantHeader        = ""
+	DefaultMaxTenants          = 100
+	DefaultAdminToken          = ""
+	DefaultRemoteWriteCounters = remotewrite.DefaultCounterSuffixes
+	DefaultInfluxIntegers      = influx.DefaultIntegers
+	DefaultInfluxTags          = influx.DefaultTags
+	DefaultStatsDAddress       = ""
+	DefaultStatsDFlush         = 10
+	DefaultGraphiteAddress     = ""
+	DefaultGraphitePickle      = ""
+	DefaultGraphiteTemplates   = ""
+	DefaultRateLimit           = 0
+	DefaultMetricRateLimit     = 0
+	DefaultRateLimitKey        = ratelimit.KeyIP
+	DefaultMaxBodySize         = admission.DefaultMaxBodySize
+	DefaultMaxDecompressedSize = admission.DefaultMaxDecompressedSize
+	DefaultMaxBatchSize        = admission.DefaultMaxBatchSize
+	DefaultMaxInFlight         = admission.DefaultMaxInFlight
+	DefaultSignatureSkew       = int(hash.DefaultMaxSkew / time.Second)
+	DefaultRequireSignature    = false
+	DefaultRequireToken        = false
 )
 
 type Options struct {
@@ -28,32 +63,151 @@ type Options struct {
 	RestoreOnStart  bool
 	DataBaseDSN     string
 	Key             string
+	// KeyringFile is the JSON file of the keys accepted besides Key,
+	// re-read on SIGHUP.
+	KeyringFile string
+	// Keys is the keyring of Key and KeyringFile, built once and shared
+	// by the HTTP and gRPC servers (see Keyring).
+	Keys            *hash.Keyring
 	TrustedSubnet   string
+	MemShards       int
+	WriteBehind     int
+	WriteBehindSize int
+	// Tenants is the tenants specification "tenant:secret,...".
+	Tenants string
+	// TenantHeader is the trusted request header with the tenant ID.
+	TenantHeader string
+	// MaxTenants is the largest number of tenant storages (0 = unlimited).
+	// It bounds the tenants the trusted header may create.
+	MaxTenants int
+	// AdminToken is the bearer token of the admin endpoints.
+	AdminToken string
+	// RemoteWriteCounters are the comma-separated name suffixes of the
+	// remote write series stored as counters.
+	RemoteWriteCounters string
+	// InfluxIntegers is how integer line protocol fields are stored:
+	// "gauge" (the default) or "counter".
+	InfluxIntegers string
+	// InfluxTags is how line protocol tags are stored: "labels" or "prefix".
+	InfluxTags string
+	// StatsDAddress is the UDP and TCP address of the StatsD listener,
+	// empty to disable it.
+	StatsDAddress string
+	// StatsDFlush is the interval in seconds of flushing StatsD aggregates.
+	StatsDFlush int
+	// GraphiteAddress is the TCP address of the Graphite plaintext
+	// listener, empty to disable it.
+	GraphiteAddress string
+	// GraphitePickle is the TCP address of the Graphite pickle listener,
+	// empty to disable it.
+	GraphitePickle string
+	// GraphiteTemplates are the semicolon-separated templates mapping
+	// Graphite paths to metric names and labels.
+	GraphiteTemplates string
+	// RateLimit is the number of requests per second of every client,
+	// zero to not limit them.
+	RateLimit float64
+	// MetricRateLimit is the number of metrics per second every client
+	// can store, zero to not limit them.
+	MetricRateLimit float64
+	// RateLimitKey is the identity of the clients the limits are kept
+	// for: "ip", "agent" or "tenant".
+	RateLimitKey string
+	// MaxBodySize is the largest request body in bytes, as it is sent.
+	MaxBodySize int64
+	// MaxDecompressedSize is the largest request body in bytes after it
+	// is decompressed.
+	MaxDecompressedSize int64
+	// MaxBatchSize is the largest number of metrics in a batch update.
+	MaxBatchSize int
+	// MaxInFlight is the largest number of requests served at once,
+	// zero to not limit them.
+	MaxInFlight int
+	// SignatureSkew is how far in seconds the timestamp of a request
+	// signature may be from the clock of the server.
+	SignatureSkew int
+	// RequireSignature rejects the requests changing metrics without
+	// a canonical signature.
+	RequireSignature bool
+	// RequireToken rejects the metric requests without an API token.
+	RequireToken bool
 }
 
 type EnvConfig struct {
-	EndPointAddr    string `env:"ADDRESS"`
-	GRPCAddress     string `env:"GRPC_ADDRESS"`
-	StoreInterval   int    `env:"STORE_INTERVAL"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH"`
-	RestoreOnStart  bool   `env:"RESTORE"`
-	DataBaseDSN     string `env:"DATABASE_DSN"`
-	Key             string `env:"KEY"`
-	TrustedSubnet   string `env:"TRUSTED_SUBNET"`
+	EndPointAddr        string  `env:"ADDRESS"`
+	GRPCAddress         string  `env:"GRPC_ADDRESS"`
+	StoreInterval       int     `env:"STORE_INTERVAL"`
+	FileStoragePath     string  `env:"FILE_STORAGE_PATH"`
+	RestoreOnStart      bool    `env:"RESTORE"`
+	DataBaseDSN         string  `env:"DATABASE_DSN"`
+	Key                 string  `env:"KEY"`
+	KeyringFile         string  `env:"KEYRING_FILE"`
+	TrustedSubnet       string  `env:"TRUSTED_SUBNET"`
+	MemShards           int     `env:"MEM_SHARDS"`
+	WriteBehind         int     `env:"WRITE_BEHIND_INTERVAL"`
+	WriteBehindSize     int     `env:"WRITE_BEHIND_BATCH"`
+	Tenants             string  `env:"TENANTS"`
+	TenantHeader        string  `env:"TENANT_HEADER"`
+	MaxTenants          int     `env:"MAX_TENANTS"`
+	AdminToken          string  `env:"ADMIN_TOKEN"`
+	RemoteWriteCounters string  `env:"REMOTE_WRITE_COUNTERS"`
+	InfluxIntegers      string  `env:"INFLUX_INTEGERS"`
+	InfluxTags          string  `env:"INFLUX_TAGS"`
+	StatsDAddress       string  `env:"STATSD_ADDRESS"`
+	StatsDFlush         int     `env:"STATSD_FLUSH_INTERVAL"`
+	GraphiteAddress     string  `env:"GRAPHITE_ADDRESS"`
+	GraphitePickle      string  `env:"GRAPHITE_PICKLE_ADDRESS"`
+	GraphiteTemplates   string  `env:"GRAPHITE_TEMPLATES"`
+	RateLimit           float64 `env:"RATE_LIMIT"`
+	MetricRateLimit     float64 `env:"METRIC_RATE_LIMIT"`
+	RateLimitKey        string  `env:"RATE_LIMIT_KEY"`
+	MaxBodySize         int64   `env:"MAX_BODY_SIZE"`
+	MaxDecompressedSize int64   `env:"MAX_DECOMPRESSED_SIZE"`
+	MaxBatchSize        int     `env:"MAX_BATCH_SIZE"`
+	MaxInFlight         int     `env:"MAX_IN_FLIGHT"`
+	SignatureSkew       int     `env:"SIGNATURE_SKEW"`
+	RequireSignature    bool    `env:"REQUIRE_SIGNATURE"`
+	RequireToken        bool    `env:"REQUIRE_TOKEN"`
 }
 
 type Option func(*Options)
 
 func NewServerOptions(options ...Option) *Options {
 	opts := &Options{
-		HTTPAddress:     DefaultHTTPAddress,
-		GRPCAddress:     DefaultGRPCAddress,
-		StoreInterval:   DefaultStoreInterval,
-		FileStoragePath: DefaultFileStoragePath,
-		RestoreOnStart:  DefaultRestoreOnStart,
-		DataBaseDSN:     DefaultDataBaseDSN,
-		Key:             DefaultKey,
-		TrustedSubnet:   DefaultTrustedSubnet,
+		HTTPAddress:         DefaultHTTPAddress,
+		GRPCAddress:         DefaultGRPCAddress,
+		StoreInterval:       DefaultStoreInterval,
+		FileStoragePath:     DefaultFileStoragePath,
+		RestoreOnStart:      DefaultRestoreOnStart,
+		DataBaseDSN:         DefaultDataBaseDSN,
+		Key:                 DefaultKey,
+		KeyringFile:         DefaultKeyringFile,
+		TrustedSubnet:       DefaultTrustedSubnet,
+		MemShards:           DefaultMemShards,
+		WriteBehind:         DefaultWriteBehind,
+		WriteBehindSize:     DefaultWriteBehindSize,
+		Tenants:             DefaultTenants,
+		TenantHeader:        DefaultTenantHeader,
+		MaxTenants:          DefaultMaxTenants,
+		AdminToken:          DefaultAdminToken,
+		RemoteWriteCounters: DefaultRemoteWriteCounters,
+		InfluxIntegers:      DefaultInfluxIntegers,
+		InfluxTags:          DefaultInfluxTags,
+		StatsDAddress:       DefaultStatsDAddress,
+		StatsDFlush:         DefaultStatsDFlush,
+		GraphiteAddress:     DefaultGraphiteAddress,
+		GraphitePickle:      DefaultGraphitePickle,
+		GraphiteTemplates:   DefaultGraphiteTemplates,
+		RateLimit:           DefaultRateLimit,
+		MetricRateLimit:     DefaultMetricRateLimit,
+		RateLimitKey:        DefaultRateLimitKey,
+		MaxBodySize:         DefaultMaxBodySize,
+		MaxDecompressedSize: DefaultMaxDecompressedSize,
+		MaxBatchSize:        DefaultMaxBatchSize,
+		MaxInFlight:         DefaultMaxInFlight,
+		SignatureSkew:       DefaultSignatureSkew,
+		RequireSignature:    DefaultRequireSignature,
+		RequireToken:        DefaultRequireToken,
 	}
 
 	for _, opt := range options {
@@ -105,12 +259,155 @@ func WithKey(key string) Option {
 	}
 }
 
+func WithKeyringFile(path string) Option {
+	return func(o *Options) {
+		o.KeyringFile = path
+	}
+}
+
+func WithKeys(keys *hash.Keyring) Option {
+	return func(o *Options) {
+		o.Keys = keys
+	}
+}
+
+// Keyring returns Keys if it is set, otherwise a new keyring of Key, under
+// the empty key ID, and of the keys in KeyringFile. It returns nil if
+// there are no keys.
+func (o *Options) Keyring() (*hash.Keyring, error) {
+	if o.Keys != nil {
+		return o.Keys, nil
+	}
+	if o.Key == "" && o.KeyringFile == "" {
+		return nil, nil
+	}
+
+	var keys []hash.Key
+	if o.Key != "" {
+		keys = append(keys, hash.Key{Secret: o.Key})
+	}
+
+	return hash.LoadKeyring(o.KeyringFile, keys...)
+}
+
 func WithTrustedSubnet(subnet string) Option {
 	return func(o *Options) {
 		o.TrustedSubnet = subnet
 	}
 }
 
+func WithMemShards(shards int) Option {
+	return func(o *Options) {
+		o.MemShards = shards
+	}
+}
+
+func WithWriteBehind(interval, size int) Option {
+	return func(o *Options) {
+		o.WriteBehind = interval
+		o.WriteBehindSize = size
+	}
+}
+
+func WithTenants(spec, header string) Option {
+	return func(o *Options) {
+		o.Tenants = spec
+		o.TenantHeader = header
+	}
+}
+
+func WithMaxTenants(maxTenants int) Option {
+	return func(o *Options) {
+		o.MaxTenants = maxTenants
+	}
+}
+
+func WithAdminToken(token string) Option {
+	return func(o *Options) {
+		o.AdminToken = token
+	}
+}
+
+func WithRemoteWriteCounters(suffixes string) Option {
+	return func(o *Options) {
+		o.RemoteWriteCounters = suffixes
+	}
+}
+
+func WithInflux(integers, tags string) Option {
+	return func(o *Options) {
+		o.InfluxIntegers = integers
+		o.InfluxTags = tags
+	}
+}
+
+func WithStatsD(address string, flush int) Option {
+	return func(o *Options) {
+		o.StatsDAddress = address
+		o.StatsDFlush = flush
+	}
+}
+
+func WithGraphite(address, pickle, templates string) Option {
+	return func(o *Options) {
+		o.GraphiteAddress = address
+		o.GraphitePickle = pickle
+		o.GraphiteTemplates = templates
+	}
+}
+
+func WithRateLimit(requests, metrics float64, key string) Option {
+	return func(o *Options) {
+		o.RateLimit = requests
+		o.MetricRateLimit = metrics
+		o.RateLimitKey = key
+	}
+}
+
+func WithLimits(body, decompressed int64, batch, inFlight int) Option {
+	return func(o *Options) {
+		o.MaxBodySize = body
+		o.MaxDecompressedSize = decompressed
+		o.MaxBatchSize = batch
+		o.MaxInFlight = inFlight
+	}
+}
+
+// Limits returns the limits the requests are admitted with.
+func (o *Options) Limits() admission.Limits {
+	return admission.Limits{
+		MaxBodySize:         o.MaxBodySize,
+		MaxDecompressedSize: o.MaxDecompressedSize,
+		MaxBatchSize:        o.MaxBatchSize,
+		MaxInFlight:         o.MaxInFlight,
+	}
+}
+
+func WithSignature(skew int, require bool) Option {
+	return func(o *Options) {
+		o.SignatureSkew = skew
+		o.RequireSignature = require
+	}
+}
+
+// WithRequireToken makes the metric requests require an API token.
+func WithRequireToken(require bool) Option {
+	return func(o *Options) {
+		o.RequireToken = require
+	}
+}
+
+// SignatureVerifier returns a verifier of the canonical request signatures
+// with the skew window of the options.
+func (o *Options) SignatureVerifier() *hash.Verifier {
+	return hash.NewVerifier(time.Duration(o.SignatureSkew) * time.Second)
+}
+
+// MultiTenant reports whether the server serves several tenants.
+func (o *Options) MultiTenant() bool {
+	return o.Tenants != "" || o.TenantHeader != ""
+}
+
 func ParseOptionsFromCmdAndEnvs(cmd *cobra.Command, src *Options) (*Options, error) {
 	opts, err := ParseFlags(cmd, src)
 	if err != nil {
@@ -125,6 +422,37 @@ func ParseOptionsFromCmdAndEnvs(cmd *cobra.Command, src *Options) (*Options, err
 		return nil, fmt.Errorf("invalid address %s: %w", opts.HTTPAddress, err)
 	}
 
+	if _, err := opts.Keyring(); err != nil {
+		return nil, fmt.Errorf("invalid keyring: %w", err)
+	}
+
+	keyring, err := tenant.ParseKeyring(opts.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenants: %w", err)
+	}
+
+	if opts.MaxTenants > 0 && keyring.Len() > opts.MaxTenants {
+		return nil, fmt.Errorf("%d tenants configured: %w", keyring.Len(), tenant.ErrTooMany)
+	}
+
+	// The tenant header selects a tenant without its secret, so it may only
+	// come from a proxy in the trusted subnet.
+	if opts.TenantHeader != "" && opts.TrustedSubnet == "" {
+		return nil, fmt.Errorf("tenant header %s: %w", opts.TenantHeader, tenant.ErrUntrusted)
+	}
+
+	if _, err := influx.ParseConvention(opts.InfluxIntegers, opts.InfluxTags); err != nil {
+		return nil, err
+	}
+
+	if _, err := graphite.ParseTemplates(opts.GraphiteTemplates); err != nil {
+		return nil, err
+	}
+
+	if err := ratelimit.ValidateKey(opts.RateLimitKey); err != nil {
+		return nil, err
+	}
+
 	return opts, nil
 }
 
@@ -162,10 +490,150 @@ func ParseFlags(cmd *cobra.Command, src *Options) (*Options, error) {
 		}
 	}
 
+	if cmd.Flags().Changed("K") {
+		opts.KeyringFile = src.KeyringFile
+	}
+
 	if cmd.Flags().Changed("t") {
 		opts.TrustedSubnet = src.TrustedSubnet
 	}
 
+	if cmd.Flags().Changed("s") {
+		if src.MemShards < 0 {
+			return nil, fmt.Errorf("mem shards must be >= 0, got %d", src.MemShards)
+		}
+		opts.MemShards = src.MemShards
+	}
+
+	if cmd.Flags().Changed("w") {
+		if src.WriteBehind < 0 {
+			return nil, fmt.Errorf("write-behind interval must be >= 0, got %d", src.WriteBehind)
+		}
+		opts.WriteBehind = src.WriteBehind
+	}
+
+	if cmd.Flags().Changed("b") {
+		if src.WriteBehindSize <= 0 {
+			return nil, fmt.Errorf("write-behind batch size must be > 0, got %d", src.WriteBehindSize)
+		}
+		opts.WriteBehindSize = src.WriteBehindSize
+	}
+
+	if cmd.Flags().Changed("n") {
+		opts.Tenants = src.Tenants
+	}
+
+	if cmd.Flags().Changed("H") {
+		opts.TenantHeader = src.TenantHeader
+	}
+
+	if cmd.Flags().Changed("N") {
+		if src.MaxTenants < 0 {
+			return nil, fmt.Errorf("max tenants must be >= 0, got %d", src.MaxTenants)
+		}
+		opts.MaxTenants = src.MaxTenants
+	}
+
+	if cmd.Flags().Changed("A") {
+		opts.AdminToken = src.AdminToken
+	}
+
+	if cmd.Flags().Changed("c") {
+		opts.RemoteWriteCounters = src.RemoteWriteCounters
+	}
+
+	if cmd.Flags().Changed("I") {
+		opts.InfluxIntegers = src.InfluxIntegers
+	}
+
+	if cmd.Flags().Changed("T") {
+		opts.InfluxTags = src.InfluxTags
+	}
+
+	if cmd.Flags().Changed("S") {
+		opts.StatsDAddress = src.StatsDAddress
+	}
+
+	if cmd.Flags().Changed("F") {
+		if src.StatsDFlush <= 0 {
+			return nil, fmt.Errorf("statsd flush interval must be > 0, got %d", src.StatsDFlush)
+		}
+		opts.StatsDFlush = src.StatsDFlush
+	}
+
+	if cmd.Flags().Changed("G") {
+		opts.GraphiteAddress = src.GraphiteAddress
+	}
+
+	if cmd.Flags().Changed("P") {
+		opts.GraphitePickle = src.GraphitePickle
+	}
+
+	if cmd.Flags().Changed("m") {
+		opts.GraphiteTemplates = src.GraphiteTemplates
+	}
+
+	if cmd.Flags().Changed("l") {
+		if src.RateLimit < 0 {
+			return nil, fmt.Errorf("rate limit must be >= 0, got %g", src.RateLimit)
+		}
+		opts.RateLimit = src.RateLimit
+	}
+
+	if cmd.Flags().Changed("L") {
+		if src.MetricRateLimit < 0 {
+			return nil, fmt.Errorf("metric rate limit must be >= 0, got %g", src.MetricRateLimit)
+		}
+		opts.MetricRateLimit = src.MetricRateLimit
+	}
+
+	if cmd.Flags().Changed("R") {
+		opts.RateLimitKey = src.RateLimitKey
+	}
+
+	if cmd.Flags().Changed("z") {
+		if src.MaxBodySize <= 0 {
+			return nil, fmt.Errorf("max body size must be > 0, got %d", src.MaxBodySize)
+		}
+		opts.MaxBodySize = src.MaxBodySize
+	}
+
+	if cmd.Flags().Changed("Z") {
+		if src.MaxDecompressedSize <= 0 {
+			return nil, fmt.Errorf("max decompressed size must be > 0, got %d", src.MaxDecompressedSize)
+		}
+		opts.MaxDecompressedSize = src.MaxDecompressedSize
+	}
+
+	if cmd.Flags().Changed("M") {
+		if src.MaxBatchSize <= 0 {
+			return nil, fmt.Errorf("max batch size must be > 0, got %d", src.MaxBatchSize)
+		}
+		opts.MaxBatchSize = src.MaxBatchSize
+	}
+
+	if cmd.Flags().Changed("Q") {
+		if src.MaxInFlight < 0 {
+			return nil, fmt.Errorf("max in-flight requests must be >= 0, got %d", src.MaxInFlight)
+		}
+		opts.MaxInFlight = src.MaxInFlight
+	}
+
+	if cmd.Flags().Changed("x") {
+		if src.SignatureSkew <= 0 {
+			return nil, fmt.Errorf("signature skew must be > 0, got %d", src.SignatureSkew)
+		}
+		opts.SignatureSkew = src.SignatureSkew
+	}
+
+	if cmd.Flags().Changed("X") {
+		opts.RequireSignature = src.RequireSignature
+	}
+
+	if cmd.Flags().Changed("y") {
+		opts.RequireToken = src.RequireToken
+	}
+
 	return &opts, nil
 }
 
@@ -197,9 +665,97 @@ func ParseEnvs(cmd *cobra.Command, opts *Options) error {
 	if envCfg.Key != "" {
 		opts.Key = envCfg.Key
 	}
+
+	if envCfg.KeyringFile != "" {
+		opts.KeyringFile = envCfg.KeyringFile
+	}
 	if envCfg.TrustedSubnet != "" {
 		opts.TrustedSubnet = envCfg.TrustedSubnet
 	}
+	if envCfg.MemShards < 0 {
+		return fmt.Errorf("mem shards must be >= 0, got %d", envCfg.MemShards)
+	}
+	if envCfg.MemShards > 0 {
+		opts.MemShards = envCfg.MemShards
+	}
+	if envCfg.WriteBehind > 0 {
+		opts.WriteBehind = envCfg.WriteBehind
+	}
+	if envCfg.WriteBehindSize > 0 {
+		opts.WriteBehindSize = envCfg.WriteBehindSize
+	}
+	if envCfg.Tenants != "" {
+		opts.Tenants = envCfg.Tenants
+	}
+	if envCfg.TenantHeader != "" {
+		opts.TenantHeader = envCfg.TenantHeader
+	}
+	if envCfg.MaxTenants < 0 {
+		return fmt.Errorf("max tenants must be >= 0, got %d", envCfg.MaxTenants)
+	}
+	if envCfg.MaxTenants > 0 {
+		opts.MaxTenants = envCfg.MaxTenants
+	}
+	if envCfg.AdminToken != "" {
+		opts.AdminToken = envCfg.AdminToken
+	}
+	if envCfg.RemoteWriteCounters != "" {
+		opts.RemoteWriteCounters = envCfg.RemoteWriteCounters
+	}
+	if envCfg.InfluxIntegers != "" {
+		opts.InfluxIntegers = envCfg.InfluxIntegers
+	}
+	if envCfg.InfluxTags != "" {
+		opts.InfluxTags = envCfg.InfluxTags
+	}
+	if envCfg.StatsDAddress != "" {
+		opts.StatsDAddress = envCfg.StatsDAddress
+	}
+	if envCfg.StatsDFlush > 0 {
+		opts.StatsDFlush = envCfg.StatsDFlush
+	}
+	if envCfg.GraphiteAddress != "" {
+		opts.GraphiteAddress = envCfg.GraphiteAddress
+	}
+	if envCfg.GraphitePickle != "" {
+		opts.GraphitePickle = envCfg.GraphitePickle
+	}
+	if envCfg.GraphiteTemplates != "" {
+		opts.GraphiteTemplates = envCfg.GraphiteTemplates
+	}
+	if envCfg.RateLimit > 0 {
+		opts.RateLimit = envCfg.RateLimit
+	}
+	if envCfg.MetricRateLimit > 0 {
+		opts.MetricRateLimit = envCfg.MetricRateLimit
+	}
+	if envCfg.RateLimitKey != "" {
+		opts.RateLimitKey = envCfg.RateLimitKey
+	}
+	if envCfg.MaxBodySize > 0 {
+		opts.MaxBodySize = envCfg.MaxBodySize
+	}
+	if envCfg.MaxDecompressedSize > 0 {
+		opts.MaxDecompressedSize = envCfg.MaxDecompressedSize
+	}
+	if envCfg.MaxBatchSize > 0 {
+		opts.MaxBatchSize = envCfg.MaxBatchSize
+	}
+	if envCfg.MaxInFlight > 0 {
+		opts.MaxInFlight = envCfg.MaxInFlight
+	}
+
+	if envCfg.SignatureSkew > 0 {
+		opts.SignatureSkew = envCfg.SignatureSkew
+	}
+
+	if envCfg.RequireSignature {
+		opts.RequireSignature = true
+	}
+
+	if envCfg.RequireToken {
+		opts.RequireToken = true
+	}
 	opts.RestoreOnStart = envCfg.RestoreOnStart
 
 	return nil
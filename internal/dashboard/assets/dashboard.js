@@ -0,0 +1,414 @@
+// dashboard.js renders the metrics with search, sorting, grouping and
+// highlighting of changed values. In the live mode the updates are pushed
+// by the server over the /stream Server-Sent Events; otherwise the page URL
+// is polled for the metrics in JSON. The view settings are kept in the URL
+// fragment, so a view can be bookmarked or shared.
+(function () {
+    'use strict';
+
+    // HIGHLIGHT_MS is how long a changed value stays highlighted.
+    var HIGHLIGHT_MS = 10000;
+    var LIVE = 'live';
+
+    var table = document.getElementById('metrics');
+    var tbody = table.tBodies[0];
+    var status = document.getElementById('status');
+    var controls = document.getElementById('controls');
+    var search = document.getElementById('search');
+    var typeSelect = document.getElementById('type');
+    var groupSelect = document.getElementById('group');
+    var refreshSelect = document.getElementById('refresh');
+
+    var view = {sort: 'name', desc: false};
+    // metrics maps "type name" to the metric and the time its value last changed.
+    var metrics = new Map();
+    var timer = null;
+    var source = null;
+    var loaded = false;
+
+    // parseLabels splits `name{key="value",...}` into the name and the labels.
+    function parseLabels(id) {
+        var open = id.indexOf('{');
+        if (open < 0 || id[id.length - 1] !== '}') {
+            return {name: id, labels: {}};
+        }
+
+        var labels = {};
+        var s = id.slice(open + 1, -1);
+        var i = 0;
+        while (i < s.length) {
+            var eq = s.indexOf('="', i);
+            if (eq < 0) {
+                break;
+            }
+            var key = s.slice(i, eq).replace(/^,/, '');
+            var value = '';
+            for (i = eq + 2; i < s.length && s[i] !== '"'; i++) {
+                if (s[i] === '\\' && i + 1 < s.length) {
+                    i++;
+                    value += s[i] === 'n' ? '\n' : s[i];
+                } else {
+                    value += s[i];
+                }
+            }
+            labels[key] = value;
+            i++;
+        }
+
+        return {name: id.slice(0, open), labels: labels};
+    }
+
+    // update applies the metrics of a snapshot or, if partial, of an update,
+    // which doesn't remove the metrics it leaves out.
+    function update(list, now, partial) {
+        var seen = new Set();
+
+        list.forEach(function (m) {
+            var value = m.type === 'counter' ? m.delta : m.value;
+            var key = m.type + ' ' + m.id;
+            var old = metrics.get(key);
+            seen.add(key);
+
+            if (old) {
+                if (old.value !== value) {
+                    old.value = value;
+                    old.changedAt = now;
+                }
+                return;
+            }
+
+            var parsed = parseLabels(m.id);
+            metrics.set(key, {
+                id: m.id,
+                type: m.type,
+                name: parsed.name,
+                labels: parsed.labels,
+                value: value,
+                // Metrics of the first load are not highlighted.
+                changedAt: loaded ? now : 0
+            });
+        });
+
+        if (!partial) {
+            metrics.forEach(function (_, key) {
+                if (!seen.has(key)) {
+                    metrics.delete(key);
+                }
+            });
+        }
+
+        loaded = true;
+    }
+
+    function updateGroupOptions() {
+        var keys = new Set();
+        metrics.forEach(function (m) {
+            Object.keys(m.labels).forEach(function (k) {
+                keys.add(k);
+            });
+        });
+
+        var current = groupSelect.value;
+        Array.from(groupSelect.options).forEach(function (option) {
+            if (option.value.indexOf('label:') === 0 && !keys.has(option.value.slice(6)) && option.value !== current) {
+                option.remove();
+            }
+        });
+
+        Array.from(keys).sort().forEach(function (k) {
+            var value = 'label:' + k;
+            if (!groupSelect.querySelector('option[value="' + CSS.escape(value) + '"]')) {
+                groupSelect.add(new Option('label ' + k, value));
+            }
+        });
+    }
+
+    function compare(a, b) {
+        var d = 0;
+        switch (view.sort) {
+        case 'value':
+            d = a.value - b.value;
+            break;
+        case 'type':
+            d = a.type.localeCompare(b.type);
+            break;
+        }
+        if (d === 0) {
+            d = a.id.localeCompare(b.id);
+        }
+        return view.desc ? -d : d;
+    }
+
+    function groupOf(m) {
+        var group = groupSelect.value;
+        if (group === 'type') {
+            return m.type;
+        }
+        if (group.indexOf('label:') === 0) {
+            var key = group.slice(6);
+            return key in m.labels ? key + '=' + m.labels[key] : 'no ' + key;
+        }
+        return '';
+    }
+
+    function cell(text, className) {
+        var td = document.createElement('td');
+        td.textContent = text;
+        if (className) {
+            td.className = className;
+        }
+        return td;
+    }
+
+    function render() {
+        var query = search.value.trim().toLowerCase();
+        var type = typeSelect.value;
+        var now = Date.now();
+
+        var rows = [];
+        metrics.forEach(function (m) {
+            if (type && m.type !== type) {
+                return;
+            }
+            if (query && m.id.toLowerCase().indexOf(query) < 0) {
+                return;
+            }
+            rows.push(m);
+        });
+
+        rows.sort(compare);
+
+        var groups = new Map();
+        rows.forEach(function (m) {
+            var g = groupOf(m);
+            if (!groups.has(g)) {
+                groups.set(g, []);
+            }
+            groups.get(g).push(m);
+        });
+
+        var names = Array.from(groups.keys()).sort();
+        var fragment = document.createDocumentFragment();
+
+        names.forEach(function (g) {
+            var members = groups.get(g);
+
+            if (groupSelect.value) {
+                var tr = document.createElement('tr');
+                var th = document.createElement('th');
+                tr.className = 'group';
+                th.colSpan = 3;
+                th.textContent = g + ' (' + members.length + ')';
+                tr.appendChild(th);
+                fragment.appendChild(tr);
+            }
+
+            members.forEach(function (m) {
+                var tr = document.createElement('tr');
+                tr.appendChild(cell(m.id));
+                tr.appendChild(cell(m.type));
+                tr.appendChild(cell(String(m.value), 'number'));
+
+                var age = now - m.changedAt;
+                if (m.changedAt && age < HIGHLIGHT_MS) {
+                    tr.className = 'changed';
+                    // Continue the fading where the previous render left it.
+                    tr.style.animationDelay = -age + 'ms';
+                }
+                fragment.appendChild(tr);
+            });
+        });
+
+        if (rows.length === 0) {
+            var empty = document.createElement('tr');
+            var td = cell(metrics.size ? 'No metrics match the filters' : 'No metrics yet', 'empty');
+            td.colSpan = 3;
+            empty.appendChild(td);
+            fragment.appendChild(empty);
+        }
+
+        tbody.replaceChildren(fragment);
+
+        Array.from(table.tHead.rows[0].cells).forEach(function (th) {
+            if (th.dataset.sort === view.sort) {
+                th.setAttribute('aria-sort', view.desc ? 'descending' : 'ascending');
+            } else {
+                th.removeAttribute('aria-sort');
+            }
+        });
+    }
+
+    function setStatus(text, error) {
+        status.textContent = text;
+        status.classList.toggle('error', !!error);
+    }
+
+    function load() {
+        return fetch(window.location.pathname, {
+            headers: {Accept: 'application/json'},
+            // Revalidated with the ETag, unchanged metrics come from the cache.
+            cache: 'no-cache'
+        }).then(function (resp) {
+            if (!resp.ok) {
+                throw new Error(resp.status + ' ' + resp.statusText);
+            }
+            return resp.json();
+        }).then(function (list) {
+            apply(list, false);
+        }).catch(function (err) {
+            setStatus('Update failed: ' + err.message, true);
+        });
+    }
+
+    function apply(list, partial) {
+        update(list || [], Date.now(), partial);
+        updateGroupOptions();
+        render();
+        setStatus(metrics.size + ' metrics, ' +
+            (source ? 'live' : 'updated ' + new Date().toLocaleTimeString()));
+    }
+
+    // connect subscribes to the stream, which starts with a snapshot.
+    // EventSource reconnects by itself when the stream is cut, e.g. when
+    // the server drops a client that doesn't keep up.
+    function connect() {
+        var base = window.location.pathname.replace(/\/?$/, '/');
+        source = new EventSource(base + 'stream');
+
+        source.addEventListener('snapshot', function (e) {
+            apply(JSON.parse(e.data), false);
+        });
+        source.addEventListener('update', function (e) {
+            apply(JSON.parse(e.data), true);
+        });
+        // Both the error events of the server and the connection errors.
+        source.addEventListener('error', function (e) {
+            var reason = e.data ? JSON.parse(e.data).error : 'disconnected';
+            setStatus('Stream ' + reason + ', reconnecting', true);
+        });
+    }
+
+    function disconnect() {
+        if (source) {
+            source.close();
+            source = null;
+        }
+    }
+
+    function schedule() {
+        clearTimeout(timer);
+        timer = null;
+
+        if (refreshSelect.value === LIVE) {
+            if (!source) {
+                connect();
+            }
+            return;
+        }
+        disconnect();
+
+        var seconds = Number(refreshSelect.value);
+        if (seconds > 0 && !document.hidden) {
+            timer = setTimeout(function () {
+                load().then(schedule);
+            }, seconds * 1000);
+        }
+    }
+
+    function saveView() {
+        var params = new URLSearchParams();
+        if (search.value) {
+            params.set('q', search.value);
+        }
+        if (typeSelect.value) {
+            params.set('type', typeSelect.value);
+        }
+        if (groupSelect.value) {
+            params.set('group', groupSelect.value);
+        }
+        if (view.sort !== 'name' || view.desc) {
+            params.set('sort', (view.desc ? '-' : '') + view.sort);
+        }
+        if (refreshSelect.value !== defaultRefresh) {
+            params.set('refresh', refreshSelect.value);
+        }
+
+        var hash = params.toString();
+        history.replaceState(null, '', hash ? '#' + hash : window.location.pathname + window.location.search);
+    }
+
+    function restoreView() {
+        var params = new URLSearchParams(window.location.hash.slice(1));
+
+        search.value = params.get('q') || '';
+        typeSelect.value = params.get('type') || '';
+
+        var group = params.get('group') || '';
+        if (group.indexOf('label:') === 0) {
+            groupSelect.add(new Option('label ' + group.slice(6), group));
+        }
+        groupSelect.value = group;
+
+        var sort = params.get('sort') || 'name';
+        view.desc = sort[0] === '-';
+        view.sort = sort.replace(/^-/, '');
+
+        if (params.has('refresh')) {
+            refreshSelect.value = params.get('refresh');
+        }
+    }
+
+    table.tHead.addEventListener('click', function (e) {
+        var key = e.target.dataset && e.target.dataset.sort;
+        if (!key) {
+            return;
+        }
+        view.desc = view.sort === key ? !view.desc : false;
+        view.sort = key;
+        saveView();
+        render();
+    });
+
+    [search, typeSelect, groupSelect].forEach(function (input) {
+        input.addEventListener('input', function () {
+            saveView();
+            render();
+        });
+    });
+
+    refreshSelect.addEventListener('change', function () {
+        saveView();
+        schedule();
+    });
+
+    controls.addEventListener('submit', function (e) {
+        e.preventDefault();
+    });
+
+    // Polling a hidden tab is wasted work; refresh once it is visible again.
+    // The live stream stays connected.
+    document.addEventListener('visibilitychange', function () {
+        if (refreshSelect.value === LIVE) {
+            return;
+        }
+        if (document.hidden) {
+            schedule();
+            return;
+        }
+        load().then(schedule);
+    });
+
+    if (!window.EventSource) {
+        refreshSelect.querySelector('option[value="' + LIVE + '"]').remove();
+        refreshSelect.value = '5';
+    }
+    var defaultRefresh = refreshSelect.value;
+
+    restoreView();
+    controls.hidden = false;
+    if (refreshSelect.value === LIVE) {
+        schedule();
+    } else {
+        load().then(schedule);
+    }
+})();
@@ -0,0 +1,74 @@
+// Package dashboard is the HTML dashboard of the metrics, served from
+// assets embedded in the binary, so it works without any CDN.
+//
+// The page is rendered with the metrics sorted by name, which is all a
+// browser without JavaScript gets. dashboard.js then polls the same URL
+// with "Accept: application/json" and adds the search, sorting, grouping
+// and highlighting of changed values.
+package dashboard
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+// AssetsPath is the URL path the assets are served under.
+const AssetsPath = "/assets/"
+
+//go:embed assets
+var assets embed.FS
+
+//go:embed index.html
+var indexHTML string
+
+var index = template.Must(template.New("index").Parse(indexHTML))
+
+// page is the data of index.html.
+type page struct {
+	AssetsPath string
+	Metrics    []models.MetricTable
+}
+
+// Render writes the dashboard page with the metrics, sorted by name and type.
+func Render(w io.Writer, metrics []models.MetricTable) error {
+	sorted := make([]models.MetricTable, len(metrics))
+	copy(sorted, metrics)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+
+	return index.Execute(w, page{AssetsPath: AssetsPath, Metrics: sorted})
+}
+
+// Assets serves the stylesheet and the script under AssetsPath.
+func Assets() http.Handler {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.StripPrefix(AssetsPath, http.FileServer(http.FS(sub)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		// The embedded files have no modification time to revalidate
+		// against, and change with every release.
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package dashboard_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/dashboard"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+func TestRender(t *testing.T) {
+	var b strings.Builder
+	err := dashboard.Render(&b, []models.MetricTable{
+		{Name: "b", Type: models.GaugeType, Value: "2"},
+		{Name: `a{path="<script>"}`, Type: models.CounterType, Value: "1"},
+		{Name: "b", Type: models.CounterType, Value: "3"},
+	})
+	require.NoError(t, err)
+
+	page := b.String()
+	assert.Contains(t, page, `<script src="/assets/dashboard.js"></script>`)
+	assert.NotContains(t, page, `path="<script>"`)
+
+	// Sorted by name and type.
+	a := strings.Index(page, "a{path=")
+	bCounter := strings.Index(page, "<td>b</td>\n                <td>counter</td>")
+	bGauge := strings.Index(page, "<td>b</td>\n                <td>gauge</td>")
+	assert.True(t, 0 < a && a < bCounter && bCounter < bGauge, page)
+}
+
+func TestAssets(t *testing.T) {
+	handler := dashboard.Assets()
+
+	for path, contentType := range map[string]string{
+		"/assets/dashboard.js":  "text/javascript; charset=utf-8",
+		"/assets/dashboard.css": "text/css; charset=utf-8",
+	} {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, contentType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+			assert.NotZero(t, rr.Body.Len())
+		})
+	}
+
+	for _, path := range []string{"/assets/", "/assets/missing.js"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+}
@@ -48,20 +48,22 @@ package agent
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/mailru/easyjson"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -69,10 +71,13 @@ import (
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/agent"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	_ "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression/grpcencoding"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
 	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
 	rt "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/runtime-stats"
 	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
 	worker "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/worker-pool"
@@ -81,11 +86,72 @@ import (
 // Agent is a struct that contains the use case for the agent.
 type Agent struct {
 	Usecase *agent.AgentUsecase
+	// Compression is how the agent compresses the batches it sends.
+	Compression Compression
+	// Signing is how the agent names the key and algorithm it signs with.
+	Signing Signing
+}
+
+// Signing names the key and the algorithm of the signatures of the agent,
+// for a server accepting several keys (see hash.Keyring).
+type Signing struct {
+	// KeyID is the ID of the key on the server, empty for its default key.
+	KeyID string
+	// Algorithm is the algorithm of the canonical signatures,
+	// hash.HMACSHA256 if empty.
+	Algorithm string
+}
+
+// headers returns the headers, or the gRPC metadata, naming the key and
+// the algorithm.
+func (s Signing) headers() map[string]string {
+	headers := map[string]string{}
+	if s.KeyID != "" {
+		headers[hash.KeyIDHeader] = s.KeyID
+	}
+	if s.Algorithm != "" {
+		headers[hash.AlgorithmHeader] = s.Algorithm
+	}
+
+	return headers
+}
+
+// Compression is how the agent compresses a batch of metrics.
+type Compression struct {
+	// Codec is the name of a codec of the compression package, or
+	// compression.Identity not to compress.
+	Codec string
+	// Level is the level of the codec, compression.DefaultLevel by default.
+	Level int
+	// Threshold is the size in bytes a batch is compressed above.
+	Threshold int
+}
+
+// DefaultCompression returns the default compression: zstd, which suits
+// the large batches of the agent best, of batches above 1 KiB.
+func DefaultCompression() Compression {
+	return Compression{
+		Codec:     compression.Zstd,
+		Level:     compression.DefaultLevel,
+		Threshold: 1024,
+	}
 }
 
 // NewAgent is a function that creates a new agent.
 func NewAgent(uc *agent.AgentUsecase) *Agent {
-	return &Agent{Usecase: uc}
+	return &Agent{Usecase: uc, Compression: DefaultCompression()}
+}
+
+// WithCompression sets how the agent compresses the batches it sends.
+func (ag *Agent) WithCompression(c Compression) *Agent {
+	ag.Compression = c
+	return ag
+}
+
+// WithSigning sets the key ID and the algorithm of the signatures.
+func (ag *Agent) WithSigning(s Signing) *Agent {
+	ag.Signing = s
+	return ag
 }
 
 // @Title UpdateAllMetrics
@@ -153,7 +219,7 @@ func (ag *Agent) SendAllMetrics(ctx context.Context, client *resty.Client, key s
 	}
 
 	if len(metricsToSend) > 0 {
-		sendBatch(client, metricsToSend, key)
+		sendBatch(client, metricsToSend, key, ag.Signing, ag.Compression)
 	}
 
 	log.Info().Int("count", len(metricsToSend)).Msg("Sending metrics batch")
@@ -165,7 +231,7 @@ func (ag *Agent) SendAllMetrics(ctx context.Context, client *resty.Client, key s
 // @Produces text/plain
 // @Success 200 {string} string "Metrics sent successfully"
 // @Failure 500 {string} string "Internal server error"
-func sendBatch(client *resty.Client, metrics []serialize.Metric, key string) {
+func sendBatch(client *resty.Client, metrics []serialize.Metric, key string, s Signing, c Compression) {
 	// Create a backoff schedule for the agent.
 	backoffSchedule := []time.Duration{
 		100 * time.Millisecond,
@@ -173,10 +239,10 @@ func sendBatch(client *resty.Client, metrics []serialize.Metric, key string) {
 		1 * time.Second,
 	}
 
-	// Convert the metrics to gzip data.
-	buf, ok, err := ConvertToGzipData(metrics)
+	// Convert the metrics to compressed data.
+	buf, encoding, err := ConvertToCompressedData(metrics, c)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to convert metric to gzip")
+		log.Error().Err(err).Msg("Failed to compress metrics")
 		return
 	}
 
@@ -204,25 +270,96 @@ func sendBatch(client *resty.Client, metrics []serialize.Metric, key string) {
 		req := client.R().
 			SetHeader("Content-Type", "application/json").
 			SetHeader("X-Real-IP", ip.String()).
+			SetHeader(ratelimit.AgentHeader, agentID()).
 			SetBody(buf.Bytes())
 
-		if ok {
-			req.SetHeader("Content-Encoding", "gzip")
+		if encoding != "" {
+			req.SetHeader("Content-Encoding", encoding)
 		}
 
 		if h != "" {
 			req.SetHeader("HashSHA256", h)
+			req.SetHeaders(s.headers())
+
+			// Every attempt is signed anew: the server rejects a nonce
+			// it has seen.
+			signature, err := signatureHeaders(key, s.Algorithm, http.MethodPost, updatesPath, buf.Bytes())
+			if err != nil {
+				log.Error().Err(err).Msg("can't sign request")
+				return
+			}
+			req.SetHeaders(signature)
 		}
 
-		res, err := req.Post("updates/")
+		res, err := req.Post(updatesPath)
 
 		if err != nil || res.StatusCode() != http.StatusOK {
 		} else {
 			break
 		}
 
-		time.Sleep(backoff)
+		time.Sleep(retryDelay(res, backoff))
+	}
+}
+
+// updatesPath is the path of the batch updates.
+const updatesPath = "/updates/"
+
+// signatureHeaders returns the headers, or the gRPC metadata, with the
+// canonical signature of a request of body to path by algorithm.
+func signatureHeaders(key, algorithm, method, path string, body []byte) (map[string]string, error) {
+	nonce, err := hash.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	r := hash.Request{
+		Method:    method,
+		Path:      path,
+		Body:      body,
+		Timestamp: time.Now(),
+		Nonce:     nonce,
+		Algorithm: algorithm,
+	}
+
+	signature, err := hash.SignRequest([]byte(key), r)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		hash.SignatureHeader: signature,
+		hash.TimestampHeader: strconv.FormatInt(r.Timestamp.Unix(), 10),
+		hash.NonceHeader:     nonce,
+	}, nil
+}
+
+// maxRetryAfter caps the wait the server asks for before a retry.
+const maxRetryAfter = time.Minute
+
+// retryDelay returns how long to wait before retrying the request: the
+// Retry-After of a response rate limited by the server, or the backoff.
+func retryDelay(res *resty.Response, backoff time.Duration) time.Duration {
+	if res == nil || res.StatusCode() != http.StatusTooManyRequests {
+		return backoff
+	}
+
+	wait, ok := ratelimit.ParseRetryAfter(res.Header().Get(ratelimit.RetryAfterHeader))
+	if !ok {
+		return backoff
+	}
+
+	return min(wait, maxRetryAfter)
+}
+
+// agentID returns the ID the agent sends to the server, its host name.
+func agentID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
+
+	return host
 }
 
 // This func is used to get the outbound ip of the machine
@@ -240,50 +377,61 @@ func GetOutboundIP() (net.IP, error) {
 	return conn.LocalAddr().(*net.UDPAddr).IP, nil
 }
 
-// @Title ConvertToGzipData
-// @Description Convert metrics to gzip data
+// @Title ConvertToCompressedData
+// @Description Convert metrics to JSON compressed by the codec of c
 // @Tags metrics
 // @Produces text/plain
 // @Success 200 {string} string "Metrics converted successfully"
 // @Failure 500 {string} string "Internal server error"
-func ConvertToGzipData(metrics serialize.MetricsList) (*bytes.Buffer, bool, error) {
+//
+// It returns the Content-Encoding of the data, or "" if the data is not
+// compressed: the codec is identity or the JSON is not above c.Threshold.
+func ConvertToCompressedData(metrics serialize.MetricsList, c Compression) (*bytes.Buffer, string, error) {
 	var jsonBuf bytes.Buffer
 
 	// Marshal the metrics to JSON.
 	_, err := easyjson.MarshalToWriter(metrics, &jsonBuf)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to marshal metrics")
-		return nil, false, err
+		return nil, "", err
+	}
+
+	// If the metrics are small, we don't need to compress them.
+	if !c.compresses(jsonBuf.Len()) {
+		return &jsonBuf, "", nil
 	}
 
-	// If the metrics is less than 1024 bytes, we don't need to compress it.
-	if jsonBuf.Len() <= 1024 {
-		return &jsonBuf, false, nil
+	codec, ok := compression.Lookup(c.Codec)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %q", compression.ErrUnsupported, c.Codec)
 	}
 
 	var buf bytes.Buffer
 
-	// Create a gzip writer.
-	gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	// Create a writer of the codec.
+	w, err := codec.NewWriter(&buf, c.Level)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create gzip writer")
-		return nil, false, err
+		log.Error().Err(err).Str("codec", codec.Name()).Msg("Failed to create compress writer")
+		return nil, "", err
 	}
-	defer func() {
-		err := gz.Close()
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to close gzip writer")
-		}
-	}()
 
-	// Write the metrics to the gzip writer.
-	_, err = gz.Write(jsonBuf.Bytes())
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to write gzip data")
-		return nil, false, err
+	// Write the metrics to the writer.
+	if _, err := w.Write(jsonBuf.Bytes()); err != nil {
+		log.Error().Err(err).Msg("Failed to write compressed data")
+		return nil, "", err
+	}
+
+	if err := w.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close compress writer")
+		return nil, "", err
 	}
 
-	return &buf, true, nil
+	return &buf, codec.Name(), nil
+}
+
+// compresses reports whether a body of size bytes is compressed.
+func (c Compression) compresses(size int) bool {
+	return c.Codec != "" && c.Codec != compression.Identity && size > c.Threshold
 }
 
 // This func is used to collect metrics from the system every pollInterval seconds.
@@ -363,15 +511,31 @@ func (ag *Agent) SendAllMetricsGRPC(ctx context.Context, client pb.MetricsServic
 	h := hex.EncodeToString(hashBytes)
 
 	// Create a metadata for the metrics.
-	md := metadata.New(map[string]string{"HashSHA256": h})
+	md := metadata.New(map[string]string{"HashSHA256": h, ratelimit.AgentHeader: agentID()})
+
+	// Sign the request.
+	if key != "" {
+		signature, err := signatureHeaders(key, ag.Signing.Algorithm, http.MethodPost, pb.MetricsService_UpdateMetrics_FullMethodName, data)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to sign request")
+			return
+		}
+		md = metadata.Join(md, metadata.New(signature), metadata.New(ag.Signing.headers()))
+	}
 
 	// Create a context for the metrics.
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
+	// Compress the request as the batches sent over HTTP.
+	var opts []grpc.CallOption
+	if ag.Compression.compresses(len(data)) {
+		opts = append(opts, grpc.UseCompressor(ag.Compression.Codec))
+	}
+
 	// Send the metrics to the server.
 	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
 		Metrics: metricsToProto,
-	})
+	}, opts...)
 	if err != nil {
 		_ = status.Errorf(codes.Internal, "failed to send metrics")
 		log.Error().Err(err).Msg("failed to send metrics")
@@ -2,12 +2,26 @@ package agent_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	agent "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/agent"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
 	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
 	auc "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/agent"
-	"github.com/stretchr/testify/assert"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
 )
 
 func TestAgent_UpdateAllMetrics(t *testing.T) {
@@ -48,3 +62,89 @@ func TestAgent_UpdateAllMetrics(t *testing.T) {
 	})
 
 }
+
+func TestAgent_SendAllMetrics_RetryAfter(t *testing.T) {
+	if _, err := agent.GetOutboundIP(); err != nil {
+		t.Skipf("no outbound ip: %v", err)
+	}
+
+	var (
+		requests atomic.Int32
+		agentIDs = make(chan string, 2)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentIDs <- r.Header.Get(ratelimit.AgentHeader)
+		if requests.Add(1) == 1 {
+			w.Header().Set(ratelimit.RetryAfterHeader, "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	metricStorage := repo.NewMemStorage()
+	require.NoError(t, metricStorage.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.0))
+	ag := agent.NewAgent(auc.NewAgentUsecase(metricStorage, metricStorage))
+
+	start := time.Now()
+	ag.SendAllMetrics(ctx, resty.New().SetBaseURL(srv.URL), "")
+
+	// The retry waits the second the server asked for, not the 100ms backoff.
+	assert.Equal(t, int32(2), requests.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.NotEmpty(t, <-agentIDs)
+}
+
+func TestConvertToCompressedData(t *testing.T) {
+	value := 1.5
+	small := serialize.MetricsList{{ID: "Alloc", MType: models.GaugeType, Value: &value}}
+
+	large := make(serialize.MetricsList, 100)
+	for i := range large {
+		large[i] = serialize.Metric{ID: fmt.Sprintf("Metric%d", i), MType: models.GaugeType, Value: &value}
+	}
+
+	tests := []struct {
+		name         string
+		metrics      serialize.MetricsList
+		compression  agent.Compression
+		wantEncoding string
+	}{
+		{name: "default", metrics: large, compression: agent.DefaultCompression(), wantEncoding: compression.Zstd},
+		{name: "below threshold", metrics: small, compression: agent.DefaultCompression()},
+		{
+			name:         "brotli",
+			metrics:      large,
+			compression:  agent.Compression{Codec: compression.Brotli, Level: 4, Threshold: 1024},
+			wantEncoding: compression.Brotli,
+		},
+		{
+			name:         "gzip without threshold",
+			metrics:      small,
+			compression:  agent.Compression{Codec: compression.Gzip, Level: compression.FastestLevel},
+			wantEncoding: compression.Gzip,
+		},
+		{name: "identity", metrics: large, compression: agent.Compression{Codec: compression.Identity}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, encoding, err := agent.ConvertToCompressedData(tt.metrics, tt.compression)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEncoding, encoding)
+
+			body, err := compression.NewReader(io.NopCloser(buf), encoding)
+			require.NoError(t, err)
+			defer body.Close()
+
+			var got serialize.MetricsList
+			require.NoError(t, json.NewDecoder(body).Decode(&got))
+			assert.Equal(t, tt.metrics, got)
+		})
+	}
+
+	_, _, err := agent.ConvertToCompressedData(large, agent.Compression{Codec: "lz4"})
+	assert.ErrorIs(t, err, compression.ErrUnsupported)
+}
@@ -0,0 +1,124 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/snapshot"
+)
+
+const (
+	// MaxRestoreSize is the largest accepted snapshot, as it is sent.
+	// A signed snapshot is read into memory to check the signature.
+	MaxRestoreSize = 64 << 20
+	// MaxRestoreDecompressedSize is the largest accepted snapshot once
+	// decompressed. It is decoded metric by metric, so the decoded metrics
+	// are held in memory, not the JSON document.
+	MaxRestoreDecompressedSize = 256 << 20
+)
+
+// RestoreResult is the response of the restore endpoint.
+type RestoreResult struct {
+	Mode     string `json:"mode"`
+	Restored int    `json:"restored"`
+}
+
+// @Title Snapshot
+// @Description Stream a consistent gzip-compressed snapshot of all metrics of a tenant
+// @Tags admin
+// @Produces application/gzip
+// @Param tenant query string false "Tenant, the default one if empty"
+// @Success 200 {file} file "Snapshot, a gzip-compressed JSON list of metrics"
+// @Failure 400 {string} string "Invalid tenant"
+// @Failure 401 {string} string "Admin credentials required"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/snapshot [GET]
+func (srv *Server) Snapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := srv.MetricUsecase.Snapshot(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to take snapshot")
+			http.Error(w, "failed to take snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		filename := "metrics-snapshot-" + time.Now().UTC().Format("20060102T150405Z") + snapshot.FileExt
+
+		w.Header().Set("Content-Type", snapshot.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Header().Set("X-Metrics-Count", strconv.Itoa(len(metrics)))
+
+		// The status is already sent, a failure can only cut the stream.
+		if err := snapshot.Write(w, metrics); err != nil {
+			log.Error().Err(err).Msg("failed to write snapshot")
+			return
+		}
+
+		log.Info().Int("metrics", len(metrics)).Msg("snapshot sent")
+	}
+}
+
+// @Title Restore
+// @Description Load a snapshot, merging it into the current metrics (mode=merge, default)
+// @Description or replacing them (mode=replace)
+// @Tags admin
+// @Accept application/gzip
+// @Accept application/json
+// @Produces application/json
+// @Param mode query string false "merge or replace"
+// @Param tenant query string false "Tenant, the default one if empty"
+// @Success 200 {object} RestoreResult
+// @Failure 400 {string} string "Invalid snapshot, mode or tenant"
+// @Failure 401 {string} string "Admin credentials required"
+// @Failure 413 {string} string "Snapshot too large"
+// @Failure 501 {string} string "Storage doesn't support the mode"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/restore [POST]
+func (srv *Server) Restore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := srvUsecase.RestoreMode(r.URL.Query().Get("mode"))
+		if mode == "" {
+			mode = srvUsecase.RestoreMerge
+		}
+
+		if mode != srvUsecase.RestoreMerge && mode != srvUsecase.RestoreReplace {
+			http.Error(w, fmt.Sprintf("invalid mode %q, must be merge or replace", mode), http.StatusBadRequest)
+			return
+		}
+
+		metrics, err := snapshot.ReadLimit(r.Body, MaxRestoreDecompressedSize)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid snapshot")
+			if tooLarge(err) {
+				http.Error(w, "snapshot too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := srv.MetricUsecase.Restore(r.Context(), metrics, mode); err != nil {
+			log.Error().Err(err).Str("mode", string(mode)).Msg("failed to restore snapshot")
+
+			status := updateStatus(err, http.StatusInternalServerError)
+			if errors.Is(err, srvUsecase.ErrReplaceUnsupported) || errors.Is(err, srvUsecase.ErrMergeUnsupported) {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, fmt.Sprintf("failed to restore snapshot: %v", err), status)
+			return
+		}
+
+		log.Info().Int("metrics", len(metrics)).Str("mode", string(mode)).Msg("snapshot restored")
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RestoreResult{Mode: string(mode), Restored: len(metrics)}); err != nil {
+			log.Error().Err(err).Msg("failed to encode json")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// WithLimits sets the limits of request bodies and batches.
+func (srv *Server) WithLimits(limits admission.Limits) *Server {
+	srv.Limits = limits
+	return srv
+}
+
+// WithBodyLimit is an HTTP middleware that limits the request body to max
+// bytes, as it is sent. Reading past the limit fails with
+// *http.MaxBytesError, and the handlers reply 413 Request Entity Too Large.
+//
+// A route may set its own limit with another WithBodyLimit: the innermost
+// limit wins, unless a middleware in between has already read the body.
+func WithBodyLimit(max int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				body := r.Body
+				if limited, ok := body.(*limitedBody); ok {
+					body = limited.body
+				}
+				r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, body, max), body: body}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody is a request body limited by WithBodyLimit.
+type limitedBody struct {
+	io.ReadCloser
+	// body is the unlimited body.
+	body io.ReadCloser
+}
+
+// WithLoadShedding is an HTTP middleware that serves at most max requests
+// at once and rejects the others with 503 Service Unavailable, instead of
+// queueing them. If max is not positive, it returns next as is.
+func WithLoadShedding(max int) func(http.Handler) http.Handler {
+	gate := admission.NewGate(max)
+
+	return func(next http.Handler) http.Handler {
+		if gate == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !gate.Enter() {
+				log.Warn().Int("max_in_flight", max).Msg("shedding request")
+				w.Header().Set("Retry-After", "1")
+				httpError(w, r, "server is overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			defer gate.Leave()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestBody returns the request body, decompressed by the codec of its
+// Content-Encoding and then limited to MaxDecompressedSize. A body without
+// a codec fails with compression.ErrUnsupported. The caller must close it.
+func (srv *Server) requestBody(req *http.Request) (io.ReadCloser, error) {
+	return srv.decodeBody(req.Body, req.Header.Get("Content-Encoding"), srv.Limits.MaxDecompressedSize)
+}
+
+// decodeBody decompresses body by the Content-Encoding encoding, limited
+// to max bytes after decompression.
+func (srv *Server) decodeBody(body io.ReadCloser, encoding string, max int64) (io.ReadCloser, error) {
+	decoded, err := compression.NewReader(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if decoded == body {
+		return body, nil
+	}
+
+	return &decodedBody{Reader: admission.LimitReader(decoded, max), decoder: decoded}, nil
+}
+
+// decodedBody is a decompressed request body.
+type decodedBody struct {
+	io.Reader
+	decoder io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	return b.decoder.Close()
+}
+
+// writeDecodeError replies to a body requestBody failed to decode: 415 for
+// an unsupported Content-Encoding, 400 for a corrupt body.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, compression.ErrUnsupported) {
+		http.Error(w, "Content-Encoding must be one of identity, "+strings.Join(compression.Names(), ", "),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	http.Error(w, "failed to decode request body", http.StatusBadRequest)
+}
+
+// closeBody closes a body returned by requestBody.
+func closeBody(body io.Closer) {
+	if err := body.Close(); err != nil {
+		log.Error().Err(err).Msg("failed close body decoder")
+	}
+}
+
+// decompressedLimit returns the smaller of max and MaxDecompressedSize.
+func (srv *Server) decompressedLimit(max int64) int64 {
+	if limit := srv.Limits.MaxDecompressedSize; limit > 0 && limit < max {
+		return limit
+	}
+
+	return max
+}
+
+// tooLarge reports whether err is a failure to read a body over the
+// limit, before or after decompression.
+func tooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, admission.ErrTooLarge)
+}
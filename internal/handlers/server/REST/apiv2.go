@@ -0,0 +1,604 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/content"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// Error codes of the /api/v2 error envelope.
+const (
+	CodeInvalidArgument      = "invalid_argument"
+	CodeUnauthenticated      = "unauthenticated"
+	CodePermissionDenied     = "permission_denied"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTooLarge             = "too_large"
+	CodeResourceExhausted    = "resource_exhausted"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal"
+)
+
+// APIError is the error of an /api/v2 response.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ErrorResponse is the envelope every /api/v2 error is returned in.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// MetricV2 is a metric of the /api/v2 API.
+//
+// ID is the full metric name, Name and Labels are its parts (see models.SplitName).
+// On update either ID or Name with optional Labels identifies the metric.
+type MetricV2 struct {
+	ID     string        `json:"id"`
+	Name   string        `json:"name,omitempty"`
+	Labels models.Labels `json:"labels,omitempty"`
+	Type   string        `json:"type"`
+	Delta  *int64        `json:"delta,omitempty"`
+	Value  *float64      `json:"value,omitempty"`
+}
+
+// MetricsPage is a page of the metrics list.
+type MetricsPage struct {
+	Metrics    []MetricV2 `json:"metrics"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// UpdateRequest is the body of the batch update.
+type UpdateRequest struct {
+	Metrics []MetricV2 `json:"metrics"`
+}
+
+// UpdateResult is the response of the batch update.
+type UpdateResult struct {
+	Updated int `json:"updated"`
+}
+
+// StatusResponse is the response of the health check.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+func newMetricV2(metric models.Metric) (MetricV2, error) {
+	name, labels := models.SplitName(metric.Name())
+
+	m := MetricV2{
+		ID:     metric.Name(),
+		Name:   name,
+		Labels: labels,
+		Type:   metric.Type(),
+	}
+
+	switch v := metric.Value().(type) {
+	case int64:
+		m.Delta = &v
+	case float64:
+		m.Value = &v
+	default:
+		return MetricV2{}, fmt.Errorf("%w: %T", models.ErrInvalidValueType, v)
+	}
+
+	return m, nil
+}
+
+// id returns the full metric name.
+func (m *MetricV2) id() string {
+	if m.ID != "" || m.Name == "" {
+		return m.ID
+	}
+
+	return models.JoinName(m.Name, m.Labels)
+}
+
+// value returns the value of the metric, checking it matches the type.
+func (m *MetricV2) value() (any, error) {
+	switch m.Type {
+	case models.CounterType:
+		if m.Delta == nil || m.Value != nil {
+			return nil, errors.New("counter needs delta and no value")
+		}
+		return *m.Delta, nil
+	case models.GaugeType:
+		if m.Value == nil || m.Delta != nil {
+			return nil, errors.New("gauge needs value and no delta")
+		}
+		return *m.Value, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidMetricsType, m.Type)
+	}
+}
+
+func (m *MetricV2) metric() (models.Metric, error) {
+	id := m.id()
+	if id == "" {
+		return nil, errors.New("metric id or name is required")
+	}
+
+	value, err := m.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Type == models.CounterType {
+		return models.NewCounter(id, value.(int64)), nil
+	}
+
+	return models.NewGauge(id, value.(float64)), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed to encode json")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, details map[string]string) {
+	writeJSON(w, status, ErrorResponse{Error: APIError{Code: code, Message: message, Details: details}})
+}
+
+// httpError replies to r like http.Error, but in the error envelope if r
+// is an /api/v2 request, so that the middlewares in front of the API v2
+// keep its error format.
+func httpError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !isAPIV2(r.URL.Path) {
+		http.Error(w, message, status)
+		return
+	}
+
+	writeError(w, status, errorCode(status), message, nil)
+}
+
+// isAPIV2 reports whether path is an /api/v2 route, of the server or of a
+// tenant under "/tenants/{tenant}".
+func isAPIV2(path string) bool {
+	if rest, ok := strings.CutPrefix(path, "/tenants/"); ok {
+		_, path, _ = strings.Cut(rest, "/")
+		path = "/" + path
+	}
+
+	return path == "/api/v2" || strings.HasPrefix(path, "/api/v2/")
+}
+
+// errorCode returns the error code of the envelope for an HTTP status.
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return CodeResourceExhausted
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// negotiateDocument returns the format of a document chosen by the Accept
+// header. If none is acceptable, it writes the error response and returns
+// false; errors are always JSON.
+func negotiateDocument(w http.ResponseWriter, req *http.Request) (content.Format, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	format, err := content.Negotiate(req.Header.Get("Accept"), documentFormats...)
+	if err != nil {
+		writeError(w, http.StatusNotAcceptable, CodeNotAcceptable, "no acceptable content type",
+			map[string]string{"accept": contentTypes(documentFormats)})
+		return "", false
+	}
+
+	return format, true
+}
+
+// writeDocument writes v in the negotiated format of the document.
+func writeDocument(w http.ResponseWriter, format content.Format, status int, v any) {
+	if format == content.JSON {
+		writeJSON(w, status, v)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := content.WriteValue(&buf, format, v); err != nil {
+		log.Error().Err(err).Str("format", string(format)).Msg("failed to encode document")
+		writeError(w, http.StatusInternalServerError, CodeInternal, "failed to encode response", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.WriteHeader(status)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+// readJSON decodes a JSON body, which may be gzip-compressed, into v.
+// On failure it writes the error response and returns false.
+func (srv *Server) readJSON(w http.ResponseWriter, req *http.Request, v any) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			"Content-Type must be application/json", nil)
+		return false
+	}
+
+	body, err := srv.requestBody(req)
+	if err != nil {
+		if errors.Is(err, compression.ErrUnsupported) {
+			writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, err.Error(), nil)
+			return false
+		}
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid compressed body", nil)
+		return false
+	}
+	defer closeBody(body)
+
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		if tooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeTooLarge, "request too large", nil)
+			return false
+		}
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid json body",
+			map[string]string{"reason": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// EncodeCursor returns the opaque page cursor of the key.
+func EncodeCursor(key srvUsecase.MetricKey) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key.Type + "/" + key.Name))
+}
+
+// DecodeCursor parses a page cursor returned by EncodeCursor.
+func DecodeCursor(cursor string) (srvUsecase.MetricKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return srvUsecase.MetricKey{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	mType, mName, ok := strings.Cut(string(raw), "/")
+	if !ok {
+		return srvUsecase.MetricKey{}, errors.New("invalid cursor")
+	}
+
+	return srvUsecase.MetricKey{Type: mType, Name: mName}, nil
+}
+
+// metricNameParam returns the unescaped metric name of the route,
+// since chi matches the raw path if the name has escaped labels.
+func metricNameParam(req *http.Request) string {
+	name := chi.URLParam(req, "mName")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+
+	return name
+}
+
+// parseListFilter builds the list filter from the query parameters.
+func parseListFilter(req *http.Request) (srvUsecase.ListFilter, map[string]string) {
+	query := req.URL.Query()
+
+	filter := srvUsecase.ListFilter{
+		Type:        query.Get("type"),
+		NamePattern: query.Get("name"),
+	}
+
+	for _, label := range query["label"] {
+		key, pattern, ok := strings.Cut(label, ":")
+		if !ok {
+			pattern = "*"
+		}
+		if key == "" {
+			return filter, map[string]string{"label": "label filter must be key:pattern or key"}
+		}
+
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[key] = pattern
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, map[string]string{"limit": "limit must be a positive integer"}
+		}
+		filter.Limit = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		key, err := DecodeCursor(cursor)
+		if err != nil {
+			return filter, map[string]string{"cursor": err.Error()}
+		}
+		filter.After = &key
+	}
+
+	return filter, nil
+}
+
+// @Title ListMetricsV2
+// @Description List metrics ordered by type and name, page by page
+// @Tags v2
+// @Produces application/json
+// @Produces application/msgpack
+// @Param type query string false "Metric type"
+// @Param name query string false "Glob of the metric name without labels"
+// @Param label query string false "Label filter key:glob or key, repeatable"
+// @Param limit query int false "Page size, 100 by default, at most 1000"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} MetricsPage
+// @Failure 400 {object} ErrorResponse "Invalid filter"
+// @Failure 406 {object} ErrorResponse "No acceptable content type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v2/metrics [GET]
+func (srv *Server) ListMetricsV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		filter, details := parseListFilter(req)
+		if details != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid filter", details)
+			return
+		}
+
+		format, ok := negotiateDocument(w, req)
+		if !ok {
+			return
+		}
+
+		page, err := srv.MetricUsecase.ListMetrics(req.Context(), filter)
+		if err != nil {
+			if errors.Is(err, srvUsecase.ErrInvalidFilter) {
+				writeError(w, http.StatusBadRequest, CodeInvalidArgument, err.Error(), nil)
+				return
+			}
+
+			log.Error().Err(err).Msg("failed to list metrics")
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to list metrics", nil)
+			return
+		}
+
+		resp := MetricsPage{Metrics: make([]MetricV2, 0, len(page.Metrics))}
+		for _, metric := range page.Metrics {
+			m, err := newMetricV2(metric)
+			if err != nil {
+				log.Error().Err(err).Str("metric", metric.Name()).Msg("failed to convert metric")
+				writeError(w, http.StatusInternalServerError, CodeInternal, "failed to convert metrics", nil)
+				return
+			}
+			resp.Metrics = append(resp.Metrics, m)
+		}
+
+		if page.Next != nil {
+			resp.NextCursor = EncodeCursor(*page.Next)
+		}
+
+		writeDocument(w, format, http.StatusOK, resp)
+	}
+}
+
+// @Title GetMetricV2
+// @Description Get a metric by type and name
+// @Tags v2
+// @Produces application/json
+// @Produces application/msgpack
+// @Param mType path string true "Metric type"
+// @Param mName path string true "Metric name, with labels"
+// @Success 200 {object} MetricV2
+// @Failure 400 {object} ErrorResponse "Invalid metric type"
+// @Failure 404 {object} ErrorResponse "Metric not found"
+// @Failure 406 {object} ErrorResponse "No acceptable content type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v2/metrics/{mType}/{mName} [GET]
+func (srv *Server) GetMetricV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		mType := chi.URLParam(req, "mType")
+		mName := metricNameParam(req)
+
+		if mType != models.GaugeType && mType != models.CounterType {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid metric type",
+				map[string]string{"type": mType})
+			return
+		}
+
+		format, ok := negotiateDocument(w, req)
+		if !ok {
+			return
+		}
+
+		metric, err := srv.MetricUsecase.GetMetric(req.Context(), mType, mName)
+		if err != nil {
+			if errors.Is(err, models.ErrMetricsNotFound) {
+				writeError(w, http.StatusNotFound, CodeNotFound, "metric not found",
+					map[string]string{"type": mType, "id": mName})
+				return
+			}
+
+			log.Error().Err(err).Msg("can't get metric")
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to get metric", nil)
+			return
+		}
+
+		m, err := newMetricV2(metric)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to convert metric")
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to convert metric", nil)
+			return
+		}
+
+		writeDocument(w, format, http.StatusOK, m)
+	}
+}
+
+// @Title UpdateMetricV2
+// @Description Update a metric by type and name, with {"value": ...} for gauges
+// @Description and {"delta": ...} for counters
+// @Tags v2
+// @Accept application/json
+// @Produces application/json
+// @Param mType path string true "Metric type"
+// @Param mName path string true "Metric name, with labels"
+// @Success 200 {object} MetricV2 "The updated metric"
+// @Failure 400 {object} ErrorResponse "Invalid metric"
+// @Failure 413 {object} ErrorResponse "Request too large"
+// @Failure 415 {object} ErrorResponse "Unsupported media type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v2/metrics/{mType}/{mName} [POST]
+func (srv *Server) UpdateMetricV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var m MetricV2
+		if !srv.readJSON(w, req, &m) {
+			return
+		}
+
+		m.Type = chi.URLParam(req, "mType")
+		m.ID = metricNameParam(req)
+
+		value, err := m.value()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid metric",
+				map[string]string{"type": m.Type, "id": m.ID, "reason": err.Error()})
+			return
+		}
+
+		if err := srv.MetricUsecase.UpdateMetric(req.Context(), m.Type, m.ID, value); err != nil {
+			log.Error().Err(err).Str("metric", m.ID).Msg("failed to update metric")
+			if errors.Is(err, apitoken.ErrForbidden) {
+				writeError(w, http.StatusForbidden, CodePermissionDenied, err.Error(), nil)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to update metric", nil)
+			return
+		}
+
+		srv.GetMetricV2()(w, req)
+	}
+}
+
+// @Title UpdateMetricsV2
+// @Description Update a list of metrics at once
+// @Tags v2
+// @Accept application/json
+// @Produces application/json
+// @Success 200 {object} UpdateResult
+// @Failure 400 {object} ErrorResponse "Invalid metrics, details name the first invalid one"
+// @Failure 413 {object} ErrorResponse "Request too large or too many metrics"
+// @Failure 415 {object} ErrorResponse "Unsupported media type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/v2/metrics [POST]
+func (srv *Server) UpdateMetricsV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body UpdateRequest
+		if !srv.readJSON(w, req, &body) {
+			return
+		}
+
+		if err := srv.Limits.CheckBatch(len(body.Metrics)); err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeTooLarge, err.Error(), nil)
+			return
+		}
+
+		metrics := make([]models.Metric, 0, len(body.Metrics))
+		for i := range body.Metrics {
+			metric, err := body.Metrics[i].metric()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid metric", map[string]string{
+					"index":  strconv.Itoa(i),
+					"reason": err.Error(),
+				})
+				return
+			}
+			metrics = append(metrics, metric)
+		}
+
+		if err := srv.MetricUsecase.UpdateMetricList(req.Context(), metrics); err != nil {
+			log.Error().Err(err).Msg("failed update metrics")
+			if errors.Is(err, apitoken.ErrForbidden) {
+				writeError(w, http.StatusForbidden, CodePermissionDenied, err.Error(), nil)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to update metrics", nil)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, UpdateResult{Updated: len(metrics)})
+	}
+}
+
+// @Title PingV2
+// @Description Check the storage is available
+// @Tags v2
+// @Produces application/json
+// @Success 200 {object} StatusResponse
+// @Failure 503 {object} ErrorResponse "Storage unavailable"
+// @Router /api/v2/ping [GET]
+func (srv *Server) PingV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if srv.PingUsecase != nil {
+			if err := srv.PingUsecase.Check(req.Context()); err != nil {
+				log.Error().Err(err).Msg("storage is unavailable")
+				writeError(w, http.StatusServiceUnavailable, CodeUnavailable, "storage is unavailable", nil)
+				return
+			}
+		}
+
+		writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+	}
+}
+
+// NotFoundV2 answers unknown /api/v2 routes with the error envelope.
+func NotFoundV2(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint",
+		map[string]string{"path": req.URL.Path})
+}
+
+// MethodNotAllowedV2 answers unsupported methods of /api/v2 routes with the error envelope.
+func MethodNotAllowedV2(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed",
+		map[string]string{"method": req.Method})
+}
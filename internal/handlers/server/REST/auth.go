@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// WithAdminAuth returns an HTTP middleware that lets through only administrators.
+//
+// A request is authorized if it carries the admin token as
+// "Authorization: Bearer <token>" or if it has a canonical signature with
+// a key of keys (see WithHashing). The timestamp and nonce of the canonical
+// signature keep it from being replayed, so the "HashSHA256" body signature
+// is never accepted here: the body of a GET request is empty and its
+// signature would be the same for every request.
+// A nil keyring or an empty token disables the corresponding credential;
+// if both are disabled, only API tokens are accepted.
+//
+// An API token authenticated by WithToken must grant the admin scope,
+// otherwise the request is rejected with 403 Forbidden.
+//
+// A signed body is read into memory to be checked, so the route must limit
+// it with WithBodyLimit; a body over the limit gets 413.
+func WithAdminAuth(keys *hash.Keyring, token string, verifier *hash.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := apitoken.FromContext(r.Context()); ok {
+				if !t.Allows(apitoken.ScopeAdmin) {
+					log.Error().Str("token", t.ID).Msg("api token is not an admin token")
+					http.Error(w, "api token lacks scope "+string(apitoken.ScopeAdmin), http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if auth := r.Header.Get("Authorization"); auth != "" {
+				bearer, ok := strings.CutPrefix(auth, "Bearer ")
+				if !ok || token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+					log.Error().Msg("invalid admin token")
+					http.Error(w, "invalid admin token", http.StatusUnauthorized)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if keys.Len() == 0 || r.Header.Get(hash.SignatureHeader) == "" {
+				http.Error(w, "admin credentials required", http.StatusUnauthorized)
+				return
+			}
+
+			key, err := keys.Lookup(r.Header.Get(hash.KeyIDHeader))
+			if err != nil {
+				log.Error().Err(err).Msg("invalid admin key")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = io.ReadAll(r.Body)
+				if err != nil {
+					log.Error().Err(err).Msg("failed read body")
+					if tooLarge(err) {
+						http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+						return
+					}
+					http.Error(w, "failed read body", http.StatusInternalServerError)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewBuffer(body))
+			}
+
+			if err := verifySignature(verifier, key, r, body); err != nil {
+				log.Error().Err(err).Msg("invalid admin signature")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/importer"
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/bulk"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+const (
+	// ExportFlushRows is the number of metrics written between flushes of an export.
+	ExportFlushRows = 1000
+	// MaxImportSize is the largest accepted import body.
+	MaxImportSize = 256 << 20
+	// MaxReportedRowErrors is the number of rejected rows listed in an import response.
+	MaxReportedRowErrors = 100
+)
+
+// ImportResult is the response of the import endpoint.
+type ImportResult struct {
+	Rows     int                 `json:"rows"`
+	Imported int                 `json:"imported"`
+	Rejected int                 `json:"rejected"`
+	Errors   []LineErrorResponse `json:"errors,omitempty"`
+	// Error is set if the import failed, the rows counted before it are stored.
+	Error string `json:"error,omitempty"`
+}
+
+// writtenWriter reports whether anything was written to the response.
+type writtenWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (w *writtenWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.w.Write(b)
+}
+
+// @Title Export
+// @Description Stream the metrics matching the filter as CSV (id,type,value) or NDJSON
+// @Tags metrics
+// @Produces text/csv
+// @Produces application/x-ndjson
+// @Param format query string false "csv (default) or ndjson"
+// @Param type query string false "Metric type"
+// @Param name query string false "Glob of the metric name without labels"
+// @Param label query string false "Label filter key:glob or key, repeatable"
+// @Success 200 {file} file "Metrics in the order of the storage"
+// @Failure 400 {string} string "Invalid format or filter"
+// @Failure 500 {string} string "Internal server error"
+// @Router /export [GET]
+func (srv *Server) Export() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := bulk.CSV
+		if f := r.URL.Query().Get("format"); f != "" {
+			var err error
+			if format, err = bulk.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// details hold the first invalid parameter.
+		filter, details := parseListFilter(r)
+		for _, msg := range details {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		filename := "metrics-export-" + time.Now().UTC().Format("20060102T150405Z") + "." + string(format)
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		out := &writtenWriter{w: w}
+		enc := bulk.NewEncoder(out, format)
+		rc := http.NewResponseController(w)
+
+		var count int
+		err := srv.MetricUsecase.EachMetric(r.Context(), filter, func(metric models.Metric) error {
+			if err := enc.Encode(metric); err != nil {
+				return err
+			}
+
+			count++
+			if count%ExportFlushRows != 0 {
+				return nil
+			}
+
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			return nil
+		})
+		if err == nil {
+			err = enc.Flush()
+		}
+
+		if err != nil {
+			log.Error().Err(err).Int("metrics", count).Msg("failed to export metrics")
+
+			// Once the status is sent, a failure can only cut the stream.
+			if out.written {
+				return
+			}
+
+			w.Header().Del("Content-Disposition")
+			if errors.Is(err, srvUsecase.ErrInvalidFilter) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to export metrics", http.StatusInternalServerError)
+			return
+		}
+
+		log.Debug().Int("metrics", count).Str("format", string(format)).Msg("metrics exported")
+	}
+}
+
+// @Title Import
+// @Description Import metrics in the export formats, CSV (id,type,value) or NDJSON.
+// @Description Gauges are set and counters are added to, like /updates/.
+// @Tags metrics
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produces application/json
+// @Param format query string false "csv or ndjson, the Content-Type by default"
+// @Success 200 {object} ImportResult "All rows imported"
+// @Failure 400 {object} ImportResult "Invalid body or rejected rows, the valid rows are imported"
+// @Failure 413 {string} string "Request too large"
+// @Failure 415 {string} string "Unknown format"
+// @Failure 500 {object} ImportResult "Internal server error"
+// @Router /import [POST]
+func (srv *Server) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, ok := bulk.FormatFromContentType(r.Header.Get("Content-Type"))
+		if f := r.URL.Query().Get("format"); f != "" {
+			var err error
+			if format, err = bulk.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+		} else if !ok {
+			http.Error(w, "format must be set with ?format= or Content-Type text/csv or application/x-ndjson",
+				http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, MaxImportSize)
+
+		result, err := srv.ImportUsecase.Import(r.Context(), body, format)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to import metrics")
+
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesErr):
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			case errors.Is(err, importer.ErrReadBody):
+				writeJSON(w, http.StatusBadRequest, ImportResult{Error: err.Error()})
+			case errors.Is(err, apitoken.ErrForbidden):
+				writeJSON(w, http.StatusForbidden, ImportResult{Error: err.Error()})
+			default:
+				writeJSON(w, http.StatusInternalServerError, ImportResult{Error: err.Error()})
+			}
+			return
+		}
+
+		log.Debug().
+			Int("rows", result.Rows).
+			Int("imported", result.Imported).
+			Int("rejected", len(result.Errors)).
+			Str("format", string(format)).
+			Msg("metrics imported")
+
+		resp := ImportResult{
+			Rows:     result.Rows,
+			Imported: result.Imported,
+			Rejected: len(result.Errors),
+		}
+		for _, rowErr := range result.Errors[:min(len(result.Errors), MaxReportedRowErrors)] {
+			resp.Errors = append(resp.Errors, LineErrorResponse{Line: rowErr.Line, Error: rowErr.Err.Error()})
+		}
+
+		status := http.StatusOK
+		if len(result.Errors) != 0 {
+			status = http.StatusBadRequest
+			resp.Error = fmt.Sprintf("partial import: %d of %d rows rejected", len(result.Errors), result.Rows)
+		}
+		writeJSON(w, status, resp)
+	}
+}
@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+var supportedContentType = map[string]struct{}{
+	"application/json":               {},
+	"text/html; charset=utf-8":       {},
+	"text/css; charset=utf-8":        {},
+	"text/javascript; charset=utf-8": {},
+}
+
+// compressWriter wraps http.ResponseWriter and writes the response body
+// through the writer of the negotiated codec if the response content type
+// is supported.
+type compressWriter struct {
+	http.ResponseWriter
+	// Writer is the writer of codec, created once the response is known
+	// to be compressed.
+	Writer io.WriteCloser
+	codec  compression.Codec
+	// compressed reports whether the body is written through Writer.
+	compressed  bool
+	wroteHeader bool
+}
+
+// WriteHeader decides whether to compress the response by its Content-Type.
+// The headers must be final here: a handler calling WriteHeader itself
+// sends them before the first Write.
+func (w *compressWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		_, ok := supportedContentType[w.Header().Get("Content-Type")]
+		if ok {
+			// The body depends on Accept-Encoding, even if not compressed.
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		if ok && w.codec != nil && status != http.StatusNoContent && status != http.StatusNotModified {
+			writer, err := w.codec.NewWriter(w.ResponseWriter, compression.FastestLevel)
+			if err != nil {
+				log.Error().Err(err).Str("encoding", w.codec.Name()).Msg("Failed to create compress writer")
+			} else {
+				w.Header().Set("Content-Encoding", w.codec.Name())
+				// The length of the compressed body is not known in advance.
+				w.Header().Del("Content-Length")
+				w.Writer = writer
+				w.compressed = true
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write compresses the response if the Content-Type is supported.
+// If not supported, it writes the response without compression.
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.compressed {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.Writer.Write(b)
+}
+
+// Flush sends the response written so far, compressed data included.
+func (w *compressWriter) Flush() {
+	if flusher, ok := w.Writer.(interface{ Flush() error }); ok && w.compressed {
+		if err := flusher.Flush(); err != nil {
+			log.Error().Err(err).Msg("Failed to flush compress writer")
+			return
+		}
+	}
+
+	err := http.NewResponseController(w.ResponseWriter).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Error().Err(err).Msg("Failed to flush response")
+	}
+}
+
+// Unwrap returns the wrapped writer, so that http.ResponseController
+// reaches its other features, e.g. hijacking.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets the handler take over the connection, e.g. for a WebSocket.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// WithCompress is an HTTP middleware that compresses server responses with
+// the codec negotiated by the Accept-Encoding header (see
+// compression.Negotiate), at the fastest level of the codec.
+//
+// If the client accepts a registered codec and the response has a supported
+// Content-Type, the response body is compressed and Content-Encoding names
+// the codec. Otherwise, the response is passed through uncompressed. Either
+// way, a response of a supported Content-Type varies by Accept-Encoding.
+//
+// Request bodies are not decoded here, but by the handlers reading them:
+// the hash of a signed body is of the body as it is sent.
+func WithCompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// codec is nil if the client accepts no codec.
+		codec, _ := compression.Negotiate(req.Header.Values("Accept-Encoding")...)
+		writer := &compressWriter{
+			ResponseWriter: w,
+			codec:          codec,
+		}
+
+		defer func() {
+			if !writer.compressed {
+				return
+			}
+			if err := writer.Writer.Close(); err != nil {
+				log.Error().Err(err).Msg("Failed to close compress writer")
+			}
+		}()
+
+		next.ServeHTTP(writer, req)
+	})
+}
@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// notModified sets the ETag and Last-Modified of the storage version for
+// the representation variant and, if the If-None-Match of the request
+// matches the ETag, replies 304 Not Modified and returns true.
+//
+// It must be called before reading the metrics. Without a version, e.g.
+// for a storage that doesn't keep one, the response has no validators.
+//
+// If-Modified-Since is not honoured: Last-Modified has a one second
+// precision, and a change within the same second would go unnoticed.
+func (srv *Server) notModified(w http.ResponseWriter, r *http.Request, variant string) bool {
+	version, err := srv.MetricUsecase.Version(r.Context())
+	if err != nil {
+		if !errors.Is(err, srvUsecase.ErrVersionUnsupported) {
+			log.Error().Err(err).Msg("failed to get storage version")
+		}
+		return false
+	}
+
+	// The metrics visible to an API token depend on its name prefix.
+	if t, ok := apitoken.FromContext(r.Context()); ok && t.Prefix != "" {
+		variant += " prefix " + t.Prefix
+	}
+
+	etag := version.ETag(tenant.FromContext(r.Context()) + " " + variant)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", version.Modified.UTC().Format(http.TimeFormat))
+	// The metrics change any time, so caches must revalidate them.
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if !srvUsecase.MatchETag(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
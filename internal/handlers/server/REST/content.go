@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/content"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// Formats offered by the read endpoints, the first one is the default.
+var (
+	// metricFormats are the representations of a single metric or a list;
+	// an endpoint moves its default first with offer.
+	metricFormats = []content.Format{
+		content.JSON, content.Text, content.Protobuf, content.MsgPack, content.Prometheus, content.OpenMetrics,
+	}
+	// batchFormats are the representations of a batch lookup.
+	batchFormats = []content.Format{content.JSON, content.Protobuf, content.MsgPack}
+	// documentFormats are the representations of the /api/v2 documents.
+	documentFormats = []content.Format{content.JSON, content.MsgPack}
+)
+
+// offer returns the formats with def moved first, as the default.
+func offer(def content.Format, formats []content.Format) []content.Format {
+	offers := []content.Format{def}
+	for _, f := range formats {
+		if f != def {
+			offers = append(offers, f)
+		}
+	}
+
+	return offers
+}
+
+// negotiate returns the format of the response chosen by the Accept header
+// among the offers. If none is acceptable, it replies 406 Not Acceptable
+// with the offered content types and returns false.
+func negotiate(w http.ResponseWriter, r *http.Request, offers []content.Format) (content.Format, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	f, err := content.Negotiate(r.Header.Get("Accept"), offers...)
+	if err != nil {
+		http.Error(w, "acceptable content types: "+contentTypes(offers), http.StatusNotAcceptable)
+		return "", false
+	}
+
+	return f, true
+}
+
+func contentTypes(formats []content.Format) string {
+	types := make([]string, 0, len(formats))
+	for _, f := range formats {
+		types = append(types, f.ContentType())
+	}
+
+	return strings.Join(types, ", ")
+}
+
+// writeMetric writes a single metric in the format. The metric is encoded
+// before the status is sent, so a failure is still answered with 500.
+func writeMetric(w http.ResponseWriter, format content.Format, metric models.Metric) {
+	var buf bytes.Buffer
+	if err := content.WriteMetric(&buf, format, metric); err != nil {
+		log.Error().Err(err).Msg("failed to encode metric")
+		http.Error(w, "an unexpected type of metric", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Error().Msgf("Failed to write response: %v", err)
+	}
+}
@@ -3,97 +3,170 @@ package rest
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 )
 
 // hashResponseWriter wraps http.ResponseWriter and buffers the response body
 // to calculate and set an HMAC-SHA256 hash of the full response.
+//
+// Nothing is sent before finish, so it doesn't suit streamed responses:
+// their routes are registered outside WithHashing.
 type hashResponseWriter struct {
 	http.ResponseWriter
 	body *bytes.Buffer
 	key  []byte
+	// status is held back until finish, so the hash header is set
+	// before the headers are sent.
+	status int
 }
 
-// Write stores the written data in an internal buffer, calculates a new
-// HMAC-SHA256 hash of the accumulated response body (if a key is provided),
-// sets it as the "HashSHA256" header, and writes the data to the client.
-func (hsw *hashResponseWriter) Write(b []byte) (int, error) {
-	if size, err := hsw.body.Write(b); err != nil {
-		return size, fmt.Errorf("failed write body %v", err)
+// WriteHeader records the status, finish sends it.
+func (hsw *hashResponseWriter) WriteHeader(status int) {
+	if hsw.status == 0 {
+		hsw.status = status
 	}
+}
+
+// Write stores the written data in the buffer, finish sends it.
+func (hsw *hashResponseWriter) Write(b []byte) (int, error) {
+	return hsw.body.Write(b)
+}
 
-	if hsw.key != nil && hsw.body.Len() > 0 {
-		newHash, err := hash.GetHash(hsw.key, hsw.body.Bytes())
+// finish calculates the HMAC-SHA256 hash of the buffered body (if a key is
+// provided), sets it as the "HashSHA256" header and sends the response.
+// An empty body, e.g. of 304 Not Modified, is not signed.
+func (hsw *hashResponseWriter) finish() {
+	if len(hsw.key) > 0 && hsw.body.Len() > 0 {
+		bodyHash, err := hash.GetHash(hsw.key, hsw.body.Bytes())
 		if err != nil {
-			log.Error().Err(err).Msg("failed to get new hash")
-			http.Error(hsw.ResponseWriter, "failed to get new hash", http.StatusInternalServerError)
-			return 0, fmt.Errorf("failed to get new hash: %v", err)
+			log.Error().Err(err).Msg("failed to get response hash")
+			http.Error(hsw.ResponseWriter, "failed to get response hash", http.StatusInternalServerError)
+			return
 		}
 
-		hsw.Header().Set("HashSHA256", hex.EncodeToString(newHash))
+		hsw.Header().Set("HashSHA256", hex.EncodeToString(bodyHash))
 	}
 
-	n, err := hsw.ResponseWriter.Write(b)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to writer response")
-		return 0, err
+	if hsw.status != 0 {
+		hsw.ResponseWriter.WriteHeader(hsw.status)
+	}
+
+	if hsw.body.Len() == 0 {
+		return
 	}
 
-	return n, nil
+	if _, err := hsw.ResponseWriter.Write(hsw.body.Bytes()); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
 }
 
 // WithHashing returns an HTTP middleware that verifies request integrity and
 // attaches a response hash.
 //
-// For incoming requests: if the "HashSHA256" header is present, the middleware
-// checks that it matches the hash of the request body; otherwise, it rejects
-// the request with 400 Bad Request.
+// For incoming requests: the key is the key of keys named by the
+// hash.KeyIDHeader header, the key of the empty ID if it is absent; an
+// unknown or deprecated key rejects a signed request with 401 Unauthorized.
+// A request with the hash.SignatureHeader header is verified by its
+// canonical signature (see hash.Request), which covers the method, path,
+// query, body, timestamp and nonce, by the algorithm in the
+// hash.AlgorithmHeader header: verifier rejects it with 401 Unauthorized if
+// the signature is invalid, the timestamp is outside the clock skew window
+// or the nonce was already used. Otherwise, if the "HashSHA256" header is
+// present, the middleware checks that it matches the hash of the request
+// body; otherwise, it rejects the request with 400 Bad Request.
+//
+// If requireSignature is set, requests other than GET, HEAD and OPTIONS
+// must have a canonical signature, a body hash is not enough. The same
+// holds for the requests by a hash.Key with Canonical set, and by a key
+// verifier has verified a canonical signature with: its clients sign
+// canonically, so a request with only a body hash may be one of their
+// requests with the signature stripped, replayed.
+//
+// For outgoing responses: if the request has a key, the middleware
+// calculates an HMAC-SHA256 hash of the response body and sets it as the
+// "HashSHA256" header. Responses without a body, e.g. 304 Not Modified,
+// are not signed.
 //
-// For outgoing responses: if a key is provided, the middleware calculates
-// an HMAC-SHA256 hash of the response body and sets it as the "HashSHA256"
-// header.
-func WithHashing(key []byte) func(next http.Handler) http.Handler {
+// Requests authenticated with a tenant secret (see WithTenant) are checked
+// and signed with that secret instead of the keyring; WithTenant must share
+// verifier.
+func WithHashing(keys *hash.Keyring, verifier *hash.Verifier, requireSignature bool) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.Body != nil {
 				body, err := io.ReadAll(r.Body)
 				if err != nil {
+					if tooLarge(err) {
+						httpError(w, r, "request too large", http.StatusRequestEntityTooLarge)
+						return
+					}
 					log.Error().Err(err).Msg("failed read body")
-					http.Error(w, fmt.Sprintf("failed read body %v", err), http.StatusInternalServerError)
+					httpError(w, r, fmt.Sprintf("failed read body %v", err), http.StatusInternalServerError)
 					return
 				}
 
 				r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-				h := r.Header.Get("HashSHA256")
-				if h != "" {
-					decoded, err := hex.DecodeString(h)
-					if err != nil {
-						log.Error().Err(err).Msg("failed to decode hash")
-						http.Error(w, "invalid hash format", http.StatusBadRequest)
-						return
+				key, err := requestKey(keys, r)
+				if err != nil && signed(r) {
+					log.Error().Err(err).Msg("invalid request key")
+					httpError(w, r, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				secret := []byte(key.Secret)
+
+				// WithTenant verified the signature of a tenant, its
+				// nonce is used up.
+				verify := func() error {
+					if tenant.SignatureVerified(r.Context()) {
+						return nil
 					}
-					valid := hash.CheckHash(key, body, decoded)
-					if !valid {
-						log.Error().Msg("invalid hash message")
-						http.Error(w, "invalid hash message", http.StatusBadRequest)
+					return verifySignature(verifier, key, r, body)
+				}
 
-						return
+				switch err := verify(); {
+				case err == nil:
+				case !errors.Is(err, hash.ErrNotSigned):
+					log.Error().Err(err).Msg("invalid request signature")
+					httpError(w, r, err.Error(), http.StatusUnauthorized)
+					return
+				case !safeMethod(r.Method) && (requireSignature || key.Canonical || verifier.Signed(secret)):
+					httpError(w, r, "request signature required", http.StatusUnauthorized)
+					return
+				default:
+					h := r.Header.Get("HashSHA256")
+					if h != "" {
+						decoded, err := hex.DecodeString(h)
+						if err != nil {
+							log.Error().Err(err).Msg("failed to decode hash")
+							httpError(w, r, "invalid hash format", http.StatusBadRequest)
+							return
+						}
+						valid := hash.CheckHash(secret, body, decoded)
+						if !valid {
+							log.Error().Msg("invalid hash message")
+							httpError(w, r, "invalid hash message", http.StatusBadRequest)
+
+							return
+						}
 					}
 				}
 
 				hw := &hashResponseWriter{
 					ResponseWriter: w,
 					body:           bytes.NewBuffer(nil),
-					key:            key,
+					key:            secret,
 				}
 
 				next.ServeHTTP(hw, r)
+				hw.finish()
 				return
 			}
 
@@ -101,3 +174,67 @@ func WithHashing(key []byte) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+// requestKey returns the key of r: its tenant secret, or the key of keys
+// named by the hash.KeyIDHeader header.
+func requestKey(keys *hash.Keyring, r *http.Request) (hash.Key, error) {
+	if tenantKey, ok := tenant.KeyFromContext(r.Context()); ok {
+		return hash.Key{Secret: string(tenantKey)}, nil
+	}
+
+	return keys.Lookup(r.Header.Get(hash.KeyIDHeader))
+}
+
+// signed reports whether r has a canonical signature or a body hash.
+func signed(r *http.Request) bool {
+	return r.Header.Get(hash.SignatureHeader) != "" || r.Header.Get("HashSHA256") != ""
+}
+
+// verifySignature verifies the canonical signature of r with the body
+// body. It returns hash.ErrNotSigned if r has no signature.
+func verifySignature(verifier *hash.Verifier, key hash.Key, r *http.Request, body []byte) error {
+	req, signature, err := canonicalRequest(r, body)
+	if err != nil {
+		return err
+	}
+
+	if err := key.Allows(req.Algorithm); err != nil {
+		return err
+	}
+
+	return verifier.Verify([]byte(key.Secret), req, signature)
+}
+
+// canonicalRequest returns the signed part of r with the body body and its
+// canonical signature. It returns hash.ErrNotSigned if r has no signature.
+func canonicalRequest(r *http.Request, body []byte) (hash.Request, string, error) {
+	signature := r.Header.Get(hash.SignatureHeader)
+	if signature == "" {
+		return hash.Request{}, "", hash.ErrNotSigned
+	}
+
+	algorithm := r.Header.Get(hash.AlgorithmHeader)
+	if err := hash.ValidateAlgorithm(algorithm); err != nil {
+		return hash.Request{}, "", err
+	}
+
+	timestamp, err := hash.ParseTimestamp(r.Header.Get(hash.TimestampHeader))
+	if err != nil {
+		return hash.Request{}, "", err
+	}
+
+	return hash.Request{
+		Method:    r.Method,
+		Path:      r.URL.EscapedPath(),
+		Query:     r.URL.Query(),
+		Body:      body,
+		Timestamp: timestamp,
+		Nonce:     r.Header.Get(hash.NonceHeader),
+		Algorithm: algorithm,
+	}, signature, nil
+}
+
+// safeMethod reports whether method doesn't change metrics.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/influx"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/lineprotocol"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+const (
+	// MaxLineProtocolSize is the largest accepted line protocol body, decompressed.
+	MaxLineProtocolSize = 32 << 20
+	// MaxReportedLineErrors is the number of rejected lines listed in a response.
+	MaxReportedLineErrors = 100
+)
+
+// LineErrorResponse describes a rejected line.
+type LineErrorResponse struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// InfluxWriteError is the response of a partial write, the InfluxDB
+// {"error": "..."} body extended with the rejected lines.
+type InfluxWriteError struct {
+	Error string              `json:"error"`
+	Lines []LineErrorResponse `json:"lines,omitempty"`
+}
+
+// @Title InfluxWrite
+// @Description Ingest points in the InfluxDB line protocol, as sent by Telegraf
+// @Tags metrics
+// @Accept text/plain
+// @Produces application/json
+// @Param precision query string false "Timestamp precision: ns (default), us, ms, s, m or h"
+// @Success 204 "All lines stored"
+// @Failure 400 {object} InfluxWriteError "Invalid precision or rejected lines, the valid lines are stored"
+// @Failure 413 {string} string "Request too large"
+// @Failure 415 {string} string "Unsupported encoding"
+// @Failure 500 {string} string "Internal server error"
+// @Router /write [POST]
+func (srv *Server) InfluxWrite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		precision, err := lineprotocol.ParsePrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			writeInfluxError(w, http.StatusBadRequest, InfluxWriteError{Error: err.Error()})
+			return
+		}
+
+		body, err := srv.decodeBody(http.MaxBytesReader(w, r.Body, MaxLineProtocolSize),
+			r.Header.Get("Content-Encoding"), srv.decompressedLimit(MaxLineProtocolSize))
+		if err != nil {
+			if errors.Is(err, compression.ErrUnsupported) {
+				writeDecodeError(w, err)
+				return
+			}
+
+			log.Error().Err(err).Msg("failed to create body decoder")
+			writeInfluxError(w, http.StatusBadRequest, InfluxWriteError{Error: "invalid compressed body"})
+			return
+		}
+		defer closeBody(body)
+
+		result, err := srv.InfluxUsecase.Write(r.Context(), body, precision)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to apply line protocol write")
+
+			switch {
+			case tooLarge(err):
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			case errors.Is(err, influx.ErrLineTooLong), errors.Is(err, influx.ErrReadBody):
+				writeInfluxError(w, http.StatusBadRequest, InfluxWriteError{Error: err.Error()})
+			case errors.Is(err, apitoken.ErrForbidden):
+				writeInfluxError(w, http.StatusForbidden, InfluxWriteError{Error: err.Error()})
+			default:
+				writeInfluxError(w, http.StatusInternalServerError, InfluxWriteError{Error: err.Error()})
+			}
+			return
+		}
+
+		log.Debug().
+			Int("lines", result.Lines).
+			Int("points", result.Points).
+			Int("gauges", result.Gauges).
+			Int("counters", result.Counters).
+			Int("rejected", len(result.Errors)).
+			Msg("line protocol write applied")
+
+		if len(result.Errors) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		resp := InfluxWriteError{
+			Error: fmt.Sprintf("partial write: %d of %d lines rejected", len(result.Errors), result.Lines),
+		}
+		for _, lineErr := range result.Errors[:min(len(result.Errors), MaxReportedLineErrors)] {
+			resp.Lines = append(resp.Lines, LineErrorResponse{Line: lineErr.Line, Error: lineErr.Err.Error()})
+		}
+		writeInfluxError(w, http.StatusBadRequest, resp)
+	}
+}
+
+func writeInfluxError(w http.ResponseWriter, status int, resp InfluxWriteError) {
+	w.Header().Set("X-Influxdb-Error", resp.Error)
+	writeJSON(w, status, resp)
+}
@@ -1,6 +1,8 @@
 package rest
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"time"
 
@@ -26,6 +28,16 @@ func (res *loggingResponseWriter) Write(body []byte) (int, error) {
 	return size, err
 }
 
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (res *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return res.ResponseWriter
+}
+
+// Hijack lets the handler take over the connection, e.g. for a WebSocket.
+func (res *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(res.ResponseWriter).Hijack()
+}
+
 // WithLogging is an HTTP middleware that logs the request and response.
 //
 // It logs the URI, method, status, duration, and size of the request.
@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+)
+
+const (
+	// MaxOTLPSize is the largest accepted OTLP request, compressed and decompressed.
+	MaxOTLPSize = 32 << 20
+	// ContentTypeProtobuf is the media type of OTLP/HTTP protobuf messages.
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// @Title OTLPExport
+// @Description Ingest metrics sent with the OTLP/HTTP protocol in the binary protobuf encoding
+// @Tags metrics
+// @Accept application/x-protobuf
+// @Produces application/x-protobuf
+// @Success 200 {string} string "ExportMetricsServiceResponse, the rejected data points are reported in its partial success"
+// @Failure 400 {string} string "google.rpc.Status of an invalid request, not retried by exporters"
+// @Failure 413 {string} string "Request too large"
+// @Failure 415 {string} string "Unsupported media type or encoding"
+// @Failure 503 {string} string "google.rpc.Status of a failure to store the metrics, retried by exporters"
+// @Router /v1/metrics [POST]
+func (srv *Server) OTLPExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != ContentTypeProtobuf {
+			http.Error(w, "Content-Type must be "+ContentTypeProtobuf, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := srv.decodeBody(http.MaxBytesReader(w, r.Body, MaxOTLPSize),
+			r.Header.Get("Content-Encoding"), srv.decompressedLimit(MaxOTLPSize))
+		if err != nil {
+			if errors.Is(err, compression.ErrUnsupported) {
+				writeDecodeError(w, err)
+				return
+			}
+
+			log.Error().Err(err).Msg("failed to create body decoder")
+			writeOTLPError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid compressed body")
+			return
+		}
+		defer closeBody(body)
+
+		data, err := io.ReadAll(body)
+		if err != nil {
+			if tooLarge(err) {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			log.Error().Err(err).Msg("failed read body")
+			writeOTLPError(w, http.StatusBadRequest, codes.InvalidArgument, "failed read body")
+			return
+		}
+
+		var req otlppb.ExportMetricsServiceRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			log.Error().Err(err).Msg("invalid otlp request")
+			writeOTLPError(w, http.StatusBadRequest, codes.InvalidArgument, "invalid export request: "+err.Error())
+			return
+		}
+
+		result, err := srv.OTLPUsecase.Export(r.Context(), &req)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to export otlp metrics")
+			if errors.Is(err, apitoken.ErrForbidden) {
+				writeOTLPError(w, http.StatusForbidden, codes.PermissionDenied, err.Error())
+				return
+			}
+			writeOTLPError(w, http.StatusServiceUnavailable, codes.Unavailable, err.Error())
+			return
+		}
+
+		log.Debug().
+			Int("data_points", result.DataPoints).
+			Int("gauges", result.Gauges).
+			Int("counters", result.Counters).
+			Int64("rejected", result.Rejected).
+			Msg("otlp export applied")
+
+		writeProtobuf(w, http.StatusOK, result.Response())
+	}
+}
+
+// writeOTLPError writes the google.rpc.Status body OTLP/HTTP clients expect.
+func writeOTLPError(w http.ResponseWriter, httpStatus int, code codes.Code, msg string) {
+	writeProtobuf(w, httpStatus, status.New(code, msg).Proto())
+}
+
+func writeProtobuf(w http.ResponseWriter, httpStatus int, msg proto.Message) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal response")
+		http.Error(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProtobuf)
+	w.WriteHeader(httpStatus)
+	if _, err := w.Write(data); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
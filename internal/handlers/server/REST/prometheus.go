@@ -0,0 +1,42 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/content"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// @Title PrometheusMetrics
+// @Description Get all metrics of the request tenant in the Prometheus text format or,
+// @Description with "Accept: application/openmetrics-text", in the OpenMetrics format.
+// @Description An Accept header that excludes both gets the Prometheus text format.
+// @Tags metrics
+// @Produces text/plain
+// @Produces application/openmetrics-text
+// @Success 200 {string} string "Metrics exposition"
+// @Failure 500 {string} string "Internal server error"
+// @Router /metrics [GET]
+func (srv *Server) PrometheusMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := srv.MetricUsecase.GetAllMetrics(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get metrics")
+			http.Error(w, "failed to get metrics", http.StatusInternalServerError)
+			return
+		}
+
+		// Scrapers are not refused: an Accept header that excludes both
+		// formats gets the Prometheus one.
+		format, err := content.Negotiate(r.Header.Get("Accept"), content.Prometheus, content.OpenMetrics)
+		if err != nil {
+			format = content.Prometheus
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Add("Vary", "Accept")
+		if err := content.WriteMetrics(w, format, metrics); err != nil {
+			log.Error().Err(err).Msg("failed to write metrics exposition")
+		}
+	}
+}
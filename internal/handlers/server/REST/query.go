@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/query"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// QuerySample is a sample of a query result. ID, Name and Labels are
+// empty for a scalar and for samples computed from several metrics.
+type QuerySample struct {
+	ID     string        `json:"id,omitempty"`
+	Name   string        `json:"name,omitempty"`
+	Labels models.Labels `json:"labels,omitempty"`
+	Value  float64       `json:"value"`
+}
+
+// QueryResponse is the result of a query: a "vector" of samples, or
+// a "scalar" with a single sample.
+type QueryResponse struct {
+	Type    string        `json:"type"`
+	Samples []QuerySample `json:"samples"`
+}
+
+// @Title Query
+// @Description Evaluate a query over the metrics of the request tenant, e.g. "sum(Heap*)"
+// @Description or "errors / requests". Errors are returned in the API v2 envelope;
+// @Description the details of an invalid query hold the position of the error.
+// @Tags metrics
+// @Produces application/json
+// @Produces application/msgpack
+// @Param q query string true "Query"
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Success 200 {object} QueryResponse
+// @Success 304 {string} string "Not modified since the ETag"
+// @Failure 400 {object} ErrorResponse "Invalid query"
+// @Failure 406 {object} ErrorResponse "No acceptable content type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /query [GET]
+func (srv *Server) Query() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query().Get("q")
+		if q == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "query is required",
+				map[string]string{"q": "required"})
+			return
+		}
+
+		format, ok := negotiateDocument(w, req)
+		if !ok {
+			return
+		}
+
+		if srv.notModified(w, req, "query "+string(format)+" "+q) {
+			return
+		}
+
+		result, err := srv.QueryUsecase.Query(req.Context(), q)
+		if err != nil {
+			var qerr *query.Error
+			if errors.As(err, &qerr) {
+				writeError(w, http.StatusBadRequest, CodeInvalidArgument, err.Error(),
+					map[string]string{"position": strconv.Itoa(qerr.Pos)})
+				return
+			}
+
+			log.Error().Err(err).Msg("failed to evaluate query")
+			writeError(w, http.StatusInternalServerError, CodeInternal, "failed to evaluate query", nil)
+			return
+		}
+
+		resp := QueryResponse{Type: string(result.Type)}
+		if result.Type == query.ScalarType {
+			resp.Samples = []QuerySample{{Value: result.Scalar}}
+		} else {
+			resp.Samples = make([]QuerySample, 0, len(result.Vector))
+			for _, sample := range result.Vector {
+				resp.Samples = append(resp.Samples, QuerySample{
+					ID:     sample.ID(),
+					Name:   sample.Name,
+					Labels: sample.Labels,
+					Value:  sample.Value,
+				})
+			}
+		}
+
+		writeDocument(w, format, http.StatusOK, resp)
+	}
+}
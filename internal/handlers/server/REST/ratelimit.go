@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// WithRateLimit is an HTTP middleware that limits the requests and the
+// stored metrics per second of every client, identified by identity (see
+// ratelimit.KeyIP, ratelimit.KeyAgent and ratelimit.KeyTenant). It must
+// run after WithTenant and WithToken to limit tenants and API tokens.
+//
+// The X-Real-IP and X-Agent-ID headers identify the client only if the
+// connection comes from trustedSubnet, otherwise the client is identified
+// by the address of the connection.
+//
+// A request over the limits is rejected with 429 Too Many Requests and the
+// Retry-After header. If neither rate is set, it returns next as is.
+func WithRateLimit(requestRate, metricRate float64, identity, trustedSubnet string) func(http.Handler) http.Handler {
+	quota := ratelimit.NewQuota(requestRate, metricRate)
+
+	var subnet *net.IPNet
+	if trustedSubnet != "" {
+		_, s, err := net.ParseCIDR(trustedSubnet)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to parse trusted subnet")
+		}
+		subnet = s
+	}
+
+	return func(next http.Handler) http.Handler {
+		if quota == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := ratelimit.ClientKey(identity, requestClient(r, subnet))
+
+			ctx, wait := quota.Admit(r.Context(), key)
+			if wait > 0 {
+				log.Debug().Str("client", key).Dur("retry_after", wait).Msg("rate limit exceeded")
+				w.Header().Set(ratelimit.RetryAfterHeader, ratelimit.RetryAfter(wait))
+				httpError(w, r, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestClient returns the client of the request, taking its X-Real-IP
+// and X-Agent-ID only if it comes from subnet.
+func requestClient(r *http.Request, subnet *net.IPNet) ratelimit.Client {
+	client := ratelimit.Client{
+		IP:     r.RemoteAddr,
+		Tenant: tenant.FromContext(r.Context()),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.IP = host
+	}
+
+	if t, ok := apitoken.FromContext(r.Context()); ok {
+		client.Token = t.ID
+	}
+
+	if subnet != nil && subnet.Contains(net.ParseIP(client.IP)) {
+		if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+			client.IP = ip.String()
+		}
+		client.Agent = r.Header.Get(ratelimit.AgentHeader)
+	}
+
+	return client
+}
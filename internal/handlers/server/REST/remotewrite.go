@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/remotewrite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/prompb"
+)
+
+// MaxRemoteWriteSize is the largest accepted remote write request,
+// compressed and decompressed.
+const MaxRemoteWriteSize = 32 << 20
+
+// DecodeWriteRequest decodes a snappy-compressed protobuf remote write
+// request of at most maxSize bytes decompressed.
+func DecodeWriteRequest(compressed []byte, maxSize int64) (*prompb.WriteRequest, error) {
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy body: %w", err)
+	}
+	if int64(size) > maxSize {
+		return nil, fmt.Errorf("%w: decompressed body of %d bytes", admission.ErrTooLarge, size)
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy body: %w", err)
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid write request: %w", err)
+	}
+
+	return &req, nil
+}
+
+// @Title RemoteWrite
+// @Description Ingest samples sent with the Prometheus remote write protocol 1.0
+// @Tags metrics
+// @Accept application/x-protobuf
+// @Success 204 "Samples stored"
+// @Failure 400 {string} string "Invalid write request, not retried by Prometheus"
+// @Failure 413 {string} string "Request too large"
+// @Failure 415 {string} string "Unsupported media type or encoding"
+// @Failure 500 {string} string "Internal server error, retried by Prometheus"
+// @Router /api/v1/write [POST]
+func (srv *Server) RemoteWrite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || mediaType != "application/x-protobuf" {
+				http.Error(w, "Content-Type must be application/x-protobuf", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "snappy" {
+			http.Error(w, "Content-Encoding must be snappy", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRemoteWriteSize))
+		if err != nil {
+			if tooLarge(err) {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			log.Error().Err(err).Msg("failed read body")
+			http.Error(w, "failed read body", http.StatusInternalServerError)
+			return
+		}
+
+		req, err := DecodeWriteRequest(compressed, srv.decompressedLimit(MaxRemoteWriteSize))
+		if err != nil {
+			if tooLarge(err) {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			log.Error().Err(err).Msg("invalid remote write request")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := srv.RemoteWriteUsecase.Write(r.Context(), req)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to apply remote write request")
+
+			status := updateStatus(err, http.StatusInternalServerError)
+			if errors.Is(err, remotewrite.ErrInvalidSeries) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		log.Debug().
+			Int("series", result.Series).
+			Int("samples", result.Samples).
+			Int("gauges", result.Gauges).
+			Int("counters", result.Counters).
+			Msg("remote write applied")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -7,44 +7,96 @@
 package rest
 
 import (
-	"compress/gzip"
+	"bytes"
 	"fmt"
-	"html/template"
-	"io"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mailru/easyjson"
 
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/dashboard"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/importer"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/influx"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/otlp"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/ping"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/query"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/remotewrite"
 	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/stream"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/content"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
 	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
 )
 
 type Server struct {
-	MetricUsecase *srvUsecase.MetricUsecase
-	PingUsecase   *ping.PingUsecase
+	MetricUsecase      *srvUsecase.MetricUsecase
+	PingUsecase        *ping.PingUsecase
+	RemoteWriteUsecase *remotewrite.RemoteWriteUsecase
+	InfluxUsecase      *influx.InfluxUsecase
+	OTLPUsecase        *otlp.OTLPUsecase
+	ImportUsecase      *importer.ImportUsecase
+	QueryUsecase       *query.QueryUsecase
+	// StreamHub is nil if the stream is disabled.
+	StreamHub *stream.Hub
+	// TokenUsecase is nil if the storage doesn't keep API tokens.
+	TokenUsecase *token.TokenUsecase
+	Limits       admission.Limits
 }
 
+// NewServer creates a Server; remote write and line protocol requests are
+// applied with the default conventions until WithRemoteWrite and WithInflux
+// are called. WithOTLP shares the OTLP usecase with the gRPC server. The
+// stream is disabled until WithStream is called.
 func NewServer(uc *srvUsecase.MetricUsecase, puc *ping.PingUsecase) *Server {
 	return &Server{
-		MetricUsecase: uc,
-		PingUsecase:   puc,
+		MetricUsecase:      uc,
+		PingUsecase:        puc,
+		RemoteWriteUsecase: remotewrite.NewRemoteWriteUsecase(uc, remotewrite.ParseConvention(remotewrite.DefaultCounterSuffixes)),
+		InfluxUsecase:      influx.NewInfluxUsecase(uc, influx.Convention{Integers: influx.DefaultIntegers, Tags: influx.DefaultTags}),
+		OTLPUsecase:        otlp.NewOTLPUsecase(uc),
+		ImportUsecase:      importer.NewImportUsecase(uc),
+		QueryUsecase:       query.NewQueryUsecase(uc),
+		Limits:             admission.DefaultLimits(),
 	}
 }
 
+// WithRemoteWrite sets the usecase applying remote write requests.
+func (srv *Server) WithRemoteWrite(rwuc *remotewrite.RemoteWriteUsecase) *Server {
+	srv.RemoteWriteUsecase = rwuc
+	return srv
+}
+
+// WithInflux sets the usecase applying line protocol writes.
+func (srv *Server) WithInflux(iuc *influx.InfluxUsecase) *Server {
+	srv.InfluxUsecase = iuc
+	return srv
+}
+
+// WithOTLP sets the usecase applying OTLP exports.
+func (srv *Server) WithOTLP(ouc *otlp.OTLPUsecase) *Server {
+	srv.OTLPUsecase = ouc
+	return srv
+}
+
 // @Title GetMetric
-// @Description Get a metric by type and name from URL parameters
+// @Description Get a metric by type and name from URL parameters, as its bare value
+// @Description or in the format chosen by the Accept header
 // @Tags metrics
 // @Produces text/plain
+// @Produces application/json
+// @Produces application/x-protobuf
+// @Produces application/msgpack
 // @Param mType path string true "Metric type"
 // @Param mName path string true "Metric name"
+// @Param If-None-Match header string false "ETag of a previous response"
 // @Success 200 {string} string "Metric value"
+// @Success 304 {string} string "Not modified since the ETag"
 // @Failure 400 {string} string "Bad request"
 // @Failure 404 {string} string "Metric not found"
+// @Failure 406 {string} string "No acceptable format"
 // @Failure 500 {string} string "Internal server error"
 // @Router /value/{mType}/{mName} [GET]
 func (srv *Server) GetMetric() http.HandlerFunc {
@@ -52,45 +104,53 @@ func (srv *Server) GetMetric() http.HandlerFunc {
 		mType := chi.URLParam(req, "mType")
 		mName := chi.URLParam(req, "mName")
 
-		metric, err := srv.MetricUsecase.GetMetric(req.Context(), mType, mName)
-		if err != nil {
-			log.Error().Err(err).Msg("can't get valid metric")
-			http.Error(res, fmt.Sprintf("Metric %s was not found", mName), http.StatusNotFound)
+		format, ok := negotiate(res, req, offer(content.Text, metricFormats))
+		if !ok {
 			return
 		}
 
-		var valueStr string
-		switch v := metric.Value().(type) {
-		case float64:
-			valueStr = strconv.FormatFloat(v, 'f', -1, 64)
-		case int64:
-			valueStr = strconv.FormatInt(v, 10)
-		default:
-			http.Error(res, "an unexpected type of metric", http.StatusInternalServerError)
+		if srv.notModified(res, req, "value "+string(format)+" "+mType+"/"+mName) {
 			return
 		}
 
-		res.Header().Set("Content-Type", "text/plain")
-		res.WriteHeader(http.StatusOK)
-
-		_, err = res.Write([]byte(valueStr))
+		metric, err := srv.MetricUsecase.GetMetric(req.Context(), mType, mName)
 		if err != nil {
-			log.Error().Msgf("Failed to write response: %v", err)
-
+			log.Error().Err(err).Msg("can't get valid metric")
+			http.Error(res, fmt.Sprintf("Metric %s was not found", mName), http.StatusNotFound)
+			return
 		}
+
+		writeMetric(res, format, metric)
 	}
 }
 
 // @Title GetAllMetrics
-// @Description Get all metrics
+// @Description Get all metrics of the request tenant, as the HTML dashboard or,
+// @Description in the format chosen by the Accept header, e.g. "application/json"
 // @Tags metrics
 // @Produces text/html
-// @Success 200 {string} string "Metrics table"
+// @Produces application/json
+// @Produces text/plain
+// @Produces application/x-protobuf
+// @Produces application/msgpack
+// @Param If-None-Match header string false "ETag of a previous response"
+// @Success 200 {string} string "Metrics dashboard"
+// @Success 304 {string} string "Not modified since the ETag"
 // @Failure 404 {string} string "Metrics not found"
+// @Failure 406 {string} string "No acceptable format"
 // @Failure 500 {string} string "Internal server error"
 // @Router / [GET]
 func (srv *Server) GetAllMetrics() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
+		format, ok := negotiate(res, req, offer(content.HTML, metricFormats))
+		if !ok {
+			return
+		}
+
+		if srv.notModified(res, req, string(format)) {
+			return
+		}
+
 		metrics, err := srv.MetricUsecase.GetAllMetrics(req.Context())
 		if err != nil {
 			log.Error().Err(err).Msg("failed to Get metrics")
@@ -98,53 +158,24 @@ func (srv *Server) GetAllMetrics() http.HandlerFunc {
 			return
 		}
 
-		metricsToTable, err := converter.ConvertToMetricTable(metrics)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to convert metrics to table")
-			http.Error(res, "failed to convert metrics to table", http.StatusInternalServerError)
+		if format != content.HTML {
+			res.Header().Set("Content-Type", format.ContentType())
+			if err := content.WriteMetrics(res, format, metrics); err != nil {
+				log.Error().Err(err).Str("format", string(format)).Msg("failed to encode metrics")
+			}
 			return
 		}
 
-		const htmlTemplate = `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>Metrics</title>
-</head>
-<body>
-    <h1>Metric</h1>
-    <table border="1">
-        <thead>
-            <tr>
-                <th>Name of Metric</th>
-                <th>Type</th>
-                <th>Value</th>
-            </tr>
-        </thead>
-        <tbody>
-            {{range .}}
-            <tr>
-                <td>{{.Name}}</td>
-                <td>{{.Type}}</td>
-                <td>{{.Value}}</td>
-            </tr>
-            {{end}}
-        </tbody>
-    </table>
-</body>
-</html>
-`
-
-		template, err := template.New("Metrics").Parse(htmlTemplate)
+		metricsToTable, err := converter.ConvertToMetricTable(metrics)
 		if err != nil {
-			log.Error().Msgf("couldn't make it out HTML template: %v", err)
-			http.Error(res, "Internal server error, failed html-template", http.StatusInternalServerError)
+			log.Error().Err(err).Msg("failed to convert metrics to table")
+			http.Error(res, "failed to convert metrics to table", http.StatusInternalServerError)
 			return
 		}
 
 		res.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-		if err := template.Execute(res, metricsToTable); err != nil {
+		if err := dashboard.Render(res, metricsToTable); err != nil {
 			log.Error().Msgf("failed complete template: %v", err)
 		}
 	}
@@ -189,7 +220,7 @@ func (srv *Server) UpdateMetric() http.HandlerFunc {
 		}
 
 		if err := srv.MetricUsecase.UpdateMetric(req.Context(), mType, mName, val); err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			http.Error(res, err.Error(), updateStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -202,17 +233,31 @@ func (srv *Server) UpdateMetric() http.HandlerFunc {
 }
 
 // @Title GetMetricsHandlerJSON
-// @Description Get a metric by type and name
+// @Description Get a metric by type and name, in JSON or in the format chosen by the Accept header
 // @Tags metrics
 // @Produces application/json
+// @Produces text/plain
+// @Produces application/x-protobuf
+// @Produces application/msgpack
 // @Accept application/json
+// @Param If-None-Match header string false "ETag of a previous response for the same metric"
+// @Success 304 {string} string "Not modified since the ETag"
 // @Failure 400 {string} string "Invalid JSON body"
+// @Failure 413 {string} string "Request too large"
 // @Failure 415 {string} string "Unsupported media type"
 // @Failure 404 {string} string "Metric not found"
+// @Failure 406 {string} string "No acceptable format"
 // @Failure 500 {string} string "Internal server error"
 // @Router /value [POST]
 func (srv *Server) GetMetricsHandlerJSON() http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
+		reader, err := srv.requestBody(req)
+		if err != nil {
+			writeDecodeError(resp, err)
+			return
+		}
+		defer closeBody(reader)
+
 		var jsonMetric serialize.Metric
 
 		log.Info().Msg("GetMetricsHandlerJSON called")
@@ -221,12 +266,25 @@ func (srv *Server) GetMetricsHandlerJSON() http.HandlerFunc {
 			return
 		}
 
-		if err := easyjson.UnmarshalFromReader(req.Body, &jsonMetric); err != nil {
+		if err := easyjson.UnmarshalFromReader(reader, &jsonMetric); err != nil {
+			if tooLarge(err) {
+				http.Error(resp, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(resp, fmt.Sprintf("invalid json body: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		log.Debug().Str("type", jsonMetric.MType).Str("name", jsonMetric.ID).Msg("")
+		format, ok := negotiate(resp, req, offer(content.JSON, metricFormats))
+		if !ok {
+			return
+		}
+
+		if srv.notModified(resp, req, "value "+string(format)+" "+jsonMetric.MType+"/"+jsonMetric.ID) {
+			return
+		}
+
 		metric, err := srv.MetricUsecase.GetMetric(req.Context(), jsonMetric.MType, jsonMetric.ID)
 		if err != nil {
 			log.Error().Err(err).Msg("can't get valid metric")
@@ -234,18 +292,89 @@ func (srv *Server) GetMetricsHandlerJSON() http.HandlerFunc {
 			return
 		}
 
-		if err := jsonMetric.SetValue(metric.Value()); err != nil {
-			log.Error().Err(err).Msg("can't set new value")
-			http.Error(resp, "can't set new value", http.StatusInternalServerError)
+		writeMetric(resp, format, metric)
+		log.Info().Msg("Metric successfully returned\n\n")
+	}
+}
+
+// @Title GetMetricsBatchHandlerJSON
+// @Description Get many metrics by type and name in one request; the metrics
+// @Description that are not found are listed in "missing"
+// @Tags metrics
+// @Produces application/json
+// @Produces application/x-protobuf
+// @Produces application/msgpack
+// @Accept application/json
+// @Success 200 {object} serialize.MetricsBatch
+// @Failure 400 {string} string "Invalid JSON body"
+// @Failure 406 {string} string "No acceptable format"
+// @Failure 413 {string} string "Request too large or too many metrics"
+// @Failure 415 {string} string "Unsupported media type"
+// @Failure 500 {string} string "Internal server error"
+// @Router /values [POST]
+func (srv *Server) GetMetricsBatchHandlerJSON() http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		reader, err := srv.requestBody(req)
+		if err != nil {
+			writeDecodeError(resp, err)
 			return
 		}
+		defer closeBody(reader)
 
-		resp.Header().Set("Content-Type", "application/json")
-		if _, err := easyjson.MarshalToWriter(&jsonMetric, resp); err != nil {
-			http.Error(resp, fmt.Sprintf("failed to encode json: %v", err), http.StatusInternalServerError)
+		var jsonMetrics serialize.MetricsList
+
+		if req.Header.Get("Content-Type") != "application/json" {
+			http.Error(resp, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
 			return
 		}
-		log.Info().Msg("Metric successfully returned\n\n")
+
+		format, ok := negotiate(resp, req, batchFormats)
+		if !ok {
+			return
+		}
+
+		if err := easyjson.UnmarshalFromReader(reader, &jsonMetrics); err != nil {
+			if tooLarge(err) {
+				http.Error(resp, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(resp, fmt.Sprintf("invalid json body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := srv.Limits.CheckBatch(len(jsonMetrics)); err != nil {
+			http.Error(resp, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		keys := make([]srvUsecase.MetricKey, 0, len(jsonMetrics))
+		for _, jsonMetric := range jsonMetrics {
+			keys = append(keys, srvUsecase.MetricKey{Type: jsonMetric.MType, Name: jsonMetric.ID})
+		}
+
+		metrics, missing, err := srv.MetricUsecase.GetMetrics(req.Context(), keys)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get metrics")
+			http.Error(resp, "failed to get metrics", http.StatusInternalServerError)
+			return
+		}
+
+		notFound := make(serialize.MetricsList, 0, len(missing))
+		for _, key := range missing {
+			notFound = append(notFound, serialize.Metric{ID: key.Name, MType: key.Type})
+		}
+
+		var buf bytes.Buffer
+		if err := content.WriteBatch(&buf, format, metrics, notFound); err != nil {
+			log.Error().Err(err).Str("format", string(format)).Msg("failed to encode metrics")
+			http.Error(resp, "failed to encode metrics", http.StatusInternalServerError)
+			return
+		}
+
+		resp.Header().Set("Content-Type", format.ContentType())
+		if _, err := resp.Write(buf.Bytes()); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
 	}
 }
 
@@ -255,28 +384,19 @@ func (srv *Server) GetMetricsHandlerJSON() http.HandlerFunc {
 // @Produces application/json
 // @Accept application/json
 // @Failure 400 {string} string "Invalid JSON body"
+// @Failure 413 {string} string "Request too large"
 // @Failure 415 {string} string "Unsupported media type"
 // @Failure 404 {string} string "Metric not found"
 // @Failure 500 {string} string "Internal server error"
 // @Router /update [POST]
 func (srv *Server) UpdateMetricsHandlerJSON() http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
-		var reader io.Reader
-		if req.Header.Get("Content-Encoding") == "gzip" {
-			gz, err := gzip.NewReader(req.Body)
-			if err != nil {
-				http.Error(resp, "failed to create gzip reader", http.StatusBadRequest)
-				return
-			}
-			defer func() {
-				if err := gz.Close(); err != nil {
-					log.Error().Err(err).Msg("failed close gz reader")
-				}
-			}()
-			reader = gz
-		} else {
-			reader = req.Body
+		reader, err := srv.requestBody(req)
+		if err != nil {
+			writeDecodeError(resp, err)
+			return
 		}
+		defer closeBody(reader)
 
 		var jsonMetric serialize.Metric
 
@@ -287,6 +407,10 @@ func (srv *Server) UpdateMetricsHandlerJSON() http.HandlerFunc {
 		}
 
 		if err := easyjson.UnmarshalFromReader(reader, &jsonMetric); err != nil {
+			if tooLarge(err) {
+				http.Error(resp, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(resp, fmt.Sprintf("invalid json body: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -299,7 +423,7 @@ func (srv *Server) UpdateMetricsHandlerJSON() http.HandlerFunc {
 		}
 
 		if err := srv.MetricUsecase.UpdateMetric(req.Context(), jsonMetric.MType, jsonMetric.ID, value); err != nil {
-			http.Error(resp, fmt.Sprintf("invalid update metric %s: %v", jsonMetric.ID, err), http.StatusBadRequest)
+			http.Error(resp, fmt.Sprintf("invalid update metric %s: %v", jsonMetric.ID, err), updateStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -331,27 +455,18 @@ func (srv *Server) UpdateMetricsHandlerJSON() http.HandlerFunc {
 // @Accept application/json
 // @Success 200 {string} string "Metrics updated successfully"
 // @Failure 400 {string} string "Invalid JSON body"
+// @Failure 413 {string} string "Request too large or too many metrics"
 // @Failure 415 {string} string "Unsupported media type"
 // @Failure 500 {string} string "Internal server error"
 // @Router /updates [POST]
 func (srv *Server) UpdatesMetricsHandlerJSON() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		var reader io.Reader
-		if req.Header.Get("Content-Encoding") == "gzip" {
-			gz, err := gzip.NewReader(req.Body)
-			if err != nil {
-				http.Error(w, "failed to create gzip reader", http.StatusBadRequest)
-				return
-			}
-			defer func() {
-				if err := gz.Close(); err != nil {
-					log.Error().Err(err).Msg("failed close gz reader")
-				}
-			}()
-			reader = gz
-		} else {
-			reader = req.Body
+		reader, err := srv.requestBody(req)
+		if err != nil {
+			writeDecodeError(w, err)
+			return
 		}
+		defer closeBody(reader)
 
 		var jsonMetrics serialize.MetricsList
 
@@ -362,10 +477,19 @@ func (srv *Server) UpdatesMetricsHandlerJSON() http.HandlerFunc {
 		}
 
 		if err := easyjson.UnmarshalFromReader(reader, &jsonMetrics); err != nil {
+			if tooLarge(err) {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, fmt.Sprintf("invalid json body: %v", err), http.StatusBadRequest)
 			return
 		}
 
+		if err := srv.Limits.CheckBatch(len(jsonMetrics)); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
 		metrics, err := converter.ConvertMetrics(jsonMetrics)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("invalid convert metrics: %v", err), http.StatusBadRequest)
@@ -374,7 +498,7 @@ func (srv *Server) UpdatesMetricsHandlerJSON() http.HandlerFunc {
 
 		if err := srv.MetricUsecase.UpdateMetricList(req.Context(), metrics); err != nil {
 			log.Error().Err(err).Msg("failed update metrics")
-			http.Error(w, fmt.Sprintf("failed update metrics: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("failed update metrics: %v", err), updateStatus(err, http.StatusInternalServerError))
 			return
 		}
 
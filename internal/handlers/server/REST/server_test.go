@@ -1,13 +1,31 @@
 package rest_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/klauspost/compress/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
 
 	srvCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/server"
 	rest "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/REST"
@@ -15,8 +33,21 @@ import (
 	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/router"
 	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/stream"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/exposition"
+	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/prompb"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/snapshot"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpdateMetric(t *testing.T) {
@@ -212,4 +243,2385 @@ func TestGetAllMetrics(t *testing.T) {
 		assert.Contains(t, string(body), "requests_total")
 		assert.Contains(t, string(body), "<html>")
 	})
+
+	t.Run("dashboard assets are gzipped", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/assets/dashboard.js", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		assert.Empty(t, rr.Header().Get("Content-Length"))
+
+		gz, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		js, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(js), "application/json")
+	})
+
+	// A handler setting the status before the body must get the
+	// Content-Encoding header too.
+	t.Run("gzipped status before body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v2/metrics/gauge/missing", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+		gz, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "not_found")
+	})
+}
+
+func TestTenants(t *testing.T) {
+	// The tenant header is trusted from the proxy's subnet only.
+	opts := srvCfg.NewServerOptions(
+		srvCfg.WithTenants("team-a:secret-a,team-b:secret-b", "X-Tenant-ID"),
+		srvCfg.WithTrustedSubnet("192.0.2.0/24"),
+	)
+
+	tenants := repo.NewTenantStorage(repo.NewMemStorage(), func(string) (srvUsecase.Collector, error) {
+		return repo.NewMemStorage(), nil
+	})
+	base, err := tenants.ForTenant(tenant.Default)
+	require.NoError(t, err)
+
+	metricUsecase := srvUsecase.NewMetricUsecase(base, base, base).WithTenants(tenants)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), opts)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set("X-Real-IP", "192.0.2.10")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	update := func(url string, header http.Header) int {
+		req := httptest.NewRequest(http.MethodPost, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return serve(req).Code
+	}
+
+	require.Equal(t, http.StatusOK, update("/update/counter/PollCount/1", nil))
+	require.Equal(t, http.StatusOK, update("/update/counter/PollCount/10",
+		http.Header{"Authorization": {"Bearer secret-a"}}))
+	require.Equal(t, http.StatusOK, update("/update/counter/PollCount/100",
+		http.Header{"X-Tenant-Id": {"team-b"}}))
+	require.Equal(t, http.StatusOK, update("/tenants/team-b/update/gauge/Alloc/2.5", nil))
+
+	t.Run("signed by tenant secret", func(t *testing.T) {
+		body := []byte(`[{"id":"PollCount","type":"counter","delta":5}]`)
+		signature, err := hash.GetHash([]byte("secret-a"), body)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("HashSHA256", hex.EncodeToString(signature))
+
+		rr := serve(req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	tests := []struct {
+		name       string
+		url        string
+		header     http.Header
+		wantStatus int
+		wantValue  string
+	}{
+		{
+			name:       "default tenant",
+			url:        "/value/counter/PollCount",
+			wantStatus: http.StatusOK,
+			wantValue:  "1",
+		},
+		{
+			name:       "tenant by token",
+			url:        "/value/counter/PollCount",
+			header:     http.Header{"Authorization": {"Bearer secret-a"}},
+			wantStatus: http.StatusOK,
+			wantValue:  "15",
+		},
+		{
+			name:       "tenant by header",
+			url:        "/value/counter/PollCount",
+			header:     http.Header{"X-Tenant-Id": {"team-b"}},
+			wantStatus: http.StatusOK,
+			wantValue:  "100",
+		},
+		{
+			name:       "tenant by path",
+			url:        "/tenants/team-b/value/gauge/Alloc",
+			wantStatus: http.StatusOK,
+			wantValue:  "2.5",
+		},
+		{
+			name:       "metric of another tenant",
+			url:        "/value/gauge/Alloc",
+			header:     http.Header{"Authorization": {"Bearer secret-a"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "token of another tenant",
+			url:        "/tenants/team-b/value/gauge/Alloc",
+			header:     http.Header{"Authorization": {"Bearer secret-a"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "header of another tenant",
+			url:        "/value/gauge/Alloc",
+			header:     http.Header{"Authorization": {"Bearer secret-a"}, "X-Tenant-Id": {"team-b"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unknown token",
+			url:        "/value/counter/PollCount",
+			header:     http.Header{"Authorization": {"Bearer secret-c"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown tenant",
+			url:        "/tenants/team-c/value/counter/PollCount",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid tenant",
+			url:        "/value/counter/PollCount",
+			header:     http.Header{"X-Tenant-Id": {"team/a"}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+
+			rr := serve(req)
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantValue != "" {
+				assert.Equal(t, tt.wantValue, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("per-tenant views", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/tenants/team-b/", nil)
+		req.Header.Set("Accept", "application/json")
+
+		rr := serve(req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		// The storage lists the metrics in no particular order.
+		var got []map[string]any
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.ElementsMatch(t, []map[string]any{
+			{"id": "Alloc", "type": "gauge", "value": 2.5},
+			{"id": "PollCount", "type": "counter", "delta": 100.0},
+		}, got)
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret-a")
+
+		rr = serve(req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "PollCount")
+		assert.NotContains(t, rr.Body.String(), "Alloc")
+		assert.NotEmpty(t, rr.Header().Get("HashSHA256"))
+	})
+
+	t.Run("signed canonically by tenant secret", func(t *testing.T) {
+		// signed sends an update signed with secret, under keyID.
+		signed := func(t *testing.T, url, keyID, secret string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, url, nil)
+			nonce, err := hash.NewNonce()
+			require.NoError(t, err)
+
+			r := hash.Request{
+				Method:    req.Method,
+				Path:      req.URL.EscapedPath(),
+				Timestamp: time.Now(),
+				Nonce:     nonce,
+			}
+			signature, err := hash.SignRequest([]byte(secret), r)
+			require.NoError(t, err)
+
+			req.Header.Set(hash.KeyIDHeader, keyID)
+			req.Header.Set(hash.SignatureHeader, signature)
+			req.Header.Set(hash.TimestampHeader, strconv.FormatInt(r.Timestamp.Unix(), 10))
+			req.Header.Set(hash.NonceHeader, nonce)
+			return serve(req)
+		}
+
+		assert.Equal(t, http.StatusOK, signed(t, "/update/gauge/Signed/1", "", "secret-a").Code)
+		assert.Equal(t, http.StatusOK, signed(t, "/update/gauge/Signed/2", "team-b", "secret-b").Code)
+		assert.Equal(t, http.StatusUnauthorized, signed(t, "/update/gauge/Signed/3", "team-a", "secret-b").Code,
+			"signed by another tenant")
+
+		for token, want := range map[string]string{"secret-a": "1", "secret-b": "2"} {
+			req := httptest.NewRequest(http.MethodGet, "/value/gauge/Signed", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := serve(req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, want, rr.Body.String())
+		}
+
+		// The tenant signs canonically now, so its body hash alone may be
+		// a signed request with the signature stripped.
+		sum, err := hash.GetHash([]byte("secret-a"), nil)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Signed/4", nil)
+		req.Header.Set("HashSHA256", hex.EncodeToString(sum))
+		assert.Equal(t, http.StatusUnauthorized, serve(req).Code, "stripped signature")
+	})
+
+	t.Run("tenant header disabled", func(t *testing.T) {
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+			srvCfg.WithTenants("team-a:secret-a", ""),
+		))
+
+		req := httptest.NewRequest(http.MethodGet, "/tenants/team-a/value/counter/PollCount", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		req = httptest.NewRequest(http.MethodGet, "/tenants/team-a/value/counter/PollCount", nil)
+		req.Header.Set("Authorization", "Bearer secret-a")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "15", rr.Body.String())
+	})
+
+	t.Run("tenant header without trusted subnet", func(t *testing.T) {
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+			srvCfg.WithTenants("team-a:secret-a,team-b:secret-b", "X-Tenant-ID"),
+		))
+
+		for _, url := range []string{"/value/counter/PollCount", "/tenants/team-b/value/counter/PollCount"} {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set("X-Tenant-ID", "team-b")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusForbidden, rr.Code, url)
+		}
+
+		// The tenant's own secret still selects it.
+		req := httptest.NewRequest(http.MethodGet, "/value/counter/PollCount", nil)
+		req.Header.Set("Authorization", "Bearer secret-b")
+		req.Header.Set("X-Tenant-ID", "team-b")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "100", rr.Body.String())
+	})
+}
+
+func TestAdminSnapshotRestore(t *testing.T) {
+	const (
+		key   = "server-key"
+		token = "admin-token"
+	)
+
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 10),
+	}))
+
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+		srvCfg.WithKey(key),
+		srvCfg.WithAdminToken(token),
+	))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// sign sets the canonical signature of req with secret.
+	sign := func(req *http.Request, secret string, body []byte) {
+		nonce, err := hash.NewNonce()
+		require.NoError(t, err)
+
+		now := time.Now()
+		signature, err := hash.SignRequest([]byte(secret), hash.Request{
+			Method:    req.Method,
+			Path:      req.URL.EscapedPath(),
+			Query:     req.URL.Query(),
+			Body:      body,
+			Timestamp: now,
+			Nonce:     nonce,
+		})
+		require.NoError(t, err)
+
+		req.Header.Set(hash.SignatureHeader, signature)
+		req.Header.Set(hash.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(hash.NonceHeader, nonce)
+	}
+
+	// bodyHash is the legacy "HashSHA256" signature of body.
+	bodyHash := func(body []byte) string {
+		signature, err := hash.GetHash([]byte(key), body)
+		require.NoError(t, err)
+		return hex.EncodeToString(signature)
+	}
+
+	t.Run("credentials", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			header     http.Header
+			secret     string
+			wantStatus int
+		}{
+			{name: "no credentials", wantStatus: http.StatusUnauthorized},
+			{name: "admin token", header: http.Header{"Authorization": {"Bearer " + token}}, wantStatus: http.StatusOK},
+			{name: "wrong token", header: http.Header{"Authorization": {"Bearer nope"}}, wantStatus: http.StatusUnauthorized},
+			{name: "signed with key", secret: key, wantStatus: http.StatusOK},
+			{name: "signed with other key", secret: "bad", wantStatus: http.StatusUnauthorized},
+			// The body hash of every GET is the same, so it could be replayed.
+			{name: "body hash", header: http.Header{"Hashsha256": {bodyHash(nil)}}, wantStatus: http.StatusUnauthorized},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+				for k, v := range tt.header {
+					req.Header[k] = v
+				}
+				if tt.secret != "" {
+					sign(req, tt.secret, nil)
+				}
+				assert.Equal(t, tt.wantStatus, serve(req).Code)
+			})
+		}
+	})
+
+	t.Run("replayed signature", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		sign(req, key, nil)
+		require.Equal(t, http.StatusOK, serve(req).Code)
+
+		replayed := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		replayed.Header = req.Header.Clone()
+		assert.Equal(t, http.StatusUnauthorized, serve(replayed).Code)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := serve(req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, snapshot.ContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, "2", rr.Header().Get("X-Metrics-Count"))
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), snapshot.FileExt)
+
+	snap := rr.Body.Bytes()
+
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewCounter("PollCount", 5),
+		models.NewGauge("RandomValue", 0.1),
+	}))
+
+	restore := func(mode string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/restore?mode="+mode, bytes.NewReader(body))
+		req.Header.Set("Content-Type", snapshot.ContentType)
+		sign(req, key, body)
+		return serve(req)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		// The counter is set to the snapshot total, however many times it is restored.
+		for range 2 {
+			rr := restore("merge", snap)
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, `{"mode":"merge","restored":2}`, rr.Body.String())
+		}
+
+		metrics, err := storage.GetAllMetrics(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.Metric{
+			models.NewGauge("Alloc", 1.5),
+			models.NewCounter("PollCount", 10),
+			models.NewGauge("RandomValue", 0.1),
+		}, metrics)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		rr := restore("replace", snap)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		metrics, err := storage.GetAllMetrics(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.Metric{
+			models.NewGauge("Alloc", 1.5),
+			models.NewCounter("PollCount", 10),
+		}, metrics)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, restore("append", snap).Code)
+		assert.Equal(t, http.StatusBadRequest, restore("merge", []byte("not a snapshot")).Code)
+	})
+
+	t.Run("body over the limit", func(t *testing.T) {
+		keys, err := hash.NewKeyring(hash.Key{Secret: key})
+		require.NoError(t, err)
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler reached with a body over the limit")
+		})
+		handler := rest.WithBodyLimit(16)(rest.WithAdminAuth(keys, "", hash.NewVerifier(time.Minute))(next))
+
+		body := bytes.Repeat([]byte("x"), 64)
+		req := httptest.NewRequest(http.MethodPost, "/admin/restore", bytes.NewReader(body))
+		sign(req, key, body)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("tenant of a single-tenant server", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot?tenant=team-a", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		assert.Equal(t, http.StatusBadRequest, serve(req).Code)
+	})
+
+	t.Run("disabled without credentials", func(t *testing.T) {
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestAdminSnapshotRestore_Tenants(t *testing.T) {
+	const token = "admin-token"
+
+	ctx := context.Background()
+	tenants := repo.NewTenantStorage(repo.NewMemStorage(), func(string) (srvUsecase.Collector, error) {
+		return repo.NewMemStorage(), nil
+	})
+	base, err := tenants.ForTenant(tenant.Default)
+	require.NoError(t, err)
+	teamA, err := tenants.ForTenant("team-a")
+	require.NoError(t, err)
+
+	require.NoError(t, base.UpdateMetric(ctx, models.CounterType, "PollCount", int64(1)))
+	require.NoError(t, teamA.UpdateMetric(ctx, models.CounterType, "PollCount", int64(10)))
+
+	metricUsecase := srvUsecase.NewMetricUsecase(base, base, base).WithTenants(tenants)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+		srvCfg.WithTenants("team-a:secret-a,team-b:secret-b", ""),
+		srvCfg.WithAdminToken(token),
+	))
+
+	serve := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/admin/snapshot?tenant=team-a", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	snap, err := snapshot.Read(rr.Body)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metric{models.NewCounter("PollCount", 10)}, snap)
+
+	var buf bytes.Buffer
+	require.NoError(t, snapshot.Write(&buf, snap))
+
+	rr = serve(http.MethodPost, "/admin/restore?mode=replace&tenant=team-b", buf.Bytes())
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	teamB, err := tenants.ForTenant("team-b")
+	require.NoError(t, err)
+	metric, err := teamB.GetMetric(ctx, models.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), metric.Value())
+
+	// The default tenant is untouched.
+	metric, err = base.GetMetric(ctx, models.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), metric.Value())
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/admin/snapshot?tenant=../etc", nil).Code)
+}
+
+func TestAPIV2(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 10),
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewGauge(`cpu{host="b"}`, 0.7),
+	}))
+
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	apiError := func(t *testing.T, rr *httptest.ResponseRecorder) rest.APIError {
+		t.Helper()
+
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		var resp rest.ErrorResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp.Error
+	}
+
+	t.Run("list pages", func(t *testing.T) {
+		var ids []string
+		target := "/api/v2/metrics?limit=3"
+		for {
+			rr := serve(http.MethodGet, target, "")
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var page rest.MetricsPage
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+			for _, m := range page.Metrics {
+				ids = append(ids, m.ID)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			target = "/api/v2/metrics?limit=3&cursor=" + page.NextCursor
+		}
+
+		assert.Equal(t, []string{"PollCount", "Alloc", `cpu{host="a"}`, `cpu{host="b"}`}, ids)
+	})
+
+	t.Run("list filters", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/v2/metrics?type=gauge&name=cpu&label=host:a", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page rest.MetricsPage
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		require.Len(t, page.Metrics, 1)
+
+		value := 0.5
+		assert.Equal(t, rest.MetricV2{
+			ID:     `cpu{host="a"}`,
+			Name:   "cpu",
+			Labels: models.Labels{"host": "a"},
+			Type:   models.GaugeType,
+			Value:  &value,
+		}, page.Metrics[0])
+	})
+
+	t.Run("get and update", func(t *testing.T) {
+		rr := serve(http.MethodPost, "/api/v2/metrics/counter/PollCount", `{"delta":5}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var m rest.MetricV2
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+		require.NotNil(t, m.Delta)
+		assert.Equal(t, int64(15), *m.Delta)
+
+		rr = serve(http.MethodGet, "/api/v2/metrics/gauge/"+url.PathEscape(`cpu{host="b"}`), "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+		assert.Equal(t, "cpu", m.Name)
+	})
+
+	t.Run("batch update", func(t *testing.T) {
+		rr := serve(http.MethodPost, "/api/v2/metrics", `{"metrics":[
+			{"name":"disk","labels":{"dev":"sda"},"type":"gauge","value":42},
+			{"id":"PollCount","type":"counter","delta":1}
+		]}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"updated":2}`, rr.Body.String())
+
+		metric, err := storage.GetMetric(ctx, models.GaugeType, `disk{dev="sda"}`)
+		require.NoError(t, err)
+		assert.Equal(t, 42.0, metric.Value())
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown metric", http.MethodGet, "/api/v2/metrics/gauge/unknown", "", http.StatusNotFound, rest.CodeNotFound},
+		{"invalid type", http.MethodGet, "/api/v2/metrics/histogram/x", "", http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid filter", http.MethodGet, "/api/v2/metrics?type=histogram", "", http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid glob", http.MethodGet, "/api/v2/metrics?name=%5B", "", http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid cursor", http.MethodGet, "/api/v2/metrics?cursor=!", "", http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid limit", http.MethodGet, "/api/v2/metrics?limit=-1", "", http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"wrong value kind", http.MethodPost, "/api/v2/metrics/gauge/Alloc", `{"delta":1}`, http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid json", http.MethodPost, "/api/v2/metrics", `{"metrics":`, http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"invalid batch metric", http.MethodPost, "/api/v2/metrics", `{"metrics":[{"type":"gauge","value":1}]}`, http.StatusBadRequest, rest.CodeInvalidArgument},
+		{"not json", http.MethodPost, "/api/v2/metrics", "", http.StatusUnsupportedMediaType, rest.CodeUnsupportedMediaType},
+		{"unknown route", http.MethodGet, "/api/v2/unknown", "", http.StatusNotFound, rest.CodeNotFound},
+		{"method not allowed", http.MethodDelete, "/api/v2/metrics", "", http.StatusMethodNotAllowed, rest.CodeMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, tt.target, tt.body)
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			assert.Equal(t, tt.wantCode, apiError(t, rr).Code)
+		})
+	}
+
+	t.Run("ping", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/api/v2/ping", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+	})
+
+	t.Run("middleware errors", func(t *testing.T) {
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+			srvCfg.WithTrustedSubnet("192.0.2.0/24"),
+			srvCfg.WithRequireToken(true),
+		))
+
+		serve := func(target, ip string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("X-Real-IP", ip)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := serve("/api/v2/metrics", "198.51.100.1")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, rest.CodePermissionDenied, apiError(t, rr).Code)
+
+		rr = serve("/api/v2/metrics", "192.0.2.10")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, rest.CodeUnauthenticated, apiError(t, rr).Code)
+
+		// The other routes keep the plain text errors.
+		rr = serve("/metrics", "192.0.2.10")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+	})
+}
+
+func TestGetMetricsBatch(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 10),
+	}))
+
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "found and missing",
+			contentType: "application/json",
+			body:        `[{"id":"PollCount","type":"counter"},{"id":"unknown","type":"gauge"},{"id":"Alloc","type":"gauge"}]`,
+			wantStatus:  http.StatusOK,
+			wantBody: `{"metrics":[{"id":"PollCount","type":"counter","delta":10},{"id":"Alloc","type":"gauge","value":1.5}],` +
+				`"missing":[{"id":"unknown","type":"gauge"}]}`,
+		},
+		{
+			name:        "empty list",
+			contentType: "application/json",
+			body:        `[]`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"metrics":[],"missing":[]}`,
+		},
+		{
+			name:        "invalid json",
+			contentType: "application/json",
+			body:        `[{"id":`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "not json",
+			contentType: "text/plain",
+			body:        `[]`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewCounter("PollCount", 10),
+	}))
+
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "text",
+			wantContentType: exposition.ContentTypeText,
+			wantBody:        "# TYPE PollCount counter\nPollCount 10\n# TYPE cpu gauge\ncpu{host=\"a\"} 0.5\n",
+		},
+		{
+			name:            "openmetrics",
+			accept:          "application/openmetrics-text;version=1.0.0,text/plain;q=0.5",
+			wantContentType: exposition.ContentTypeOpenMetrics,
+			wantBody:        "# TYPE PollCount counter\nPollCount_total 10\n# TYPE cpu gauge\ncpu{host=\"a\"} 0.5\n# EOF\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.wantContentType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, rr.Body.String())
+		})
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	write := func(body []byte, contentType, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	value := func(mType, name string) any {
+		metric, err := storage.GetMetric(ctx, mType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	const (
+		up       = `up{instance="localhost:9090",job="prometheus"}`
+		requests = `prometheus_http_requests_total{code="200",handler="/metrics",instance="localhost:9090",job="prometheus"}`
+	)
+
+	// Payloads recorded from Prometheus, 30 seconds apart.
+	first, err := os.ReadFile("testdata/remote_write_1.snappy")
+	require.NoError(t, err)
+	second, err := os.ReadFile("testdata/remote_write_2.snappy")
+	require.NoError(t, err)
+
+	rr := write(first, "application/x-protobuf", "snappy")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	assert.Equal(t, 1.0, value(models.GaugeType, up))
+	assert.Equal(t, 2.3e7, value(models.GaugeType, `go_memstats_alloc_bytes{instance="localhost:9090",job="prometheus"}`))
+	assert.Equal(t, int64(12), value(models.CounterType, requests))
+	assert.Equal(t, int64(12), value(models.CounterType,
+		`prometheus_http_request_duration_seconds_bucket{handler="/metrics",instance="localhost:9090",job="prometheus",le="0.1"}`))
+	assert.Equal(t, int64(4), value(models.CounterType, `process_cpu_seconds{instance="localhost:9090",job="prometheus"}`))
+
+	_, err = storage.GetMetric(ctx, models.GaugeType, `scrape_samples_scraped{instance="localhost:9090",job="prometheus"}`)
+	assert.Error(t, err, "stale samples are skipped")
+
+	rr = write(second, "application/x-protobuf", "snappy")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	assert.Equal(t, 0.0, value(models.GaugeType, up))
+	// 12 -> 15 -> 3 after a restart of the target: 3 + 3.
+	assert.Equal(t, int64(18), value(models.CounterType, requests))
+
+	noName := snappy.Encode(nil, mustMarshal(t, &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{{
+			Labels:  []*prompb.Label{{Name: "job", Value: "node"}},
+			Samples: []*prompb.Sample{{Value: 1}},
+		}},
+	}))
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		encoding    string
+		wantCode    int
+	}{
+		{
+			name:        "not snappy",
+			body:        []byte("not snappy"),
+			contentType: "application/x-protobuf",
+			encoding:    "snappy",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported encoding",
+			body:        first,
+			contentType: "application/x-protobuf",
+			encoding:    "gzip",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "unsupported content type",
+			body:        first,
+			contentType: "application/json",
+			encoding:    "snappy",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "series without name",
+			body:        noName,
+			contentType: "application/x-protobuf",
+			encoding:    "snappy",
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := write(tt.body, tt.contentType, tt.encoding)
+			assert.Equal(t, tt.wantCode, rr.Code)
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return data
+}
+
+func TestInfluxWrite(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	write := func(url string, body io.Reader, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, body)
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	lines := "cpu,cpu=cpu-total,host=web-1 usage_idle=97.5,usage_user=1.25 1760860800000\n" +
+		"net,host=web-1,interface=eth0 bytes_recv=1024i,err_in=0i 1760860800000\n"
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write([]byte(lines))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	rr := write("/write?db=telegraf&precision=ms", &gzipped, "gzip")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+
+	metric, err := storage.GetMetric(ctx, models.GaugeType, `cpu_usage_idle{cpu="cpu-total",host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 97.5, metric.Value())
+
+	// Integer fields are gauges unless counters are enabled.
+	metric, err = storage.GetMetric(ctx, models.GaugeType, `net_bytes_recv{host="web-1",interface="eth0"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1024.0, metric.Value())
+
+	t.Run("partial write", func(t *testing.T) {
+		rr := write("/write", strings.NewReader("mem used_percent=42.5\nmem used_percent\nmem,host free=1\n"), "")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		var resp rest.InfluxWriteError
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		assert.Equal(t, "partial write: 2 of 3 lines rejected", resp.Error)
+		require.Len(t, resp.Lines, 2)
+		assert.Equal(t, 2, resp.Lines[0].Line)
+		assert.Equal(t, 3, resp.Lines[1].Line)
+
+		// The valid line is stored.
+		metric, err := storage.GetMetric(ctx, models.GaugeType, "mem_used_percent")
+		require.NoError(t, err)
+		assert.Equal(t, 42.5, metric.Value())
+	})
+
+	t.Run("invalid precision", func(t *testing.T) {
+		rr := write("/write?precision=days", strings.NewReader("mem used_percent=1\n"), "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		rr := write("/write", strings.NewReader("mem used_percent=1\n"), "snappy")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+}
+
+func TestOTLPExport(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	export := func(body []byte, contentType, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	value := func(mType, name string) any {
+		metric, err := storage.GetMetric(ctx, mType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	const (
+		requests = `http.requests{route="/cart",service.name="checkout"}`
+		queue    = `queue.size{service.name="checkout"}`
+	)
+
+	// Payloads recorded from the OpenTelemetry Go SDK HTTP exporter, two
+	// exports of cumulative instruments.
+	first, err := os.ReadFile("testdata/otlp_export_1.pb")
+	require.NoError(t, err)
+	second, err := os.ReadFile("testdata/otlp_export_2.pb")
+	require.NoError(t, err)
+
+	rr := export(first, "application/x-protobuf", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/x-protobuf", rr.Header().Get("Content-Type"))
+
+	var resp otlppb.ExportMetricsServiceResponse
+	require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Nil(t, resp.GetPartialSuccess())
+
+	assert.Equal(t, int64(5), value(models.CounterType, requests))
+	assert.Equal(t, 3.0, value(models.GaugeType, queue))
+	assert.Equal(t, 21.5, value(models.GaugeType, `temperature{service.name="checkout"}`))
+	assert.Equal(t, int64(3), value(models.CounterType, `http.duration_count{route="/cart",service.name="checkout"}`))
+	assert.Equal(t, int64(2), value(models.CounterType, `http.duration_bucket{le="0.5",route="/cart",service.name="checkout"}`))
+	assert.Equal(t, int64(3), value(models.CounterType, `http.duration_bucket{le="+Inf",route="/cart",service.name="checkout"}`))
+	assert.Equal(t, 2.35, value(models.GaugeType, `http.duration_sum{route="/cart",service.name="checkout"}`))
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(second)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	rr = export(gzipped.Bytes(), "application/x-protobuf", "gzip")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Cumulative sums 5 -> 7 add the increase.
+	assert.Equal(t, int64(7), value(models.CounterType, requests))
+	assert.Equal(t, 2.0, value(models.GaugeType, queue))
+	assert.Equal(t, int64(4), value(models.CounterType, `http.duration_count{route="/cart",service.name="checkout"}`))
+
+	t.Run("partial success", func(t *testing.T) {
+		body := mustMarshal(t, &otlppb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*otlppb.ResourceMetrics{{
+				ScopeMetrics: []*otlppb.ScopeMetrics{{
+					Metrics: []*otlppb.Metric{
+						{
+							Name: "jobs",
+							Data: &otlppb.Metric_Sum{Sum: &otlppb.Sum{
+								AggregationTemporality: otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+								IsMonotonic:            true,
+								DataPoints: []*otlppb.NumberDataPoint{
+									{Value: &otlppb.NumberDataPoint_AsInt{AsInt: 2}},
+								},
+							}},
+						},
+						{
+							Name: "latency",
+							Data: &otlppb.Metric_Summary{Summary: &otlppb.Summary{
+								DataPoints: []*otlppb.UnsupportedDataPoint{{}, {}},
+							}},
+						},
+					},
+				}},
+			}},
+		})
+
+		rr := export(body, "application/x-protobuf", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp otlppb.ExportMetricsServiceResponse
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, int64(2), resp.GetPartialSuccess().GetRejectedDataPoints())
+		assert.Contains(t, resp.GetPartialSuccess().GetErrorMessage(), `metric "latency"`)
+
+		assert.Equal(t, int64(2), value(models.CounterType, "jobs"))
+	})
+
+	t.Run("gzip accepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(first))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		// Protobuf responses are not compressed, nor followed by an empty gzip stream.
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		var resp otlppb.ExportMetricsServiceResponse
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &resp))
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		rr := export([]byte("not protobuf"), "application/x-protobuf", "")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var st spb.Status
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &st))
+		assert.Equal(t, int32(codes.InvalidArgument), st.GetCode())
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		rr := export([]byte("{}"), "application/json", "")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		rr := export(first, "application/x-protobuf", "snappy")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(t *testing.T) (http.Handler, *repo.MemStorage) {
+		t.Helper()
+
+		storage := repo.NewMemStorage()
+		metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+		return router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions()), storage
+	}
+
+	src, storage := newHandler(t)
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewGauge(`cpu{host="b"}`, 0.75),
+		models.NewGauge("Alloc", 1024),
+		models.NewCounter("PollCount", 7),
+	}))
+
+	export := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		src.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		return rr
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		rr := export("/export?format=csv&type=gauge&name=cpu&label=host:a")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), ".csv")
+		assert.Equal(t, "id,type,value\n\"cpu{host=\"\"a\"\"}\",gauge,0.5\n", rr.Body.String())
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, export("/export?format=xml").Code)
+		assert.Equal(t, http.StatusBadRequest, export("/export?name=cpu[").Code)
+		assert.Equal(t, http.StatusBadRequest, export("/export?type=histogram").Code)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, format := range []string{"csv", "ndjson"} {
+			t.Run(format, func(t *testing.T) {
+				rr := export("/export?format=" + format)
+				require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+				dst, dstStorage := newHandler(t)
+				req := httptest.NewRequest(http.MethodPost, "/import", rr.Body)
+				req.Header.Set("Content-Type", rr.Header().Get("Content-Type"))
+
+				resp := httptest.NewRecorder()
+				dst.ServeHTTP(resp, req)
+				require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+				var result rest.ImportResult
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+				assert.Equal(t, rest.ImportResult{Rows: 4, Imported: 4}, result)
+
+				want, err := storage.Snapshot(ctx)
+				require.NoError(t, err)
+				got, err := dstStorage.Snapshot(ctx)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, want, got)
+			})
+		}
+	})
+
+	t.Run("partial import", func(t *testing.T) {
+		dst, dstStorage := newHandler(t)
+		body := `{"id":"a","type":"gauge","value":1}` + "\n" +
+			`{"id":"b","type":"counter","delta":1.5}` + "\n" +
+			`{"id":"c","type":"counter","delta":2}` + "\n"
+
+		rr := httptest.NewRecorder()
+		dst.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/import?format=ndjson", strings.NewReader(body)))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var result rest.ImportResult
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+		assert.Equal(t, 3, result.Rows)
+		assert.Equal(t, 2, result.Imported)
+		assert.Equal(t, 1, result.Rejected)
+		require.Len(t, result.Errors, 1)
+		assert.Equal(t, 2, result.Errors[0].Line)
+
+		// The valid rows are imported.
+		metric, err := dstStorage.GetMetric(ctx, models.CounterType, "c")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), metric.Value())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		dst, _ := newHandler(t)
+
+		rr := httptest.NewRecorder()
+		dst.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/import", strings.NewReader("a,b\n")))
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		dst, _ := newHandler(t)
+
+		rr := httptest.NewRecorder()
+		dst.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/import?format=csv", strings.NewReader("name,value\na,1\n")))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("outside the signer", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(
+			srvCfg.WithKey("secret"),
+			srvCfg.WithSignature(60, true),
+			srvCfg.WithTenants("team-a:secret-a", ""),
+			srvCfg.WithLimits(64, srvCfg.DefaultMaxDecompressedSize, srvCfg.DefaultMaxBatchSize, 0),
+		))
+
+		var body strings.Builder
+		for i := range 10 {
+			fmt.Fprintf(&body, `{"id":"m%d","type":"gauge","value":%d}`+"\n", i, i)
+		}
+
+		// An import can't be signed, so it needs a bearer token.
+		req := httptest.NewRequest(http.MethodPost, "/import?format=ndjson", strings.NewReader(body.String()))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		// It isn't read whole, so its own limit applies rather than -z.
+		req = httptest.NewRequest(http.MethodPost, "/import?format=ndjson", strings.NewReader(body.String()))
+		req.Header.Set("Authorization", "Bearer secret-a")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		req = httptest.NewRequest(http.MethodGet, "/export?format=ndjson", nil)
+		req.Header.Set("Authorization", "Bearer secret-a")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("HashSHA256"))
+		assert.Equal(t, 10, strings.Count(rr.Body.String(), "\n"))
+	})
+}
+
+func TestWithHashing_Response(t *testing.T) {
+	const key = "secret"
+
+	keys, err := hash.NewKeyring(hash.Key{Secret: key})
+	require.NoError(t, err)
+
+	// The response is signed whole, however many writes it takes.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("first,"))
+		_, _ = w.Write([]byte("second"))
+	})
+	handler := rest.WithHashing(keys, hash.NewVerifier(time.Minute), false)(next)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "first,second", rr.Body.String())
+
+	want, err := hash.GetHash([]byte(key), []byte("first,second"))
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(want), rr.Header().Get("HashSHA256"))
+}
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewCounter("hits", 1),
+	}))
+
+	hub := stream.NewHub()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage).WithUpdateHook(hub)
+	srv := rest.NewServer(metricUsecase, nil).WithStream(hub)
+	// The hashing middleware must not hold the stream back.
+	ts := httptest.NewServer(router.NewRouter(srv, srvCfg.NewServerOptions(srvCfg.WithKey("secret"))))
+	defer ts.Close()
+
+	ids := func(metrics []serialize.Metric) []string {
+		var got []string
+		for _, m := range metrics {
+			got = append(got, m.ID)
+		}
+		return got
+	}
+
+	t.Run("server-sent events", func(t *testing.T) {
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, ts.URL+"/stream?name=cpu", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+		events := bufio.NewReader(resp.Body)
+		next := func() (string, []serialize.Metric) {
+			t.Helper()
+
+			var event string
+			for {
+				line, err := events.ReadString('\n')
+				require.NoError(t, err)
+
+				switch {
+				case strings.HasPrefix(line, "event: "):
+					event = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+				case strings.HasPrefix(line, "data: "):
+					var metrics []serialize.Metric
+					require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &metrics))
+					return event, metrics
+				}
+			}
+		}
+
+		event, metrics := next()
+		assert.Equal(t, rest.StreamSnapshot, event)
+		assert.Equal(t, []string{`cpu{host="a"}`}, ids(metrics))
+
+		require.NoError(t, metricUsecase.UpdateMetric(ctx, models.CounterType, "hits", int64(1)))
+		require.NoError(t, metricUsecase.UpdateMetricList(ctx, []models.Metric{models.NewGauge(`cpu{host="a"}`, 0.75)}))
+
+		event, metrics = next()
+		assert.Equal(t, rest.StreamUpdate, event)
+		require.Equal(t, []string{`cpu{host="a"}`}, ids(metrics))
+		assert.Equal(t, 0.75, *metrics[0].Value)
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/stream?type=gauge", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		defer resp.Body.Close()
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		var msg rest.StreamMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, rest.StreamSnapshot, msg.Type)
+		assert.Equal(t, []string{`cpu{host="a"}`}, ids(msg.Metrics))
+
+		// Changing the filter sends a new snapshot.
+		require.NoError(t, conn.WriteJSON(rest.StreamFilter{Type: models.CounterType}))
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, rest.StreamSnapshot, msg.Type)
+		assert.Equal(t, []string{"hits"}, ids(msg.Metrics))
+
+		require.NoError(t, metricUsecase.UpdateMetric(ctx, models.CounterType, "hits", int64(3)))
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, rest.StreamUpdate, msg.Type)
+		require.Equal(t, []string{"hits"}, ids(msg.Metrics))
+		assert.Equal(t, int64(5), *msg.Metrics[0].Delta)
+
+		require.NoError(t, conn.WriteJSON(rest.StreamFilter{Name: "cpu["}))
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, rest.StreamError, msg.Type)
+
+		// The hub ends the subscription when the server shuts down.
+		hub.Close()
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, rest.StreamError, msg.Type)
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/stream?type=histogram")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("disabled without a hub", func(t *testing.T) {
+		handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stream", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestConditionalGet(t *testing.T) {
+	ctx := context.Background()
+
+	const key = "secret"
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(srvCfg.WithKey(key)))
+
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 7),
+	}))
+
+	do := func(method, url, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("all metrics", func(t *testing.T) {
+		jsonAccept := map[string]string{"Accept": "application/json"}
+
+		rr := do(http.MethodGet, "/", "", jsonAccept)
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+		assert.True(t, strings.HasPrefix(etag, `W/"`))
+		assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+
+		rr = do(http.MethodGet, "/", "", map[string]string{"Accept": "application/json", "If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		assert.Empty(t, rr.Header().Get("HashSHA256"), "an empty body is not signed")
+
+		// The HTML page is another representation of the same state.
+		rr = do(http.MethodGet, "/", "", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+
+		require.NoError(t, storage.UpdateMetric(ctx, models.GaugeType, "Alloc", 2.5))
+
+		rr = do(http.MethodGet, "/", "", map[string]string{"Accept": "application/json", "If-None-Match": etag})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+		assert.Contains(t, rr.Body.String(), "2.5")
+
+		rr = do(http.MethodGet, "/", "", map[string]string{"Accept": "application/json", "If-None-Match": "*"})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+	})
+
+	t.Run("value", func(t *testing.T) {
+		rr := do(http.MethodGet, "/value/gauge/Alloc", "", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		// The status is sent after the hash of the body is set.
+		signature, err := hash.GetHash([]byte(key), rr.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(signature), rr.Header().Get("HashSHA256"))
+
+		rr = do(http.MethodGet, "/value/gauge/Alloc", "", map[string]string{"If-None-Match": `"other", ` + etag})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+
+		// The tag of a metric doesn't match another one.
+		rr = do(http.MethodGet, "/value/counter/PollCount", "", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "7", rr.Body.String())
+	})
+
+	t.Run("json value", func(t *testing.T) {
+		body := `{"id":"PollCount","type":"counter"}`
+		header := map[string]string{"Content-Type": "application/json"}
+
+		rr := do(http.MethodPost, "/value/", body, header)
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		header["If-None-Match"] = etag
+		rr = do(http.MethodPost, "/value/", body, header)
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Body.String())
+
+		require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "PollCount", int64(1)))
+
+		rr = do(http.MethodPost, "/value/", body, header)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"delta":8`)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		rr := do(http.MethodGet, "/", "", map[string]string{"Accept-Encoding": "gzip"})
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+		rr = do(http.MethodGet, "/", "", map[string]string{
+			"Accept-Encoding": "gzip",
+			"If-None-Match":   rr.Header().Get("ETag"),
+		})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Empty(t, rr.Body.String())
+	})
+}
+
+func TestContentNegotiation(t *testing.T) {
+	ctx := context.Background()
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 7),
+	}))
+
+	do := func(method, url, body, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("value", func(t *testing.T) {
+		rr := do(http.MethodGet, "/value/gauge/Alloc", "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "1.5", rr.Body.String())
+		assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+
+		rr = do(http.MethodGet, "/value/gauge/Alloc", "", "application/json")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":1.5}`, rr.Body.String())
+
+		rr = do(http.MethodGet, "/value/counter/PollCount", "", "application/x-protobuf")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/x-protobuf", rr.Header().Get("Content-Type"))
+		var metric pb.Metric
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &metric))
+		assert.Equal(t, "PollCount", metric.GetId())
+		assert.Equal(t, int64(7), metric.GetDelta())
+
+		rr = do(http.MethodGet, "/value/gauge/Alloc", "", "image/png")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+		assert.Contains(t, rr.Body.String(), "application/msgpack")
+	})
+
+	t.Run("json value", func(t *testing.T) {
+		body := `{"id":"PollCount","type":"counter"}`
+
+		rr := do(http.MethodPost, "/value", body, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		rr = do(http.MethodPost, "/value", body, "application/msgpack")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/msgpack", rr.Header().Get("Content-Type"))
+		var metric map[string]any
+		require.NoError(t, msgpack.Unmarshal(rr.Body.Bytes(), &metric))
+		assert.Equal(t, "PollCount", metric["id"])
+		assert.EqualValues(t, 7, metric["delta"])
+
+		rr = do(http.MethodPost, "/value", body, "text/plain")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "7", rr.Body.String())
+	})
+
+	t.Run("all metrics", func(t *testing.T) {
+		rr := do(http.MethodGet, "/", "", "text/html,application/xhtml+xml,*/*;q=0.8")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+
+		rr = do(http.MethodGet, "/", "", "text/plain")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.ElementsMatch(t, []string{"Alloc\tgauge\t1.5", "PollCount\tcounter\t7"},
+			strings.Split(strings.TrimSpace(rr.Body.String()), "\n"))
+
+		rr = do(http.MethodGet, "/", "", "text/plain; version=0.0.4")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, exposition.ContentTypeText, rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "# TYPE PollCount counter")
+
+		rr = do(http.MethodGet, "/", "", "application/protobuf")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var all pb.GetAllMetricsResponse
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &all))
+		assert.Len(t, all.GetMetrics(), 2)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		body := `[{"id":"Alloc","type":"gauge"},{"id":"Missing","type":"gauge"}]`
+
+		rr := do(http.MethodPost, "/values", body, "application/x-protobuf")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var batch pb.GetMetricsResponse
+		require.NoError(t, proto.Unmarshal(rr.Body.Bytes(), &batch))
+		assert.Len(t, batch.GetMetrics(), 1)
+		assert.Len(t, batch.GetMissing(), 1)
+
+		rr = do(http.MethodPost, "/values", body, "text/plain")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+	})
+
+	t.Run("api v2", func(t *testing.T) {
+		rr := do(http.MethodGet, "/api/v2/metrics", "", "application/msgpack")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page rest.MetricsPage
+		dec := msgpack.NewDecoder(bytes.NewReader(rr.Body.Bytes()))
+		dec.SetCustomStructTag("json")
+		require.NoError(t, dec.Decode(&page))
+		assert.Len(t, page.Metrics, 2)
+
+		rr = do(http.MethodGet, "/api/v2/metrics/gauge/Alloc", "", "text/plain")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		var resp rest.ErrorResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, rest.CodeNotAcceptable, resp.Error.Code)
+	})
+
+	t.Run("prometheus", func(t *testing.T) {
+		rr := do(http.MethodGet, "/metrics", "", "application/json")
+		require.Equal(t, http.StatusOK, rr.Code, "scrapers are not refused")
+		assert.Equal(t, exposition.ContentTypeText, rr.Header().Get("Content-Type"))
+	})
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions())
+
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("HeapAlloc", 100),
+		models.NewGauge("HeapSys", 200),
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewCounter("errors", 5),
+		models.NewCounter("requests", 20),
+	}))
+
+	do := func(q string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/query?q="+url.QueryEscape(q), nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("vector", func(t *testing.T) {
+		rr := do(`sum(Heap*) + cpu{host="a"} * 0`, nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.JSONEq(t, `{"type":"vector","samples":[]}`, rr.Body.String())
+
+		rr = do("topk(1, Heap*)", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"type":"vector","samples":[{"id":"HeapSys","name":"HeapSys","value":200}]}`, rr.Body.String())
+
+		rr = do(`cpu{host="a"} * 2`, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"type":"vector","samples":[{"id":"cpu{host=\"a\"}","name":"cpu","labels":{"host":"a"},"value":1}]}`,
+			rr.Body.String())
+
+		rr = do("errors / requests", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"type":"vector","samples":[{"value":0.25}]}`, rr.Body.String())
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		rr := do("1 + 2 * 3", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"type":"scalar","samples":[{"value":7}]}`, rr.Body.String())
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		rr := do("sum(Heap*)", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		rr = do("sum(Heap*)", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, rr.Code)
+
+		rr = do("max(Heap*)", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusOK, rr.Code, "the tag is per query")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rr := do("sum(Heap* +", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var resp rest.ErrorResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, rest.CodeInvalidArgument, resp.Error.Code)
+		assert.Equal(t, "12", resp.Error.Details["position"])
+		assert.Contains(t, resp.Error.Message, "position 12")
+
+		rr = do("", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	newHandler := func(requests, metrics float64, key string, opts ...srvCfg.Option) http.Handler {
+		storage := repo.NewMemStorage()
+		metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+		return router.NewRouter(rest.NewServer(metricUsecase, nil),
+			srvCfg.NewServerOptions(append(opts, srvCfg.WithRateLimit(requests, metrics, key))...))
+	}
+
+	do := func(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("requests", func(t *testing.T) {
+		handler := newHandler(2, 0, "ip")
+
+		get := func(addr, realIP string) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/value/gauge/missing", nil)
+			req.RemoteAddr = addr + ":1234"
+			if realIP != "" {
+				req.Header.Set("X-Real-IP", realIP)
+			}
+			return req
+		}
+
+		for range 2 {
+			rr := do(handler, get("10.0.0.1", ""))
+			require.NotEqual(t, http.StatusTooManyRequests, rr.Code)
+		}
+
+		// X-Real-IP is ignored outside the trusted subnet.
+		rr := do(handler, get("10.0.0.1", "10.0.0.3"))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+		rr = do(handler, get("10.0.0.2", ""))
+		assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "every client has its own limit")
+
+		t.Run("behind a trusted proxy", func(t *testing.T) {
+			handler := newHandler(2, 0, "ip", srvCfg.WithTrustedSubnet("10.0.0.0/24"))
+
+			for range 2 {
+				rr := do(handler, get("10.0.0.1", "10.0.0.5"))
+				require.NotEqual(t, http.StatusTooManyRequests, rr.Code)
+			}
+
+			rr := do(handler, get("10.0.0.1", "10.0.0.5"))
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+			rr = do(handler, get("10.0.0.1", "10.0.0.6"))
+			assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "the proxy reports the client")
+		})
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		handler := newHandler(0, 3, "agent", srvCfg.WithTrustedSubnet("192.0.2.0/24"))
+
+		update := func(agent string, n int) *http.Request {
+			metrics := make([]string, n)
+			for i := range metrics {
+				metrics[i] = `{"id":"m` + string(rune('a'+i)) + `","type":"gauge","value":1}`
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("["+strings.Join(metrics, ",")+"]"))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Agent-ID", agent)
+			req.Header.Set("X-Real-IP", "192.0.2.10")
+			return req
+		}
+
+		// The batch over the quota is stored, and the agent owes the excess.
+		rr := do(handler, update("agent-1", 9))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = do(handler, update("agent-1", 1))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+		rr = do(handler, update("agent-2", 1))
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		t.Run("agent ID outside the trusted subnet", func(t *testing.T) {
+			handler := newHandler(0, 3, "agent")
+
+			rr := do(handler, update("agent-1", 9))
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			// A new agent ID doesn't reset the limit of the address.
+			rr = do(handler, update("agent-2", 1))
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		handler := newHandler(0, 0, "ip")
+
+		for range 10 {
+			rr := do(handler, httptest.NewRequest(http.MethodGet, "/value/gauge/missing", nil))
+			require.NotEqual(t, http.StatusTooManyRequests, rr.Code)
+		}
+	})
+}
+
+func TestAdmission(t *testing.T) {
+	const limit = 64 << 10
+
+	newHandler := func(opts ...srvCfg.Option) http.Handler {
+		storage := repo.NewMemStorage()
+		metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+		options := srvCfg.NewServerOptions(append([]srvCfg.Option{
+			srvCfg.WithLimits(limit, limit, 2, 0),
+		}, opts...)...)
+
+		return router.NewRouter(rest.NewServer(metricUsecase, nil).WithLimits(options.Limits()), options)
+	}
+
+	// gzipBomb returns a gzip body of the prefix followed by 16 MiB of
+	// spaces, which compress to about 16 KiB.
+	gzipBomb := func(t *testing.T, prefix string) *bytes.Buffer {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(prefix))
+		require.NoError(t, err)
+
+		spaces := bytes.Repeat([]byte(" "), 1<<20)
+		for range 16 {
+			_, err := gz.Write(spaces)
+			require.NoError(t, err)
+		}
+		require.NoError(t, gz.Close())
+		require.Less(t, buf.Len(), limit)
+
+		return &buf
+	}
+
+	do := func(handler http.Handler, path, contentType string, body io.Reader, gzipped bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, body)
+		req.Header.Set("Content-Type", contentType)
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("gzip bomb", func(t *testing.T) {
+		handler := newHandler()
+
+		tests := []struct {
+			path        string
+			contentType string
+			prefix      string
+		}{
+			{path: "/update/", contentType: "application/json", prefix: `{"id":"a","type":"gauge","value":1`},
+			{path: "/updates/", contentType: "application/json", prefix: `[{"id":"a","type":"gauge","value":1}`},
+			{path: "/value/", contentType: "application/json", prefix: `{"id":"a","type":"gauge"`},
+			{path: "/values/", contentType: "application/json", prefix: `[{"id":"a","type":"gauge"}`},
+			{path: "/api/v2/metrics", contentType: "application/json", prefix: `{"metrics":[`},
+			{path: "/write", contentType: "text/plain", prefix: "cpu value=1\n"},
+			{path: "/v1/metrics", contentType: rest.ContentTypeProtobuf},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.path, func(t *testing.T) {
+				rr := do(handler, tt.path, tt.contentType, gzipBomb(t, tt.prefix), true)
+				assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+			})
+		}
+
+		rr := do(handler, "/api/v2/metrics", "application/json", gzipBomb(t, `{"metrics":[`), true)
+		var resp rest.ErrorResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, rest.CodeTooLarge, resp.Error.Code)
+	})
+
+	t.Run("body size", func(t *testing.T) {
+		large := `[{"id":"a","type":"gauge","value":1}` + strings.Repeat(" ", limit) + `]`
+
+		rr := do(newHandler(), "/updates/", "application/json", strings.NewReader(large), false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		// The hashing middleware reads the body before the handler.
+		rr = do(newHandler(srvCfg.WithKey("secret")), "/updates/", "application/json", strings.NewReader(large), false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		rr = do(newHandler(), "/updates/", "application/json", strings.NewReader(`[{"id":"a","type":"gauge","value":1}]`), false)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		// Imports have a limit of their own.
+		rows := "id,type,value\n" + strings.Repeat("a,gauge,1\n", limit/10+1)
+		rr = do(newHandler(), "/import?format=csv", "text/csv", strings.NewReader(rows), false)
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	})
+
+	t.Run("batch size", func(t *testing.T) {
+		handler := newHandler()
+
+		rr := do(handler, "/updates/", "application/json", strings.NewReader(
+			`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]`), false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		rr = do(handler, "/api/v2/metrics", "application/json", strings.NewReader(
+			`{"metrics":[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]}`), false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		rr = do(handler, "/values/", "application/json", strings.NewReader(
+			`[{"id":"a","type":"gauge"},{"id":"b","type":"gauge"},{"id":"c","type":"gauge"}]`), false)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		rr = do(handler, "/updates/", "application/json", strings.NewReader(
+			`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1}]`), false)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = do(handler, "/values/", "application/json", strings.NewReader(
+			`[{"id":"a","type":"gauge"},{"id":"b","type":"gauge"}]`), false)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestWithLoadShedding(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := rest.WithLoadShedding(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-entered
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	close(release)
+	<-done
+
+	go func() { <-entered }()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	const key = "secret"
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), srvCfg.NewServerOptions(srvCfg.WithKey(key)))
+
+	encode := func(t *testing.T, encoding string, data []byte) []byte {
+		codec, ok := compression.Lookup(encoding)
+		require.True(t, ok)
+
+		var buf bytes.Buffer
+		w, err := codec.NewWriter(&buf, compression.DefaultLevel)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		return buf.Bytes()
+	}
+
+	for _, encoding := range []string{compression.Zstd, compression.Brotli, compression.Gzip, compression.Deflate} {
+		t.Run("request body "+encoding, func(t *testing.T) {
+			// The body is signed as it is sent, compressed.
+			body := encode(t, encoding, []byte(`[{"id":"`+encoding+`","type":"gauge","value":1.5}]`))
+			signature, err := hash.GetHash([]byte(key), body)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+			req.Header.Set("HashSHA256", hex.EncodeToString(signature))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			metric, err := storage.GetMetric(ctx, models.GaugeType, encoding)
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, metric.Value())
+		})
+	}
+
+	t.Run("unsupported request encoding", func(t *testing.T) {
+		for _, path := range []string{"/update/", "/updates/", "/api/v2/metrics", "/write"} {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("[]"))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "lz4")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code, path)
+		}
+	})
+
+	tests := []struct {
+		accept       string
+		wantEncoding string
+	}{
+		{accept: "gzip, deflate, br, zstd", wantEncoding: compression.Zstd},
+		{accept: "br;q=1.0, gzip;q=0.8", wantEncoding: compression.Brotli},
+		{accept: "deflate", wantEncoding: compression.Deflate},
+		{accept: "zstd;q=0, lz4"},
+		{accept: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("response "+tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v2/metrics/gauge/missing", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, http.StatusNotFound, rr.Code)
+			assert.Equal(t, tt.wantEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Contains(t, rr.Header().Values("Vary"), "Accept-Encoding")
+
+			body, err := compression.NewReader(io.NopCloser(rr.Body), tt.wantEncoding)
+			require.NoError(t, err)
+			defer body.Close()
+
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Contains(t, string(data), "not_found")
+		})
+	}
+}
+
+func TestRequestSigning(t *testing.T) {
+	const key = "secret"
+
+	newHandler := func(require bool) http.Handler {
+		storage := repo.NewMemStorage()
+		metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+		opts := srvCfg.NewServerOptions(srvCfg.WithKey(key), srvCfg.WithSignature(60, require))
+
+		return router.NewRouter(rest.NewServer(metricUsecase, nil), opts)
+	}
+
+	// sign sets the canonical signature of req, made at ts.
+	sign := func(t *testing.T, req *http.Request, body []byte, ts time.Time) {
+		nonce, err := hash.NewNonce()
+		require.NoError(t, err)
+
+		signature, err := hash.SignRequest([]byte(key), hash.Request{
+			Method:    req.Method,
+			Path:      req.URL.EscapedPath(),
+			Query:     req.URL.Query(),
+			Body:      body,
+			Timestamp: ts,
+			Nonce:     nonce,
+		})
+		require.NoError(t, err)
+
+		req.Header.Set(hash.SignatureHeader, signature)
+		req.Header.Set(hash.TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		req.Header.Set(hash.NonceHeader, nonce)
+	}
+
+	serve := func(handler http.Handler, req *http.Request) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("url parameters", func(t *testing.T) {
+		handler := newHandler(false)
+
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		sign(t, req, nil, time.Now())
+		require.Equal(t, http.StatusOK, serve(handler, req))
+
+		replayed := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		replayed.Header = req.Header.Clone()
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, replayed), "replay")
+
+		// The signature of one value doesn't sign another.
+		forged := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/100", nil)
+		forged.Header = req.Header.Clone()
+		forged.Header.Set(hash.NonceHeader, "other")
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, forged), "forged")
+
+		stale := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		sign(t, stale, nil, time.Now().Add(-time.Hour))
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, stale), "stale")
+	})
+
+	t.Run("body and query", func(t *testing.T) {
+		handler := newHandler(false)
+		body := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		sign(t, req, body, time.Now())
+		require.Equal(t, http.StatusOK, serve(handler, req))
+
+		req = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[]`))
+		req.Header.Set("Content-Type", "application/json")
+		sign(t, req, body, time.Now())
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, req), "other body")
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v2/metrics?type=gauge", nil)
+		sign(t, req, nil, time.Now())
+		req.URL.RawQuery = "type=counter"
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, req), "other query")
+	})
+
+	t.Run("required", func(t *testing.T) {
+		handler := newHandler(true)
+
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, req), "unsigned")
+
+		signature, err := hash.GetHash([]byte(key), nil)
+		require.NoError(t, err)
+		req = httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		req.Header.Set("HashSHA256", hex.EncodeToString(signature))
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, req), "body hash")
+
+		req = httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		sign(t, req, nil, time.Now())
+		assert.Equal(t, http.StatusOK, serve(handler, req), "signed")
+
+		req = httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc", nil)
+		assert.Equal(t, http.StatusOK, serve(handler, req), "reads are not required to be signed")
+
+		req = httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		req.Header.Set("HashSHA256", hex.EncodeToString(signature))
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, req), "admin body hash")
+
+		req = httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		sign(t, req, nil, time.Now())
+		assert.Equal(t, http.StatusOK, serve(handler, req), "admin signed")
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "old", "secret": "old-secret"},
+		{"id": "new", "secret": "new-secret", "algorithm": "hmac-sha512"},
+		{"id": "legacy", "secret": "legacy-secret"},
+		{"id": "strict", "secret": "strict-secret", "canonical": true}
+	]`), 0o600))
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	opts := srvCfg.NewServerOptions(srvCfg.WithKey("default"), srvCfg.WithKeyringFile(path))
+	keys, err := opts.Keyring()
+	require.NoError(t, err)
+	opts.Keys = keys
+	handler := router.NewRouter(rest.NewServer(metricUsecase, nil), opts)
+
+	// send sends an update signed with secret by algorithm, under keyID.
+	send := func(t *testing.T, keyID, secret, algorithm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+		nonce, err := hash.NewNonce()
+		require.NoError(t, err)
+
+		r := hash.Request{
+			Method:    req.Method,
+			Path:      req.URL.EscapedPath(),
+			Timestamp: time.Now(),
+			Nonce:     nonce,
+			Algorithm: algorithm,
+		}
+		signature, err := hash.SignRequest([]byte(secret), r)
+		require.NoError(t, err)
+
+		req.Header.Set(hash.KeyIDHeader, keyID)
+		req.Header.Set(hash.AlgorithmHeader, algorithm)
+		req.Header.Set(hash.SignatureHeader, signature)
+		req.Header.Set(hash.TimestampHeader, strconv.FormatInt(r.Timestamp.Unix(), 10))
+		req.Header.Set(hash.NonceHeader, nonce)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, send(t, "", "default", "").Code, "default key")
+	assert.Equal(t, http.StatusOK, send(t, "old", "old-secret", hash.HMACSHA256).Code, "old key")
+	assert.Equal(t, http.StatusOK, send(t, "new", "new-secret", hash.HMACSHA512).Code, "new key")
+	assert.Equal(t, http.StatusUnauthorized, send(t, "new", "new-secret", hash.HMACSHA256).Code, "pinned algorithm")
+	assert.Equal(t, http.StatusUnauthorized, send(t, "old", "new-secret", hash.HMACSHA256).Code, "other secret")
+	assert.Equal(t, http.StatusUnauthorized, send(t, "other", "old-secret", hash.HMACSHA256).Code, "unknown key")
+
+	// sendHash sends an update with only a body hash by secret, under keyID.
+	sendHash := func(t *testing.T, keyID, secret string) *httptest.ResponseRecorder {
+		sum, err := hash.GetHash([]byte(secret), nil)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/2", nil)
+		req.Header.Set(hash.KeyIDHeader, keyID)
+		req.Header.Set("HashSHA256", hex.EncodeToString(sum))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The body hash is checked with the named key too.
+	assert.Equal(t, http.StatusOK, sendHash(t, "legacy", "legacy-secret").Code, "body hash")
+	assert.Equal(t, http.StatusBadRequest, sendHash(t, "legacy", "old-secret").Code, "body hash of another key")
+
+	// Once a key signs canonically, a body hash alone may be a signed
+	// request with the signature stripped.
+	assert.Equal(t, http.StatusUnauthorized, sendHash(t, "old", "old-secret").Code, "stripped signature")
+	assert.Equal(t, http.StatusUnauthorized, sendHash(t, "", "default").Code, "stripped signature of the default key")
+	assert.Equal(t, http.StatusUnauthorized, sendHash(t, "strict", "strict-secret").Code, "canonical key")
+	assert.Equal(t, http.StatusOK, send(t, "strict", "strict-secret", "").Code, "canonical key signed")
+
+	// The old key is deprecated, and the change is picked up on reload.
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "old", "secret": "old-secret", "deprecated": "2000-01-01T00:00:00Z"},
+		{"id": "new", "secret": "new-secret", "algorithm": "hmac-sha512"}
+	]`), 0o600))
+	require.NoError(t, keys.Reload())
+
+	assert.Equal(t, http.StatusUnauthorized, send(t, "old", "old-secret", hash.HMACSHA256).Code, "deprecated key")
+	assert.Equal(t, http.StatusOK, send(t, "new", "new-secret", hash.HMACSHA512).Code, "new key after reload")
+}
+
+func TestAPITokens(t *testing.T) {
+	const adminToken = "admin-token"
+
+	storage := repo.NewMemStorage()
+	metricUsecase := srvUsecase.NewMetricUsecase(storage, storage, storage)
+	srv := rest.NewServer(metricUsecase, nil).WithTokens(token.NewTokenUsecase(storage))
+	handler := router.NewRouter(srv, srvCfg.NewServerOptions(
+		srvCfg.WithAdminToken(adminToken),
+		srvCfg.WithRequireToken(true),
+	))
+
+	serve := func(method, target, bearer string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	create := func(t *testing.T, body string) rest.TokenCreated {
+		rr := serve(http.MethodPost, "/admin/tokens", adminToken, strings.NewReader(body))
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+		var created rest.TokenCreated
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+		return created
+	}
+
+	writer := create(t, `{"name": "agent-1", "scopes": ["metrics:write"], "prefix": "host1."}`)
+	reader := create(t, `{"name": "dashboard", "scopes": ["metrics:read"]}`)
+	assert.Equal(t, []apitoken.Scope{apitoken.ScopeWrite}, writer.Scopes)
+	assert.Equal(t, "host1.", writer.Prefix)
+	assert.True(t, apitoken.IsSecret(writer.Secret))
+
+	t.Run("invalid requests", func(t *testing.T) {
+		rr := serve(http.MethodPost, "/admin/tokens", adminToken, strings.NewReader(`{"name": "x", "scopes": ["all"]}`))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr = serve(http.MethodPost, "/admin/tokens", adminToken, strings.NewReader(`{"name": "", "scopes": ["admin"]}`))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			method     string
+			target     string
+			bearer     string
+			wantStatus int
+		}{
+			{name: "no token", method: http.MethodPost, target: "/update/gauge/host1.Alloc/1.5", wantStatus: http.StatusUnauthorized},
+			{name: "unknown token", method: http.MethodPost, target: "/update/gauge/host1.Alloc/1.5", bearer: "mst_unknown", wantStatus: http.StatusUnauthorized},
+			{name: "write", method: http.MethodPost, target: "/update/gauge/host1.Alloc/1.5", bearer: writer.Secret, wantStatus: http.StatusOK},
+			{name: "write outside prefix", method: http.MethodPost, target: "/update/gauge/host2.Alloc/1.5", bearer: writer.Secret, wantStatus: http.StatusForbidden},
+			{name: "read without scope", method: http.MethodGet, target: "/value/gauge/host1.Alloc", bearer: writer.Secret, wantStatus: http.StatusForbidden},
+			{name: "read", method: http.MethodGet, target: "/value/gauge/host1.Alloc", bearer: reader.Secret, wantStatus: http.StatusOK},
+			{name: "write without scope", method: http.MethodPost, target: "/update/gauge/Alloc/1.5", bearer: reader.Secret, wantStatus: http.StatusForbidden},
+			{name: "api v2 write without scope", method: http.MethodPost, target: "/api/v2/metrics/gauge/Alloc", bearer: reader.Secret, wantStatus: http.StatusForbidden},
+			{name: "admin without scope", method: http.MethodGet, target: "/admin/tokens", bearer: reader.Secret, wantStatus: http.StatusForbidden},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := serve(tt.method, tt.target, tt.bearer, nil)
+				assert.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			})
+		}
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		require.NoError(t, storage.UpdateMetric(context.Background(), models.GaugeType, "host2.Alloc", 2.5))
+		prefixed := create(t, `{"name": "host1", "scopes": ["metrics:read", "metrics:write"], "prefix": "host1."}`)
+
+		rr := serve(http.MethodGet, "/value/gauge/host2.Alloc", prefixed.Secret, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+prefixed.Secret)
+		req.Header.Set("Accept", "application/json")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "host1.Alloc")
+		assert.NotContains(t, rr.Body.String(), "host2.Alloc")
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		rr := serve(http.MethodGet, "/admin/tokens", adminToken, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), writer.Secret)
+		assert.NotContains(t, rr.Body.String(), apitoken.Hash(writer.Secret))
+
+		var tokens []rest.TokenInfo
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+		require.Len(t, tokens, 3)
+
+		admin := create(t, `{"name": "ops", "scopes": ["admin"]}`)
+		rr = serve(http.MethodDelete, "/admin/tokens/"+writer.ID, admin.Secret, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+
+		rr = serve(http.MethodDelete, "/admin/tokens/"+writer.ID, adminToken, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		rr = serve(http.MethodPost, "/update/gauge/host1.Alloc/1.5", writer.Secret, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "revoked")
+	})
 }
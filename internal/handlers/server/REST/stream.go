@@ -0,0 +1,313 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/stream"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+const (
+	// StreamHeartbeat is the interval of the keep-alive messages of a stream.
+	StreamHeartbeat = 15 * time.Second
+	// StreamRetry is the reconnection delay advised to SSE clients.
+	StreamRetry = 3 * time.Second
+	// streamWriteTimeout bounds writing a message to a stream client, so a
+	// client that stopped reading is disconnected.
+	streamWriteTimeout = 10 * time.Second
+	// maxStreamMessageSize is the largest message of a WebSocket client.
+	maxStreamMessageSize = 64 * 1024
+)
+
+// Stream message types, the SSE event names.
+const (
+	StreamSnapshot = "snapshot"
+	StreamUpdate   = "update"
+	StreamError    = "error"
+)
+
+// StreamMessage is a message of the WebSocket stream.
+type StreamMessage struct {
+	// Type is snapshot, update or error.
+	Type string `json:"type"`
+	// Metrics are all the metrics matching the filter in a snapshot,
+	// and the updated ones in an update. Counters have their total.
+	Metrics []serialize.Metric `json:"metrics,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// StreamFilter is the message a WebSocket client sends to change the
+// filter of its subscription, answered by a new snapshot.
+type StreamFilter struct {
+	Type string `json:"type,omitempty"`
+	// Name is a glob of the metric name without labels.
+	Name string `json:"name,omitempty"`
+	// Labels are globs the label values must match; "*" only requires the label.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// WithStream sets the hub of the stream, nil to disable it. The hub has
+// to be the update hook of the metric usecase to be notified of the updates.
+func (srv *Server) WithStream(hub *stream.Hub) *Server {
+	srv.StreamHub = hub
+	return srv
+}
+
+// @Title Stream
+// @Description Push the metric updates as Server-Sent Events or, with a WebSocket upgrade,
+// @Description as WebSocket messages. The stream starts with a snapshot of the matching metrics.
+// @Description A client that doesn't keep up is sent an error and disconnected, and should reconnect.
+// @Tags metrics
+// @Produces text/event-stream
+// @Param type query string false "Metric type"
+// @Param name query string false "Glob of the metric name without labels"
+// @Param label query string false "Label filter key:glob or key, repeatable"
+// @Success 200 {string} string "Events snapshot, update and error with JSON data"
+// @Success 101 {string} string "WebSocket of StreamMessage, the client sends StreamFilter"
+// @Failure 400 {string} string "Invalid filter"
+// @Failure 503 {string} string "Server is shutting down"
+// @Router /stream [GET]
+func (srv *Server) Stream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// details hold the first invalid parameter.
+		filter, details := parseListFilter(r)
+		for _, msg := range details {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		sub, err := srv.StreamHub.Subscribe(tenant.FromContext(r.Context()), filter)
+		if err != nil {
+			if errors.Is(err, srvUsecase.ErrInvalidFilter) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer sub.Close()
+
+		if websocket.IsWebSocketUpgrade(r) {
+			srv.streamWebSocket(w, r, sub)
+			return
+		}
+
+		srv.streamEvents(w, r, sub)
+	}
+}
+
+// streamSnapshot returns the metrics matching the filter of the subscription.
+// The subscription is started first, so no update is lost in between.
+// The storage is ranged over, so only the matching metrics are held and
+// they are not sorted.
+func (srv *Server) streamSnapshot(r *http.Request, sub *stream.Subscription) ([]serialize.Metric, error) {
+	var metrics []models.Metric
+	err := srv.MetricUsecase.EachMetric(r.Context(), sub.Filter(), func(metric models.Metric) error {
+		metrics = append(metrics, metric)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return converter.ConvertToSerialization(metrics)
+}
+
+func (srv *Server) streamEvents(w http.ResponseWriter, r *http.Request, sub *stream.Subscription) {
+	snapshot, err := srv.streamSnapshot(r, sub)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get stream snapshot")
+		http.Error(w, "failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Proxies must not buffer the events.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(text string) error {
+		// A stream outlives the server's write timeout, so every
+		// message gets its own deadline.
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil &&
+			!errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := fmt.Fprint(w, text); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	send := func(event string, data any) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		return write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, payload))
+	}
+
+	if err := write(fmt.Sprintf("retry: %d\n\n", StreamRetry.Milliseconds())); err != nil {
+		return
+	}
+	if err := send(StreamSnapshot, snapshot); err != nil {
+		log.Debug().Err(err).Msg("failed to send stream snapshot")
+		return
+	}
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case updates := <-sub.Updates():
+			err = send(StreamUpdate, updates)
+		case <-heartbeat.C:
+			err = write(": ping\n\n")
+		case <-sub.Done():
+			log.Info().Err(sub.Err()).Msg("stream ended")
+			_ = send(StreamError, map[string]string{"error": sub.Err().Error()})
+			return
+		}
+
+		if err != nil {
+			log.Debug().Err(err).Msg("stream client disconnected")
+			return
+		}
+	}
+}
+
+func (srv *Server) streamWebSocket(w http.ResponseWriter, r *http.Request, sub *stream.Subscription) {
+	// Upgrade answers the failed handshakes itself.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to upgrade to websocket")
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Debug().Err(err).Msg("failed to close websocket")
+		}
+	}()
+
+	conn.SetReadLimit(maxStreamMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * StreamHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * StreamHeartbeat))
+	})
+
+	// Only this goroutine writes to the connection; the reader passes the
+	// messages of the client over.
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			select {
+			case messages <- data:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	send := func(msg StreamMessage) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(msg)
+	}
+
+	sendSnapshot := func() error {
+		snapshot, err := srv.streamSnapshot(r, sub)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get stream snapshot")
+			return send(StreamMessage{Type: StreamError, Error: "failed to get metrics"})
+		}
+		return send(StreamMessage{Type: StreamSnapshot, Metrics: snapshot})
+	}
+
+	if err := sendSnapshot(); err != nil {
+		log.Debug().Err(err).Msg("failed to send stream snapshot")
+		return
+	}
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case err := <-readErr:
+			log.Debug().Err(err).Msg("stream client disconnected")
+			return
+		case data := <-messages:
+			var msg StreamFilter
+			if err = json.Unmarshal(data, &msg); err != nil {
+				err = send(StreamMessage{Type: StreamError, Error: "invalid filter message: " + err.Error()})
+				break
+			}
+
+			filter := srvUsecase.ListFilter{Type: msg.Type, NamePattern: msg.Name, Labels: msg.Labels}
+			if err = sub.SetFilter(filter); err != nil {
+				err = send(StreamMessage{Type: StreamError, Error: err.Error()})
+				break
+			}
+			err = sendSnapshot()
+		case updates := <-sub.Updates():
+			err = send(StreamMessage{Type: StreamUpdate, Metrics: updates})
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case <-sub.Done():
+			log.Info().Err(sub.Err()).Msg("stream ended")
+
+			code := websocket.CloseTryAgainLater
+			if errors.Is(sub.Err(), stream.ErrClosed) {
+				code = websocket.CloseGoingAway
+			}
+			_ = send(StreamMessage{Type: StreamError, Error: sub.Err().Error()})
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, sub.Err().Error()), time.Now().Add(streamWriteTimeout))
+			return
+		}
+
+		if err != nil {
+			log.Debug().Err(err).Msg("stream client disconnected")
+			return
+		}
+	}
+}
@@ -46,14 +46,14 @@ func WithTrustedSubnet(trustedSubnet string) func(http.Handler) http.Handler {
 			ip := net.ParseIP(ipStr)
 			if ip == nil {
 				log.Error().Msg("failed to parse ip")
-				http.Error(w, "failed to parse ip", http.StatusBadRequest)
+				httpError(w, r, "failed to parse ip", http.StatusBadRequest)
 				return
 			}
 
 			// Check if the IP address is in the trusted subnet.
 			if !subnet.Contains(ip) {
 				log.Error().Msg("ip is not in trusted subnet")
-				http.Error(w, "ip is not in trusted subnet", http.StatusForbidden)
+				httpError(w, r, "ip is not in trusted subnet", http.StatusForbidden)
 				return
 			}
 
@@ -0,0 +1,271 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// TenantParam is the URL parameter with the tenant ID of tenant routes.
+const TenantParam = "tenant"
+
+// tenantResolver determines the tenant of requests.
+type tenantResolver struct {
+	keyring *tenant.Keyring
+	// header is the trusted header with the tenant ID; empty if tenants
+	// may only be selected by their secrets.
+	header string
+	// trusted is set if the server has a trusted subnet, so the anonymous
+	// requests come from a trusted proxy.
+	trusted bool
+	// verifier checks the canonical signatures of tenants.
+	verifier *hash.Verifier
+}
+
+func newTenantResolver(spec, header, trustedSubnet string) (*tenantResolver, error) {
+	keyring, err := tenant.ParseKeyring(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenantResolver{keyring: keyring, header: header, trusted: trustedSubnet != ""}, nil
+}
+
+// authenticate looks for the tenant whose secret authenticates the request:
+// as the bearer token, as the key of the canonical signature or as the HMAC
+// key of the body signature.
+func (tr *tenantResolver) authenticate(r *http.Request) (context.Context, int, error) {
+	ctx := r.Context()
+
+	// API tokens are checked by WithToken.
+	if auth := r.Header.Get("Authorization"); auth != "" && !apitoken.IsSecret(strings.TrimPrefix(auth, "Bearer ")) {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return nil, http.StatusUnauthorized, errors.New("unsupported authorization scheme")
+		}
+
+		id, key, ok := tr.keyring.ByToken(token)
+		if !ok {
+			return nil, http.StatusUnauthorized, tenant.ErrUnknown
+		}
+
+		return tenant.WithTenantKey(ctx, id, key), http.StatusOK, nil
+	}
+
+	h := r.Header.Get("HashSHA256")
+	if (h == "" && r.Header.Get(hash.SignatureHeader) == "") || tr.keyring.Len() == 0 || r.Body == nil {
+		return ctx, http.StatusOK, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if tooLarge(err) {
+			return nil, http.StatusRequestEntityTooLarge, errors.New("request too large")
+		}
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed read body %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	// A canonical signature is checked rather than the body hash sent
+	// along with it, which can't be replayed.
+	if r.Header.Get(hash.SignatureHeader) != "" {
+		return tr.authenticateSignature(r, body)
+	}
+
+	signature, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid hash format")
+	}
+
+	// A body not signed by any tenant may be signed with the server key,
+	// WithHashing checks it.
+	if id, key, ok := tr.keyring.BySignature(body, signature); ok {
+		return tenant.WithTenantKey(ctx, id, key), http.StatusOK, nil
+	}
+
+	return ctx, http.StatusOK, nil
+}
+
+// authenticateSignature looks for the tenant whose secret is the key of the
+// canonical signature of the request: the tenant named by the
+// hash.KeyIDHeader header, or any tenant if it is absent. The signature is
+// verified here, so the routes outside WithHashing can trust the tenant.
+func (tr *tenantResolver) authenticateSignature(r *http.Request, body []byte) (context.Context, int, error) {
+	ctx := r.Context()
+
+	req, signature, err := canonicalRequest(r, body)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	keyID := r.Header.Get(hash.KeyIDHeader)
+	id, key, ok := tr.keyring.ByRequest(keyID, req, signature)
+	if !ok {
+		if keyID != "" && tr.keyring.Has(keyID) {
+			return nil, http.StatusUnauthorized, hash.ErrInvalidSignature
+		}
+		// A request not signed by any tenant may be signed with a
+		// server key, WithHashing checks it.
+		return ctx, http.StatusOK, nil
+	}
+
+	if err := tr.verifier.Verify(key, req, signature); err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	return tenant.WithVerifiedKey(ctx, id, key), http.StatusOK, nil
+}
+
+// claim applies the tenant named by the request (header or URL).
+//
+// An authenticated request may only name its own tenant. An anonymous
+// request may name any tenant only if the tenant header is enabled and the
+// server has a trusted subnet, since nothing but the proxy setting the
+// header vouches for it; with a keyring the tenant must be known.
+func (tr *tenantResolver) claim(ctx context.Context, id string) (context.Context, int, error) {
+	if err := tenant.ValidateID(id); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	if current := tenant.FromContext(ctx); current != tenant.Default {
+		if current != id {
+			return nil, http.StatusForbidden, tenant.ErrTenantDenied
+		}
+		return ctx, http.StatusOK, nil
+	}
+
+	if tr.header == "" {
+		return nil, http.StatusUnauthorized, errors.New("tenant credentials required")
+	}
+
+	if !tr.trusted {
+		return nil, http.StatusForbidden, tenant.ErrUntrusted
+	}
+
+	if tr.keyring.Len() > 0 && !tr.keyring.Has(id) {
+		return nil, http.StatusForbidden, tenant.ErrUnknown
+	}
+
+	return tenant.WithTenant(ctx, id), http.StatusOK, nil
+}
+
+// WithTenant returns an HTTP middleware that determines the tenant of the request
+// and stores it in the request context, so the MetricUsecase serves the request
+// from the storage of that tenant.
+//
+// The tenant is taken from the "Authorization: Bearer <secret>" header, from the
+// canonical signature (see hash.Request) made with the secret of a tenant, whose ID
+// may be sent as the key ID, from the "HashSHA256" header if the body is signed with
+// the secret of a tenant, or from the trusted tenant header. Requests without any of
+// them belong to the default tenant.
+//
+// verifier checks the timestamps and nonces of the canonical signatures, and must
+// be the verifier of WithHashing, which doesn't check them again.
+//
+// spec is the tenants specification "tenant:secret,...", header is the name
+// of the trusted tenant header (empty to disable it). The header selects a
+// tenant without its secret, so it must only be set by a proxy within the
+// trusted subnet, which the WithTrustedSubnet middleware checks: without
+// trustedSubnet the requests naming a tenant in it are rejected.
+func WithTenant(spec, header, trustedSubnet string, verifier *hash.Verifier) func(http.Handler) http.Handler {
+	tr, err := newTenantResolver(spec, header, trustedSubnet)
+	if err == nil {
+		tr.verifier = verifier
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err != nil {
+				log.Error().Err(err).Msg("invalid tenants configuration")
+				httpError(w, r, "invalid tenants configuration", http.StatusInternalServerError)
+				return
+			}
+
+			ctx, status, err := tr.authenticate(r)
+			if err == nil && tr.header != "" {
+				if id := r.Header.Get(tr.header); id != "" {
+					ctx, status, err = tr.claim(ctx, id)
+				}
+			}
+
+			if err != nil {
+				log.Error().Err(err).Msg("failed to determine tenant")
+				httpError(w, r, err.Error(), status)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithPathTenant returns an HTTP middleware for routes under "/tenants/{tenant}":
+// it serves the request for the tenant from the URL.
+//
+// It must run after WithTenant: the URL tenant must match the authenticated one,
+// or, for anonymous requests, the tenant header must be enabled and trusted,
+// see WithTenant.
+func WithPathTenant(spec, header, trustedSubnet string) func(http.Handler) http.Handler {
+	tr, err := newTenantResolver(spec, header, trustedSubnet)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err != nil {
+				log.Error().Err(err).Msg("invalid tenants configuration")
+				httpError(w, r, "invalid tenants configuration", http.StatusInternalServerError)
+				return
+			}
+
+			ctx, status, err := tr.claim(r.Context(), chi.URLParam(r, TenantParam))
+			if err != nil {
+				log.Error().Err(err).Msg("tenant route denied")
+				httpError(w, r, err.Error(), status)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithAdminTenant returns an HTTP middleware for the admin routes: it serves
+// the request for the tenant of the "tenant" query parameter, or for the
+// default tenant if there is none. The administrator isn't a tenant, so the
+// admin routes skip WithTenant; this middleware must run after WithAdminAuth.
+//
+// A server that isn't multi-tenant rejects a tenant with 400 Bad Request.
+func WithAdminTenant(multiTenant bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.URL.Query().Get(TenantParam)
+			if id == tenant.Default {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !multiTenant {
+				http.Error(w, "server is not multi-tenant", http.StatusBadRequest)
+				return
+			}
+
+			if err := tenant.ValidateID(id); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// TokenRequest is the request of the token creation endpoint.
+type TokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Prefix restricts the token to the metrics whose names start with it.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// TokenInfo describes a token, without its secret.
+type TokenInfo struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	Scopes  []apitoken.Scope `json:"scopes"`
+	Prefix  string           `json:"prefix,omitempty"`
+	Created time.Time        `json:"created"`
+}
+
+// TokenCreated is the response of the token creation endpoint, the only
+// one showing the secret.
+type TokenCreated struct {
+	TokenInfo
+	Secret string `json:"secret"`
+}
+
+func newTokenInfo(t apitoken.Token) TokenInfo {
+	return TokenInfo{
+		ID:      t.ID,
+		Name:    t.Name,
+		Scopes:  t.Scopes,
+		Prefix:  t.Prefix,
+		Created: t.Created,
+	}
+}
+
+// WithTokens sets the usecase of the API tokens, nil to disable them.
+func (srv *Server) WithTokens(tuc *token.TokenUsecase) *Server {
+	srv.TokenUsecase = tuc
+	return srv
+}
+
+// WithToken returns an HTTP middleware that authenticates the API token
+// sent as "Authorization: Bearer <secret>" and stores it in the request
+// context, so WithScope and the MetricUsecase can enforce it.
+//
+// Other bearer tokens are left to WithTenant and WithAdminAuth. An invalid
+// token, or any API token if tokens is nil, is rejected with 401
+// Unauthorized; so is a request without one if required is set.
+func WithToken(tokens *token.TokenUsecase, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !apitoken.IsSecret(secret) {
+				if required {
+					httpError(w, r, "api token required", http.StatusUnauthorized)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if tokens == nil {
+				httpError(w, r, "api tokens are disabled", http.StatusUnauthorized)
+				return
+			}
+
+			t, err := tokens.Authenticate(r.Context(), secret)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to authenticate api token")
+				if errors.Is(err, apitoken.ErrInvalidToken) {
+					httpError(w, r, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				httpError(w, r, "failed to authenticate api token", http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(apitoken.WithToken(r.Context(), t)))
+		})
+	}
+}
+
+// WithScope returns an HTTP middleware that rejects with 403 Forbidden the
+// requests whose API token doesn't grant scope. Requests without a token
+// are let through, WithToken decides whether they need one.
+func WithScope(scope apitoken.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := apitoken.FromContext(r.Context()); ok && !t.Allows(scope) {
+				log.Error().Str("token", t.ID).Str("scope", string(scope)).Msg("api token scope denied")
+				httpError(w, r, "api token lacks scope "+string(scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithBearerAuth is an HTTP middleware for the routes whose request body is
+// streamed, so WithHashing can't check its signature. It rejects with 401
+// Unauthorized the requests authenticated neither by an API token (see
+// WithToken) nor by a tenant secret as the bearer token (see WithTenant).
+func WithBearerAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasToken := apitoken.FromContext(r.Context())
+		_, hasTenant := tenant.KeyFromContext(r.Context())
+		bearer := strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !hasToken && !(hasTenant && bearer) {
+			httpError(w, r, "api token or tenant secret required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// updateStatus returns 403 Forbidden for an update of metrics the API
+// token may not write, status otherwise.
+func updateStatus(err error, status int) int {
+	if errors.Is(err, apitoken.ErrForbidden) {
+		return http.StatusForbidden
+	}
+
+	return status
+}
+
+// tokensEnabled replies 501 Not Implemented and returns false if the
+// storage doesn't keep API tokens.
+func (srv *Server) tokensEnabled(w http.ResponseWriter) bool {
+	if srv.TokenUsecase == nil {
+		http.Error(w, "storage doesn't support api tokens", http.StatusNotImplemented)
+		return false
+	}
+
+	return true
+}
+
+// @Title CreateToken
+// @Description Create an API token; the secret is shown only in this response
+// @Tags admin
+// @Accept application/json
+// @Produces application/json
+// @Param request body TokenRequest true "Name, scopes and name prefix of the token"
+// @Success 201 {object} TokenCreated
+// @Failure 400 {string} string "Invalid name or scopes"
+// @Failure 401 {string} string "Admin credentials required"
+// @Failure 501 {string} string "Storage doesn't support api tokens"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/tokens [POST]
+func (srv *Server) CreateToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.tokensEnabled(w) {
+			return
+		}
+
+		var req TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid token request", http.StatusBadRequest)
+			return
+		}
+
+		secret, t, err := srv.TokenUsecase.Create(r.Context(), req.Name, req.Scopes, req.Prefix)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create api token")
+			if errors.Is(err, token.ErrInvalidName) || errors.Is(err, apitoken.ErrInvalidScope) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to create api token", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info().Str("token", t.ID).Str("name", t.Name).Msg("api token created")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(TokenCreated{TokenInfo: newTokenInfo(t), Secret: secret}); err != nil {
+			log.Error().Err(err).Msg("failed to encode json")
+		}
+	}
+}
+
+// @Title ListTokens
+// @Description List the API tokens, without their secrets
+// @Tags admin
+// @Produces application/json
+// @Success 200 {array} TokenInfo
+// @Failure 401 {string} string "Admin credentials required"
+// @Failure 501 {string} string "Storage doesn't support api tokens"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/tokens [GET]
+func (srv *Server) ListTokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.tokensEnabled(w) {
+			return
+		}
+
+		tokens, err := srv.TokenUsecase.List(r.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list api tokens")
+			http.Error(w, "failed to list api tokens", http.StatusInternalServerError)
+			return
+		}
+
+		infos := make([]TokenInfo, 0, len(tokens))
+		for _, t := range tokens {
+			infos = append(infos, newTokenInfo(t))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(infos); err != nil {
+			log.Error().Err(err).Msg("failed to encode json")
+		}
+	}
+}
+
+// @Title RevokeToken
+// @Description Revoke an API token, its secret is rejected from now on
+// @Tags admin
+// @Param id path string true "Token ID"
+// @Success 204 "Token revoked"
+// @Failure 401 {string} string "Admin credentials required"
+// @Failure 404 {string} string "Token not found"
+// @Failure 501 {string} string "Storage doesn't support api tokens"
+// @Failure 500 {string} string "Internal server error"
+// @Router /admin/tokens/{id} [DELETE]
+func (srv *Server) RevokeToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.tokensEnabled(w) {
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		if err := srv.TokenUsecase.Revoke(r.Context(), id); err != nil {
+			log.Error().Err(err).Str("token", id).Msg("failed to revoke api token")
+			if errors.Is(err, apitoken.ErrNotFound) {
+				http.Error(w, "api token not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to revoke api token", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info().Str("token", id).Msg("api token revoked")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
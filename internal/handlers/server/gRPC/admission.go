@@ -0,0 +1,35 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// WithLimits sets the limits of batch updates.
+func (s *Server) WithLimits(limits admission.Limits) *Server {
+	s.Limits = limits
+	return s
+}
+
+// WithLoadShedding returns a gRPC unary interceptor that serves at most max
+// requests at once and fails the others with UNAVAILABLE, instead of
+// queueing them. If max is not positive, it serves every request.
+func WithLoadShedding(max int) grpc.UnaryServerInterceptor {
+	gate := admission.NewGate(max)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !gate.Enter() {
+			log.Warn().Int("max_in_flight", max).Str("method", info.FullMethod).Msg("shedding request")
+			return nil, status.Errorf(codes.Unavailable, "server is overloaded")
+		}
+		defer gate.Leave()
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// Metadata of the conditional reads, the counterparts of the HTTP headers.
+const (
+	// MetadataIfNoneMatch carries the etag of a previous response.
+	MetadataIfNoneMatch = "if-none-match"
+	// MetadataETag and MetadataLastModified are the response headers
+	// with the storage version.
+	MetadataETag         = "etag"
+	MetadataLastModified = "last-modified"
+	// MetadataNotModified is set to "true" in the response header if
+	// MetadataIfNoneMatch matches; the response is then empty.
+	MetadataNotModified = "not-modified"
+)
+
+// notModified sets the etag and last-modified response headers of the
+// storage version for the representation variant and reports whether the
+// if-none-match metadata of the request matches the etag. If it does,
+// the not-modified header is set too and the method must return an
+// empty response.
+//
+// It must be called before reading the metrics. Without a version, e.g.
+// for a storage that doesn't keep one, no headers are set.
+func (s *Server) notModified(ctx context.Context, variant string) bool {
+	version, err := s.MetricUsecase.Version(ctx)
+	if err != nil {
+		if !errors.Is(err, srvUsecase.ErrVersionUnsupported) {
+			log.Error().Err(err).Msg("failed to get storage version")
+		}
+		return false
+	}
+
+	// The metrics visible to an API token depend on its name prefix.
+	if t, ok := apitoken.FromContext(ctx); ok && t.Prefix != "" {
+		variant += " prefix " + t.Prefix
+	}
+
+	etag := version.ETag(tenant.FromContext(ctx) + " " + variant)
+	header := metadata.Pairs(
+		MetadataETag, etag,
+		MetadataLastModified, version.Modified.UTC().Format(http.TimeFormat),
+	)
+
+	var ifNoneMatch string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ifNoneMatch = strings.Join(md.Get(MetadataIfNoneMatch), ",")
+	}
+
+	matched := srvUsecase.MatchETag(ifNoneMatch, etag)
+	if matched {
+		header.Set(MetadataNotModified, "true")
+	}
+
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		log.Error().Err(err).Msg("failed to set header")
+		return false
+	}
+
+	return matched
+}
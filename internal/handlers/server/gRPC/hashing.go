@@ -3,6 +3,9 @@ package grpc
 import (
 	"context"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -12,19 +15,34 @@ import (
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 )
 
 // WithHashing returns a gRPC unary interceptor that verifies and adds
 // an HMAC-SHA256 hash for requests and responses.
 //
-// If the incoming metadata contains a "HashSHA256" header, the interceptor
-// decodes it and verifies that it matches the hash of the request body.
-// If the hash is invalid, it returns Internal error.
+// The key is the key of keys named by the hash.KeyIDHeader metadata, the key
+// of the empty ID if it is absent; an unknown or deprecated key fails a
+// signed request with Unauthenticated error.
+// If the incoming metadata contains a canonical signature (see hash.Request),
+// verifier checks it, by the algorithm in the hash.AlgorithmHeader metadata,
+// its timestamp and its nonce, and a failure returns Unauthenticated error. Otherwise, if the incoming metadata contains
+// a "HashSHA256" header, the interceptor decodes it and verifies that it
+// matches the hash of the request body. If the hash is invalid, it returns
+// Internal error. If requireSignature is set, every request must have
+// a canonical signature, and so must the requests by a hash.Key with
+// Canonical set or by a key verifier has verified a canonical signature
+// with: a body hash alone may be a signed request with the signature
+// stripped, replayed.
 //
 // After the handler runs successfully, the interceptor calculates a new
 // hash for the response and sets it in the response headers as "HashSHA256".
 // If the request is not a proto.Message, the interceptor returns Internal error.
-func WithHashing(key []byte) grpc.UnaryServerInterceptor {
+//
+// Requests authenticated with a tenant secret (see WithTenant) are checked
+// and signed with that secret instead of the keyring; WithTenant must share
+// verifier.
+func WithHashing(keys *hash.Keyring, verifier *hash.Verifier, requireSignature bool) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 		// Get the metadata from the incoming context.
 		md, ok := metadata.FromIncomingContext(ctx)
@@ -32,6 +50,30 @@ func WithHashing(key []byte) grpc.UnaryServerInterceptor {
 			return nil, status.Errorf(codes.Internal, "failed to get metadata")
 		}
 
+		// Find the key of the request.
+		key, err := requestKey(ctx, keys, md)
+		if err != nil && (len(md.Get(hash.SignatureHeader)) > 0 || len(md.Get("HashSHA256")) > 0) {
+			log.Error().Err(err).Msg("invalid request key")
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		secret := []byte(key.Secret)
+
+		// Verify the canonical signature, if any. WithTenant verified the
+		// signature of a tenant, its nonce is used up.
+		if tenant.SignatureVerified(ctx) {
+			return signResponse(ctx, secret, req, handler)
+		}
+
+		switch err := verifySignature(verifier, key, md, info.FullMethod, req); {
+		case err == nil:
+			return signResponse(ctx, secret, req, handler)
+		case !errors.Is(err, hash.ErrNotSigned):
+			log.Error().Err(err).Msg("invalid request signature")
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case requireSignature || key.Canonical || verifier.Signed(secret):
+			return nil, status.Error(codes.Unauthenticated, "request signature required")
+		}
+
 		// Get the hash from the metadata.
 		hashes := md.Get("HashSHA256")
 		if len(hashes) == 0 {
@@ -53,41 +95,119 @@ func WithHashing(key []byte) grpc.UnaryServerInterceptor {
 			}
 
 			// Check if the hash is valid.
-			valid := hash.CheckHash(key, body, decoded)
+			valid := hash.CheckHash(secret, body, decoded)
 			if !valid {
 				log.Error().Msg("invalid hash message")
 				return nil, status.Errorf(codes.Internal, "invalid hash message")
 			}
 		}
-		// Run the handler.
-		resp, err := handler(ctx, req)
+
+		return signResponse(ctx, secret, req, handler)
+	}
+}
+
+// requestKey returns the key of the request: its tenant secret, or the key
+// of keys named by the hash.KeyIDHeader metadata.
+func requestKey(ctx context.Context, keys *hash.Keyring, md metadata.MD) (hash.Key, error) {
+	if tenantKey, ok := tenant.KeyFromContext(ctx); ok {
+		return hash.Key{Secret: string(tenantKey)}, nil
+	}
+
+	return keys.Lookup(first(md.Get(hash.KeyIDHeader)))
+}
+
+// verifySignature verifies the canonical signature in md of the request
+// req to method. It returns hash.ErrNotSigned if md has no signature.
+func verifySignature(verifier *hash.Verifier, key hash.Key, md metadata.MD, method string, req any) error {
+	r, signature, err := canonicalRequest(md, method, req)
+	if err != nil {
+		return err
+	}
+
+	if err := key.Allows(r.Algorithm); err != nil {
+		return err
+	}
+
+	return verifier.Verify([]byte(key.Secret), r, signature)
+}
+
+// canonicalRequest returns the signed part of the request req to method
+// and its canonical signature in md. It returns hash.ErrNotSigned if md has
+// no signature.
+func canonicalRequest(md metadata.MD, method string, req any) (hash.Request, string, error) {
+	signatures := md.Get(hash.SignatureHeader)
+	if len(signatures) == 0 {
+		return hash.Request{}, "", hash.ErrNotSigned
+	}
+
+	algorithm := first(md.Get(hash.AlgorithmHeader))
+	if err := hash.ValidateAlgorithm(algorithm); err != nil {
+		return hash.Request{}, "", err
+	}
+
+	timestamp, err := hash.ParseTimestamp(first(md.Get(hash.TimestampHeader)))
+	if err != nil {
+		return hash.Request{}, "", err
+	}
+
+	var body []byte
+	if msg, ok := req.(proto.Message); ok {
+		body, err = proto.Marshal(msg)
 		if err != nil {
-			return resp, err
+			return hash.Request{}, "", fmt.Errorf("failed to marshal request: %w", err)
 		}
+	}
 
-		// Check if the response is a proto.Message.
-		if msg, ok := resp.(proto.Message); ok {
-			body, err := proto.Marshal(msg)
+	return hash.Request{
+		Method:    http.MethodPost,
+		Path:      method,
+		Body:      body,
+		Timestamp: timestamp,
+		Nonce:     first(md.Get(hash.NonceHeader)),
+		Algorithm: algorithm,
+	}, signatures[0], nil
+}
+
+// first returns the first of values, or "" if there are none.
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// signResponse runs the handler and sets the hash of the response in the
+// response headers as "HashSHA256".
+func signResponse(ctx context.Context, key []byte, req any, handler grpc.UnaryHandler) (any, error) {
+	// Run the handler.
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+
+	// Check if the response is a proto.Message.
+	if msg, ok := resp.(proto.Message); ok {
+		body, err := proto.Marshal(msg)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to marshal response")
+			return nil, status.Errorf(codes.Internal, "failed to marshal response: %v", err)
+		}
+		// If the response is not empty, calculate a new hash for the response.
+		if len(body) > 0 && len(key) > 0 {
+			newHash, err := hash.GetHash(key, body)
 			if err != nil {
-				log.Error().Err(err).Msg("failed to marshal response")
-				return nil, status.Errorf(codes.Internal, "failed to marshal response: %v", err)
+				log.Error().Err(err).Msg("failed to get new hash")
+				return nil, status.Errorf(codes.Internal, "failed to get new hash: %v", err)
 			}
-			// If the response is not empty, calculate a new hash for the response.
-			if len(body) > 0 {
-				newHash, err := hash.GetHash(key, body)
-				if err != nil {
-					log.Error().Err(err).Msg("failed to get new hash")
-					return nil, status.Errorf(codes.Internal, "failed to get new hash: %v", err)
-				}
-				// Set the new hash in the response headers.
-				md := metadata.Pairs("HashSHA256", hex.EncodeToString(newHash))
-				if err := grpc.SetHeader(ctx, md); err != nil {
-					log.Error().Err(err).Msg("failed to set header")
-					return nil, status.Errorf(codes.Internal, "failed to set header: %v", err)
-				}
+			// Set the new hash in the response headers.
+			md := metadata.Pairs("HashSHA256", hex.EncodeToString(newHash))
+			if err := grpc.SetHeader(ctx, md); err != nil {
+				log.Error().Err(err).Msg("failed to set header")
+				return nil, status.Errorf(codes.Internal, "failed to set header: %v", err)
 			}
 		}
-		// Return the response.
-		return resp, nil
 	}
+	// Return the response.
+	return resp, nil
 }
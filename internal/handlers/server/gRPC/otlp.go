@@ -0,0 +1,46 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/otlp"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+)
+
+// OTLPServer implements the OTLP MetricsService, so OpenTelemetry SDKs and
+// collectors can export metrics to the server over gRPC.
+type OTLPServer struct {
+	otlppb.UnimplementedMetricsServiceServer
+	OTLPUsecase *otlp.OTLPUsecase
+}
+
+// NewOTLPServer creates a new OTLPServer with the given use case.
+func NewOTLPServer(uc *otlp.OTLPUsecase) *OTLPServer {
+	return &OTLPServer{
+		OTLPUsecase: uc,
+	}
+}
+
+// Export implements the Export RPC method.
+//
+// It stores the data points of the request. Rejected data points are
+// reported in the partial success of the response. If the metrics can't be
+// stored, it returns an Unavailable error, which exporters retry.
+func (s *OTLPServer) Export(ctx context.Context, req *otlppb.ExportMetricsServiceRequest) (*otlppb.ExportMetricsServiceResponse, error) {
+	result, err := s.OTLPUsecase.Export(ctx, req)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to export otlp metrics")
+		return nil, status.Errorf(updateCode(err, codes.Unavailable), "failed to store metrics: %v", err)
+	}
+
+	log.Debug().
+		Int("data_points", result.DataPoints).
+		Int64("rejected", result.Rejected).
+		Msg("otlp export applied")
+
+	return result.Response(), nil
+}
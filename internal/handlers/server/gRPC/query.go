@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/query"
+	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// Query implements the Query RPC method.
+//
+// It evaluates the query over the metrics of the request tenant. A query
+// that can't be parsed or evaluated gets an InvalidArgument error with the
+// position of the error in the message. Like the other reads, it returns
+// an empty response with the not-modified header if the if-none-match
+// metadata matches the storage version.
+func (s *Server) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	if req.Query == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "query is required")
+	}
+
+	if s.notModified(ctx, "query proto "+req.Query) {
+		return &pb.QueryResponse{}, nil
+	}
+
+	result, err := s.QueryUsecase.Query(ctx, req.Query)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidQuery) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		log.Error().Err(err).Msg("failed to evaluate query")
+		return nil, status.Errorf(codes.Internal, "failed to evaluate query: %v", err)
+	}
+
+	resp := &pb.QueryResponse{Type: string(result.Type)}
+	if result.Type == query.ScalarType {
+		resp.Samples = []*pb.QuerySample{{Value: result.Scalar}}
+		return resp, nil
+	}
+
+	resp.Samples = make([]*pb.QuerySample, 0, len(result.Vector))
+	for _, sample := range result.Vector {
+		resp.Samples = append(resp.Samples, &pb.QuerySample{
+			Id:     sample.ID(),
+			Name:   sample.Name,
+			Labels: sample.Labels,
+			Value:  sample.Value,
+		})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// WithRateLimit returns a gRPC unary interceptor that limits the requests
+// and the stored metrics per second of every client, identified by identity
+// (see ratelimit.KeyIP, ratelimit.KeyAgent and ratelimit.KeyTenant). It must
+// run after WithTenant and WithToken to limit tenants and API tokens.
+//
+// The X-Real-IP and X-Agent-ID metadata identify the client only if the
+// connection comes from trustedSubnet, otherwise the client is identified
+// by the peer address.
+//
+// A request over the limits fails with RESOURCE_EXHAUSTED and the
+// "retry-after" trailer with the seconds to wait.
+func WithRateLimit(requestRate, metricRate float64, identity, trustedSubnet string) grpc.UnaryServerInterceptor {
+	quota := ratelimit.NewQuota(requestRate, metricRate)
+
+	var subnet *net.IPNet
+	if trustedSubnet != "" {
+		_, s, err := net.ParseCIDR(trustedSubnet)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to parse trusted subnet")
+		}
+		subnet = s
+	}
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if quota == nil {
+			return handler(ctx, req)
+		}
+
+		key := ratelimit.ClientKey(identity, peerClient(ctx, subnet))
+
+		ctx, wait := quota.Admit(ctx, key)
+		if wait > 0 {
+			log.Debug().Str("client", key).Dur("retry_after", wait).Msg("rate limit exceeded")
+			retryAfter := ratelimit.RetryAfter(wait)
+			_ = grpc.SetTrailer(ctx, metadata.Pairs(strings.ToLower(ratelimit.RetryAfterHeader), retryAfter))
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ss", retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// peerClient returns the client of the request, taking its X-Real-IP
+// and X-Agent-ID only if it comes from subnet.
+func peerClient(ctx context.Context, subnet *net.IPNet) ratelimit.Client {
+	client := ratelimit.Client{
+		IP:     peerIP(ctx),
+		Tenant: tenant.FromContext(ctx),
+	}
+
+	if t, ok := apitoken.FromContext(ctx); ok {
+		client.Token = t.ID
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || subnet == nil || !subnet.Contains(net.ParseIP(client.IP)) {
+		return client
+	}
+
+	if ips := md.Get("X-Real-IP"); len(ips) > 0 {
+		if ip := net.ParseIP(ips[0]); ip != nil {
+			client.IP = ip.String()
+		}
+	}
+	if agents := md.Get(ratelimit.AgentHeader); len(agents) > 0 {
+		client.Agent = agents[0]
+	}
+
+	return client
+}
+
+// peerIP returns the address of the connection of the request.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -5,7 +5,10 @@ import (
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/ping"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/query"
 	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	_ "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression/grpcencoding"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
 	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
 	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
@@ -23,13 +26,18 @@ type Server struct {
 	pb.UnimplementedMetricsServiceServer
 	MetricUsecase *srvUsecase.MetricUsecase
 	PingUsecase   *ping.PingUsecase
+	QueryUsecase  *query.QueryUsecase
+	Limits        admission.Limits
 }
 
-// NewServer creates a new Server with the given use cases.
+// NewServer creates a new Server with the given use cases; queries are
+// evaluated over the metrics of uc.
 func NewServer(uc *srvUsecase.MetricUsecase, puc *ping.PingUsecase) *Server {
 	return &Server{
 		MetricUsecase: uc,
 		PingUsecase:   puc,
+		QueryUsecase:  query.NewQueryUsecase(uc),
+		Limits:        admission.DefaultLimits(),
 	}
 }
 
@@ -38,7 +46,14 @@ func NewServer(uc *srvUsecase.MetricUsecase, puc *ping.PingUsecase) *Server {
 // It retrieves a single metric by its type and name, converts it to a protobuf
 // message, and returns it. If the metric is not found, it returns a NotFound
 // error. If there is an internal error, it returns an Internal error.
+//
+// Like the other reads, it returns an empty response with the not-modified
+// header if the if-none-match metadata matches the storage version.
 func (s *Server) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	if s.notModified(ctx, "value proto "+req.Type+"/"+req.Id) {
+		return &pb.GetMetricResponse{}, nil
+	}
+
 	metric, err := s.MetricUsecase.GetMetric(ctx, req.Type, req.Id)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get metric")
@@ -56,12 +71,65 @@ func (s *Server) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.G
 	}, nil
 }
 
+// GetMetrics implements the GetMetrics RPC method.
+//
+// It retrieves many metrics in one storage lookup and returns the found ones
+// in the order of the request. Metrics that are not found are listed as missing
+// instead of failing the whole call. If there is an internal error, it returns
+// an Internal error.
+func (s *Server) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	if len(req.Metrics) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "metrics are required")
+	}
+
+	if err := s.Limits.CheckBatch(len(req.Metrics)); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+	}
+
+	keys := make([]srvUsecase.MetricKey, 0, len(req.Metrics))
+	variant := "values proto"
+	for _, m := range req.Metrics {
+		keys = append(keys, srvUsecase.MetricKey{Type: m.Type, Name: m.Id})
+		variant += " " + m.Type + "/" + m.Id
+	}
+
+	if s.notModified(ctx, variant) {
+		return &pb.GetMetricsResponse{}, nil
+	}
+
+	metrics, missing, err := s.MetricUsecase.GetMetrics(ctx, keys)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get metrics")
+		return nil, status.Errorf(codes.Internal, "failed to get metrics: %v", err)
+	}
+
+	protoMetrics, err := converter.ConvertToProtoMetrics(metrics)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to convert metrics to proto")
+		return nil, status.Errorf(codes.Internal, "failed to convert metrics to proto: %v", err)
+	}
+
+	resp := &pb.GetMetricsResponse{
+		Metrics: protoMetrics,
+		Missing: make([]*pb.GetMetricRequest, 0, len(missing)),
+	}
+	for _, key := range missing {
+		resp.Missing = append(resp.Missing, &pb.GetMetricRequest{Id: key.Name, Type: key.Type})
+	}
+
+	return resp, nil
+}
+
 // GetAllMetrics implements the GetAllMetrics RPC method.
 //
 // It retrieves all metrics from the use case, converts them to protobuf
 // messages, and returns them. If there is an internal error, it returns an
 // Internal error.
-func (s *Server) GetAllMetrics(ctx context.Context) (*pb.GetAllMetricsResponse, error) {
+func (s *Server) GetAllMetrics(ctx context.Context, _ *emptypb.Empty) (*pb.GetAllMetricsResponse, error) {
+	if s.notModified(ctx, "all proto") {
+		return &pb.GetAllMetricsResponse{}, nil
+	}
+
 	metrics, err := s.MetricUsecase.GetAllMetrics(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get all metrics")
@@ -86,12 +154,17 @@ func (s *Server) GetAllMetrics(ctx context.Context) (*pb.GetAllMetricsResponse,
 func (s *Server) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*emptypb.Empty, error) {
 	metric := req.Metric
 
-	if metric.Id == "" {
+	if metric == nil || metric.Id == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "metric id is required")
 	}
 
-	if err := s.MetricUsecase.UpdateMetric(ctx, metric.MType, metric.Id, metric.MetricValue); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to update metric: %v", err)
+	metrics, err := converter.ConvertFromProtoToMetrics([]*pb.Metric{metric})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid metric: %v", err)
+	}
+
+	if err := s.MetricUsecase.UpdateMetric(ctx, metric.MType, metric.Id, metrics[0].Value()); err != nil {
+		return nil, status.Errorf(updateCode(err, codes.Internal), "failed to update metric: %v", err)
 	}
 
 	return &emptypb.Empty{}, nil
@@ -100,6 +173,7 @@ func (s *Server) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest)
 // UpdateMetrics implements the UpdateMetrics RPC method.
 //
 // It updates a list of metrics.
+// A list over the batch limit fails with a ResourceExhausted error.
 // If there is an internal error, it returns an Internal error.
 func (s *Server) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*emptypb.Empty, error) {
 	protoMetrics := req.Metrics
@@ -108,6 +182,10 @@ func (s *Server) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest
 		return nil, status.Errorf(codes.InvalidArgument, "metrics are required")
 	}
 
+	if err := s.Limits.CheckBatch(len(protoMetrics)); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+	}
+
 	metrics, err := converter.ConvertFromProtoToMetrics(protoMetrics)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to convert metrics")
@@ -116,17 +194,17 @@ func (s *Server) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest
 
 	if err := s.MetricUsecase.UpdateMetricList(ctx, metrics); err != nil {
 		log.Error().Err(err).Msg("failed to update metrics")
-		return nil, status.Errorf(codes.Internal, "failed to update metrics: %v", err)
+		return nil, status.Errorf(updateCode(err, codes.Internal), "failed to update metrics: %v", err)
 	}
 
 	return &emptypb.Empty{}, nil
 }
 
-// PingHandler implements the PingHandler RPC method.
+// Ping implements the Ping RPC method.
 //
 // It checks if the database is reachable.
 // If there is an internal error, it returns an Internal error.
-func (s *Server) PingHandler(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+func (s *Server) Ping(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
 	if err := s.PingUsecase.Check(ctx); err != nil {
 		log.Error().Err(err).Msg("failed to ping")
 		return nil, status.Errorf(codes.Internal, "failed to ping: %v", err)
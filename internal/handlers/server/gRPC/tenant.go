@@ -0,0 +1,135 @@
+package grpc
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// WithTenant returns a gRPC unary interceptor that determines the tenant
+// of the request and stores it in the context, so the MetricUsecase serves
+// the request from the storage of that tenant.
+//
+// The tenant is taken from the "authorization: Bearer <secret>" metadata,
+// from the canonical signature (see hash.Request) made with the secret of a
+// tenant, whose ID may be sent as the key ID, from the "HashSHA256" metadata
+// if the request is signed with the secret of a tenant, or from the trusted
+// tenant header. An authenticated request may only name its own tenant in
+// the header. Requests without any of them belong to the default tenant.
+//
+// The header selects a tenant without its secret, so it must only be set by
+// a proxy within the trusted subnet: without trustedSubnet the anonymous
+// requests naming a tenant in it are rejected.
+//
+// verifier checks the timestamps and nonces of the canonical signatures, and
+// must be the verifier of WithHashing, which doesn't check them again.
+//
+// It returns an error if spec is not a valid tenants specification.
+func WithTenant(spec, header, trustedSubnet string, verifier *hash.Verifier) (grpc.UnaryServerInterceptor, error) {
+	keyring, err := tenant.ParseKeyring(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	header = strings.ToLower(header)
+
+	interceptor := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		// API tokens are checked by WithToken.
+		if auth := md.Get("authorization"); len(auth) > 0 && !apitoken.IsSecret(strings.TrimPrefix(auth[0], "Bearer ")) {
+			token, ok := strings.CutPrefix(auth[0], "Bearer ")
+			if !ok {
+				return nil, status.Errorf(codes.Unauthenticated, "unsupported authorization scheme")
+			}
+
+			id, key, ok := keyring.ByToken(token)
+			if !ok {
+				return nil, status.Errorf(codes.Unauthenticated, "%v", tenant.ErrUnknown)
+			}
+			ctx = tenant.WithTenantKey(ctx, id, key)
+
+		} else if len(md.Get(hash.SignatureHeader)) > 0 && keyring.Len() > 0 {
+			// A canonical signature is checked rather than the body hash
+			// sent along with it, which can't be replayed.
+			r, signature, err := canonicalRequest(md, info.FullMethod, req)
+			if err != nil {
+				return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+			}
+
+			keyID := first(md.Get(hash.KeyIDHeader))
+			id, key, ok := keyring.ByRequest(keyID, r, signature)
+			switch {
+			case ok:
+				if err := verifier.Verify(key, r, signature); err != nil {
+					return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+				}
+				ctx = tenant.WithVerifiedKey(ctx, id, key)
+			case keyID != "" && keyring.Has(keyID):
+				return nil, status.Errorf(codes.Unauthenticated, "%v", hash.ErrInvalidSignature)
+			}
+			// A request not signed by any tenant may be signed with a
+			// server key, WithHashing checks it.
+
+		} else if hashes := md.Get("HashSHA256"); len(hashes) > 0 && keyring.Len() > 0 {
+			decoded, err := hex.DecodeString(hashes[0])
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "failed to decode hash: %v", err)
+			}
+
+			if msg, ok := req.(proto.Message); ok {
+				body, err := proto.Marshal(msg)
+				if err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to marshal request: %v", err)
+				}
+
+				// A request not signed by any tenant may be signed with
+				// the server key, WithHashing checks it.
+				if id, key, ok := keyring.BySignature(body, decoded); ok {
+					ctx = tenant.WithTenantKey(ctx, id, key)
+				}
+			}
+		}
+
+		if header == "" {
+			return handler(ctx, req)
+		}
+
+		if ids := md.Get(header); len(ids) > 0 && ids[0] != "" {
+			id := ids[0]
+			if err := tenant.ValidateID(id); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+			}
+
+			switch current := tenant.FromContext(ctx); {
+			case current != tenant.Default:
+				if current != id {
+					return nil, status.Errorf(codes.PermissionDenied, "%v", tenant.ErrTenantDenied)
+				}
+			case trustedSubnet == "":
+				return nil, status.Errorf(codes.PermissionDenied, "%v", tenant.ErrUntrusted)
+			case keyring.Len() > 0 && !keyring.Has(id):
+				return nil, status.Errorf(codes.PermissionDenied, "%v", tenant.ErrUnknown)
+			default:
+				ctx = tenant.WithTenant(ctx, id)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+
+	return interceptor, nil
+}
@@ -0,0 +1,91 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// methodScopes are the scopes an API token needs for the methods; the
+// methods not listed, e.g. the OTLP export, need metrics:write.
+var methodScopes = map[string]apitoken.Scope{
+	pb.MetricsService_GetMetric_FullMethodName:     apitoken.ScopeRead,
+	pb.MetricsService_GetMetrics_FullMethodName:    apitoken.ScopeRead,
+	pb.MetricsService_GetAllMetrics_FullMethodName: apitoken.ScopeRead,
+	pb.MetricsService_Query_FullMethodName:         apitoken.ScopeRead,
+	pb.MetricsService_UpdateMetric_FullMethodName:  apitoken.ScopeWrite,
+	pb.MetricsService_UpdateMetrics_FullMethodName: apitoken.ScopeWrite,
+	// Health checks need a token only if tokens are required.
+	pb.MetricsService_Ping_FullMethodName: "",
+}
+
+// updateCode returns PERMISSION_DENIED for an update of metrics the API
+// token may not write, code otherwise.
+func updateCode(err error, code codes.Code) codes.Code {
+	if errors.Is(err, apitoken.ErrForbidden) {
+		return codes.PermissionDenied
+	}
+
+	return code
+}
+
+// WithToken returns a gRPC unary interceptor that authenticates the API
+// token of the "authorization: Bearer <secret>" metadata, checks that it
+// grants the scope of the method and stores it in the context, so the
+// MetricUsecase enforces its name prefix.
+//
+// Other bearer tokens are left to WithTenant. An invalid token, or any API
+// token if tokens is nil, fails with UNAUTHENTICATED; so does a request
+// without one if required is set. A token without the scope of the method
+// fails with PERMISSION_DENIED.
+func WithToken(tokens *token.TokenUsecase, required bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var secret string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if auth := md.Get("authorization"); len(auth) > 0 {
+				secret = strings.TrimPrefix(auth[0], "Bearer ")
+			}
+		}
+
+		if !apitoken.IsSecret(secret) {
+			if required {
+				return nil, status.Errorf(codes.Unauthenticated, "api token required")
+			}
+			return handler(ctx, req)
+		}
+
+		if tokens == nil {
+			return nil, status.Errorf(codes.Unauthenticated, "api tokens are disabled")
+		}
+
+		t, err := tokens.Authenticate(ctx, secret)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to authenticate api token")
+			if errors.Is(err, apitoken.ErrInvalidToken) {
+				return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to authenticate api token")
+		}
+
+		scope, ok := methodScopes[info.FullMethod]
+		if !ok {
+			scope = apitoken.ScopeWrite
+		}
+		if scope != "" && !t.Allows(scope) {
+			log.Error().Str("token", t.ID).Str("method", info.FullMethod).Msg("api token scope denied")
+			return nil, status.Errorf(codes.PermissionDenied, "api token lacks scope %s", scope)
+		}
+
+		return handler(apitoken.WithToken(ctx, t), req)
+	}
+}
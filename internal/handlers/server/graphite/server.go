@@ -0,0 +1,158 @@
+// Package graphite serves the Graphite plaintext and pickle protocols over TCP.
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/listener"
+	graphiteUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/graphite"
+	graphiteParser "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/graphite"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+const (
+	// DefaultFlushInterval is how often the received samples are stored.
+	DefaultFlushInterval = time.Second
+	// MaxLineSize is the longest plaintext line.
+	MaxLineSize = 64 * 1024
+	// shutdownTimeout bounds the final flush.
+	shutdownTimeout = 2 * time.Second
+)
+
+// Server receives Graphite plaintext lines and, if its address is set,
+// pickled samples, and stores them with the usecase on an interval.
+type Server struct {
+	addr          string
+	pickleAddr    string
+	usecase       *graphiteUsecase.GraphiteUsecase
+	flushInterval time.Duration
+
+	tcp *listener.TCPServer
+}
+
+func NewServer(addr, pickleAddr string, uc *graphiteUsecase.GraphiteUsecase, flushInterval time.Duration) *Server {
+	return &Server{
+		addr:          addr,
+		pickleAddr:    pickleAddr,
+		usecase:       uc,
+		flushInterval: flushInterval,
+		tcp:           listener.NewTCPServer(),
+	}
+}
+
+// ListenAndServe listens on the addresses that are set and serves until
+// ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	var plainListener, pickleListener net.Listener
+
+	closeAll := func() {
+		for _, l := range []net.Listener{plainListener, pickleListener} {
+			if l != nil {
+				_ = l.Close()
+			}
+		}
+	}
+
+	var err error
+	if s.addr != "" {
+		if plainListener, err = net.Listen("tcp", s.addr); err != nil {
+			return fmt.Errorf("failed to listen tcp %s: %w", s.addr, err)
+		}
+	}
+	if s.pickleAddr != "" {
+		if pickleListener, err = net.Listen("tcp", s.pickleAddr); err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen tcp %s: %w", s.pickleAddr, err)
+		}
+	}
+
+	return s.Serve(ctx, plainListener, pickleListener)
+}
+
+// Serve receives plaintext connections from plainListener and pickle
+// connections from pickleListener, either of which may be nil, until ctx
+// is done. Then it closes them, waits for the connections to finish and
+// flushes the samples for the last time.
+func (s *Server) Serve(ctx context.Context, plainListener, pickleListener net.Listener) error {
+	listeners := make([]net.Listener, 0, 2)
+
+	if plainListener != nil {
+		listeners = append(listeners, plainListener)
+		s.tcp.Start(plainListener, func(conn net.Conn) {
+			listener.ScanLines(conn, MaxLineSize, s.handleLine)
+		})
+	}
+	if pickleListener != nil {
+		listeners = append(listeners, pickleListener)
+		s.tcp.Start(pickleListener, s.servePickle)
+	}
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			if err := s.usecase.Flush(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to flush graphite metrics")
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	for _, l := range listeners {
+		if err := l.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close graphite listener")
+		}
+	}
+
+	s.tcp.Shutdown()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.usecase.Flush(flushCtx); err != nil {
+		log.Error().Err(err).Msg("failed to flush graphite metrics on shutdown")
+		return err
+	}
+
+	log.Info().Msg("Graphite server gracefully stopped")
+	return nil
+}
+
+func (s *Server) handleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	if err := s.usecase.HandleLine(string(line)); err != nil {
+		log.Debug().Err(err).Msg("invalid graphite line")
+	}
+}
+
+// servePickle reads the pickled payloads of the connection. An invalid
+// payload closes the connection, since the stream can't be resynchronized.
+func (s *Server) servePickle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	for {
+		samples, err := graphiteParser.ReadPickle(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !listener.IsTimeout(err) {
+				log.Debug().Err(err).Msg("invalid graphite pickle")
+			}
+			return
+		}
+
+		s.usecase.Add(samples...)
+	}
+}
@@ -0,0 +1,158 @@
+package graphite_test
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/graphite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	graphiteUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/graphite"
+)
+
+// pickled is [("pickled.temp", (1700000000, 21.5))] of the pickle protocol
+// 2 with its length prefix.
+const pickled = "0000002e80025d7100580c0000007069636b6c65642e74656d7071014a00f15365474035800000000000867102867103612e"
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	templates, err := graphiteUsecase.ParseTemplates("servers.* .host.measurement*")
+	require.NoError(t, err)
+
+	storage := repo.NewMemStorage()
+	srv := graphite.NewServer("", "", graphiteUsecase.NewGraphiteUsecase(storage, templates), 20*time.Millisecond)
+
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pickleListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, plainListener, pickleListener)
+	}()
+
+	// Snapshot copies the metrics, so they are read while the server flushes.
+	value := func(name string) any {
+		metrics, err := storage.Snapshot(context.Background())
+		require.NoError(t, err)
+
+		for _, metric := range metrics {
+			if metric.Type() == models.GaugeType && metric.Name() == name {
+				return metric.Value()
+			}
+		}
+		return nil
+	}
+
+	plain, err := net.Dial("tcp", plainListener.Addr().String())
+	require.NoError(t, err)
+	defer plain.Close()
+
+	_, err = plain.Write([]byte("servers.web-1.cpu.load 0.5 -1\ninvalid line\nqueue 5\n"))
+	require.NoError(t, err)
+
+	// Flushed on the interval.
+	require.Eventually(t, func() bool {
+		return value(`cpu.load{host="web-1"}`) == 0.5 && value("queue") == 5.0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	payload, err := hex.DecodeString(pickled)
+	require.NoError(t, err)
+
+	pickle, err := net.Dial("tcp", pickleListener.Addr().String())
+	require.NoError(t, err)
+	defer pickle.Close()
+
+	_, err = pickle.Write(payload)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return value("pickled.temp") == 21.5
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// The samples received before the shutdown are flushed by it,
+	// even though the client is still connected.
+	_, err = plain.Write([]byte("queue 7\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+
+	assert.Equal(t, 7.0, value("queue"))
+}
+
+func TestServer_shutdown_persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	// The storage has a context of its own, so it is still running when
+	// the stopped server flushes for the last time, and saves on Close.
+	storage, err := repo.NewFileStorage(context.Background(), &repo.FileParams{
+		FileStoragePath: path,
+		StoreInterval:   3600,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := graphite.NewServer("", "", graphiteUsecase.NewGraphiteUsecase(storage, nil), time.Hour)
+
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, plainListener, nil)
+	}()
+
+	plain, err := net.Dial("tcp", plainListener.Addr().String())
+	require.NoError(t, err)
+	defer plain.Close()
+
+	_, err = plain.Write([]byte("queue 7\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+	require.NoError(t, storage.Close())
+
+	restored, err := repo.NewFileStorage(context.Background(), &repo.FileParams{
+		FileStoragePath: path,
+		RestoreOnStart:  true,
+	})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	metric, err := restored.GetMetric(context.Background(), models.GaugeType, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, metric.Value())
+}
+
+func TestServer_ListenAndServe_invalid_address(t *testing.T) {
+	uc := graphiteUsecase.NewGraphiteUsecase(repo.NewMemStorage(), nil)
+
+	srv := graphite.NewServer("127.0.0.1:0", "invalid:address:1", uc, time.Second)
+	assert.Error(t, srv.ListenAndServe(context.Background()))
+}
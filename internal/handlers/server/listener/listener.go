@@ -0,0 +1,112 @@
+// Package listener serves the line protocols received over plain TCP
+// connections, such as StatsD and Graphite, and stops them gracefully.
+package listener
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+// TCPServer handles the connections of a listener, each in its own goroutine.
+type TCPServer struct {
+	wg sync.WaitGroup
+
+	mutex   sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+func NewTCPServer() *TCPServer {
+	return &TCPServer{
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start accepts connections in the background until the listener is
+// closed and passes them to handle. A connection is closed when handle
+// returns.
+func (s *TCPServer) Start(listener net.Listener, handle func(conn net.Conn)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.serve(listener, handle)
+	}()
+}
+
+func (s *TCPServer) serve(listener net.Listener, handle func(conn net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Str("address", listener.Addr().String()).Msg("failed to accept connection")
+			}
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		if s.closing {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.release(conn)
+
+			handle(conn)
+		}()
+	}
+}
+
+// Shutdown makes the pending reads of the connections time out, so they
+// finish handling what is already received, and waits for them. The
+// listeners must be closed before.
+func (s *TCPServer) Shutdown() {
+	s.mutex.Lock()
+	s.closing = true
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *TCPServer) release(conn net.Conn) {
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+
+	if err := conn.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close connection")
+	}
+}
+
+// ScanLines calls handle for every line of the connection, without the
+// trailing whitespace, until the connection is closed or times out.
+func ScanLines(conn net.Conn, maxLineSize int, handle func(line []byte)) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+
+	for scanner.Scan() {
+		handle(scanner.Bytes())
+	}
+
+	if err := scanner.Err(); err != nil && !IsTimeout(err) {
+		log.Error().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("failed to read connection")
+	}
+}
+
+// IsTimeout reports whether err is a timeout, such as the read deadline
+// set by Shutdown.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,142 @@
+// Package statsd serves the StatsD protocol over UDP and TCP.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/listener"
+	statsdUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/statsd"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
+)
+
+const (
+	// MaxPacketSize is the largest UDP packet.
+	MaxPacketSize = 64 * 1024
+	// MaxLineSize is the longest line of a TCP stream.
+	MaxLineSize = 64 * 1024
+	// shutdownTimeout bounds the final flush.
+	shutdownTimeout = 2 * time.Second
+)
+
+// Server receives StatsD lines on the same address over UDP and TCP,
+// aggregates them with the usecase and flushes them on an interval.
+type Server struct {
+	addr          string
+	usecase       *statsdUsecase.StatsDUsecase
+	flushInterval time.Duration
+
+	tcp *listener.TCPServer
+}
+
+func NewServer(addr string, uc *statsdUsecase.StatsDUsecase, flushInterval time.Duration) *Server {
+	return &Server{
+		addr:          addr,
+		usecase:       uc,
+		flushInterval: flushInterval,
+		tcp:           listener.NewTCPServer(),
+	}
+}
+
+// ListenAndServe listens on the address and serves until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	packetConn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen udp %s: %w", s.addr, err)
+	}
+
+	tcpListener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		_ = packetConn.Close()
+		return fmt.Errorf("failed to listen tcp %s: %w", s.addr, err)
+	}
+
+	return s.Serve(ctx, packetConn, tcpListener)
+}
+
+// Serve receives packets from packetConn and connections from tcpListener
+// until ctx is done. Then it closes them, waits for the connections to
+// finish and flushes the aggregates for the last time.
+func (s *Server) Serve(ctx context.Context, packetConn net.PacketConn, tcpListener net.Listener) error {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.serveUDP(packetConn)
+	}()
+	s.tcp.Start(tcpListener, func(conn net.Conn) {
+		listener.ScanLines(conn, MaxLineSize, s.handleLine)
+	})
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			if err := s.usecase.Flush(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to flush statsd metrics")
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	if err := packetConn.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close statsd udp listener")
+	}
+	if err := tcpListener.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close statsd tcp listener")
+	}
+
+	wg.Wait()
+	s.tcp.Shutdown()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.usecase.Flush(flushCtx); err != nil {
+		log.Error().Err(err).Msg("failed to flush statsd metrics on shutdown")
+		return err
+	}
+
+	log.Info().Msg("StatsD server gracefully stopped")
+	return nil
+}
+
+func (s *Server) serveUDP(packetConn net.PacketConn) {
+	buf := make([]byte, MaxPacketSize)
+
+	for {
+		n, _, err := packetConn.ReadFrom(buf)
+		if n > 0 {
+			for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+				s.handleLine(line)
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Msg("failed to read statsd packet")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) handleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	if err := s.usecase.HandleLine(string(line)); err != nil {
+		log.Debug().Err(err).Msg("invalid statsd line")
+	}
+}
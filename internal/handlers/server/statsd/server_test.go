@@ -0,0 +1,93 @@
+package statsd_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/statsd"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	statsdUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/statsd"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := repo.NewMemStorage()
+	srv := statsd.NewServer("", statsdUsecase.NewStatsDUsecase(storage), 20*time.Millisecond)
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, packetConn, listener)
+	}()
+
+	// Snapshot copies the metrics, so they are read while the server flushes.
+	value := func(mType, name string) any {
+		metrics, err := storage.Snapshot(context.Background())
+		require.NoError(t, err)
+
+		for _, metric := range metrics {
+			if metric.Type() == mType && metric.Name() == name {
+				return metric.Value()
+			}
+		}
+		return nil
+	}
+
+	udp, err := net.Dial("udp", packetConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+
+	_, err = udp.Write([]byte("requests:2|c\nqueue:5|g\ninvalid line\n"))
+	require.NoError(t, err)
+
+	// Flushed on the interval.
+	require.Eventually(t, func() bool {
+		return value(models.CounterType, "requests") == int64(2) && value(models.GaugeType, "queue") == 5.0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	tcp, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer tcp.Close()
+
+	_, err = tcp.Write([]byte("requests:3|c\nqueue:-2|g\ndb:15|ms\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return value(models.CounterType, "requests") == int64(5)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// The samples received before the shutdown are flushed by it,
+	// even though the TCP client is still connected.
+	_, err = tcp.Write([]byte("requests:10|c\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+
+	assert.Equal(t, int64(15), value(models.CounterType, "requests"))
+	assert.Equal(t, 3.0, value(models.GaugeType, "queue"))
+	assert.Equal(t, 15.0, value(models.GaugeType, "db.max"))
+}
+
+func TestServer_ListenAndServe_invalid_address(t *testing.T) {
+	srv := statsd.NewServer("invalid:address:1", statsdUsecase.NewStatsDUsecase(repo.NewMemStorage()), time.Second)
+	assert.Error(t, srv.ListenAndServe(context.Background()))
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+// Labels are the key-value pairs that distinguish series of the same metric.
+//
+// Storages know only metric names, so labels are kept in the name itself,
+// in the Prometheus notation: `base{key="value",other="value"}`, with keys
+// sorted. JoinName builds such a name and SplitName parses it back.
+type Labels map[string]string
+
+// JoinName returns the metric name of the base name with the labels.
+// Keys are sorted, so equal label sets always give the same name.
+func JoinName(base string, labels Labels) string {
+	if len(labels) == 0 {
+		return base
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(base)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// SplitName splits a metric name into the base name and the labels.
+//
+// A name without labels, or with a malformed label set, is returned
+// as the base name with no labels.
+func SplitName(name string) (string, Labels) {
+	open := strings.IndexByte(name, '{')
+	if open <= 0 || !strings.HasSuffix(name, "}") {
+		return name, nil
+	}
+
+	labels, ok := parseLabels(name[open+1 : len(name)-1])
+	if !ok {
+		return name, nil
+	}
+
+	return name[:open], labels
+}
+
+// parseLabels parses `key="value",key="value"`.
+func parseLabels(s string) (Labels, bool) {
+	labels := make(Labels)
+
+	for len(s) > 0 {
+		eq := strings.Index(s, `="`)
+		if eq <= 0 {
+			return nil, false
+		}
+		key := s[:eq]
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 == len(s) {
+					return nil, false
+				}
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+			case '"':
+				closed = true
+				s = s[i+1:]
+			default:
+				value.WriteByte(s[i])
+			}
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return nil, false
+		}
+
+		labels[key] = value.String()
+
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, false
+			}
+			s = s[1:]
+		}
+	}
+
+	return labels, true
+}
+
+func escapeLabelValue(value string) string {
+	if !strings.ContainsAny(value, "\\\"\n") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
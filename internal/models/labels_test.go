@@ -0,0 +1,64 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+func TestJoinName(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		labels models.Labels
+		want   string
+	}{
+		{name: "no labels", base: "Alloc", want: "Alloc"},
+		{name: "sorted keys", base: "http_requests", labels: models.Labels{"method": "GET", "code": "200"},
+			want: `http_requests{code="200",method="GET"}`},
+		{name: "escaped value", base: "m", labels: models.Labels{"path": `a"b\c`},
+			want: `m{path="a\"b\\c"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, models.JoinName(tt.base, tt.labels))
+		})
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		name       string
+		metric     string
+		wantBase   string
+		wantLabels models.Labels
+	}{
+		{name: "no labels", metric: "Alloc", wantBase: "Alloc"},
+		{name: "labels", metric: `http_requests{code="200",method="GET"}`, wantBase: "http_requests",
+			wantLabels: models.Labels{"code": "200", "method": "GET"}},
+		{name: "escaped value", metric: `m{path="a\"b\\c,d"}`, wantBase: "m",
+			wantLabels: models.Labels{"path": `a"b\c,d`}},
+		{name: "empty value", metric: `m{env=""}`, wantBase: "m", wantLabels: models.Labels{"env": ""}},
+		{name: "unterminated value", metric: `m{env="prod}`, wantBase: `m{env="prod}`},
+		{name: "missing quotes", metric: `m{env=prod}`, wantBase: `m{env=prod}`},
+		{name: "only braces", metric: `{env="prod"}`, wantBase: `{env="prod"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, labels := models.SplitName(tt.metric)
+			assert.Equal(t, tt.wantBase, base)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		labels := models.Labels{"a": "1", "b": "x\ny\"z"}
+		base, got := models.SplitName(models.JoinName("metric", labels))
+		assert.Equal(t, "metric", base)
+		assert.Equal(t, labels, got)
+	})
+}
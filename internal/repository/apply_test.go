@@ -0,0 +1,111 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// applyStorage is a storage that returns the values it stores.
+type applyStorage interface {
+	server.MetricGetter
+	server.MetricUpdater
+	server.MetricApplier
+}
+
+func TestStorage_ApplyMetricList(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) applyStorage{
+		"memory": func(t *testing.T) applyStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) applyStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) applyStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) applyStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "requests", int64(5)))
+
+			stored, err := storage.ApplyMetricList(ctx, []models.Metric{
+				models.NewCounter("requests", 2),
+				models.NewGauge("temperature", 36.6),
+				models.NewCounter("requests", 3),
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []models.Metric{
+				models.NewCounter("requests", 7),
+				models.NewGauge("temperature", 36.6),
+				models.NewCounter("requests", 10),
+			}, stored)
+
+			// The returned values are not updated with the storage.
+			require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "requests", int64(1)))
+			assert.Equal(t, int64(10), stored[2].Value())
+
+			_, err = storage.ApplyMetricList(ctx, []models.Metric{&invalidMetric{}})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDatabase_ApplyMetricList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repository.Database{
+		DB: db,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`RETURNING "Delta", "Value"`)).
+		WithArgs("requests", models.CounterType, int64(2), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"Delta", "Value"}).AddRow(int64(7), nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`RETURNING "Delta", "Value"`)).
+		WithArgs("temperature", models.GaugeType, nil, 36.6).
+		WillReturnRows(sqlmock.NewRows([]string{"Delta", "Value"}).AddRow(nil, 36.6))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	stored, err := repo.ApplyMetricList(context.Background(), []models.Metric{
+		models.NewCounter("requests", 2),
+		models.NewGauge("temperature", 36.6),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metric{
+		models.NewCounter("requests", 7),
+		models.NewGauge("temperature", 36.6),
+	}, stored)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,85 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// batchStorage is a storage supporting multi-key lookups.
+type batchStorage interface {
+	server.MetricUpdater
+	server.MetricBatchGetter
+}
+
+var (
+	_ server.MetricBatchGetter = (*repository.MemStorage)(nil)
+	_ server.MetricBatchGetter = (*repository.ShardedMemStorage)(nil)
+	_ server.MetricBatchGetter = (*repository.FileStorage)(nil)
+	_ server.MetricBatchGetter = (*repository.WriteBehindStorage)(nil)
+	_ server.MetricBatchGetter = (*repository.Database)(nil)
+)
+
+func TestStorage_GetMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) batchStorage{
+		"memory": func(t *testing.T) batchStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) batchStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) batchStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+				StoreInterval:   300,
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) batchStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+
+			require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+				models.NewGauge("Alloc", 1.5),
+				models.NewCounter("PollCount", 10),
+			}))
+
+			metrics, err := storage.GetMetrics(ctx, []server.MetricKey{
+				{Type: models.CounterType, Name: "PollCount"},
+				{Type: models.GaugeType, Name: "unknown"},
+				{Type: models.CounterType, Name: "Alloc"},
+				{Type: "histogram", Name: "Alloc"},
+				{Type: models.GaugeType, Name: "Alloc"},
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metric{
+				models.NewGauge("Alloc", 1.5),
+				models.NewCounter("PollCount", 10),
+			}, metrics)
+
+			metrics, err = storage.GetMetrics(ctx, nil)
+			require.NoError(t, err)
+			assert.Empty(t, metrics)
+		})
+	}
+}
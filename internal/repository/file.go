@@ -9,13 +9,20 @@ import (
 	"time"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/files"
 	"github.com/rs/zerolog/log"
 )
 
+// FileStorage keeps the metrics in memory and saves them to a file. The API
+// tokens are saved to their own file, see TokenFilePath.
 type FileStorage struct {
+	*fileTokens
+
 	mutex      sync.RWMutex
 	wg         sync.WaitGroup
+	done       chan struct{}
+	closeOnce  sync.Once
 	filePath   string
 	storage    *MemStorage
 	SyncRecord bool
@@ -39,10 +46,19 @@ func (fs *FileStorage) save(ctx context.Context) {
 func NewFileStorage(ctx context.Context, fp *FileParams) (*FileStorage, error) {
 	fs := &FileStorage{
 		wg:         sync.WaitGroup{},
+		done:       make(chan struct{}),
 		filePath:   fp.FileStoragePath,
 		storage:    NewMemStorage(),
 		SyncRecord: fp.StoreInterval == 0,
 	}
+	// Tokens are credentials rather than data: they are loaded whatever
+	// RestoreOnStart is.
+	tokens, err := newFileTokens(TokenFilePath(fp.FileStoragePath))
+	if err != nil {
+		return nil, err
+	}
+	fs.fileTokens = tokens
+
 	if fp.RestoreOnStart {
 		err := files.LoadFromDB(ctx, fs.storage, fp.FileStoragePath)
 
@@ -68,6 +84,8 @@ func NewFileStorage(ctx context.Context, fp *FileParams) (*FileStorage, error) {
 					log.Info().Msg("Shutting down server, saving metrics")
 					fs.save(ctx)
 					return
+				case <-fs.done:
+					return
 				}
 			}
 		}()
@@ -103,6 +121,49 @@ func (fs *FileStorage) UpdateMetricList(ctx context.Context, metrics []models.Me
 		return fmt.Errorf("failed update metric list from file storage %w", err)
 	}
 
+	if fs.SyncRecord {
+		if err := files.SaveToDB(ctx, fs.storage, fs.filePath); err != nil {
+			log.Error().Err(err).Msg("failed save storage")
+			return fmt.Errorf("failed save storage %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyMetricList updates a list of metrics like UpdateMetricList and
+// returns their updated values (see server.MetricApplier).
+func (fs *FileStorage) ApplyMetricList(ctx context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	stored, err := fs.storage.ApplyMetricList(ctx, metrics)
+	if err != nil {
+		log.Error().Err(err).Msg("failed update metric list from file storage")
+		return nil, fmt.Errorf("failed update metric list from file storage %w", err)
+	}
+
+	if fs.SyncRecord {
+		if err := files.SaveToDB(ctx, fs.storage, fs.filePath); err != nil {
+			log.Error().Err(err).Msg("failed save storage")
+			return nil, fmt.Errorf("failed save storage %w", err)
+		}
+	}
+
+	return stored, nil
+}
+
+// SetMetricList stores the metrics with their values, counters are not
+// added to (see server.MetricSetter).
+func (fs *FileStorage) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	if err := fs.storage.SetMetricList(ctx, metrics); err != nil {
+		return fmt.Errorf("failed set metric list in file storage: %w", err)
+	}
+
+	if fs.SyncRecord {
+		if err := files.SaveToDB(ctx, fs.storage, fs.filePath); err != nil {
+			log.Error().Err(err).Msg("failed save storage")
+			return fmt.Errorf("failed save storage %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -119,6 +180,11 @@ func (fs *FileStorage) GetMetric(ctx context.Context, mType, mName string) (mode
 	return metric, nil
 }
 
+// GetMetrics get the metrics with the given keys, skipping the ones that are not found.
+func (fs *FileStorage) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	return fs.storage.GetMetrics(ctx, keys)
+}
+
 func (fs *FileStorage) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
 	metrics, err := fs.storage.GetAllMetrics(ctx)
 	if err != nil {
@@ -129,7 +195,67 @@ func (fs *FileStorage) GetAllMetrics(ctx context.Context) ([]models.Metric, erro
 	return metrics, nil
 }
 
+// RangeMetrics calls fn for every metric in memory, see MemStorage.RangeMetrics.
+func (fs *FileStorage) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	return fs.storage.RangeMetrics(ctx, fn)
+}
+
+// Snapshot returns copies of all metrics at a single point in time.
+func (fs *FileStorage) Snapshot(ctx context.Context) ([]models.Metric, error) {
+	return fs.storage.Snapshot(ctx)
+}
+
+// Version returns the change version of the metrics in memory.
+func (fs *FileStorage) Version(ctx context.Context) (server.Version, error) {
+	return fs.storage.Version(ctx)
+}
+
+// ReplaceAll replaces all metrics with the given ones and saves
+// the file right away, whatever the store interval is.
+func (fs *FileStorage) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	if err := fs.storage.ReplaceAll(ctx, metrics); err != nil {
+		return fmt.Errorf("failed replace metrics in file storage %w", err)
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	// SaveToDB skips empty storages, an emptied storage must still overwrite the file.
+	if len(metrics) == 0 {
+		if err := files.WriteFile(fs.filePath, []byte("[]")); err != nil {
+			return fmt.Errorf("failed save storage %w", err)
+		}
+		return nil
+	}
+
+	if err := files.SaveToDB(ctx, fs.storage, fs.filePath); err != nil {
+		log.Error().Err(err).Msg("failed save storage")
+		return fmt.Errorf("failed save storage %w", err)
+	}
+
+	return nil
+}
+
+// Close saves the metrics for the last time, so the updates made after the
+// storage context is done, e.g. the final flush of a listener, are kept.
 func (fs *FileStorage) Close() error {
+	fs.closeOnce.Do(func() {
+		close(fs.done)
+	})
 	fs.wg.Wait()
+
+	if fs.SyncRecord {
+		return nil
+	}
+
+	log.Info().Msg("Closing file storage, saving metrics")
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := files.SaveToDB(context.Background(), fs.storage, fs.filePath); err != nil {
+		return fmt.Errorf("failed to save metrics on close: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage_CloseSavesLateUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+		FileStoragePath: path,
+		StoreInterval:   3600,
+	})
+	require.NoError(t, err)
+
+	// The interval saving stops with ctx, the listeners still drain.
+	cancel()
+	require.NoError(t, fs.UpdateMetric(context.Background(), models.CounterType, "requests", int64(3)))
+	require.NoError(t, fs.Close())
+	require.NoError(t, fs.Close())
+
+	restored, err := repository.NewFileStorage(context.Background(), &repository.FileParams{
+		FileStoragePath: path,
+		RestoreOnStart:  true,
+	})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	metric, err := restored.GetMetric(context.Background(), models.CounterType, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metric.Value())
+}
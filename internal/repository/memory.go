@@ -3,8 +3,10 @@ package repository
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
 )
 
 // MemStorage is a memory storage for metrics with a mutex for thread safety.
@@ -12,21 +14,35 @@ import (
 // - the first level of keys is the type of metric (for example, "gauge" or "counter")
 // - the second level of keys is the name of the metric
 // - the value is an object implementing the models.Metric
+//
+// Every write also advances the change version of the storage.
+// API tokens are kept in memory too.
 type MemStorage struct {
+	*MemTokenStore
+
 	mutex   sync.RWMutex
 	storage map[string]map[string]models.Metric
+	version server.Version
 }
 
 // NewMemStorage creates a new memory storage for metrics
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
+		MemTokenStore: NewMemTokenStore(),
 		storage: map[string]map[string]models.Metric{
 			models.GaugeType:   make(map[string]models.Metric),
 			models.CounterType: make(map[string]models.Metric),
 		},
+		version: server.Version{Modified: time.Now()},
 	}
 }
 
+// touch advances the change version; the caller holds the write lock.
+func (ms *MemStorage) touch() {
+	ms.version.Seq++
+	ms.version.Modified = time.Now()
+}
+
 // updateMetric is a internal function to update a metric in the memory storage.
 //
 // If the metric is not found, a new metric is created.
@@ -65,6 +81,7 @@ func updateMetric(ms *MemStorage, mType, mName string, mValue any) error {
 func (ms *MemStorage) UpdateMetric(_ context.Context, mType, mName string, mValue any) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
+	defer ms.touch()
 
 	return updateMetric(ms, mType, mName, mValue)
 }
@@ -73,6 +90,8 @@ func (ms *MemStorage) UpdateMetric(_ context.Context, mType, mName string, mValu
 func (ms *MemStorage) UpdateMetricList(_ context.Context, metrics []models.Metric) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
+	// A failed list may be applied partially, so the version advances anyway.
+	defer ms.touch()
 
 	for _, metric := range metrics {
 		if err := updateMetric(ms, metric.Type(), metric.Name(), metric.Value()); err != nil {
@@ -83,6 +102,79 @@ func (ms *MemStorage) UpdateMetricList(_ context.Context, metrics []models.Metri
 	return nil
 }
 
+// ApplyMetricList updates a list of metrics in the memory storage like
+// UpdateMetricList and returns copies of their updated values (see
+// server.MetricApplier).
+func (ms *MemStorage) ApplyMetricList(_ context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	defer ms.touch()
+
+	stored := make([]models.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if err := updateMetric(ms, metric.Type(), metric.Name(), metric.Value()); err != nil {
+			return nil, err
+		}
+		stored = append(stored, copyMetric(ms.storage[metric.Type()][metric.Name()]))
+	}
+
+	return stored, nil
+}
+
+// copyMetric returns a copy of a stored metric, which is updated in place.
+func copyMetric(metric models.Metric) models.Metric {
+	switch value := metric.Value().(type) {
+	case int64:
+		return models.NewCounter(metric.Name(), value)
+	case float64:
+		return models.NewGauge(metric.Name(), value)
+	default:
+		return metric
+	}
+}
+
+// setMetric stores a copy of the metric, replacing the stored value; the
+// caller holds the write lock.
+func setMetric(ms *MemStorage, metric models.Metric) error {
+	var stored models.Metric
+
+	switch metric.Type() {
+	case models.GaugeType:
+		value, ok := metric.Value().(float64)
+		if !ok {
+			return models.ErrInvalidValueType
+		}
+		stored = models.NewGauge(metric.Name(), value)
+	case models.CounterType:
+		value, ok := metric.Value().(int64)
+		if !ok {
+			return models.ErrInvalidValueType
+		}
+		stored = models.NewCounter(metric.Name(), value)
+	default:
+		return models.ErrInvalidMetricsType
+	}
+
+	ms.storage[metric.Type()][metric.Name()] = stored
+	return nil
+}
+
+// SetMetricList stores the metrics with their values, counters are not
+// added to (see server.MetricSetter).
+func (ms *MemStorage) SetMetricList(_ context.Context, metrics []models.Metric) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	defer ms.touch()
+
+	for _, metric := range metrics {
+		if err := setMetric(ms, metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // GetMetric get a metric from the memory storage
 func (ms *MemStorage) GetMetric(_ context.Context, mType, mName string) (models.Metric, error) {
 	ms.mutex.RLock()
@@ -101,6 +193,22 @@ func (ms *MemStorage) GetMetric(_ context.Context, mType, mName string) (models.
 	return metric, nil
 }
 
+// GetMetrics get the metrics with the given keys from the memory storage,
+// skipping the ones that are not found
+func (ms *MemStorage) GetMetrics(_ context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	result := make([]models.Metric, 0, len(keys))
+	for _, key := range keys {
+		if metric, ok := ms.storage[key.Type][key.Name]; ok {
+			result = append(result, metric)
+		}
+	}
+
+	return result, nil
+}
+
 // GetAllMetrics get all metrics from the memory storage
 func (ms *MemStorage) GetAllMetrics(_ context.Context) ([]models.Metric, error) {
 	ms.mutex.RLock()
@@ -121,6 +229,92 @@ func (ms *MemStorage) GetAllMetrics(_ context.Context) ([]models.Metric, error)
 	return result, nil
 }
 
+// RangeMetrics calls fn for every metric of the memory storage, in no
+// particular order, and stops at the first error fn returns.
+//
+// Only the metric keys are copied up front, and fn runs without the storage
+// lock, so a slow fn doesn't block the writers. Metrics added during the
+// walk may be missed.
+func (ms *MemStorage) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	ms.mutex.RLock()
+	keys := make([]server.MetricKey, 0, len(ms.storage[models.GaugeType])+len(ms.storage[models.CounterType]))
+	for mType, innerMap := range ms.storage {
+		for mName := range innerMap {
+			keys = append(keys, server.MetricKey{Type: mType, Name: mName})
+		}
+	}
+	ms.mutex.RUnlock()
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ms.mutex.RLock()
+		metric, ok := ms.storage[key.Type][key.Name]
+		ms.mutex.RUnlock()
+
+		if !ok {
+			continue
+		}
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshot returns copies of all metrics, taken under the storage lock,
+// so later updates don't change the result.
+func (ms *MemStorage) Snapshot(_ context.Context) ([]models.Metric, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	result := make([]models.Metric, 0, len(ms.storage[models.GaugeType])+len(ms.storage[models.CounterType]))
+
+	for _, innerMap := range ms.storage {
+		for _, metric := range innerMap {
+			switch value := metric.Value().(type) {
+			case float64:
+				result = append(result, models.NewGauge(metric.Name(), value))
+			case int64:
+				result = append(result, models.NewCounter(metric.Name(), value))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// ReplaceAll replaces all metrics of the memory storage with the given ones.
+//
+// If a metric is invalid, the storage is left unchanged.
+func (ms *MemStorage) ReplaceAll(_ context.Context, metrics []models.Metric) error {
+	fresh := NewMemStorage()
+	for _, metric := range metrics {
+		if err := updateMetric(fresh, metric.Type(), metric.Name(), metric.Value()); err != nil {
+			return err
+		}
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.storage = fresh.storage
+	ms.touch()
+
+	return nil
+}
+
+// Version returns the change version of the memory storage
+func (ms *MemStorage) Version(_ context.Context) (server.Version, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return ms.version, nil
+}
+
 // Close closes the memory storage
 func (ms *MemStorage) Close() error {
 	return nil
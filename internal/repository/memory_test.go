@@ -2,7 +2,11 @@ package repository_test
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
@@ -300,6 +304,110 @@ func BenchmarkMemStorage_UpdateMetricList(b *testing.B) {
 	}
 }
 
+// metricsUpdater is the part of the storages compared by the parallel benchmarks.
+type metricsUpdater interface {
+	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
+}
+
+// agentBatches builds one batch of metrics per simulated agent,
+// every agent reporting its own set of gauges and counters.
+func agentBatches(agents, perAgent int) [][]models.Metric {
+	batches := make([][]models.Metric, agents)
+
+	for a := range batches {
+		batch := make([]models.Metric, 0, perAgent)
+		for i := 0; i < perAgent/2; i++ {
+			batch = append(batch,
+				models.NewGauge(fmt.Sprintf("agent%d_gauge%d", a, i), float64(i)),
+				models.NewCounter(fmt.Sprintf("agent%d_counter%d", a, i), 1),
+			)
+		}
+		batches[a] = batch
+	}
+
+	return batches
+}
+
+// benchmarkParallelUpdateMetricList runs UpdateMetricList from the given number
+// of parallel writers per GOMAXPROCS, each writer sending the batches of
+// a different simulated agent.
+func benchmarkParallelUpdateMetricList(b *testing.B, storage metricsUpdater, writers int) {
+	ctx := context.Background()
+	batches := agentBatches(64, 32)
+
+	var next atomic.Int64
+
+	b.SetParallelism(writers)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		batch := batches[int(next.Add(1))%len(batches)]
+		for pb.Next() {
+			_ = storage.UpdateMetricList(ctx, batch)
+		}
+	})
+}
+
+func BenchmarkMemStorage_UpdateMetricListParallel(b *testing.B) {
+	for _, writers := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			benchmarkParallelUpdateMetricList(b, repository.NewMemStorage(), writers)
+		})
+	}
+}
+
+func BenchmarkShardedMemStorage_UpdateMetricListParallel(b *testing.B) {
+	for _, shards := range []int{1, 8, 32, 128} {
+		for _, writers := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("shards=%d/writers=%d", shards, writers), func(b *testing.B) {
+				benchmarkParallelUpdateMetricList(b, repository.NewShardedMemStorage(shards), writers)
+			})
+		}
+	}
+}
+
+func BenchmarkMemStorage_GetAllMetricsWithWriters(b *testing.B) {
+	benchmarkGetAllMetricsWithWriters(b, repository.NewMemStorage())
+}
+
+func BenchmarkShardedMemStorage_GetAllMetricsWithWriters(b *testing.B) {
+	benchmarkGetAllMetricsWithWriters(b, repository.NewShardedMemStorage(repository.DefaultShardCount))
+}
+
+// benchmarkGetAllMetricsWithWriters measures GetAllMetrics while background
+// writers keep updating the storage, one batch every few microseconds each.
+func benchmarkGetAllMetricsWithWriters(b *testing.B, storage interface {
+	metricsUpdater
+	GetAllMetrics(ctx context.Context) ([]models.Metric, error)
+}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := agentBatches(16, 32)
+
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		_ = storage.UpdateMetricList(ctx, batch)
+
+		wg.Add(1)
+		go func(batch []models.Metric) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_ = storage.UpdateMetricList(ctx, batch)
+				time.Sleep(10 * time.Microsecond)
+			}
+		}(batch)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = storage.GetAllMetrics(ctx)
+	}
+	b.StopTimer()
+
+	cancel()
+	wg.Wait()
+}
+
 func BenchmarkMemStorage_GetMetric(b *testing.B) {
 	ctx := context.Background()
 	m := repository.NewMemStorage()
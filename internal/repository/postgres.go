@@ -5,16 +5,28 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
 	errH "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/errors-handlers"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 	"github.com/rs/zerolog/log"
 )
 
+// defaultTable is the table of the default tenant.
+const defaultTable = "collector"
+
 type Database struct {
 	DB *sql.DB
+
+	// table is the metrics table; empty means defaultTable.
+	table string
+	// shared is set for tenant databases, which use the connection pool
+	// of the default one and must not close it.
+	shared bool
 }
 
 func NewDatabase(ctx context.Context, dataBaseDSN string) (*Database, error) {
@@ -25,20 +37,19 @@ func NewDatabase(ctx context.Context, dataBaseDSN string) (*Database, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	_, err = db.ExecContext(ctx,
-		"CREATE TABLE IF NOT EXISTS collector ("+
-			"\"ID\" VARCHAR(250) PRIMARY KEY,"+
-			"\"MType\" TEXT,"+
-			"\"Delta\" BIGINT,"+
-			"\"Value\" DOUBLE PRECISION"+
-			");")
+	if err := createTable(ctx, db, defaultTable); err != nil {
+		if err := db.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close database")
+		}
+		return nil, err
+	}
 
-	if err != nil {
+	if err := createTokenTable(ctx, db); err != nil {
+		log.Error().Err(err).Msg("failed create token table")
 		if err := db.Close(); err != nil {
 			log.Error().Err(err).Msg("failed to close database")
 		}
-		log.Error().Err(err).Msg("failed create table for database")
-		return nil, fmt.Errorf("failed create table for database %w", err)
+		return nil, err
 	}
 
 	return &Database{
@@ -46,6 +57,79 @@ func NewDatabase(ctx context.Context, dataBaseDSN string) (*Database, error) {
 	}, nil
 }
 
+// ForTenant returns the database of a tenant. Every tenant has its own
+// table "collector_<tenant>" in the same database, created if needed.
+func (db *Database) ForTenant(ctx context.Context, tenantID string) (*Database, error) {
+	if err := tenant.ValidateID(tenantID); err != nil {
+		return nil, err
+	}
+
+	table := `"` + defaultTable + "_" + tenantID + `"`
+	if err := createTable(ctx, db.DB, table); err != nil {
+		return nil, err
+	}
+
+	return &Database{
+		DB:     db.DB,
+		table:  table,
+		shared: true,
+	}, nil
+}
+
+// createTable creates the metrics table and its version table, which has
+// a single row with the change version of the metrics.
+func createTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+table+" ("+
+			"\"ID\" VARCHAR(250) PRIMARY KEY,"+
+			"\"MType\" TEXT,"+
+			"\"Delta\" BIGINT,"+
+			"\"Value\" DOUBLE PRECISION"+
+			");")
+
+	if err != nil {
+		log.Error().Err(err).Str("table", table).Msg("failed create table for database")
+		return fmt.Errorf("failed create table for database %w", err)
+	}
+
+	vtable := versionTable(table)
+	_, err = db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+vtable+" ("+
+			"\"ID\" BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (\"ID\"),"+
+			"\"Version\" BIGINT NOT NULL,"+
+			"\"Modified\" TIMESTAMPTZ NOT NULL"+
+			");")
+	if err == nil {
+		_, err = db.ExecContext(ctx,
+			"INSERT INTO "+vtable+" (\"Version\", \"Modified\") VALUES (0, clock_timestamp()) ON CONFLICT DO NOTHING")
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("table", vtable).Msg("failed create version table for database")
+		return fmt.Errorf("failed create version table for database %w", err)
+	}
+
+	return nil
+}
+
+// versionTable returns the name of the version table of a metrics table,
+// which may be quoted.
+func versionTable(table string) string {
+	if name, ok := strings.CutSuffix(table, `"`); ok {
+		return name + `_version"`
+	}
+
+	return table + "_version"
+}
+
+func (db *Database) tableName() string {
+	if db.table == "" {
+		return defaultTable
+	}
+
+	return db.table
+}
+
 func (db *Database) GetMetric(ctx context.Context, mType, mName string) (models.Metric, error) {
 	var (
 		id    string
@@ -56,7 +140,7 @@ func (db *Database) GetMetric(ctx context.Context, mType, mName string) (models.
 
 	getMtr := func() error {
 		builder := sq.Select(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
-			From("collector").
+			From(db.tableName()).
 			Where(sq.Eq{`"ID"`: mName, `"MType"`: mType}).
 			Limit(1).
 			PlaceholderFormat(sq.Dollar)
@@ -104,7 +188,7 @@ func (db *Database) GetMetric(ctx context.Context, mType, mName string) (models.
 
 func (db *Database) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
 	builder := sq.Select(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
-		From("collector")
+		From(db.tableName())
 
 	query, args, err := builder.ToSql()
 	if err != nil {
@@ -117,14 +201,106 @@ func (db *Database) GetAllMetrics(ctx context.Context) ([]models.Metric, error)
 		log.Error().Err(err).Msg("The request was not processed")
 		return nil, fmt.Errorf("failed to query all metrics: %w", err)
 	}
+
+	return scanMetrics(rows, make([]models.Metric, 0))
+}
+
+// RangeMetrics calls fn for every metric of the table, in no particular
+// order, and stops at the first error fn returns. The rows are read as fn
+// consumes them, so the table is never loaded into memory at once.
+func (db *Database) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	query, args, err := sq.Select(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
+		From(db.tableName()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("The request was not processed")
+		return fmt.Errorf("failed to query all metrics: %w", err)
+	}
+
+	return rangeRows(rows, fn)
+}
+
+// lookupBatchSize is the largest number of keys looked up in one query,
+// to stay far below the limit of query parameters.
+const lookupBatchSize = 1000
+
+// GetMetrics get the metrics with the given keys, selecting them with
+// `"ID" IN (...)` per metric type, and skips the ones that are not found.
+func (db *Database) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	metrics := make([]models.Metric, 0, len(keys))
+
+	for start := 0; start < len(keys); start += lookupBatchSize {
+		names := make(map[string][]string)
+		for _, key := range keys[start:min(start+lookupBatchSize, len(keys))] {
+			names[key.Type] = append(names[key.Type], key.Name)
+		}
+
+		where := sq.Or{}
+		for _, mType := range []string{models.GaugeType, models.CounterType} {
+			if len(names[mType]) > 0 {
+				where = append(where, sq.And{sq.Eq{`"MType"`: mType}, sq.Eq{`"ID"`: names[mType]}})
+			}
+		}
+		if len(where) == 0 {
+			continue
+		}
+
+		query, args, err := sq.Select(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
+			From(db.tableName()).
+			Where(where).
+			PlaceholderFormat(sq.Dollar).
+			ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build query: %w", err)
+		}
+
+		var rows *sql.Rows
+		err = errH.WithRetry(func() error {
+			var err error
+			rows, err = db.DB.QueryContext(ctx, query, args...)
+			return err
+		}, errH.IsPostgresRetriableError)
+		if err != nil {
+			log.Error().Err(err).Msg("The request was not processed")
+			return nil, fmt.Errorf("failed to query metrics: %w", err)
+		}
+
+		metrics, err = scanMetrics(rows, metrics)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+// scanMetrics appends the metrics of the rows to metrics and closes the rows.
+func scanMetrics(rows *sql.Rows, metrics []models.Metric) ([]models.Metric, error) {
+	err := rangeRows(rows, func(metric models.Metric) error {
+		metrics = append(metrics, metric)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// rangeRows calls fn for the metric of every row, closes the rows and
+// stops at the first error.
+func rangeRows(rows *sql.Rows, fn func(models.Metric) error) error {
 	defer func() {
 		if err := rows.Close(); err != nil {
 			log.Printf("failed to close rows: %v", err)
 		}
 	}()
 
-	metrics := make([]models.Metric, 0)
-
 	var (
 		id    string
 		mType string
@@ -133,38 +309,46 @@ func (db *Database) GetAllMetrics(ctx context.Context) ([]models.Metric, error)
 	)
 
 	for rows.Next() {
-		err = rows.Scan(&id, &mType, &delta, &value)
+		err := rows.Scan(&id, &mType, &delta, &value)
 		if err != nil {
 			log.Error().Err(err).Msgf("failed scan row: ID = %s, MType = %s", id, mType)
-			return nil, fmt.Errorf("failed to scan metric row: %v", err)
+			return fmt.Errorf("failed to scan metric row: %v", err)
 		}
 
 		switch mType {
 		case models.GaugeType:
 			if value.Valid {
-				metrics = append(metrics, models.NewGauge(id, value.Float64))
+				err = fn(models.NewGauge(id, value.Float64))
 			}
 
 		case models.CounterType:
 			if delta.Valid {
-				metrics = append(metrics, models.NewCounter(id, delta.Int64))
+				err = fn(models.NewCounter(id, delta.Int64))
 			}
 
 		default:
-			return nil, fmt.Errorf("incorrectly metric type %v", models.ErrInvalidMetricsType)
+			return fmt.Errorf("incorrectly metric type %v", models.ErrInvalidMetricsType)
+		}
+		if err != nil {
+			return err
 		}
-
 	}
 
 	if rows.Err() != nil {
 		log.Error().Err(rows.Err()).Msg("have rows error")
-		return nil, rows.Err()
+		return rows.Err()
 	}
 
-	return metrics, nil
+	return nil
 }
 
-func (db *Database) UpdateMetric(ctx context.Context, mType, mName string, mValue any) error {
+// upsertMetric inserts a metric into the table within the given
+// transaction, adding the counter delta or replacing the gauge value
+// if the metric already exists. With set, the counter value replaces the
+// stored one too. If stored is not nil, it is set to the metric as stored
+// by the statement.
+func upsertMetric(ctx context.Context, tx *sql.Tx, table, mType, mName string, mValue any, set bool,
+	stored *models.Metric) error {
 	var delta *int64
 	var value *float64
 
@@ -185,22 +369,25 @@ func (db *Database) UpdateMetric(ctx context.Context, mType, mName string, mValu
 		return fmt.Errorf("unsupported metric value type: %T", v)
 	}
 
-	tx, err := db.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+	deltaUpdate := table + `."Delta" + EXCLUDED."Delta"`
+	if set {
+		deltaUpdate = `EXCLUDED."Delta"`
+	}
+
+	suffix := `ON CONFLICT ("ID") DO UPDATE SET
+			"Delta" = ` + deltaUpdate + `,
+			"Value" = EXCLUDED."Value",
+			"MType" = EXCLUDED."MType"`
+	if stored != nil {
+		suffix += `
+			RETURNING "Delta", "Value"`
 	}
-	defer func() {
-		_ = tx.Rollback()
-	}()
 
 	exec := func() error {
-		builder := sq.Insert("collector").
+		builder := sq.Insert(table).
 			Columns(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
 			Values(mName, mType, delta, value).
-			Suffix(`ON CONFLICT ("ID") DO UPDATE SET
-			"Delta" = collector."Delta" + EXCLUDED."Delta",
-			"Value" = EXCLUDED."Value",
-			"MType" = EXCLUDED."MType"`).
+			Suffix(suffix).
 			PlaceholderFormat(sq.Dollar)
 
 		query, args, err := builder.ToSql()
@@ -208,8 +395,23 @@ func (db *Database) UpdateMetric(ctx context.Context, mType, mName string, mValu
 			return err
 		}
 
-		_, err = tx.ExecContext(ctx, query, args...)
-		return err
+		if stored == nil {
+			_, err = tx.ExecContext(ctx, query, args...)
+			return err
+		}
+
+		var total sql.NullInt64
+		var current sql.NullFloat64
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&total, &current); err != nil {
+			return err
+		}
+
+		if mType == models.CounterType {
+			*stored = models.NewCounter(mName, total.Int64)
+		} else {
+			*stored = models.NewGauge(mName, current.Float64)
+		}
+		return nil
 	}
 
 	if err := errH.WithRetry(exec, errH.IsPostgresRetriableError); err != nil {
@@ -217,16 +419,161 @@ func (db *Database) UpdateMetric(ctx context.Context, mType, mName string, mValu
 		return fmt.Errorf("update metric: %w", err)
 	}
 
+	return nil
+}
+
+// touch advances the change version within the write transaction. It is
+// the last statement of the transaction, as the version row is locked
+// until the commit and serializes the writers.
+func touch(ctx context.Context, tx *sql.Tx, table string) error {
+	exec := func() error {
+		_, err := tx.ExecContext(ctx,
+			"UPDATE "+versionTable(table)+" SET \"Version\" = \"Version\" + 1, \"Modified\" = clock_timestamp()")
+		return err
+	}
+
+	if err := errH.WithRetry(exec, errH.IsPostgresRetriableError); err != nil {
+		log.Error().Err(err).Msg("failed to update version")
+		return fmt.Errorf("update version: %w", err)
+	}
+
+	return nil
+}
+
+// Version returns the change version of the metrics table.
+func (db *Database) Version(ctx context.Context) (server.Version, error) {
+	var version server.Version
+
+	row := db.DB.QueryRowContext(ctx,
+		"SELECT \"Version\", \"Modified\" FROM "+versionTable(db.tableName()))
+	if err := row.Scan(&version.Seq, &version.Modified); err != nil {
+		log.Error().Err(err).Msg("failed to get version")
+		return server.Version{}, fmt.Errorf("get version: %w", err)
+	}
+
+	return version, nil
+}
+
+func (db *Database) UpdateMetric(ctx context.Context, mType, mName string, mValue any) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := upsertMetric(ctx, tx, db.tableName(), mType, mName, mValue, false, nil); err != nil {
+		return err
+	}
+
+	if err := touch(ctx, tx, db.tableName()); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+// UpdateMetricList updates a list of metrics in a single transaction,
+// so either all metrics of the list are stored or none of them.
 func (db *Database) UpdateMetricList(ctx context.Context, metrics []models.Metric) error {
+	return db.writeMetricList(ctx, metrics, false)
+}
+
+// SetMetricList stores the metrics with their values in a single
+// transaction, counters are not added to (see server.MetricSetter).
+func (db *Database) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	return db.writeMetricList(ctx, metrics, true)
+}
+
+// ApplyMetricList updates a list of metrics in a single transaction like
+// UpdateMetricList and returns their values as stored by it (see
+// server.MetricApplier).
+func (db *Database) ApplyMetricList(ctx context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stored := make([]models.Metric, len(metrics))
+	for i, metric := range metrics {
+		if err := upsertMetric(ctx, tx, db.tableName(), metric.Type(), metric.Name(), metric.Value(), false,
+			&stored[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := touch(ctx, tx, db.tableName()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return stored, nil
+}
+
+// writeMetricList upserts the metrics in a single transaction, see upsertMetric.
+func (db *Database) writeMetricList(ctx context.Context, metrics []models.Metric, set bool) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
 	for _, metric := range metrics {
-		if err := db.UpdateMetric(ctx, metric.Type(), metric.Name(), metric.Value()); err != nil {
+		if err := upsertMetric(ctx, tx, db.tableName(), metric.Type(), metric.Name(), metric.Value(), set, nil); err != nil {
 			return err
 		}
 	}
 
+	if err := touch(ctx, tx, db.tableName()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceAll replaces all metrics of the table with the given ones
+// in a single transaction.
+func (db *Database) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+db.tableName()); err != nil {
+		log.Error().Err(err).Msg("failed to delete metrics")
+		return fmt.Errorf("delete metrics: %w", err)
+	}
+
+	for _, metric := range metrics {
+		if err := upsertMetric(ctx, tx, db.tableName(), metric.Type(), metric.Name(), metric.Value(), true, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := touch(ctx, tx, db.tableName()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
 	return nil
 }
 
@@ -238,7 +585,7 @@ func (db *Database) Ping(ctx context.Context) error {
 }
 
 func (db *Database) Close() error {
-	if db.DB != nil {
+	if db.DB != nil && !db.shared {
 		if err := db.DB.Close(); err != nil {
 			log.Error().Err(err).Msg("failed to close database")
 			return err
@@ -6,11 +6,13 @@ import (
 	"database/sql/driver"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
 	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -249,6 +251,8 @@ func TestDatabase_UpdateMetric(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(query)).
 			WithArgs(driverArgs...).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectCommit()
 
@@ -305,6 +309,8 @@ func TestDatabase_UpdateMetric(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(query)).
 			WithArgs(driverArgs...).
 			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectCommit()
 
@@ -338,3 +344,180 @@ func TestDatabase_UpdateMetric(t *testing.T) {
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestDatabase_UpdateMetricList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repo.Database{
+		DB: db,
+	}
+
+	upsert := func(mName, mType string, delta, value any) (string, []driver.Value) {
+		builder := sq.Insert("collector").
+			Columns(`"ID"`, `"MType"`, `"Delta"`, `"Value"`).
+			Values(mName, mType, delta, value).
+			Suffix(`ON CONFLICT ("ID") DO UPDATE SET
+			"Delta" = collector."Delta" + EXCLUDED."Delta",
+			"Value" = EXCLUDED."Value",
+			"MType" = EXCLUDED."MType"`).
+			PlaceholderFormat(sq.Dollar)
+
+		query, args, err := builder.ToSql()
+		require.NoError(t, err)
+
+		driverArgs := make([]driver.Value, len(args))
+		for i, a := range args {
+			driverArgs[i] = a
+		}
+
+		return regexp.QuoteMeta(query), driverArgs
+	}
+
+	metrics := []models.Metric{
+		models.NewGauge("test_gauge", 100.0),
+		models.NewCounter("test_counter", 5),
+	}
+
+	t.Run("UpdateMetricList_SingleTransaction", func(t *testing.T) {
+		mock.ExpectBegin()
+
+		query, args := upsert("test_gauge", "gauge", nil, 100.0)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		query, args = upsert("test_counter", "counter", 5, nil)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.UpdateMetricList(context.Background(), metrics))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("UpdateMetricList_RollbackOnError", func(t *testing.T) {
+		mock.ExpectBegin()
+
+		query, args := upsert("test_gauge", "gauge", nil, 100.0)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		query, args = upsert("test_counter", "counter", 5, nil)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnError(sql.ErrConnDone)
+
+		mock.ExpectRollback()
+
+		err := repo.UpdateMetricList(context.Background(), metrics)
+		require.ErrorIs(t, err, sql.ErrConnDone)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDatabase_ReplaceAll(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repo.Database{
+		DB: db,
+	}
+
+	t.Run("ReplaceAll_SingleTransaction", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM collector`)).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO collector`)).
+			WithArgs("test_counter", "counter", 5, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.ReplaceAll(context.Background(), []models.Metric{models.NewCounter("test_counter", 5)}))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("ReplaceAll_RollbackOnError", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM collector`)).WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		err := repo.ReplaceAll(context.Background(), []models.Metric{models.NewCounter("test_counter", 5)})
+		require.ErrorIs(t, err, sql.ErrConnDone)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDatabase_GetMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repo.Database{
+		DB: db,
+	}
+
+	query := `SELECT "ID", "MType", "Delta", "Value" FROM collector ` +
+		`WHERE (("MType" = $1 AND "ID" IN ($2,$3)) OR ("MType" = $4 AND "ID" IN ($5)))`
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("gauge", "Alloc", "unknown", "counter", "PollCount").
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "MType", "Delta", "Value"}).
+			AddRow("Alloc", "gauge", nil, 1.5).
+			AddRow("PollCount", "counter", 10, nil))
+
+	metrics, err := repo.GetMetrics(context.Background(), []server.MetricKey{
+		{Type: models.GaugeType, Name: "Alloc"},
+		{Type: models.CounterType, Name: "PollCount"},
+		{Type: models.GaugeType, Name: "unknown"},
+		{Type: "histogram", Name: "ignored"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metric{
+		models.NewGauge("Alloc", 1.5),
+		models.NewCounter("PollCount", 10),
+	}, metrics)
+
+	metrics, err = repo.GetMetrics(context.Background(), []server.MetricKey{{Type: "histogram", Name: "ignored"}})
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabase_Version(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repo.Database{
+		DB: db,
+	}
+
+	modified := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "Version", "Modified" FROM collector_version`)).
+		WillReturnRows(sqlmock.NewRows([]string{"Version", "Modified"}).AddRow(42, modified))
+
+	version, err := repo.Version(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, server.Version{Seq: 42, Modified: modified}, version)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "Version", "Modified" FROM collector_version`)).
+		WillReturnError(sql.ErrConnDone)
+
+	_, err = repo.Version(context.Background())
+	require.ErrorIs(t, err, sql.ErrConnDone)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
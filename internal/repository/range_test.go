@@ -0,0 +1,136 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// rangeStorage is a storage that can walk its metrics.
+type rangeStorage interface {
+	server.MetricUpdater
+	RangeMetrics(ctx context.Context, fn func(models.Metric) error) error
+}
+
+func TestStorage_RangeMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) rangeStorage{
+		"memory": func(t *testing.T) rangeStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) rangeStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) rangeStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+				StoreInterval:   300,
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) rangeStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	want := make([]models.Metric, 0, 20)
+	for i := range 10 {
+		want = append(want,
+			models.NewGauge(fmt.Sprintf("gauge%d", i), float64(i)),
+			models.NewCounter(fmt.Sprintf("counter%d", i), int64(i)))
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			require.NoError(t, storage.UpdateMetricList(ctx, want))
+
+			var got []models.Metric
+			require.NoError(t, storage.RangeMetrics(ctx, func(metric models.Metric) error {
+				got = append(got, metric)
+				return nil
+			}))
+			assert.ElementsMatch(t, want, got)
+
+			// fn may write to the storage, it runs without the lock.
+			require.NoError(t, storage.RangeMetrics(ctx, func(metric models.Metric) error {
+				return storage.UpdateMetric(ctx, models.GaugeType, "seen", 1.0)
+			}))
+
+			errStop := errors.New("stop")
+			calls := 0
+			err := storage.RangeMetrics(ctx, func(models.Metric) error {
+				calls++
+				return errStop
+			})
+			assert.ErrorIs(t, err, errStop)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestDatabase_RangeMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repository.Database{
+		DB: db,
+	}
+	ctx := context.Background()
+	query := regexp.QuoteMeta(`SELECT "ID", "MType", "Delta", "Value" FROM collector`)
+	columns := []string{"ID", "MType", "Delta", "Value"}
+
+	mock.ExpectQuery(query).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test_gauge", "gauge", nil, 100.0).
+			AddRow("test_counter", "counter", 100, nil))
+
+	var got []models.Metric
+	require.NoError(t, repo.RangeMetrics(ctx, func(metric models.Metric) error {
+		got = append(got, metric)
+		return nil
+	}))
+	assert.Equal(t, []models.Metric{
+		models.NewGauge("test_gauge", 100.0),
+		models.NewCounter("test_counter", 100),
+	}, got)
+
+	errStop := errors.New("stop")
+	mock.ExpectQuery(query).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test_gauge", "gauge", nil, 100.0).
+			AddRow("test_counter", "counter", 100, nil)).
+		RowsWillBeClosed()
+	calls := 0
+	assert.ErrorIs(t, repo.RangeMetrics(ctx, func(models.Metric) error {
+		calls++
+		return errStop
+	}), errStop)
+	assert.Equal(t, 1, calls)
+
+	mock.ExpectQuery(query).WillReturnError(errBackendDown)
+	assert.ErrorIs(t, repo.RangeMetrics(ctx, func(models.Metric) error { return nil }), errBackendDown)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,112 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// setStorage is a storage that can set its metrics.
+type setStorage interface {
+	server.MetricGetter
+	server.MetricUpdater
+	server.MetricSetter
+}
+
+func TestStorage_SetMetricList(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) setStorage{
+		"memory": func(t *testing.T) setStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) setStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) setStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) setStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	metrics := []models.Metric{
+		models.NewCounter("requests", 7),
+		models.NewGauge("temperature", 36.6),
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+				models.NewCounter("requests", 5),
+				models.NewCounter("errors", 1),
+			}))
+
+			// Setting the same list twice sets the counter, not adds to it.
+			require.NoError(t, storage.SetMetricList(ctx, metrics))
+			require.NoError(t, storage.SetMetricList(ctx, metrics))
+
+			got, err := storage.GetAllMetrics(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metric{
+				models.NewCounter("requests", 7),
+				models.NewCounter("errors", 1),
+				models.NewGauge("temperature", 36.6),
+			}, got)
+
+			err = storage.SetMetricList(ctx, []models.Metric{&invalidMetric{}})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// invalidMetric is a metric of an unknown type.
+type invalidMetric struct{}
+
+func (*invalidMetric) Name() string       { return "invalid" }
+func (*invalidMetric) Type() string       { return "histogram" }
+func (*invalidMetric) Value() any         { return 1 }
+func (*invalidMetric) Update(_ any) error { return nil }
+
+func TestDatabase_SetMetricList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repository.Database{
+		DB: db,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`"Delta" = EXCLUDED."Delta"`)).
+		WithArgs("requests", models.CounterType, int64(7), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE collector_version`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.SetMetricList(context.Background(), []models.Metric{models.NewCounter("requests", 7)}))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
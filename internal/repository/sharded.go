@@ -0,0 +1,464 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// DefaultShardCount is the number of shards used by NewShardedMemStorage
+// when a non-positive shard count is requested.
+const DefaultShardCount = 32
+
+// memShard is a single independently locked part of the ShardedMemStorage.
+//
+// The shard mutex protects only the maps themselves. Values of already
+// existing metrics are stored in atomics, so updating them requires just
+// a read lock of the shard.
+type memShard struct {
+	mutex    sync.RWMutex
+	gauges   map[string]*atomic.Uint64
+	counters map[string]*atomic.Int64
+
+	// version counts the writes to the shard and modified is the time
+	// of the last one in Unix nanoseconds.
+	version  atomic.Uint64
+	modified atomic.Int64
+}
+
+// ShardedMemStorage is a memory storage for metrics split into N shards.
+//
+// Each metric is hashed by its type and name to one of the shards, and every
+// shard has its own mutex, so writers touching different metrics rarely
+// contend with each other. Counter deltas and gauge values are applied with
+// atomic operations when the metric already exists; the shard write lock is
+// taken only to create a new metric.
+//
+// Metrics returned by GetMetric and GetAllMetrics are snapshots of the values
+// at the moment of the call. GetAllMetrics locks one shard at a time, so the
+// result is consistent per shard, not across the whole storage; Snapshot
+// locks all shards and is consistent across the whole storage.
+//
+// The change version is kept per shard, so writers of different shards
+// don't contend for it either.
+type ShardedMemStorage struct {
+	// API tokens are not sharded, they are read far less than metrics.
+	*MemTokenStore
+
+	shards  []*memShard
+	created time.Time
+}
+
+// NewShardedMemStorage creates a new sharded memory storage for metrics with
+// the given number of shards. If shardCount is not positive,
+// DefaultShardCount is used.
+func NewShardedMemStorage(shardCount int) *ShardedMemStorage {
+	if shardCount <= 0 {
+		shardCount = DefaultShardCount
+	}
+
+	shards := make([]*memShard, shardCount)
+	for i := range shards {
+		shards[i] = &memShard{
+			gauges:   make(map[string]*atomic.Uint64),
+			counters: make(map[string]*atomic.Int64),
+		}
+	}
+
+	return &ShardedMemStorage{
+		MemTokenStore: NewMemTokenStore(),
+		shards:        shards,
+		created:       time.Now(),
+	}
+}
+
+// shardFor returns the shard responsible for the metric with the given type and name.
+//
+// It uses FNV-1a over the type and the name, computed inline to avoid
+// allocations on the hot path.
+func (ss *ShardedMemStorage) shardFor(mType, mName string) *memShard {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+
+	h := uint32(offset32)
+	for i := 0; i < len(mType); i++ {
+		h ^= uint32(mType[i])
+		h *= prime32
+	}
+	for i := 0; i < len(mName); i++ {
+		h ^= uint32(mName[i])
+		h *= prime32
+	}
+
+	return ss.shards[h%uint32(len(ss.shards))]
+}
+
+// touch advances the change version of the shard. It is called after
+// the value is stored, so a reader that sees the new version sees the value too.
+func (sh *memShard) touch() {
+	sh.modified.Store(time.Now().UnixNano())
+	sh.version.Add(1)
+}
+
+// updateGauge stores a new gauge value, creating the gauge if it doesn't exist.
+func (sh *memShard) updateGauge(mName string, value float64) {
+	defer sh.touch()
+
+	bits := math.Float64bits(value)
+
+	// Values are updated under the read lock, so no update runs
+	// while Snapshot holds the write locks of all shards.
+	sh.mutex.RLock()
+	if gauge, ok := sh.gauges[mName]; ok {
+		gauge.Store(bits)
+		sh.mutex.RUnlock()
+		return
+	}
+	sh.mutex.RUnlock()
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// The gauge could have been created while the lock was released.
+	if gauge, ok := sh.gauges[mName]; ok {
+		gauge.Store(bits)
+		return
+	}
+
+	gauge := &atomic.Uint64{}
+	gauge.Store(bits)
+	sh.gauges[mName] = gauge
+}
+
+// updateCounter adds delta to a counter, creating the counter if it
+// doesn't exist, and returns the total.
+func (sh *memShard) updateCounter(mName string, delta int64) int64 {
+	defer sh.touch()
+
+	sh.mutex.RLock()
+	if counter, ok := sh.counters[mName]; ok {
+		total := counter.Add(delta)
+		sh.mutex.RUnlock()
+		return total
+	}
+	sh.mutex.RUnlock()
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// The counter could have been created while the lock was released.
+	if counter, ok := sh.counters[mName]; ok {
+		return counter.Add(delta)
+	}
+
+	counter := &atomic.Int64{}
+	counter.Store(delta)
+	sh.counters[mName] = counter
+	return delta
+}
+
+// setCounter sets a counter to value, creating the counter if it doesn't exist.
+func (sh *memShard) setCounter(mName string, value int64) {
+	defer sh.touch()
+
+	sh.mutex.RLock()
+	if counter, ok := sh.counters[mName]; ok {
+		counter.Store(value)
+		sh.mutex.RUnlock()
+		return
+	}
+	sh.mutex.RUnlock()
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// The counter could have been created while the lock was released.
+	if counter, ok := sh.counters[mName]; ok {
+		counter.Store(value)
+		return
+	}
+
+	counter := &atomic.Int64{}
+	counter.Store(value)
+	sh.counters[mName] = counter
+}
+
+// UpdateMetric updates a metric in the sharded memory storage.
+//
+// If the metric is not found, a new metric is created.
+// If the metric is found, the value is updated.
+func (ss *ShardedMemStorage) UpdateMetric(_ context.Context, mType, mName string, mValue any) error {
+	switch mType {
+	case models.GaugeType:
+		value, ok := mValue.(float64)
+		if !ok {
+			return models.ErrInvalidValueType
+		}
+		ss.shardFor(mType, mName).updateGauge(mName, value)
+
+	case models.CounterType:
+		delta, ok := mValue.(int64)
+		if !ok {
+			return models.ErrInvalidValueType
+		}
+		ss.shardFor(mType, mName).updateCounter(mName, delta)
+
+	default:
+		return models.ErrInvalidMetricsType
+	}
+
+	return nil
+}
+
+// UpdateMetricList updates a list of metrics in the sharded memory storage.
+//
+// Metrics are applied one by one; the first invalid metric stops the update
+// and its error is returned, metrics before it stay applied.
+func (ss *ShardedMemStorage) UpdateMetricList(ctx context.Context, metrics []models.Metric) error {
+	for _, metric := range metrics {
+		if err := ss.UpdateMetric(ctx, metric.Type(), metric.Name(), metric.Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyMetricList updates a list of metrics like UpdateMetricList and
+// returns their updated values (see server.MetricApplier). A counter is
+// returned with the total its delta was added to, even if another update
+// of it follows concurrently.
+func (ss *ShardedMemStorage) ApplyMetricList(_ context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	stored := make([]models.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		switch metric.Type() {
+		case models.GaugeType:
+			value, ok := metric.Value().(float64)
+			if !ok {
+				return nil, models.ErrInvalidValueType
+			}
+			ss.shardFor(metric.Type(), metric.Name()).updateGauge(metric.Name(), value)
+			stored = append(stored, models.NewGauge(metric.Name(), value))
+
+		case models.CounterType:
+			delta, ok := metric.Value().(int64)
+			if !ok {
+				return nil, models.ErrInvalidValueType
+			}
+			total := ss.shardFor(metric.Type(), metric.Name()).updateCounter(metric.Name(), delta)
+			stored = append(stored, models.NewCounter(metric.Name(), total))
+
+		default:
+			return nil, models.ErrInvalidMetricsType
+		}
+	}
+
+	return stored, nil
+}
+
+// SetMetricList stores the metrics with their values, counters are not
+// added to (see server.MetricSetter). Like UpdateMetricList, the first
+// invalid metric stops it.
+func (ss *ShardedMemStorage) SetMetricList(_ context.Context, metrics []models.Metric) error {
+	for _, metric := range metrics {
+		switch metric.Type() {
+		case models.GaugeType:
+			value, ok := metric.Value().(float64)
+			if !ok {
+				return models.ErrInvalidValueType
+			}
+			ss.shardFor(metric.Type(), metric.Name()).updateGauge(metric.Name(), value)
+
+		case models.CounterType:
+			value, ok := metric.Value().(int64)
+			if !ok {
+				return models.ErrInvalidValueType
+			}
+			ss.shardFor(metric.Type(), metric.Name()).setCounter(metric.Name(), value)
+
+		default:
+			return models.ErrInvalidMetricsType
+		}
+	}
+
+	return nil
+}
+
+// GetMetric get a metric from the sharded memory storage
+func (ss *ShardedMemStorage) GetMetric(_ context.Context, mType, mName string) (models.Metric, error) {
+	switch mType {
+	case models.GaugeType:
+		sh := ss.shardFor(mType, mName)
+
+		sh.mutex.RLock()
+		gauge, ok := sh.gauges[mName]
+		sh.mutex.RUnlock()
+
+		if !ok {
+			return nil, models.ErrMetricsNotFound
+		}
+
+		return models.NewGauge(mName, math.Float64frombits(gauge.Load())), nil
+
+	case models.CounterType:
+		sh := ss.shardFor(mType, mName)
+
+		sh.mutex.RLock()
+		counter, ok := sh.counters[mName]
+		sh.mutex.RUnlock()
+
+		if !ok {
+			return nil, models.ErrMetricsNotFound
+		}
+
+		return models.NewCounter(mName, counter.Load()), nil
+
+	default:
+		return nil, models.ErrInvalidMetricsType
+	}
+}
+
+// GetMetrics get the metrics with the given keys from the sharded memory
+// storage, skipping the ones that are not found
+func (ss *ShardedMemStorage) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	result := make([]models.Metric, 0, len(keys))
+	for _, key := range keys {
+		metric, err := ss.GetMetric(ctx, key.Type, key.Name)
+		if err != nil {
+			continue
+		}
+		result = append(result, metric)
+	}
+
+	return result, nil
+}
+
+// GetAllMetrics get all metrics from the sharded memory storage
+func (ss *ShardedMemStorage) GetAllMetrics(_ context.Context) ([]models.Metric, error) {
+	result := make([]models.Metric, 0)
+
+	for _, sh := range ss.shards {
+		sh.mutex.RLock()
+		for name, gauge := range sh.gauges {
+			result = append(result, models.NewGauge(name, math.Float64frombits(gauge.Load())))
+		}
+		for name, counter := range sh.counters {
+			result = append(result, models.NewCounter(name, counter.Load()))
+		}
+		sh.mutex.RUnlock()
+	}
+
+	return result, nil
+}
+
+// RangeMetrics calls fn for every metric of the sharded memory storage, in
+// no particular order, and stops at the first error fn returns.
+//
+// The metrics are copied one shard at a time and fn runs without the shard
+// lock, so only a single shard is held in memory besides the storage.
+func (ss *ShardedMemStorage) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	for _, sh := range ss.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		sh.mutex.RLock()
+		metrics := make([]models.Metric, 0, len(sh.gauges)+len(sh.counters))
+		for name, gauge := range sh.gauges {
+			metrics = append(metrics, models.NewGauge(name, math.Float64frombits(gauge.Load())))
+		}
+		for name, counter := range sh.counters {
+			metrics = append(metrics, models.NewCounter(name, counter.Load()))
+		}
+		sh.mutex.RUnlock()
+
+		for _, metric := range metrics {
+			if err := fn(metric); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// lockAll takes the write locks of all shards, in order.
+func (ss *ShardedMemStorage) lockAll() {
+	for _, sh := range ss.shards {
+		sh.mutex.Lock()
+	}
+}
+
+func (ss *ShardedMemStorage) unlockAll() {
+	for _, sh := range ss.shards {
+		sh.mutex.Unlock()
+	}
+}
+
+// Snapshot returns all metrics at a single point in time.
+//
+// Unlike GetAllMetrics it blocks all writers while the metrics are copied.
+func (ss *ShardedMemStorage) Snapshot(_ context.Context) ([]models.Metric, error) {
+	ss.lockAll()
+	defer ss.unlockAll()
+
+	result := make([]models.Metric, 0)
+	for _, sh := range ss.shards {
+		for name, gauge := range sh.gauges {
+			result = append(result, models.NewGauge(name, math.Float64frombits(gauge.Load())))
+		}
+		for name, counter := range sh.counters {
+			result = append(result, models.NewCounter(name, counter.Load()))
+		}
+	}
+
+	return result, nil
+}
+
+// ReplaceAll replaces all metrics of the storage with the given ones.
+//
+// If a metric is invalid, the storage is left unchanged.
+func (ss *ShardedMemStorage) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	fresh := NewShardedMemStorage(len(ss.shards))
+	if err := fresh.UpdateMetricList(ctx, metrics); err != nil {
+		return err
+	}
+
+	ss.lockAll()
+	defer ss.unlockAll()
+
+	for i, sh := range ss.shards {
+		sh.gauges = fresh.shards[i].gauges
+		sh.counters = fresh.shards[i].counters
+	}
+	ss.shards[0].touch()
+
+	return nil
+}
+
+// Version returns the change version of the storage: the sum of the
+// versions of the shards, which only grows, and the latest write time.
+func (ss *ShardedMemStorage) Version(_ context.Context) (server.Version, error) {
+	version := server.Version{Modified: ss.created}
+
+	for _, sh := range ss.shards {
+		version.Seq += sh.version.Load()
+		if nanos := sh.modified.Load(); nanos > version.Modified.UnixNano() {
+			version.Modified = time.Unix(0, nanos)
+		}
+	}
+
+	return version, nil
+}
+
+// Close closes the sharded memory storage
+func (ss *ShardedMemStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedMemStorage_UpdateMetric(t *testing.T) {
+	ctx := context.Background()
+
+	type args struct {
+		mType  string
+		mName  string
+		mValue any
+	}
+	tests := []struct {
+		name       string
+		initial    []models.Metric
+		args       args
+		wantErr    error
+		wantResult any
+	}{
+		{
+			name: "Add new gauge",
+			args: args{
+				mType:  models.GaugeType,
+				mName:  "g1",
+				mValue: 3.14,
+			},
+			wantResult: 3.14,
+		},
+		{
+			name: "Add new counter",
+			args: args{
+				mType:  models.CounterType,
+				mName:  "c1",
+				mValue: int64(10),
+			},
+			wantResult: int64(10),
+		},
+		{
+			name: "Update existing gauge",
+			initial: []models.Metric{
+				models.NewGauge("g2", 1.5),
+			},
+			args: args{
+				mType:  models.GaugeType,
+				mName:  "g2",
+				mValue: 2.5,
+			},
+			wantResult: 2.5,
+		},
+		{
+			name: "Update existing counter",
+			initial: []models.Metric{
+				models.NewCounter("c2", 5),
+			},
+			args: args{
+				mType:  models.CounterType,
+				mName:  "c2",
+				mValue: int64(7),
+			},
+			wantResult: int64(12),
+		},
+		{
+			name: "Invalid gauge value",
+			args: args{
+				mType:  models.GaugeType,
+				mName:  "g3",
+				mValue: "not a float",
+			},
+			wantErr: models.ErrInvalidValueType,
+		},
+		{
+			name: "Unknown metric type",
+			args: args{
+				mType:  "unknown",
+				mName:  "whatever",
+				mValue: 1,
+			},
+			wantErr: models.ErrInvalidMetricsType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := repository.NewShardedMemStorage(4)
+			require.NoError(t, ss.UpdateMetricList(ctx, tt.initial))
+
+			err := ss.UpdateMetric(ctx, tt.args.mType, tt.args.mName, tt.args.mValue)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			metric, err := ss.GetMetric(ctx, tt.args.mType, tt.args.mName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantResult, metric.Value())
+		})
+	}
+}
+
+func TestShardedMemStorage_GetMetric(t *testing.T) {
+	ctx := context.Background()
+	ss := repository.NewShardedMemStorage(0)
+
+	require.NoError(t, ss.UpdateMetric(ctx, models.GaugeType, "temperature", 36.6))
+
+	_, err := ss.GetMetric(ctx, models.GaugeType, "nonexistent")
+	assert.ErrorIs(t, err, models.ErrMetricsNotFound)
+
+	_, err = ss.GetMetric(ctx, models.CounterType, "temperature")
+	assert.ErrorIs(t, err, models.ErrMetricsNotFound)
+
+	_, err = ss.GetMetric(ctx, "unknown_type", "temperature")
+	assert.ErrorIs(t, err, models.ErrInvalidMetricsType)
+
+	metric, err := ss.GetMetric(ctx, models.GaugeType, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, "temperature", metric.Name())
+	assert.Equal(t, models.GaugeType, metric.Type())
+	assert.Equal(t, 36.6, metric.Value())
+}
+
+func TestShardedMemStorage_GetAllMetrics(t *testing.T) {
+	ctx := context.Background()
+	ss := repository.NewShardedMemStorage(3)
+
+	want := map[string]any{}
+	for i := 0; i < 20; i++ {
+		gName := fmt.Sprintf("gauge_%d", i)
+		cName := fmt.Sprintf("counter_%d", i)
+
+		require.NoError(t, ss.UpdateMetric(ctx, models.GaugeType, gName, float64(i)))
+		require.NoError(t, ss.UpdateMetric(ctx, models.CounterType, cName, int64(i)))
+
+		want[gName] = float64(i)
+		want[cName] = int64(i)
+	}
+
+	got, err := ss.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, got, len(want))
+
+	for _, m := range got {
+		assert.Equal(t, want[m.Name()], m.Value(), "metric %s", m.Name())
+	}
+}
+
+func TestShardedMemStorage_ConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	ss := repository.NewShardedMemStorage(8)
+
+	const (
+		writers    = 16
+		increments = 1000
+	)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				_ = ss.UpdateMetric(ctx, models.CounterType, "shared", int64(1))
+				_ = ss.UpdateMetric(ctx, models.GaugeType, fmt.Sprintf("gauge_%d", w), float64(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	metric, err := ss.GetMetric(ctx, models.CounterType, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers*increments), metric.Value())
+
+	for w := 0; w < writers; w++ {
+		metric, err := ss.GetMetric(ctx, models.GaugeType, fmt.Sprintf("gauge_%d", w))
+		require.NoError(t, err)
+		assert.Equal(t, float64(increments-1), metric.Value())
+	}
+}
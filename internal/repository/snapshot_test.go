@@ -0,0 +1,166 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+)
+
+// snapshotStorage is a storage supporting online snapshots and restores.
+type snapshotStorage interface {
+	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
+	GetAllMetrics(ctx context.Context) ([]models.Metric, error)
+	Snapshot(ctx context.Context) ([]models.Metric, error)
+	ReplaceAll(ctx context.Context, metrics []models.Metric) error
+}
+
+// replacingBackend is a write-behind backend that supports ReplaceAll.
+type replacingBackend struct {
+	*fakeBackend
+}
+
+func (rb replacingBackend) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	return rb.storage.ReplaceAll(ctx, metrics)
+}
+
+func TestStorage_SnapshotAndReplaceAll(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) snapshotStorage{
+		"memory": func(t *testing.T) snapshotStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) snapshotStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) snapshotStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+				StoreInterval:   300,
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) snapshotStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, replacingBackend{newFakeBackend(t)},
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+
+			require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+				models.NewGauge("Alloc", 1.5),
+				models.NewCounter("PollCount", 10),
+			}))
+
+			snapshot, err := storage.Snapshot(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metric{
+				models.NewGauge("Alloc", 1.5),
+				models.NewCounter("PollCount", 10),
+			}, snapshot)
+
+			// The snapshot is a copy, later updates don't change it.
+			require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{models.NewCounter("PollCount", 5)}))
+			assert.Contains(t, snapshot, models.NewCounter("PollCount", 10))
+
+			require.NoError(t, storage.ReplaceAll(ctx, []models.Metric{
+				models.NewCounter("PollCount", 3),
+				models.NewGauge("RandomValue", 0.5),
+			}))
+
+			all, err := storage.GetAllMetrics(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metric{
+				models.NewCounter("PollCount", 3),
+				models.NewGauge("RandomValue", 0.5),
+			}, all)
+
+			err = storage.ReplaceAll(ctx, []models.Metric{models.NewCounter("PollCount", 1), &badMetric{}})
+			require.Error(t, err)
+
+			all, err = storage.GetAllMetrics(ctx)
+			require.NoError(t, err)
+			assert.Len(t, all, 2, "failed replace must keep the storage unchanged")
+		})
+	}
+}
+
+func TestFileStorage_ReplaceAllSavesFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+		FileStoragePath: path,
+		StoreInterval:   300,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, fs.ReplaceAll(ctx, []models.Metric{models.NewCounter("PollCount", 7)}))
+
+	restored, err := repository.NewFileStorage(ctx, &repository.FileParams{
+		FileStoragePath: path,
+		RestoreOnStart:  true,
+		StoreInterval:   300,
+	})
+	require.NoError(t, err)
+
+	metric, err := restored.GetMetric(ctx, models.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), metric.Value())
+
+	require.NoError(t, fs.ReplaceAll(ctx, nil))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(data))
+}
+
+func TestWriteBehindStorage_ReplaceAll(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("drops pending updates", func(t *testing.T) {
+		backend := newFakeBackend(t, models.NewCounter("PollCount", 1))
+		wb, err := repository.NewWriteBehindStorage(ctx, replacingBackend{backend},
+			&repository.WriteBehindParams{FlushInterval: time.Hour})
+		require.NoError(t, err)
+
+		require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "PollCount", int64(5)))
+		require.NoError(t, wb.ReplaceAll(ctx, []models.Metric{models.NewCounter("PollCount", 2)}))
+		require.NoError(t, wb.Close())
+
+		assert.Equal(t, int64(2), backend.value(t, models.CounterType, "PollCount"))
+		assert.Equal(t, 0, backend.flushes())
+	})
+
+	t.Run("backend without replace", func(t *testing.T) {
+		wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+			&repository.WriteBehindParams{FlushInterval: time.Hour})
+		require.NoError(t, err)
+		defer func() { _ = wb.Close() }()
+
+		assert.Error(t, wb.ReplaceAll(ctx, nil))
+	})
+}
+
+// badMetric is a metric of an unknown type.
+type badMetric struct{}
+
+func (badMetric) Name() string       { return "bad" }
+func (badMetric) Type() string       { return "histogram" }
+func (badMetric) Value() any         { return 1 }
+func (badMetric) Update(_ any) error { return nil }
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+	"github.com/rs/zerolog/log"
+)
+
+// TenantFactory creates the storage of a tenant.
+type TenantFactory func(tenantID string) (server.Collector, error)
+
+// TenantStorage keeps an isolated storage for every tenant.
+//
+// The storage of the default tenant is passed to NewTenantStorage,
+// storages of other tenants are created by the factory on first use.
+type TenantStorage struct {
+	mutex   sync.RWMutex
+	base    server.Collector
+	factory TenantFactory
+	tenants map[string]server.Collector
+	limit   int
+}
+
+func NewTenantStorage(base server.Collector, factory TenantFactory) *TenantStorage {
+	return &TenantStorage{
+		base:    base,
+		factory: factory,
+		tenants: make(map[string]server.Collector),
+	}
+}
+
+// WithLimit bounds the number of tenant storages besides the default one
+// (0 = unlimited). A tenant claimed by the trusted header needs no secret,
+// so without a limit every new header value would create a storage.
+func (ts *TenantStorage) WithLimit(limit int) *TenantStorage {
+	ts.limit = limit
+	return ts
+}
+
+// ForTenant returns the storage of the tenant, creating it if needed.
+// It returns tenant.ErrTooMany if the limit of tenants is reached.
+func (ts *TenantStorage) ForTenant(tenantID string) (server.Collector, error) {
+	if tenantID == tenant.Default {
+		return ts.base, nil
+	}
+
+	ts.mutex.RLock()
+	collector, ok := ts.tenants[tenantID]
+	ts.mutex.RUnlock()
+
+	if ok {
+		return collector, nil
+	}
+
+	if err := tenant.ValidateID(tenantID); err != nil {
+		return nil, err
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if collector, ok := ts.tenants[tenantID]; ok {
+		return collector, nil
+	}
+
+	if ts.limit > 0 && len(ts.tenants) >= ts.limit {
+		log.Warn().Str("tenant", tenantID).Int("limit", ts.limit).Msg("tenant storage not created")
+		return nil, fmt.Errorf("storage of tenant %s: %w", tenantID, tenant.ErrTooMany)
+	}
+
+	collector, err := ts.factory(tenantID)
+	if err != nil {
+		log.Error().Err(err).Str("tenant", tenantID).Msg("failed to create tenant storage")
+		return nil, fmt.Errorf("failed to create storage of tenant %s: %w", tenantID, err)
+	}
+
+	ts.tenants[tenantID] = collector
+	log.Info().Str("tenant", tenantID).Msg("tenant storage created")
+
+	return collector, nil
+}
+
+// Tenants returns the sorted IDs of tenants with a created storage.
+func (ts *TenantStorage) Tenants() []string {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	ids := make([]string, 0, len(ts.tenants))
+	for id := range ts.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Close closes storages of all tenants and then the default one,
+// which may own resources shared with the others (e.g. the database pool).
+func (ts *TenantStorage) Close() error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	var errs []error
+	for id, collector := range ts.tenants {
+		if err := collector.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close storage of tenant %s: %w", id, err))
+		}
+	}
+	ts.tenants = make(map[string]server.Collector)
+
+	if err := ts.base.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,145 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+func TestTenantStorage_Isolation(t *testing.T) {
+	ctx := context.Background()
+	base := repo.NewMemStorage()
+	created := 0
+
+	ts := repo.NewTenantStorage(base, func(string) (server.Collector, error) {
+		created++
+		return repo.NewMemStorage(), nil
+	})
+
+	teamA, err := ts.ForTenant("team-a")
+	require.NoError(t, err)
+	teamB, err := ts.ForTenant("team-b")
+	require.NoError(t, err)
+
+	defaultStorage, err := ts.ForTenant(tenant.Default)
+	require.NoError(t, err)
+	assert.Same(t, base, defaultStorage)
+
+	require.NoError(t, base.UpdateMetric(ctx, models.CounterType, "PollCount", int64(1)))
+	require.NoError(t, teamA.UpdateMetric(ctx, models.CounterType, "PollCount", int64(10)))
+	require.NoError(t, teamB.UpdateMetric(ctx, models.GaugeType, "Alloc", 2.5))
+
+	metric, err := teamA.GetMetric(ctx, models.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), metric.Value())
+
+	_, err = teamB.GetMetric(ctx, models.CounterType, "PollCount")
+	assert.Error(t, err)
+
+	all, err := base.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	again, err := ts.ForTenant("team-a")
+	require.NoError(t, err)
+	assert.Same(t, teamA, again)
+	assert.Equal(t, 2, created)
+	assert.Equal(t, []string{"team-a", "team-b"}, ts.Tenants())
+
+	require.NoError(t, ts.Close())
+}
+
+func TestTenantStorage_Errors(t *testing.T) {
+	errFactory := errors.New("factory failed")
+
+	ts := repo.NewTenantStorage(repo.NewMemStorage(), func(string) (server.Collector, error) {
+		return nil, errFactory
+	})
+
+	_, err := ts.ForTenant("../etc")
+	assert.ErrorIs(t, err, tenant.ErrInvalidID)
+
+	_, err = ts.ForTenant("team-a")
+	assert.ErrorIs(t, err, errFactory)
+	assert.Empty(t, ts.Tenants())
+}
+
+func TestTenantStorage_Limit(t *testing.T) {
+	ts := repo.NewTenantStorage(repo.NewMemStorage(), func(string) (server.Collector, error) {
+		return repo.NewMemStorage(), nil
+	}).WithLimit(2)
+
+	_, err := ts.ForTenant("team-a")
+	require.NoError(t, err)
+	_, err = ts.ForTenant("team-b")
+	require.NoError(t, err)
+
+	_, err = ts.ForTenant("team-c")
+	assert.ErrorIs(t, err, tenant.ErrTooMany)
+
+	// Existing tenants and the default one are still served.
+	_, err = ts.ForTenant("team-a")
+	assert.NoError(t, err)
+	_, err = ts.ForTenant(tenant.Default)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, ts.Tenants())
+}
+
+func TestDatabase_ForTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	ctx := context.Background()
+	root := &repo.Database{DB: db}
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "collector_team-a"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "collector_team-a_version"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "collector_team-a_version"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	teamA, err := root.ForTenant(ctx, "team-a")
+	require.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ID", "MType", "Delta", "Value" FROM "collector_team-a"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "MType", "Delta", "Value"}).
+			AddRow("PollCount", models.CounterType, 5, nil))
+
+	metrics, err := teamA.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metric{models.NewCounter("PollCount", 5)}, metrics)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "collector_team-a"`)).
+		WithArgs("Alloc", models.GaugeType, nil, 1.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "collector_team-a_version"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, teamA.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.5))
+
+	// The tenant database shares the pool, closing it must not close the pool.
+	require.NoError(t, teamA.Close())
+	mock.ExpectPing()
+	require.NoError(t, root.Ping(ctx))
+
+	_, err = root.ForTenant(ctx, `x"; DROP TABLE collector; --`)
+	assert.ErrorIs(t, err, tenant.ErrInvalidID)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
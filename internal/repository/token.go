@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/files"
+)
+
+// tokenTable is the table of the API tokens, shared by all tenants.
+const tokenTable = "api_tokens"
+
+// ErrTokensUnsupported is returned by storages whose backend can't keep
+// API tokens.
+var ErrTokensUnsupported = errors.New("storage doesn't support api tokens")
+
+// MemTokenStore keeps API tokens in memory.
+type MemTokenStore struct {
+	mutex  sync.RWMutex
+	byID   map[string]apitoken.Token
+	byHash map[string]string
+}
+
+// NewMemTokenStore creates an empty memory store of API tokens.
+func NewMemTokenStore() *MemTokenStore {
+	return &MemTokenStore{
+		byID:   make(map[string]apitoken.Token),
+		byHash: make(map[string]string),
+	}
+}
+
+func (ts *MemTokenStore) CreateToken(_ context.Context, t apitoken.Token) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if _, ok := ts.byID[t.ID]; ok {
+		return fmt.Errorf("duplicate token id %s", t.ID)
+	}
+	if _, ok := ts.byHash[t.Hash]; ok {
+		return fmt.Errorf("duplicate token hash")
+	}
+
+	ts.byID[t.ID] = t
+	ts.byHash[t.Hash] = t.ID
+
+	return nil
+}
+
+func (ts *MemTokenStore) TokenByHash(_ context.Context, hash string) (apitoken.Token, error) {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	id, ok := ts.byHash[hash]
+	if !ok {
+		return apitoken.Token{}, apitoken.ErrNotFound
+	}
+
+	return ts.byID[id], nil
+}
+
+// ListTokens returns the tokens, the oldest first.
+func (ts *MemTokenStore) ListTokens(_ context.Context) ([]apitoken.Token, error) {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	tokens := make([]apitoken.Token, 0, len(ts.byID))
+	for _, t := range ts.byID {
+		tokens = append(tokens, t)
+	}
+	sortTokens(tokens)
+
+	return tokens, nil
+}
+
+func (ts *MemTokenStore) RevokeToken(_ context.Context, id string) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	t, ok := ts.byID[id]
+	if !ok {
+		return apitoken.ErrNotFound
+	}
+
+	delete(ts.byID, id)
+	delete(ts.byHash, t.Hash)
+
+	return nil
+}
+
+// sortTokens sorts tokens by creation time and then by ID.
+func sortTokens(tokens []apitoken.Token) {
+	slices.SortFunc(tokens, func(a, b apitoken.Token) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
+
+// fileTokens keeps the API tokens of a FileStorage in memory and saves
+// them to a file next to the metrics file on every change, whatever the
+// store interval is.
+type fileTokens struct {
+	*MemTokenStore
+	path string
+	// saveMutex orders the saves of concurrent changes.
+	saveMutex sync.Mutex
+}
+
+// TokenFilePath returns the file of the API tokens of the metrics file path.
+func TokenFilePath(path string) string {
+	return path + ".tokens"
+}
+
+// newFileTokens loads the tokens of the file path, if it exists.
+func newFileTokens(path string) (*fileTokens, error) {
+	ft := &fileTokens{MemTokenStore: NewMemTokenStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ft, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+
+	var tokens []apitoken.Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens %s: %w", path, err)
+	}
+
+	for _, t := range tokens {
+		if err := ft.MemTokenStore.CreateToken(context.Background(), t); err != nil {
+			return nil, fmt.Errorf("failed to load tokens %s: %w", path, err)
+		}
+	}
+
+	return ft, nil
+}
+
+func (ft *fileTokens) CreateToken(ctx context.Context, t apitoken.Token) error {
+	ft.saveMutex.Lock()
+	defer ft.saveMutex.Unlock()
+
+	if err := ft.MemTokenStore.CreateToken(ctx, t); err != nil {
+		return err
+	}
+
+	if err := ft.save(ctx); err != nil {
+		// The token isn't kept if it can't be saved.
+		_ = ft.MemTokenStore.RevokeToken(ctx, t.ID)
+		return err
+	}
+
+	return nil
+}
+
+func (ft *fileTokens) RevokeToken(ctx context.Context, id string) error {
+	ft.saveMutex.Lock()
+	defer ft.saveMutex.Unlock()
+
+	if err := ft.MemTokenStore.RevokeToken(ctx, id); err != nil {
+		return err
+	}
+
+	return ft.save(ctx)
+}
+
+// save writes the tokens to the file; the caller holds saveMutex.
+func (ft *fileTokens) save(ctx context.Context) error {
+	tokens, err := ft.ListTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tokens: %w", err)
+	}
+
+	if err := files.WriteFile(ft.path, data); err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+
+	return nil
+}
+
+// createTokenTable creates the table of the API tokens.
+func createTokenTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+tokenTable+" ("+
+			"\"ID\" VARCHAR(64) PRIMARY KEY,"+
+			"\"Name\" TEXT NOT NULL,"+
+			"\"Hash\" CHAR(64) NOT NULL UNIQUE,"+
+			"\"Scopes\" TEXT NOT NULL,"+
+			"\"Prefix\" TEXT NOT NULL,"+
+			"\"Created\" TIMESTAMPTZ NOT NULL"+
+			");")
+	if err != nil {
+		return fmt.Errorf("failed create token table for database %w", err)
+	}
+
+	return nil
+}
+
+// CreateToken stores t; the scopes are stored comma-separated.
+func (db *Database) CreateToken(ctx context.Context, t apitoken.Token) error {
+	scopes := make([]string, len(t.Scopes))
+	for i, scope := range t.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	query, args, err := sq.Insert(tokenTable).
+		Columns(`"ID"`, `"Name"`, `"Hash"`, `"Scopes"`, `"Prefix"`, `"Created"`).
+		Values(t.ID, t.Name, t.Hash, strings.Join(scopes, ","), t.Prefix, t.Created).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if _, err := db.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert token: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) TokenByHash(ctx context.Context, hash string) (apitoken.Token, error) {
+	tokens, err := db.selectTokens(ctx, sq.Eq{`"Hash"`: hash})
+	if err != nil {
+		return apitoken.Token{}, err
+	}
+	if len(tokens) == 0 {
+		return apitoken.Token{}, apitoken.ErrNotFound
+	}
+
+	return tokens[0], nil
+}
+
+// ListTokens returns the tokens, the oldest first.
+func (db *Database) ListTokens(ctx context.Context) ([]apitoken.Token, error) {
+	return db.selectTokens(ctx, nil)
+}
+
+func (db *Database) RevokeToken(ctx context.Context, id string) error {
+	query, args, err := sq.Delete(tokenTable).
+		Where(sq.Eq{`"ID"`: id}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	if n == 0 {
+		return apitoken.ErrNotFound
+	}
+
+	return nil
+}
+
+// selectTokens returns the tokens matching where, all if it is nil.
+func (db *Database) selectTokens(ctx context.Context, where sq.Sqlizer) ([]apitoken.Token, error) {
+	builder := sq.Select(`"ID"`, `"Name"`, `"Hash"`, `"Scopes"`, `"Prefix"`, `"Created"`).
+		From(tokenTable).
+		OrderBy(`"Created"`, `"ID"`).
+		PlaceholderFormat(sq.Dollar)
+	if where != nil {
+		builder = builder.Where(where)
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tokens: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var tokens []apitoken.Token
+	for rows.Next() {
+		var (
+			t       apitoken.Token
+			scopes  string
+			created time.Time
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &t.Prefix, &created); err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+
+		for _, scope := range strings.Split(scopes, ",") {
+			t.Scopes = append(t.Scopes, apitoken.Scope(scope))
+		}
+		t.Created = created.UTC()
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// tokenStore returns the token store of the write-behind backend.
+func (wb *WriteBehindStorage) tokenStore() (token.Store, error) {
+	store, ok := wb.backend.(token.Store)
+	if !ok {
+		return nil, ErrTokensUnsupported
+	}
+
+	return store, nil
+}
+
+// CreateToken stores t in the backend right away: tokens are not cached.
+func (wb *WriteBehindStorage) CreateToken(ctx context.Context, t apitoken.Token) error {
+	store, err := wb.tokenStore()
+	if err != nil {
+		return err
+	}
+
+	return store.CreateToken(ctx, t)
+}
+
+func (wb *WriteBehindStorage) TokenByHash(ctx context.Context, hash string) (apitoken.Token, error) {
+	store, err := wb.tokenStore()
+	if err != nil {
+		return apitoken.Token{}, err
+	}
+
+	return store.TokenByHash(ctx, hash)
+}
+
+func (wb *WriteBehindStorage) ListTokens(ctx context.Context) ([]apitoken.Token, error) {
+	store, err := wb.tokenStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.ListTokens(ctx)
+}
+
+func (wb *WriteBehindStorage) RevokeToken(ctx context.Context, id string) error {
+	store, err := wb.tokenStore()
+	if err != nil {
+		return err
+	}
+
+	return store.RevokeToken(ctx, id)
+}
@@ -0,0 +1,186 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/token"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+)
+
+// tokenBackend is a write-behind backend that keeps API tokens.
+type tokenBackend struct {
+	*fakeBackend
+	*repository.MemTokenStore
+}
+
+func newToken(id string, created time.Time) apitoken.Token {
+	return apitoken.Token{
+		ID:      id,
+		Name:    "agent " + id,
+		Hash:    apitoken.Hash("mst_" + id),
+		Scopes:  []apitoken.Scope{apitoken.ScopeWrite},
+		Prefix:  "host" + id + ".",
+		Created: created,
+	}
+}
+
+func TestStorage_Tokens(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+
+	storages := map[string]func(t *testing.T) token.Store{
+		"memory": func(t *testing.T) token.Store {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) token.Store {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) token.Store {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+				StoreInterval:   300,
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) token.Store {
+			wb, err := repository.NewWriteBehindStorage(ctx,
+				tokenBackend{newFakeBackend(t), repository.NewMemTokenStore()},
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			store := newStorage(t)
+
+			require.NoError(t, store.CreateToken(ctx, newToken("2", created.Add(time.Second))))
+			require.NoError(t, store.CreateToken(ctx, newToken("1", created)))
+			assert.Error(t, store.CreateToken(ctx, newToken("1", created)), "duplicate")
+
+			found, err := store.TokenByHash(ctx, apitoken.Hash("mst_1"))
+			require.NoError(t, err)
+			assert.Equal(t, newToken("1", created), found)
+
+			_, err = store.TokenByHash(ctx, apitoken.Hash("mst_3"))
+			assert.ErrorIs(t, err, apitoken.ErrNotFound)
+
+			tokens, err := store.ListTokens(ctx)
+			require.NoError(t, err)
+			require.Len(t, tokens, 2)
+			assert.Equal(t, "1", tokens[0].ID, "oldest first")
+			assert.Equal(t, "2", tokens[1].ID)
+
+			require.NoError(t, store.RevokeToken(ctx, "1"))
+			assert.ErrorIs(t, store.RevokeToken(ctx, "1"), apitoken.ErrNotFound)
+
+			_, err = store.TokenByHash(ctx, apitoken.Hash("mst_1"))
+			assert.ErrorIs(t, err, apitoken.ErrNotFound)
+		})
+	}
+}
+
+func TestFileStorage_TokensSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	params := &repository.FileParams{
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+		StoreInterval:   300,
+	}
+
+	fs, err := repository.NewFileStorage(ctx, params)
+	require.NoError(t, err)
+	require.NoError(t, fs.CreateToken(ctx, newToken("1", time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC))))
+	require.NoError(t, fs.CreateToken(ctx, newToken("2", time.Date(2025, 7, 29, 12, 0, 1, 0, time.UTC))))
+	require.NoError(t, fs.RevokeToken(ctx, "2"))
+	assert.FileExists(t, repository.TokenFilePath(params.FileStoragePath))
+
+	// Tokens are saved on every change, not on the store interval.
+	restarted, err := repository.NewFileStorage(ctx, params)
+	require.NoError(t, err)
+
+	tokens, err := restarted.ListTokens(ctx)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, newToken("1", time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)), tokens[0])
+}
+
+func TestWriteBehindStorage_TokensUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	wb, err := repository.NewWriteBehindStorage(ctx, newFakeBackend(t),
+		&repository.WriteBehindParams{FlushInterval: time.Hour})
+	require.NoError(t, err)
+	defer func() {
+		_ = wb.Close()
+	}()
+
+	assert.ErrorIs(t, wb.CreateToken(ctx, newToken("1", time.Now())), repository.ErrTokensUnsupported)
+	_, err = wb.ListTokens(ctx)
+	assert.ErrorIs(t, err, repository.ErrTokensUnsupported)
+}
+
+func TestDatabase_Tokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+
+	repo := &repository.Database{
+		DB: db,
+	}
+	ctx := context.Background()
+	created := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	tok := newToken("1", created)
+	tok.Scopes = []apitoken.Scope{apitoken.ScopeRead, apitoken.ScopeWrite}
+
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO api_tokens ("ID","Name","Hash","Scopes","Prefix","Created") VALUES ($1,$2,$3,$4,$5,$6)`)).
+		WithArgs(tok.ID, tok.Name, tok.Hash, "metrics:read,metrics:write", tok.Prefix, created).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, repo.CreateToken(ctx, tok))
+
+	columns := []string{"ID", "Name", "Hash", "Scopes", "Prefix", "Created"}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ID", "Name", "Hash", "Scopes", "Prefix", "Created" FROM api_tokens WHERE "Hash" = $1 ORDER BY "Created", "ID"`)).
+		WithArgs(tok.Hash).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(tok.ID, tok.Name, tok.Hash, "metrics:read,metrics:write", tok.Prefix, created))
+	found, err := repo.TokenByHash(ctx, tok.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, tok, found)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ID", "Name", "Hash", "Scopes", "Prefix", "Created" FROM api_tokens WHERE "Hash" = $1`)).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows(columns))
+	_, err = repo.TokenByHash(ctx, "missing")
+	assert.ErrorIs(t, err, apitoken.ErrNotFound)
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM api_tokens WHERE "ID" = $1`)).
+		WithArgs(tok.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, repo.RevokeToken(ctx, tok.ID))
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM api_tokens WHERE "ID" = $1`)).
+		WithArgs(tok.ID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, repo.RevokeToken(ctx, tok.ID), apitoken.ErrNotFound)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "ID", "Name", "Hash", "Scopes", "Prefix", "Created" FROM api_tokens ORDER BY "Created", "ID"`)).
+		WillReturnError(sql.ErrConnDone)
+	_, err = repo.ListTokens(ctx)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
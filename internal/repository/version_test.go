@@ -0,0 +1,89 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// versionedStorage is a storage keeping a change version.
+type versionedStorage interface {
+	server.MetricVersioner
+	UpdateMetric(ctx context.Context, mType, mName string, mValue any) error
+	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
+	GetAllMetrics(ctx context.Context) ([]models.Metric, error)
+	ReplaceAll(ctx context.Context, metrics []models.Metric) error
+}
+
+func TestStorage_Version(t *testing.T) {
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) versionedStorage{
+		"memory": func(t *testing.T) versionedStorage {
+			return repository.NewMemStorage()
+		},
+		"sharded": func(t *testing.T) versionedStorage {
+			return repository.NewShardedMemStorage(4)
+		},
+		"file": func(t *testing.T) versionedStorage {
+			fs, err := repository.NewFileStorage(ctx, &repository.FileParams{
+				FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+				StoreInterval:   300,
+			})
+			require.NoError(t, err)
+			return fs
+		},
+		"write-behind": func(t *testing.T) versionedStorage {
+			wb, err := repository.NewWriteBehindStorage(ctx, replacingBackend{newFakeBackend(t)},
+				&repository.WriteBehindParams{FlushInterval: time.Hour})
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = wb.Close() })
+			return wb
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+
+			last, err := storage.Version(ctx)
+			require.NoError(t, err)
+			assert.False(t, last.Modified.IsZero())
+
+			// advanced checks that the version grew since the last check.
+			advanced := func(msg string) {
+				t.Helper()
+
+				version, err := storage.Version(ctx)
+				require.NoError(t, err)
+				assert.Greater(t, version.Seq, last.Seq, msg)
+				assert.False(t, version.Modified.Before(last.Modified), msg)
+				last = version
+			}
+
+			require.NoError(t, storage.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.5))
+			advanced("UpdateMetric")
+
+			require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{models.NewCounter("PollCount", 1)}))
+			advanced("UpdateMetricList")
+
+			require.NoError(t, storage.ReplaceAll(ctx, nil))
+			advanced("ReplaceAll")
+
+			_, err = storage.GetAllMetrics(ctx)
+			require.NoError(t, err)
+
+			version, err := storage.Version(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, last, version, "reads must keep the version")
+		})
+	}
+}
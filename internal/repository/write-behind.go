@@ -0,0 +1,375 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults used by NewWriteBehindStorage for zero WriteBehindParams fields.
+const (
+	DefaultWriteBehindInterval  = 5 * time.Second
+	DefaultWriteBehindBatchSize = 1000
+	defaultWriteBehindTimeout   = 10 * time.Second
+)
+
+// WriteBehindBackend is the slow storage behind the WriteBehindStorage cache.
+//
+// SetMetricList stores the values of the cache, counters are set rather
+// than added to (see server.MetricSetter). If it returns an error, e.g. a
+// timeout after the commit, the batch is flushed again later, which
+// changes nothing if it was stored.
+type WriteBehindBackend interface {
+	GetAllMetrics(ctx context.Context) ([]models.Metric, error)
+	SetMetricList(ctx context.Context, metrics []models.Metric) error
+	Close() error
+}
+
+// WriteBehindParams configures the flushing of the WriteBehindStorage.
+type WriteBehindParams struct {
+	// FlushInterval is the period between flushes of pending updates.
+	FlushInterval time.Duration
+	// BatchSize is the number of pending metrics that triggers an early flush.
+	BatchSize int
+	// FlushTimeout limits a single flush to the backend.
+	FlushTimeout time.Duration
+}
+
+// pendingKey identifies a pending metric update.
+type pendingKey struct {
+	mType string
+	mName string
+}
+
+// WriteBehindStorage is a tiered storage: an in-memory cache in front of
+// a slower backend, such as the Database.
+//
+// All metrics are loaded from the backend when the storage is created, and
+// reads are served from memory only. Writes are applied to the cache
+// immediately and the written metrics are marked as pending. A flush
+// writes the cache values of the pending metrics to the backend in one
+// batch every FlushInterval, or earlier once BatchSize metrics are pending,
+// and once more on shutdown.
+//
+// The flushed counters are their totals, not the deltas since the last
+// flush, so the cache must be the only writer of the backend. In return a
+// flush is idempotent: if it fails, even after the backend has committed
+// it, the metrics are marked as pending again and flushed with their
+// latest values, never added twice.
+type WriteBehindStorage struct {
+	backend WriteBehindBackend
+	cache   *ShardedMemStorage
+	params  WriteBehindParams
+
+	pendingMutex sync.Mutex
+	pending      map[pendingKey]struct{}
+
+	// flushMutex serializes flushes, so batches reach the backend in order.
+	flushMutex sync.Mutex
+
+	flushCh   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewWriteBehindStorage loads all metrics from the backend into memory and
+// starts flushing pending updates in the background until ctx is done or the
+// storage is closed.
+func NewWriteBehindStorage(ctx context.Context, backend WriteBehindBackend, params *WriteBehindParams) (*WriteBehindStorage, error) {
+	wb := &WriteBehindStorage{
+		backend: backend,
+		cache:   NewShardedMemStorage(DefaultShardCount),
+		params:  *params,
+		pending: make(map[pendingKey]struct{}),
+		flushCh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	if wb.params.FlushInterval <= 0 {
+		wb.params.FlushInterval = DefaultWriteBehindInterval
+	}
+	if wb.params.BatchSize <= 0 {
+		wb.params.BatchSize = DefaultWriteBehindBatchSize
+	}
+	if wb.params.FlushTimeout <= 0 {
+		wb.params.FlushTimeout = defaultWriteBehindTimeout
+	}
+
+	metrics, err := backend.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metrics into cache: %w", err)
+	}
+
+	if err := wb.cache.UpdateMetricList(ctx, metrics); err != nil {
+		return nil, fmt.Errorf("failed to fill cache: %w", err)
+	}
+
+	log.Info().Int("metrics", len(metrics)).Msg("write-behind cache loaded")
+
+	wb.wg.Add(1)
+	go wb.run(ctx)
+
+	return wb, nil
+}
+
+// run flushes pending updates on every tick, on a batch size signal,
+// and one last time when the storage is stopped.
+func (wb *WriteBehindStorage) run(ctx context.Context) {
+	defer wb.wg.Done()
+
+	ticker := time.NewTicker(wb.params.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wb.flushWithTimeout(ctx)
+		case <-wb.flushCh:
+			wb.flushWithTimeout(ctx)
+		case <-ctx.Done():
+			log.Info().Msg("Shutting down write-behind cache, flushing metrics")
+			wb.flushWithTimeout(context.WithoutCancel(ctx))
+			return
+		case <-wb.done:
+			wb.flushWithTimeout(context.WithoutCancel(ctx))
+			return
+		}
+	}
+}
+
+func (wb *WriteBehindStorage) flushWithTimeout(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, wb.params.FlushTimeout)
+	defer cancel()
+
+	if err := wb.Flush(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush write-behind cache")
+	}
+}
+
+// Flush writes all pending updates to the backend as one batch.
+//
+// On failure the batch is merged back into the pending updates and
+// the error is returned; the next flush retries it.
+func (wb *WriteBehindStorage) Flush(ctx context.Context) error {
+	wb.flushMutex.Lock()
+	defer wb.flushMutex.Unlock()
+
+	wb.pendingMutex.Lock()
+	if len(wb.pending) == 0 {
+		wb.pendingMutex.Unlock()
+		return nil
+	}
+	batch := wb.pending
+	wb.pending = make(map[pendingKey]struct{}, len(batch))
+	wb.pendingMutex.Unlock()
+
+	keys := make([]server.MetricKey, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, server.MetricKey{Type: key.mType, Name: key.mName})
+	}
+
+	// The values are read after the batch is taken, so an update made
+	// meanwhile is either flushed now or marked for the next flush.
+	metrics, err := wb.cache.GetMetrics(ctx, keys)
+	if err != nil {
+		wb.requeue(batch)
+		return fmt.Errorf("failed to read %d pending metrics: %w", len(keys), err)
+	}
+
+	if err := wb.backend.SetMetricList(ctx, metrics); err != nil {
+		wb.requeue(batch)
+		return fmt.Errorf("failed to flush %d metrics: %w", len(metrics), err)
+	}
+
+	log.Debug().Int("metrics", len(metrics)).Msg("write-behind cache flushed")
+
+	return nil
+}
+
+// requeue marks the metrics of a failed batch as pending again.
+func (wb *WriteBehindStorage) requeue(batch map[pendingKey]struct{}) {
+	wb.pendingMutex.Lock()
+	defer wb.pendingMutex.Unlock()
+
+	for key := range batch {
+		wb.pending[key] = struct{}{}
+	}
+}
+
+// addPending marks metrics already written to the cache as pending.
+func (wb *WriteBehindStorage) addPending(keys ...pendingKey) {
+	wb.pendingMutex.Lock()
+	for _, key := range keys {
+		wb.pending[key] = struct{}{}
+	}
+	full := len(wb.pending) >= wb.params.BatchSize
+	wb.pendingMutex.Unlock()
+
+	if full {
+		select {
+		case wb.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// UpdateMetric updates a metric in the cache and schedules it for the backend.
+func (wb *WriteBehindStorage) UpdateMetric(ctx context.Context, mType, mName string, mValue any) error {
+	if err := wb.cache.UpdateMetric(ctx, mType, mName, mValue); err != nil {
+		return err
+	}
+
+	wb.addPending(pendingKey{mType: mType, mName: mName})
+
+	return nil
+}
+
+// UpdateMetricList updates a list of metrics in the cache and schedules them for the backend.
+func (wb *WriteBehindStorage) UpdateMetricList(ctx context.Context, metrics []models.Metric) error {
+	for _, metric := range metrics {
+		if err := wb.UpdateMetric(ctx, metric.Type(), metric.Name(), metric.Value()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyMetricList updates a list of metrics in the cache like
+// UpdateMetricList, schedules them for the backend and returns their
+// updated values (see server.MetricApplier).
+func (wb *WriteBehindStorage) ApplyMetricList(ctx context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	stored, err := wb.cache.ApplyMetricList(ctx, metrics)
+
+	// As in SetMetricList, the metrics before an invalid one are stored.
+	keys := make([]pendingKey, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, pendingKey{mType: metric.Type(), mName: metric.Name()})
+	}
+	wb.addPending(keys...)
+
+	return stored, err
+}
+
+// SetMetricList stores the metrics with their values in the cache,
+// counters are not added to (see server.MetricSetter), and schedules them
+// for the backend.
+func (wb *WriteBehindStorage) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	err := wb.cache.SetMetricList(ctx, metrics)
+
+	// The metrics before an invalid one are stored, and marking the ones
+	// not stored is harmless: a flush only writes the cached ones.
+	keys := make([]pendingKey, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, pendingKey{mType: metric.Type(), mName: metric.Name()})
+	}
+	wb.addPending(keys...)
+
+	return err
+}
+
+// GetMetric get a metric from the cache
+func (wb *WriteBehindStorage) GetMetric(ctx context.Context, mType, mName string) (models.Metric, error) {
+	return wb.cache.GetMetric(ctx, mType, mName)
+}
+
+// GetMetrics get the metrics with the given keys from the cache
+func (wb *WriteBehindStorage) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	return wb.cache.GetMetrics(ctx, keys)
+}
+
+// GetAllMetrics get all metrics from the cache
+func (wb *WriteBehindStorage) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
+	return wb.cache.GetAllMetrics(ctx)
+}
+
+// RangeMetrics calls fn for every metric of the cache, see
+// ShardedMemStorage.RangeMetrics.
+func (wb *WriteBehindStorage) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	return wb.cache.RangeMetrics(ctx, fn)
+}
+
+// Snapshot returns all metrics of the cache at a single point in time.
+func (wb *WriteBehindStorage) Snapshot(ctx context.Context) ([]models.Metric, error) {
+	return wb.cache.Snapshot(ctx)
+}
+
+// Version returns the change version of the cache, which serves the reads.
+func (wb *WriteBehindStorage) Version(ctx context.Context) (server.Version, error) {
+	return wb.cache.Version(ctx)
+}
+
+// ReplaceAll replaces all metrics of the backend and the cache with the
+// given ones; pending updates are dropped. The backend must support it.
+//
+// Updates written concurrently with the replacement may be lost.
+func (wb *WriteBehindStorage) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	replacer, ok := wb.backend.(interface {
+		ReplaceAll(ctx context.Context, metrics []models.Metric) error
+	})
+	if !ok {
+		return errors.New("write-behind backend doesn't support replacing metrics")
+	}
+
+	wb.flushMutex.Lock()
+	defer wb.flushMutex.Unlock()
+
+	if err := replacer.ReplaceAll(ctx, metrics); err != nil {
+		return err
+	}
+
+	wb.pendingMutex.Lock()
+	defer wb.pendingMutex.Unlock()
+
+	wb.pending = make(map[pendingKey]struct{})
+
+	return wb.cache.ReplaceAll(ctx, metrics)
+}
+
+// Ping checks the backend if it supports pinging.
+func (wb *WriteBehindStorage) Ping(ctx context.Context) error {
+	pinger, ok := wb.backend.(interface {
+		Ping(ctx context.Context) error
+	})
+	if !ok {
+		return nil
+	}
+
+	return pinger.Ping(ctx)
+}
+
+// Close stops the background flushing, flushes the pending updates
+// and closes the backend.
+//
+// The updates written after the background flushing has stopped, e.g. by
+// the listeners draining on shutdown, are flushed too; Close fails only if
+// that last flush does.
+func (wb *WriteBehindStorage) Close() error {
+	wb.closeOnce.Do(func() {
+		close(wb.done)
+	})
+	wb.wg.Wait()
+
+	var errs []error
+
+	ctx, cancel := context.WithTimeout(context.Background(), wb.params.FlushTimeout)
+	defer cancel()
+
+	if err := wb.Flush(ctx); err != nil {
+		errs = append(errs, err)
+		log.Error().Err(err).Msg("write-behind cache closed with unflushed metrics")
+	}
+
+	if err := wb.backend.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,292 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBackendDown = errors.New("backend is down")
+
+// fakeBackend is a WriteBehindBackend that records flushed batches
+// and can be switched to fail.
+type fakeBackend struct {
+	mutex   sync.Mutex
+	storage *repository.MemStorage
+	batches [][]models.Metric
+	fail    bool
+	// lost stores the batches but fails, like a timeout after the commit.
+	lost   bool
+	closed bool
+}
+
+func newFakeBackend(t *testing.T, initial ...models.Metric) *fakeBackend {
+	fb := &fakeBackend{storage: repository.NewMemStorage()}
+	require.NoError(t, fb.storage.UpdateMetricList(context.Background(), initial))
+
+	return fb
+}
+
+func (fb *fakeBackend) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
+	return fb.storage.GetAllMetrics(ctx)
+}
+
+func (fb *fakeBackend) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	if fb.fail {
+		return errBackendDown
+	}
+
+	fb.batches = append(fb.batches, metrics)
+	if err := fb.storage.SetMetricList(ctx, metrics); err != nil {
+		return err
+	}
+
+	if fb.lost {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (fb *fakeBackend) Close() error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	fb.closed = true
+	return nil
+}
+
+func (fb *fakeBackend) setFail(fail bool) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	fb.fail = fail
+}
+
+func (fb *fakeBackend) setLost(lost bool) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	fb.lost = lost
+}
+
+func (fb *fakeBackend) flushes() int {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+
+	return len(fb.batches)
+}
+
+func (fb *fakeBackend) value(t *testing.T, mType, mName string) any {
+	metric, err := fb.storage.GetMetric(context.Background(), mType, mName)
+	require.NoError(t, err)
+
+	return metric.Value()
+}
+
+func TestWriteBehindStorage_LoadsAndServesFromCache(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t,
+		models.NewCounter("requests", 10),
+		models.NewGauge("temperature", 36.6),
+	)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(5)))
+	require.NoError(t, wb.UpdateMetric(ctx, models.GaugeType, "temperature", 37.2))
+
+	metric, err := wb.GetMetric(ctx, models.CounterType, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), metric.Value())
+
+	metric, err = wb.GetMetric(ctx, models.GaugeType, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 37.2, metric.Value())
+
+	// Nothing reaches the backend before a flush.
+	assert.Equal(t, 0, backend.flushes())
+	assert.Equal(t, int64(10), backend.value(t, models.CounterType, "requests"))
+
+	require.NoError(t, wb.Close())
+	assert.True(t, backend.closed)
+}
+
+func TestWriteBehindStorage_FlushCoalesces(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = wb.Close()
+	}()
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(i)))
+		require.NoError(t, wb.UpdateMetric(ctx, models.GaugeType, "temperature", float64(i)))
+	}
+
+	require.NoError(t, wb.Flush(ctx))
+
+	require.Equal(t, 1, backend.flushes())
+	assert.Len(t, backend.batches[0], 2)
+	assert.Equal(t, int64(6), backend.value(t, models.CounterType, "requests"))
+	assert.Equal(t, 3.0, backend.value(t, models.GaugeType, "temperature"))
+
+	// An empty flush doesn't touch the backend.
+	require.NoError(t, wb.Flush(ctx))
+	assert.Equal(t, 1, backend.flushes())
+}
+
+func TestWriteBehindStorage_FailedFlushIsRequeued(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = wb.Close()
+	}()
+
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(2)))
+	require.NoError(t, wb.UpdateMetric(ctx, models.GaugeType, "temperature", 1.0))
+
+	backend.setFail(true)
+	require.ErrorIs(t, wb.Flush(ctx), errBackendDown)
+
+	// Updates after the failed flush are merged with the re-queued batch.
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(3)))
+	require.NoError(t, wb.UpdateMetric(ctx, models.GaugeType, "temperature", 2.0))
+
+	metric, err := wb.GetMetric(ctx, models.CounterType, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), metric.Value())
+
+	backend.setFail(false)
+	require.NoError(t, wb.Flush(ctx))
+
+	assert.Equal(t, int64(5), backend.value(t, models.CounterType, "requests"))
+	assert.Equal(t, 2.0, backend.value(t, models.GaugeType, "temperature"))
+}
+
+func TestWriteBehindStorage_BatchSizeTriggersFlush(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+		BatchSize:     2,
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = wb.Close()
+	}()
+
+	require.NoError(t, wb.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("g1", 1),
+		models.NewGauge("g2", 2),
+	}))
+
+	assert.Eventually(t, func() bool {
+		return backend.flushes() == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWriteBehindStorage_FlushOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(7)))
+
+	cancel()
+	require.NoError(t, wb.Close())
+
+	assert.Equal(t, int64(7), backend.value(t, models.CounterType, "requests"))
+}
+
+func TestWriteBehindStorage_CloseReportsUnflushed(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, wb.UpdateMetric(ctx, models.GaugeType, "temperature", 1.0))
+
+	backend.setFail(true)
+	require.Error(t, wb.Close())
+	assert.True(t, backend.closed)
+}
+
+func TestWriteBehindStorage_CloseFlushesLateUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := newFakeBackend(t)
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	// The background flushing stops with ctx, the listeners still drain.
+	cancel()
+	require.NoError(t, wb.UpdateMetric(context.Background(), models.CounterType, "requests", int64(3)))
+	require.NoError(t, wb.UpdateMetric(context.Background(), models.GaugeType, "temperature", 36.6))
+
+	require.NoError(t, wb.Close())
+
+	assert.Equal(t, int64(3), backend.value(t, models.CounterType, "requests"))
+	assert.Equal(t, 36.6, backend.value(t, models.GaugeType, "temperature"))
+	assert.True(t, backend.closed)
+}
+
+func TestWriteBehindStorage_FlushRetriedAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	backend := newFakeBackend(t, models.NewCounter("requests", 10))
+
+	wb, err := repository.NewWriteBehindStorage(ctx, backend, &repository.WriteBehindParams{
+		FlushInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = wb.Close()
+	}()
+
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(3)))
+
+	// The backend stores the batch, but the flush fails as if it timed out
+	// waiting for the commit.
+	backend.setLost(true)
+	require.ErrorIs(t, wb.Flush(ctx), context.DeadlineExceeded)
+	assert.Equal(t, int64(13), backend.value(t, models.CounterType, "requests"))
+
+	backend.setLost(false)
+	require.NoError(t, wb.UpdateMetric(ctx, models.CounterType, "requests", int64(2)))
+	require.NoError(t, wb.Flush(ctx))
+
+	// The retried flush sets the total, the delta isn't added twice.
+	assert.Equal(t, int64(15), backend.value(t, models.CounterType, "requests"))
+}
@@ -12,61 +12,250 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	srvCfg "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/config/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/dashboard"
 	rest "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/handlers/server/REST"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	log "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/logger"
 )
 
 // NewRouter creates and returns a new HTTP router configured with all routes and middleware.
 //
 // Middleware:
 // - WithLogging: Logs the request and response.
-// - WithGzipCompress: Compresses the response using gzip.
-// - WithHashing: Hashes the request body using the key.
+// - WithBodyLimit: Limits the size of the request body.
+// - WithLoadShedding: Rejects the requests over the in-flight limit with 503.
+// - WithCompress: Compresses the response with the negotiated codec.
+// - WithHashing: Verifies the request signature with the keyring and signs the response.
 // - WithTrustedSubnet: Checks if the request is from a trusted subnet.
+// - WithTenant: Determines the tenant of the request (multi-tenant server only).
+// - WithToken: Authenticates the API token of the request.
+// - WithRateLimit: Limits the requests and stored metrics per second of every client.
+// - WithScope: Checks that the API token grants the scope of the route.
+// - WithAdminAuth: Checks the admin credentials on admin routes.
+// - WithAdminTenant: Selects the tenant of admin snapshots by the "tenant" query parameter.
 //
 // Routes:
 //
-//	[GET]     "/"                          				- returns all metrics
+//	[GET]     "/"                          				- returns all metrics (HTML dashboard or JSON)
+//	[GET]     "/assets/*"                  				- dashboard stylesheet and script
 //	[POST]    "/update/"                   				- batch update metrics (JSON payload)
 //	[POST]    "/update/{mType}/{mName}/{mValue}" 		- update a single metric by parameters
 //	[POST]    "/value/"                   				- get metrics in batch (JSON payload)
 //	[GET]     "/value/{mType}/{mName}"   				- get a single metric by type and name
+//	[POST]    "/values/"                  				- get many metrics at once (JSON payload)
 //	[GET]     "/ping/"                   				- health check endpoint
+//	[GET]     "/metrics"                   				- Prometheus / OpenMetrics exposition
+//	[POST]    "/api/v1/write"              				- Prometheus remote write (snappy protobuf)
+//	[POST]    "/write?precision="          				- InfluxDB line protocol
+//	[POST]    "/v1/metrics"                				- OTLP/HTTP metrics export (protobuf)
 //	[POST]    "/updates/"                				- alternative batch update endpoint (JSON payload)
+//	[GET]     "/export?format=csv|ndjson"  				- stream metrics as CSV or NDJSON (type, name, label filters)
+//	[POST]    "/import?format=csv|ndjson"  				- import metrics in batches, with a per-row error report
+//	[GET]     "/stream?type=&name=&label="  				- live updates as Server-Sent Events or over WebSocket
+//	[GET]     "/query?q="                  				- evaluate a query, errors in the API v2 envelope
+//	[GET]     "/admin/snapshot?tenant="    				- gzip-compressed snapshot of all metrics of a tenant
+//	[POST]    "/admin/restore?mode=merge|replace"		- load a snapshot into a tenant
+//	[POST]    "/admin/tokens"              				- create an API token
+//	[GET]     "/admin/tokens"              				- list the API tokens
+//	[DELETE]  "/admin/tokens/{id}"         				- revoke an API token
+//
+// JSON API v2, every error, the middlewares' included, is returned in the
+// {"error": {...}} envelope:
+//
+//	[GET]     "/api/v2/metrics?type=&name=&label=&limit=&cursor="	- list metrics page by page
+//	[POST]    "/api/v2/metrics"                   			- batch update metrics
+//	[GET]     "/api/v2/metrics/{mType}/{mName}"   			- get a single metric
+//	[POST]    "/api/v2/metrics/{mType}/{mName}"   			- update a single metric
+//	[GET]     "/api/v2/ping"                      			- health check endpoint
+//
+// Admin routes are registered only if the hashing keyring, the admin token
+// or the API tokens are enabled.
+//
+// With an API token, the reading routes need the metrics:read scope, the
+// writing ones metrics:write and the admin routes admin.
+//
+// On a multi-tenant server the same routes are also served under
+// "/tenants/{tenant}", e.g. "/tenants/team-a/" is the HTML (or JSON) view
+// of the metrics of tenant "team-a".
 //
 // Returns:
 // - http.Handler
 func NewRouter(srv *rest.Server, opts *srvCfg.Options) http.Handler {
+	// The options are validated when parsed, so a failure here leaves the
+	// server without keys rather than without a router.
+	keys, err := opts.Keyring()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load keyring")
+	}
+
+	// The tenants and the hashing middleware share the nonces and the
+	// signing keys seen.
+	verifier := opts.SignatureVerifier()
+
 	r := chi.NewRouter()
 
 	r.Use(rest.WithLogging)
-	r.Use(rest.WithGzipCompress)
+	r.Use(rest.WithCompress)
 	r.Use(rest.WithTrustedSubnet(opts.TrustedSubnet))
 
-	if opts.Key != "" {
-		r.Use(rest.WithHashing([]byte(opts.Key)))
+	// The dashboard assets are the same for every tenant.
+	r.Handle(dashboard.AssetsPath+"*", dashboard.Assets())
+
+	r.Group(func(r chi.Router) {
+		r.Use(rest.WithBodyLimit(opts.MaxBodySize))
+		if opts.MultiTenant() {
+			r.Use(rest.WithTenant(opts.Tenants, opts.TenantHeader, opts.TrustedSubnet, verifier))
+		}
+		r.Use(rest.WithToken(srv.TokenUsecase, opts.RequireToken))
+		r.Use(rest.WithRateLimit(opts.RateLimit, opts.MetricRateLimit, opts.RateLimitKey, opts.TrustedSubnet))
+
+		// Streams are long-lived, so they bypass the hashing middleware,
+		// which holds the response back to sign it.
+		if srv.StreamHub != nil {
+			r.With(rest.WithScope(apitoken.ScopeRead)).Get("/stream", srv.Stream())
+			if opts.MultiTenant() {
+				r.With(rest.WithPathTenant(opts.Tenants, opts.TenantHeader, opts.TrustedSubnet), rest.WithScope(apitoken.ScopeRead)).
+					Get("/tenants/{"+rest.TenantParam+"}/stream", srv.Stream())
+			}
+		}
+
+		// Exports and imports are streamed, so they bypass the hashing
+		// middleware too, which reads the whole request body and holds the
+		// response back to sign it.
+		r.Group(func(r chi.Router) {
+			r.Use(rest.WithLoadShedding(opts.MaxInFlight))
+
+			bulkRoutes(srv, opts, "")(r)
+			if opts.MultiTenant() {
+				r.Group(func(r chi.Router) {
+					r.Use(rest.WithPathTenant(opts.Tenants, opts.TenantHeader, opts.TrustedSubnet))
+					bulkRoutes(srv, opts, "/tenants/{"+rest.TenantParam+"}")(r)
+				})
+			}
+		})
+
+		// Streams are long-lived, so they don't count as requests in flight.
+		r.Group(func(r chi.Router) {
+			r.Use(rest.WithLoadShedding(opts.MaxInFlight))
+			if keys != nil || opts.Tenants != "" {
+				r.Use(rest.WithHashing(keys, verifier, opts.RequireSignature))
+			}
+
+			r.Route("/api/v2", apiV2Routes(srv))
+			r.Route("/", metricRoutes(srv))
+
+			if opts.MultiTenant() {
+				r.Route("/tenants/{"+rest.TenantParam+"}", func(r chi.Router) {
+					r.Use(rest.WithPathTenant(opts.Tenants, opts.TenantHeader, opts.TrustedSubnet))
+					r.Route("/api/v2", apiV2Routes(srv))
+					metricRoutes(srv)(r)
+				})
+			}
+		})
+	})
+
+	// Admin routes check their own credentials, select the tenant by the
+	// query and stream large bodies, so they bypass the tenant and hashing
+	// middlewares. The bodies are
+	// limited before WithAdminAuth reads them to check the signature.
+	if keys != nil || opts.AdminToken != "" || srv.TokenUsecase != nil {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(rest.WithBodyLimit(rest.MaxRestoreSize))
+			r.Use(rest.WithToken(srv.TokenUsecase, false))
+			r.Use(rest.WithAdminAuth(keys, opts.AdminToken, opts.SignatureVerifier()))
+
+			// Snapshots are per tenant, the tokens are shared by all tenants.
+			r.Group(func(r chi.Router) {
+				r.Use(rest.WithAdminTenant(opts.MultiTenant()))
+				r.Get("/snapshot", srv.Snapshot())
+				r.Post("/restore", srv.Restore())
+			})
+
+			r.Route("/tokens", func(r chi.Router) {
+				r.Post("/", srv.CreateToken())
+				r.Get("/", srv.ListTokens())
+				r.Delete("/{id}", srv.RevokeToken())
+			})
+		})
 	}
 
-	r.Route("/", func(r chi.Router) {
-		r.Get("/", srv.GetAllMetrics())
+	return r
+}
+
+// bulkRoutes registers the export and import routes under prefix.
+//
+// Imports are streamed, so they have a body limit of their own, and can't be
+// signed: if signatures are required, they need an API token or a tenant
+// secret instead.
+func bulkRoutes(srv *rest.Server, opts *srvCfg.Options, prefix string) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.With(rest.WithScope(apitoken.ScopeRead)).Get(prefix+"/export", srv.Export())
+
+		write := r.With(rest.WithScope(apitoken.ScopeWrite), rest.WithBodyLimit(rest.MaxImportSize))
+		if opts.RequireSignature {
+			write = write.With(rest.WithBearerAuth)
+		}
+		write.Post(prefix+"/import", srv.Import())
+	}
+}
+
+// metricRoutes registers the metric routes.
+func metricRoutes(srv *rest.Server) func(r chi.Router) {
+	return func(r chi.Router) {
+		read := r.With(rest.WithScope(apitoken.ScopeRead))
+		write := r.With(rest.WithScope(apitoken.ScopeWrite))
+
+		read.Get("/", srv.GetAllMetrics())
+		read.Get("/metrics", srv.PrometheusMetrics())
+		write.Post("/api/v1/write", srv.RemoteWrite())
+		write.Post("/write", srv.InfluxWrite())
+		write.Post("/v1/metrics", srv.OTLPExport())
+		read.Get("/query", srv.Query())
 		r.Route("/update", func(r chi.Router) {
+			r.Use(rest.WithScope(apitoken.ScopeWrite))
 
 			r.Post("/", srv.UpdateMetricsHandlerJSON())
 			r.Post("/{mType}/{mName}/{mValue}", srv.UpdateMetric())
 		})
 
 		r.Route("/value", func(r chi.Router) {
+			r.Use(rest.WithScope(apitoken.ScopeRead))
 			r.Post("/", srv.GetMetricsHandlerJSON())
 			r.Get("/{mType}/{mName}", srv.GetMetric())
 		})
 
+		r.Route("/values", func(r chi.Router) {
+			r.Use(rest.WithScope(apitoken.ScopeRead))
+			r.Post("/", srv.GetMetricsBatchHandlerJSON())
+		})
+
 		r.Route("/ping", func(r chi.Router) {
 			r.Get("/", srv.PingHandler())
 		})
 
 		r.Route("/updates", func(r chi.Router) {
+			r.Use(rest.WithScope(apitoken.ScopeWrite))
 			r.Post("/", srv.UpdatesMetricsHandlerJSON())
 		})
-	})
+	}
+}
 
-	return r
+// apiV2Routes registers the routes of the JSON API v2.
+func apiV2Routes(srv *rest.Server) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.NotFound(rest.NotFoundV2)
+		r.MethodNotAllowed(rest.MethodNotAllowedV2)
+
+		r.Route("/metrics", func(r chi.Router) {
+			read := r.With(rest.WithScope(apitoken.ScopeRead))
+			write := r.With(rest.WithScope(apitoken.ScopeWrite))
+
+			read.Get("/", srv.ListMetricsV2())
+			write.Post("/", srv.UpdateMetricsV2())
+			read.Get("/{mType}/{mName}", srv.GetMetricV2())
+			write.Post("/{mType}/{mName}", srv.UpdateMetricV2())
+		})
+
+		r.Get("/ping", srv.PingV2())
+	}
 }
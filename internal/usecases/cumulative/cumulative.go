@@ -0,0 +1,165 @@
+// Package cumulative turns cumulative counter values, as sent by Prometheus
+// or Telegraf, into the increases added to the stored counters.
+package cumulative
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+type CounterGetter interface {
+	GetMetric(ctx context.Context, mType, mName string) (models.Metric, error)
+}
+
+// DefaultTTL is how long a series not written to is remembered by default.
+const DefaultTTL = time.Hour
+
+type seriesKey struct {
+	tenant string
+	name   string
+}
+
+type seriesValue struct {
+	value int64
+	seen  time.Time
+}
+
+// Tracker remembers the last cumulative value of every counter series.
+//
+// The increase of a series is the difference from its last value; a value
+// lower than the last one is a counter reset, the whole value is the
+// increase then. The first value of a series seen by the process continues
+// from the stored counter value.
+//
+// A series not written to for the TTL is forgotten, so short-lived series,
+// e.g. labelled by a pod name, don't pile up; its next value continues
+// from the stored counter value again, as a first one.
+type Tracker struct {
+	store CounterGetter
+	ttl   time.Duration
+
+	mutex sync.Mutex
+	last  map[seriesKey]seriesValue
+	swept time.Time
+}
+
+// NewTracker returns a tracker of the counters in store that forgets the
+// series not written to for ttl, DefaultTTL if ttl is not positive.
+func NewTracker(store CounterGetter, ttl time.Duration) *Tracker {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Tracker{
+		store: store,
+		ttl:   ttl,
+		last:  make(map[seriesKey]seriesValue),
+		swept: time.Now(),
+	}
+}
+
+// Len returns the number of series remembered.
+func (t *Tracker) Len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return len(t.last)
+}
+
+// Batch computes the increases of one write request. The values are
+// remembered only on Commit, so a request that failed to be stored is
+// applied again when it is retried.
+//
+// Batches of the same series must not run concurrently.
+type Batch struct {
+	ctx     context.Context
+	tracker *Tracker
+	last    map[seriesKey]int64
+}
+
+func (t *Tracker) NewBatch(ctx context.Context) *Batch {
+	return &Batch{
+		ctx:     ctx,
+		tracker: t,
+		last:    make(map[seriesKey]int64),
+	}
+}
+
+// Increase returns the increase of the counter id over the values,
+// which must be ordered by time.
+func (b *Batch) Increase(id string, values []int64) int64 {
+	key := seriesKey{tenant: tenant.FromContext(b.ctx), name: id}
+
+	prev, ok := b.last[key]
+	if !ok {
+		b.tracker.mutex.Lock()
+		var last seriesValue
+		last, ok = b.tracker.last[key]
+		b.tracker.mutex.Unlock()
+		prev = last.value
+	}
+	if !ok {
+		prev = b.tracker.stored(b.ctx, id)
+	}
+
+	var increase int64
+	for _, current := range values {
+		if current >= prev {
+			increase += current - prev
+		} else {
+			increase += current
+		}
+		prev = current
+	}
+
+	b.last[key] = prev
+	return increase
+}
+
+// Commit remembers the last values of the batch, and forgets the series
+// not written to for the TTL.
+func (b *Batch) Commit() {
+	now := time.Now()
+
+	b.tracker.mutex.Lock()
+	defer b.tracker.mutex.Unlock()
+
+	for key, value := range b.last {
+		b.tracker.last[key] = seriesValue{value: value, seen: now}
+	}
+
+	b.tracker.sweep(now)
+}
+
+// sweep forgets the expired series, at most once per TTL so that a commit
+// doesn't scan every series. The caller must hold the mutex.
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < t.ttl {
+		return
+	}
+	t.swept = now
+
+	for key, last := range t.last {
+		if now.Sub(last.seen) >= t.ttl {
+			delete(t.last, key)
+		}
+	}
+}
+
+func (t *Tracker) stored(ctx context.Context, id string) int64 {
+	metric, err := t.store.GetMetric(ctx, models.CounterType, id)
+	if err != nil {
+		return 0
+	}
+
+	value, ok := metric.Value().(int64)
+	if !ok {
+		return 0
+	}
+
+	return value
+}
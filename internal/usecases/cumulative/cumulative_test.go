@@ -0,0 +1,67 @@
+package cumulative_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/cumulative"
+)
+
+func TestTracker_Increase(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "requests", int64(10)))
+
+	tracker := cumulative.NewTracker(storage, time.Hour)
+
+	batch := tracker.NewBatch(ctx)
+	// The first value continues from the stored counter.
+	assert.Equal(t, int64(5), batch.Increase("requests", []int64{12, 15}))
+	assert.Equal(t, int64(7), batch.Increase("errors", []int64{7}))
+	batch.Commit()
+
+	batch = tracker.NewBatch(ctx)
+	// A lower value is a reset.
+	assert.Equal(t, int64(10), batch.Increase("requests", []int64{20, 3, 5}))
+	assert.Equal(t, int64(0), batch.Increase("errors", []int64{7}))
+	// Not committed, so applied again by the next batch.
+	assert.Equal(t, int64(5), tracker.NewBatch(ctx).Increase("requests", []int64{20}))
+	assert.Equal(t, 2, tracker.Len())
+}
+
+func TestTracker_Expire(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+
+	const ttl = 200 * time.Millisecond
+	tracker := cumulative.NewTracker(storage, ttl)
+
+	batch := tracker.NewBatch(ctx)
+	batch.Increase("pod-a", []int64{100})
+	batch.Increase("pod-b", []int64{100})
+	batch.Commit()
+	require.Equal(t, 2, tracker.Len())
+
+	time.Sleep(ttl * 3 / 5)
+	batch = tracker.NewBatch(ctx)
+	batch.Increase("pod-b", []int64{110})
+	batch.Commit()
+
+	time.Sleep(ttl * 3 / 5)
+	batch = tracker.NewBatch(ctx)
+	batch.Increase("pod-c", []int64{1})
+	batch.Commit()
+
+	// pod-a is forgotten, pod-b was written to within the TTL.
+	assert.Equal(t, 2, tracker.Len())
+
+	require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "pod-a", int64(100)))
+	assert.Equal(t, int64(20), tracker.NewBatch(ctx).Increase("pod-a", []int64{120}))
+	assert.Equal(t, int64(10), tracker.NewBatch(ctx).Increase("pod-b", []int64{120}))
+}
@@ -0,0 +1,11 @@
+package graphite
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+type MetricStore interface {
+	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
+}
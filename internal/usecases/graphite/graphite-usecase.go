@@ -0,0 +1,102 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	graphiteParser "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/graphite"
+)
+
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// GraphiteUsecase stores Graphite samples as gauges.
+//
+// Samples are kept in memory until Flush, which stores the latest sample
+// of every metric with a single UpdateMetricList call.
+type GraphiteUsecase struct {
+	store     MetricStore
+	templates []Template
+
+	mutex   sync.Mutex
+	pending map[string]sample
+}
+
+func NewGraphiteUsecase(store MetricStore, templates []Template) *GraphiteUsecase {
+	return &GraphiteUsecase{
+		store:     store,
+		templates: templates,
+		pending:   make(map[string]sample),
+	}
+}
+
+// HandleLine parses a plaintext line and adds its sample.
+func (uc *GraphiteUsecase) HandleLine(line string) error {
+	s, err := graphiteParser.ParseLine(line)
+	if err != nil {
+		return err
+	}
+
+	uc.Add(*s)
+	return nil
+}
+
+// Add adds the samples. A sample without a timestamp gets the current time.
+func (uc *GraphiteUsecase) Add(samples ...graphiteParser.Sample) {
+	now := time.Now()
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	for _, s := range samples {
+		ts := s.Time
+		if ts.IsZero() {
+			ts = now
+		}
+
+		uc.add(metricName(uc.templates, s.Path), sample{value: s.Value, time: ts})
+	}
+}
+
+// add keeps the later of the samples of the metric.
+func (uc *GraphiteUsecase) add(id string, s sample) {
+	if current, ok := uc.pending[id]; ok && current.time.After(s.time) {
+		return
+	}
+	uc.pending[id] = s
+}
+
+// Flush stores the samples added since the last flush. If it fails, they
+// are kept for the next flush.
+func (uc *GraphiteUsecase) Flush(ctx context.Context) error {
+	uc.mutex.Lock()
+	pending := uc.pending
+	uc.pending = make(map[string]sample)
+	uc.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	metrics := make([]models.Metric, 0, len(pending))
+	for id, s := range pending {
+		metrics = append(metrics, models.NewGauge(id, s.value))
+	}
+
+	if err := uc.store.UpdateMetricList(ctx, metrics); err != nil {
+		uc.mutex.Lock()
+		for id, s := range pending {
+			uc.add(id, s)
+		}
+		uc.mutex.Unlock()
+
+		return fmt.Errorf("failed to flush graphite metrics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package graphite_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/graphite"
+	graphiteParser "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/graphite"
+	graphiteMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/graphite"
+)
+
+func TestParseTemplates(t *testing.T) {
+	templates, err := graphite.ParseTemplates(" servers.* .host.measurement* ; measurement.measurement.field ;")
+	require.NoError(t, err)
+	assert.Len(t, templates, 2)
+
+	templates, err = graphite.ParseTemplates("")
+	require.NoError(t, err)
+	assert.Empty(t, templates)
+
+	for _, spec := range []string{
+		"host.region",
+		"measurement*.host",
+		"measurement.bad-label",
+		"servers.[ measurement",
+		"a b c",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := graphite.ParseTemplates(spec)
+			assert.ErrorIs(t, err, graphite.ErrInvalidTemplate)
+		})
+	}
+}
+
+func TestGraphiteUsecase_Flush(t *testing.T) {
+	ctx := context.Background()
+
+	value := func(t *testing.T, storage *repo.MemStorage, name string) any {
+		t.Helper()
+
+		metric, err := storage.GetMetric(ctx, models.GaugeType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	t.Run("TestGraphiteUsecase_Flush_templates", func(t *testing.T) {
+		templates, err := graphite.ParseTemplates("servers.* .host.measurement*; stats.*.* .env.measurement.measurement; region.measurement*")
+		require.NoError(t, err)
+
+		storage := repo.NewMemStorage()
+		uc := graphite.NewGraphiteUsecase(storage, templates)
+
+		for _, line := range []string{
+			"servers.web-1.cpu.load 0.5 1700000000",
+			"stats.prod.http.requests 12",
+			"stats.prod 3",
+			"eu.disk.free 100",
+			"plain 1",
+		} {
+			require.NoError(t, uc.HandleLine(line))
+		}
+		require.NoError(t, uc.Flush(ctx))
+
+		assert.Equal(t, 0.5, value(t, storage, `cpu.load{host="web-1"}`))
+		assert.Equal(t, 12.0, value(t, storage, `http.requests{env="prod"}`))
+		// The filter doesn't match, so the default template maps the path.
+		assert.Equal(t, 3.0, value(t, storage, `prod{region="stats"}`))
+		assert.Equal(t, 100.0, value(t, storage, `disk.free{region="eu"}`))
+		// Without a name from the template, the path is the name.
+		assert.Equal(t, 1.0, value(t, storage, "plain"))
+	})
+
+	t.Run("TestGraphiteUsecase_Flush_latest_sample", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := graphite.NewGraphiteUsecase(storage, nil)
+
+		now := time.Now()
+		uc.Add(
+			graphiteParser.Sample{Path: "temp", Value: 2, Time: now},
+			graphiteParser.Sample{Path: "temp", Value: 1, Time: now.Add(-time.Minute)},
+		)
+		require.NoError(t, uc.Flush(ctx))
+
+		assert.Equal(t, 2.0, value(t, storage, "temp"))
+	})
+
+	t.Run("TestGraphiteUsecase_Flush_retry_after_failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := graphiteMocks.NewMockMetricStore(ctrl)
+		uc := graphite.NewGraphiteUsecase(store, nil)
+
+		gomock.InOrder(
+			store.EXPECT().UpdateMetricList(ctx, gomock.Any()).Return(errors.New("db is down")),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewGauge("temp", 3)}).Return(nil),
+		)
+
+		require.NoError(t, uc.HandleLine("temp 1"))
+		require.Error(t, uc.Flush(ctx))
+
+		// The failed flush is retried with the later samples received since.
+		require.NoError(t, uc.HandleLine("temp 3"))
+		require.NoError(t, uc.Flush(ctx))
+
+		// Nothing to flush.
+		require.NoError(t, uc.Flush(ctx))
+	})
+
+	t.Run("TestGraphiteUsecase_HandleLine_invalid", func(t *testing.T) {
+		uc := graphite.NewGraphiteUsecase(repo.NewMemStorage(), nil)
+		assert.ErrorIs(t, uc.HandleLine("temp"), graphiteParser.ErrInvalidLine)
+	})
+}
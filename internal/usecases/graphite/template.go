@@ -0,0 +1,162 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+const (
+	// MeasurementPart marks a path part that is a part of the metric name.
+	MeasurementPart = "measurement"
+	// MeasurementRest marks the last path parts that are parts of the metric name.
+	MeasurementRest = "measurement*"
+)
+
+var ErrInvalidTemplate = errors.New("invalid graphite template")
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Template maps the parts of a dotted path to the metric name and labels.
+//
+// A template is "[filter ]part.part...", every part of the template
+// describes the path part at the same position:
+//
+//   - "measurement" adds the path part to the metric name;
+//   - "measurement*" adds this and all remaining path parts to the name;
+//   - an empty part skips the path part;
+//   - any other word is the label the path part is the value of.
+//
+// Path parts beyond the template are skipped. The filter is a dotted glob,
+// such as "servers.*", that must match the first parts of the path.
+//
+// For example, the template "servers.* .host.measurement*" stores
+// "servers.web-1.cpu.load" as `cpu.load{host="web-1"}`.
+type Template struct {
+	filter []string
+	parts  []string
+}
+
+// ParseTemplates parses the templates separated by semicolons.
+func ParseTemplates(spec string) ([]Template, error) {
+	var templates []Template
+
+	for _, s := range strings.Split(spec, ";") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		tmpl, err := parseTemplate(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidTemplate, s, err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	return templates, nil
+}
+
+func parseTemplate(s string) (Template, error) {
+	var tmpl Template
+
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+	case 2:
+		tmpl.filter = strings.Split(fields[0], ".")
+		for _, part := range tmpl.filter {
+			if _, err := path.Match(part, ""); err != nil {
+				return Template{}, fmt.Errorf("invalid filter: %w", err)
+			}
+		}
+	default:
+		return Template{}, errors.New(`want "[filter ]template"`)
+	}
+
+	tmpl.parts = strings.Split(fields[len(fields)-1], ".")
+
+	hasMeasurement := false
+	for i, part := range tmpl.parts {
+		switch {
+		case part == "":
+		case part == MeasurementPart:
+			hasMeasurement = true
+		case part == MeasurementRest:
+			if i != len(tmpl.parts)-1 {
+				return Template{}, fmt.Errorf("%s must be the last part", MeasurementRest)
+			}
+			hasMeasurement = true
+		case !labelNameRe.MatchString(part):
+			return Template{}, fmt.Errorf("invalid label name %q", part)
+		}
+	}
+
+	if !hasMeasurement {
+		return Template{}, fmt.Errorf("no %s part", MeasurementPart)
+	}
+
+	return tmpl, nil
+}
+
+func (t Template) match(parts []string) bool {
+	if len(t.filter) > len(parts) {
+		return false
+	}
+
+	for i, pattern := range t.filter {
+		if ok, _ := path.Match(pattern, parts[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t Template) apply(parts []string) (string, models.Labels) {
+	var name []string
+	labels := make(models.Labels)
+
+	for i, part := range t.parts {
+		if i >= len(parts) {
+			break
+		}
+
+		switch part {
+		case "":
+		case MeasurementPart:
+			name = append(name, parts[i])
+		case MeasurementRest:
+			name = append(name, parts[i:]...)
+		default:
+			labels[part] = parts[i]
+		}
+	}
+
+	return strings.Join(name, "."), labels
+}
+
+// metricName returns the metric name of the path, mapped by the first
+// template matching it. Without a matching template, or if the template
+// gives no name, the path is the name.
+func metricName(templates []Template, p string) string {
+	parts := strings.Split(p, ".")
+
+	for _, tmpl := range templates {
+		if !tmpl.match(parts) {
+			continue
+		}
+
+		name, labels := tmpl.apply(parts)
+		if name == "" {
+			return p
+		}
+		return models.JoinName(name, labels)
+	}
+
+	return p
+}
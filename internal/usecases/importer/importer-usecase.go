@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/bulk"
+)
+
+// DefaultBatchSize is the number of metrics stored with one UpdateMetricList call.
+const DefaultBatchSize = 1000
+
+var ErrReadBody = errors.New("failed to read body")
+
+// Result summarizes an import.
+type Result struct {
+	// Rows is the number of decoded rows, imported or rejected.
+	Rows     int
+	Imported int
+	// Errors are the rejected rows, the other rows are imported.
+	Errors []*bulk.RowError
+}
+
+// ImportUsecase imports metrics exported with bulk into the storage.
+type ImportUsecase struct {
+	store     MetricStore
+	batchSize int
+}
+
+func NewImportUsecase(store MetricStore) *ImportUsecase {
+	return &ImportUsecase{
+		store:     store,
+		batchSize: DefaultBatchSize,
+	}
+}
+
+// WithBatchSize sets the number of metrics stored with one UpdateMetricList call.
+func (uc *ImportUsecase) WithBatchSize(size int) *ImportUsecase {
+	if size > 0 {
+		uc.batchSize = size
+	}
+	return uc
+}
+
+// Import decodes the rows of body and stores them in batches, as they are
+// read. Gauges are set and counters are added to, like a batch update.
+//
+// Invalid rows are reported in Result.Errors and don't stop the import.
+// An error is returned if the body can't be read or the metrics can't be
+// stored; the batches stored before it are kept.
+func (uc *ImportUsecase) Import(ctx context.Context, body io.Reader, format bulk.Format) (*Result, error) {
+	result := &Result{}
+	dec := bulk.NewDecoder(body, format)
+	batch := make([]models.Metric, 0, uc.batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := uc.store.UpdateMetricList(ctx, batch); err != nil {
+			return fmt.Errorf("failed to store metrics: %w", err)
+		}
+
+		result.Imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		metric, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *bulk.RowError
+		switch {
+		case errors.As(err, &rowErr):
+			result.Rows++
+			result.Errors = append(result.Errors, rowErr)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("%w: %w", ErrReadBody, err)
+		}
+
+		result.Rows++
+		batch = append(batch, metric)
+		if len(batch) == uc.batchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package importer_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/importer"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/bulk"
+	importerMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/importer"
+)
+
+func TestImportUsecase_Import(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("csv", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "requests", int64(10)))
+		uc := importer.NewImportUsecase(storage).WithBatchSize(2)
+
+		body := "id,type,value\n" +
+			"Alloc,gauge,1.5\n" +
+			"requests,counter,5\n" +
+			"bad,counter,x\n" +
+			`"cpu{host=""a""}",gauge,0.25` + "\n" +
+			"requests,counter,1\n"
+
+		result, err := uc.Import(ctx, strings.NewReader(body), bulk.CSV)
+		require.NoError(t, err)
+		assert.Equal(t, 5, result.Rows)
+		assert.Equal(t, 4, result.Imported)
+		require.Len(t, result.Errors, 1)
+		assert.Equal(t, 4, result.Errors[0].Line)
+
+		metric, err := storage.GetMetric(ctx, models.CounterType, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(16), metric.Value())
+
+		metric, err = storage.GetMetric(ctx, models.GaugeType, `cpu{host="a"}`)
+		require.NoError(t, err)
+		assert.Equal(t, 0.25, metric.Value())
+	})
+
+	t.Run("ndjson in batches", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := importerMocks.NewMockMetricStore(ctrl)
+		uc := importer.NewImportUsecase(store).WithBatchSize(2)
+
+		gomock.InOrder(
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewGauge("a", 1), models.NewGauge("b", 2)}).Return(nil),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("c", 3)}).Return(nil),
+		)
+
+		body := `{"id":"a","type":"gauge","value":1}` + "\n" +
+			`{"id":"b","type":"gauge","value":2}` + "\n" +
+			`{"id":"c","type":"counter","delta":3}` + "\n"
+
+		result, err := uc.Import(ctx, strings.NewReader(body), bulk.NDJSON)
+		require.NoError(t, err)
+		assert.Equal(t, &importer.Result{Rows: 3, Imported: 3}, result)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := importerMocks.NewMockMetricStore(ctrl)
+		uc := importer.NewImportUsecase(store)
+
+		errStore := errors.New("db is down")
+		store.EXPECT().UpdateMetricList(ctx, gomock.Any()).Return(errStore)
+
+		_, err := uc.Import(ctx, strings.NewReader("id,type,value\na,gauge,1\n"), bulk.CSV)
+		assert.ErrorIs(t, err, errStore)
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		uc := importer.NewImportUsecase(importerMocks.NewMockMetricStore(ctrl))
+
+		_, err := uc.Import(ctx, strings.NewReader("name,value\na,1\n"), bulk.CSV)
+		assert.ErrorIs(t, err, importer.ErrReadBody)
+		assert.ErrorIs(t, err, bulk.ErrInvalidHeader)
+	})
+}
@@ -0,0 +1,314 @@
+package influx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/cumulative"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/lineprotocol"
+)
+
+// Integer field modes.
+const (
+	// IntegersAsCounters stores integer fields as cumulative counters.
+	// Telegraf sends gauges as integers too, e.g. mem used, so it is opt-in.
+	IntegersAsCounters = "counter"
+	// IntegersAsGauges stores integer fields as gauges, the default.
+	IntegersAsGauges = "gauge"
+)
+
+// Tag modes.
+const (
+	// TagsAsLabels turns tags into labels: cpu_usage{host="a"}.
+	TagsAsLabels = "labels"
+	// TagsAsPrefix prepends the tag values, ordered by tag key, to the
+	// metric name: a.cpu_usage.
+	TagsAsPrefix = "prefix"
+)
+
+const (
+	DefaultIntegers = IntegersAsGauges
+	DefaultTags     = TagsAsLabels
+	// DefaultBatchSize is the number of metrics stored with one UpdateMetricList call.
+	DefaultBatchSize = 1000
+	// MaxLineSize is the longest accepted line.
+	MaxLineSize = 1 << 20
+	// ValueField is the field whose metric is named after the measurement only.
+	ValueField = "value"
+)
+
+var (
+	ErrInvalidConvention = errors.New("invalid line protocol convention")
+	ErrLineTooLong       = errors.New("line too long")
+	ErrReadBody          = errors.New("failed to read body")
+)
+
+// Convention decides how fields of the points become metrics.
+//
+// A field is stored as a metric named measurement_field, the field "value"
+// as a metric named after the measurement. Floats and booleans (0 or 1)
+// are gauges, integers (1i and 1u) are counters or gauges depending on
+// Integers; string fields are skipped.
+type Convention struct {
+	Integers string
+	Tags     string
+}
+
+// ParseConvention validates the integer and tag modes.
+func ParseConvention(integers, tags string) (Convention, error) {
+	if integers != IntegersAsCounters && integers != IntegersAsGauges {
+		return Convention{}, fmt.Errorf("%w: integers must be %s or %s, got %q",
+			ErrInvalidConvention, IntegersAsCounters, IntegersAsGauges, integers)
+	}
+
+	if tags != TagsAsLabels && tags != TagsAsPrefix {
+		return Convention{}, fmt.Errorf("%w: tags must be %s or %s, got %q",
+			ErrInvalidConvention, TagsAsLabels, TagsAsPrefix, tags)
+	}
+
+	return Convention{Integers: integers, Tags: tags}, nil
+}
+
+func (c Convention) metricName(point *lineprotocol.Point, field string) string {
+	name := point.Measurement
+	if field != ValueField {
+		name += "_" + field
+	}
+
+	if c.Tags == TagsAsLabels {
+		return models.JoinName(name, point.Tags)
+	}
+
+	keys := make([]string, 0, len(point.Tags))
+	for key := range point.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		parts = append(parts, point.Tags[key])
+	}
+
+	return strings.Join(append(parts, name), ".")
+}
+
+// metricValue returns the type of the field metric and its value,
+// false if the field is not stored.
+func (c Convention) metricValue(value any) (string, float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return models.GaugeType, v, true
+	case bool:
+		if v {
+			return models.GaugeType, 1, true
+		}
+		return models.GaugeType, 0, true
+	case int64:
+		return c.integerType(), float64(v), true
+	case uint64:
+		return c.integerType(), float64(v), true
+	default:
+		return "", 0, false
+	}
+}
+
+func (c Convention) integerType() string {
+	if c.Integers == IntegersAsGauges {
+		return models.GaugeType
+	}
+
+	return models.CounterType
+}
+
+// LineError is the error of a rejected line.
+type LineError struct {
+	// Line is the 1-based line number.
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Result summarizes a write request.
+type Result struct {
+	Lines    int
+	Points   int
+	Gauges   int
+	Counters int
+	// Skipped is the number of string fields.
+	Skipped int
+	// Errors are the rejected lines, the other lines are stored.
+	Errors []*LineError
+}
+
+type sample struct {
+	time  time.Time
+	value float64
+}
+
+type seriesKey struct {
+	mType string
+	id    string
+}
+
+type series struct {
+	mType   string
+	id      string
+	samples []sample
+}
+
+// InfluxUsecase applies InfluxDB line protocol writes to the storage.
+//
+// A gauge takes the value of the latest point of its series. With
+// IntegersAsCounters, integer fields are cumulative counters, so their
+// increases are computed by a cumulative.Tracker.
+type InfluxUsecase struct {
+	store      MetricStore
+	convention Convention
+	batchSize  int
+
+	// mutex serializes the writes, so the increases of a series are
+	// computed and stored in order.
+	mutex    sync.Mutex
+	counters *cumulative.Tracker
+}
+
+func NewInfluxUsecase(store MetricStore, convention Convention) *InfluxUsecase {
+	return &InfluxUsecase{
+		store:      store,
+		convention: convention,
+		batchSize:  DefaultBatchSize,
+		counters:   cumulative.NewTracker(store, cumulative.DefaultTTL),
+	}
+}
+
+// WithBatchSize sets the number of metrics stored with one UpdateMetricList call.
+func (uc *InfluxUsecase) WithBatchSize(size int) *InfluxUsecase {
+	if size > 0 {
+		uc.batchSize = size
+	}
+	return uc
+}
+
+// Write parses the lines of body and stores their fields in batches.
+//
+// Invalid lines are reported in Result.Errors and don't stop the write.
+// Points are ordered by timestamp; points without one get the time of
+// the request. An error is returned if the body can't be read or the
+// metrics can't be stored; the batches stored before it are kept.
+func (uc *InfluxUsecase) Write(ctx context.Context, body io.Reader, precision lineprotocol.Precision) (*Result, error) {
+	result := &Result{}
+	now := time.Now()
+
+	var order []*series
+	index := make(map[seriesKey]*series)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+
+	for scanner.Scan() {
+		result.Lines++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		point, err := lineprotocol.Parse(line, precision)
+		if err != nil {
+			result.Errors = append(result.Errors, &LineError{Line: result.Lines, Err: err})
+			continue
+		}
+		result.Points++
+
+		ts := point.Time
+		if ts.IsZero() {
+			ts = now
+		}
+
+		for _, field := range point.Fields {
+			mType, value, ok := uc.convention.metricValue(field.Value)
+			if !ok {
+				result.Skipped++
+				continue
+			}
+
+			key := seriesKey{mType: mType, id: uc.convention.metricName(point, field.Key)}
+			s, ok := index[key]
+			if !ok {
+				s = &series{mType: key.mType, id: key.id}
+				index[key] = s
+				order = append(order, s)
+			}
+			s.samples = append(s.samples, sample{time: ts, value: value})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d: %w", result.Lines+1, ErrLineTooLong)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrReadBody, err)
+	}
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	for start := 0; start < len(order); start += uc.batchSize {
+		end := min(start+uc.batchSize, len(order))
+		counters := uc.counters.NewBatch(ctx)
+
+		if err := uc.store.UpdateMetricList(ctx, uc.metrics(order[start:end], counters, result)); err != nil {
+			return nil, fmt.Errorf("failed to store metrics: %w", err)
+		}
+
+		// Remember the counter values only once they are stored, so a
+		// failed batch retried by the client is applied again.
+		counters.Commit()
+	}
+
+	return result, nil
+}
+
+// metrics converts the series into metrics, counting them in result.
+func (uc *InfluxUsecase) metrics(batch []*series, counters *cumulative.Batch, result *Result) []models.Metric {
+	metrics := make([]models.Metric, 0, len(batch))
+
+	for _, s := range batch {
+		sort.SliceStable(s.samples, func(i, j int) bool {
+			return s.samples[i].time.Before(s.samples[j].time)
+		})
+
+		if s.mType == models.GaugeType {
+			metrics = append(metrics, models.NewGauge(s.id, s.samples[len(s.samples)-1].value))
+			result.Gauges++
+			continue
+		}
+
+		totals := make([]int64, len(s.samples))
+		for i, sample := range s.samples {
+			totals[i] = int64(math.Round(sample.value))
+		}
+
+		metrics = append(metrics, models.NewCounter(s.id, counters.Increase(s.id, totals)))
+		result.Counters++
+	}
+
+	return metrics
+}
@@ -0,0 +1,126 @@
+package influx_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/influx"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/lineprotocol"
+	influxMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/influx"
+)
+
+func TestParseConvention(t *testing.T) {
+	conv, err := influx.ParseConvention(influx.IntegersAsGauges, influx.TagsAsPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, influx.Convention{Integers: influx.IntegersAsGauges, Tags: influx.TagsAsPrefix}, conv)
+
+	_, err = influx.ParseConvention("delta", influx.TagsAsLabels)
+	assert.ErrorIs(t, err, influx.ErrInvalidConvention)
+
+	_, err = influx.ParseConvention(influx.IntegersAsCounters, "names")
+	assert.ErrorIs(t, err, influx.ErrInvalidConvention)
+}
+
+func TestInfluxUsecase_Write(t *testing.T) {
+	ctx := context.Background()
+
+	value := func(t *testing.T, storage *repo.MemStorage, mType, name string) any {
+		t.Helper()
+
+		metric, err := storage.GetMetric(ctx, mType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	t.Run("TestInfluxUsecase_Write_labels", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		conv, err := influx.ParseConvention(influx.IntegersAsCounters, influx.DefaultTags)
+		require.NoError(t, err)
+		uc := influx.NewInfluxUsecase(storage, conv).WithBatchSize(2)
+
+		body := strings.Join([]string{
+			"# telegraf",
+			"cpu,host=a usage_idle=97.5 1760860810",
+			"cpu,host=a usage_idle=98.5 1760860800",
+			"net,host=a bytes_recv=100i,iface=\"eth0\" 1760860800",
+			"net,host=a bytes_recv=150i 1760860810",
+			"",
+			"system,host=a value=1.5,up=true",
+			"broken line",
+			"cpu,host=a usage_idle=abc",
+		}, "\n")
+
+		result, err := uc.Write(ctx, strings.NewReader(body), lineprotocol.Second)
+		require.NoError(t, err)
+
+		assert.Equal(t, 9, result.Lines)
+		assert.Equal(t, 5, result.Points)
+		assert.Equal(t, 3, result.Gauges)
+		assert.Equal(t, 1, result.Counters)
+		assert.Equal(t, 1, result.Skipped)
+		require.Len(t, result.Errors, 2)
+		assert.Equal(t, 8, result.Errors[0].Line)
+		assert.Equal(t, 9, result.Errors[1].Line)
+		assert.ErrorIs(t, result.Errors[0], lineprotocol.ErrInvalidLine)
+
+		// The latest point wins, whatever the order of the lines.
+		assert.Equal(t, 97.5, value(t, storage, models.GaugeType, `cpu_usage_idle{host="a"}`))
+		assert.Equal(t, int64(150), value(t, storage, models.CounterType, `net_bytes_recv{host="a"}`))
+		assert.Equal(t, 1.5, value(t, storage, models.GaugeType, `system{host="a"}`))
+		assert.Equal(t, 1.0, value(t, storage, models.GaugeType, `system_up{host="a"}`))
+
+		// Integer counters are cumulative.
+		_, err = uc.Write(ctx, strings.NewReader("net,host=a bytes_recv=170i\n"), lineprotocol.Nanosecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(170), value(t, storage, models.CounterType, `net_bytes_recv{host="a"}`))
+	})
+
+	t.Run("TestInfluxUsecase_Write_prefix", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		conv, err := influx.ParseConvention(influx.IntegersAsGauges, influx.TagsAsPrefix)
+		require.NoError(t, err)
+		uc := influx.NewInfluxUsecase(storage, conv)
+
+		_, err = uc.Write(ctx, strings.NewReader("mem,region=eu,host=a used=42i\r\n"), lineprotocol.Nanosecond)
+		require.NoError(t, err)
+		assert.Equal(t, 42.0, value(t, storage, models.GaugeType, "a.eu.mem_used"))
+	})
+
+	t.Run("TestInfluxUsecase_Write_line_too_long", func(t *testing.T) {
+		uc := influx.NewInfluxUsecase(repo.NewMemStorage(), influx.Convention{Integers: influx.DefaultIntegers, Tags: influx.DefaultTags})
+
+		body := "cpu usage=1\ncpu,host=" + strings.Repeat("a", influx.MaxLineSize) + " usage=1\n"
+		_, err := uc.Write(ctx, strings.NewReader(body), lineprotocol.Nanosecond)
+		assert.ErrorIs(t, err, influx.ErrLineTooLong)
+	})
+
+	t.Run("TestInfluxUsecase_Write_retry_after_failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := influxMocks.NewMockMetricStore(ctrl)
+		uc := influx.NewInfluxUsecase(store, influx.Convention{Integers: influx.IntegersAsCounters, Tags: influx.DefaultTags})
+		body := "net bytes_recv=7i\n"
+
+		gomock.InOrder(
+			store.EXPECT().GetMetric(ctx, models.CounterType, "net_bytes_recv").Return(nil, models.ErrMetricsNotFound),
+			store.EXPECT().UpdateMetricList(ctx, gomock.Any()).Return(errors.New("db is down")),
+			store.EXPECT().GetMetric(ctx, models.CounterType, "net_bytes_recv").Return(nil, models.ErrMetricsNotFound),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("net_bytes_recv", 7)}).Return(nil),
+		)
+
+		_, err := uc.Write(ctx, strings.NewReader(body), lineprotocol.Nanosecond)
+		require.Error(t, err)
+
+		_, err = uc.Write(ctx, strings.NewReader(body), lineprotocol.Nanosecond)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// MetricRanger walks all metrics of a storage without loading them at once.
+type MetricRanger interface {
+	RangeMetrics(ctx context.Context, fn func(models.Metric) error) error
+}
+
+type MetricBatchGetter interface {
+	GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error)
+}
+
+// MetricSetter stores metrics with their values as they are, counters included.
+type MetricSetter interface {
+	SetMetricList(ctx context.Context, metrics []models.Metric) error
+}
+
+type Destination interface {
+	MetricRanger
+	MetricBatchGetter
+	MetricSetter
+}
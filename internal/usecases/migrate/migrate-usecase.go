@@ -0,0 +1,219 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+)
+
+// DefaultBatchSize is the number of metrics written to the destination at once.
+const DefaultBatchSize = 500
+
+// ErrVerificationFailed is returned when the destination doesn't hold
+// the expected metrics after the migration.
+var ErrVerificationFailed = errors.New("migration verification failed")
+
+// ErrDestinationNotEmpty is returned when the destination already holds
+// metrics and overwriting them wasn't allowed.
+var ErrDestinationNotEmpty = errors.New("destination storage is not empty")
+
+type Options struct {
+	BatchSize int
+	DryRun    bool
+	// Overwrite allows migrating into a destination that already holds metrics.
+	Overwrite bool
+}
+
+// Mismatch describes a metric whose value in the destination differs
+// from the expected one after the migration.
+type Mismatch struct {
+	Type     string
+	Name     string
+	Expected any
+	Actual   any
+}
+
+// Report summarizes a migration.
+type Report struct {
+	DryRun bool
+
+	Gauges   int
+	Counters int
+	// Existing is the number of metrics already present in the destination.
+	Existing int
+	// Written is the number of metrics written to the destination.
+	Written int
+	Batches int
+
+	// Verified is the number of written metrics holding the expected
+	// value in the destination.
+	Verified   int
+	Mismatches []Mismatch
+}
+
+// Total returns the number of metrics read from the source.
+func (r *Report) Total() int {
+	return r.Gauges + r.Counters
+}
+
+type MigrateUsecase struct {
+	source      MetricRanger
+	destination Destination
+}
+
+func NewMigrateUsecase(src MetricRanger, dst Destination) *MigrateUsecase {
+	return &MigrateUsecase{
+		source:      src,
+		destination: dst,
+	}
+}
+
+// Run copies all metrics from the source to the destination in batches
+// and verifies the result.
+//
+// The source is streamed: only one batch of metrics is held in memory at
+// a time. Every batch is verified right after it is written, so the
+// verification is bounded by the batch size too.
+//
+// Metrics are written with the source values, counters included, so running
+// the migration again leaves the destination unchanged. A destination that
+// already holds metrics is refused unless Options.Overwrite is set; then the
+// metrics present in the source are overwritten and the others are kept.
+//
+// In dry run mode nothing is written, the report only shows what would be done.
+func (uc *MigrateUsecase) Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	report := &Report{
+		DryRun: opts.DryRun,
+	}
+
+	err := uc.destination.RangeMetrics(ctx, func(models.Metric) error {
+		report.Existing++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination metrics: %w", err)
+	}
+
+	if report.Existing > 0 && !opts.Overwrite && !opts.DryRun {
+		return nil, fmt.Errorf("%w: it holds %d metrics", ErrDestinationNotEmpty, report.Existing)
+	}
+
+	var writeErr error
+	batch := make([]models.Metric, 0, opts.BatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if !opts.DryRun {
+			if writeErr = uc.writeBatch(ctx, batch, report); writeErr != nil {
+				return writeErr
+			}
+		}
+
+		report.Batches++
+		batch = make([]models.Metric, 0, opts.BatchSize)
+
+		return nil
+	}
+
+	err = uc.source.RangeMetrics(ctx, func(metric models.Metric) error {
+		switch metric.Type() {
+		case models.GaugeType:
+			report.Gauges++
+
+		case models.CounterType:
+			report.Counters++
+			if _, ok := metric.Value().(int64); !ok {
+				return fmt.Errorf("counter %s: %w", metric.Name(), models.ErrInvalidValueType)
+			}
+
+		default:
+			return fmt.Errorf("metric %s: %w", metric.Name(), models.ErrInvalidMetricsType)
+		}
+
+		batch = append(batch, metric)
+		if len(batch) < opts.BatchSize {
+			return nil
+		}
+
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	switch {
+	case writeErr != nil:
+		return report, writeErr
+	case err != nil:
+		return nil, fmt.Errorf("failed to read source metrics: %w", err)
+	}
+
+	if len(report.Mismatches) > 0 {
+		return report, fmt.Errorf("%w: %d of %d metrics differ",
+			ErrVerificationFailed, len(report.Mismatches), report.Written)
+	}
+
+	return report, nil
+}
+
+// writeBatch writes a batch to the destination and verifies it.
+func (uc *MigrateUsecase) writeBatch(ctx context.Context, batch []models.Metric, report *Report) error {
+	keys := make([]server.MetricKey, 0, len(batch))
+	for _, metric := range batch {
+		keys = append(keys, server.MetricKey{Type: metric.Type(), Name: metric.Name()})
+	}
+
+	expected := make(map[server.MetricKey]any, len(batch))
+	for i, metric := range batch {
+		expected[keys[i]] = metric.Value()
+	}
+
+	if err := uc.destination.SetMetricList(ctx, batch); err != nil {
+		return fmt.Errorf("failed to write batch %d: %w", report.Batches+1, err)
+	}
+
+	report.Written += len(batch)
+
+	return uc.verify(ctx, keys, expected, report)
+}
+
+// verify compares the written metrics of the destination with the expected values.
+func (uc *MigrateUsecase) verify(ctx context.Context, keys []server.MetricKey, expected map[server.MetricKey]any, report *Report) error {
+	after, err := uc.destination.GetMetrics(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read destination metrics for verification: %w", err)
+	}
+
+	actual := make(map[server.MetricKey]any, len(after))
+	for _, metric := range after {
+		actual[server.MetricKey{Type: metric.Type(), Name: metric.Name()}] = metric.Value()
+	}
+
+	for _, key := range keys {
+		want := expected[key]
+		got, ok := actual[key]
+		if !ok || got != want {
+			report.Mismatches = append(report.Mismatches, Mismatch{
+				Type:     key.Type,
+				Name:     key.Name,
+				Expected: want,
+				Actual:   got,
+			})
+			continue
+		}
+
+		report.Verified++
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/migrate"
+	migrateMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/migrate"
+)
+
+func sourceMetrics() []models.Metric {
+	return []models.Metric{
+		models.NewGauge("Alloc", 124.2),
+		models.NewCounter("PollCount", 100),
+		models.NewGauge("RandomValue", 44.2),
+	}
+}
+
+// rangeOver returns a RangeMetrics implementation walking metrics.
+func rangeOver(metrics []models.Metric) func(context.Context, func(models.Metric) error) error {
+	return func(_ context.Context, fn func(models.Metric) error) error {
+		for _, metric := range metrics {
+			if err := fn(metric); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestMigrateUsecase_Run(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("TestMigrateUsecase_Run_empty_destination", func(t *testing.T) {
+		src := repo.NewMemStorage()
+		require.NoError(t, src.UpdateMetricList(ctx, sourceMetrics()))
+		dst := repo.NewMemStorage()
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{BatchSize: 2})
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, report.Total())
+		assert.Equal(t, 2, report.Gauges)
+		assert.Equal(t, 1, report.Counters)
+		assert.Equal(t, 3, report.Written)
+		assert.Equal(t, 2, report.Batches)
+		assert.Equal(t, 3, report.Verified)
+		assert.Empty(t, report.Mismatches)
+
+		metric, err := dst.GetMetric(ctx, models.CounterType, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), metric.Value())
+	})
+
+	t.Run("TestMigrateUsecase_Run_refuses_non_empty_destination", func(t *testing.T) {
+		src := repo.NewMemStorage()
+		require.NoError(t, src.UpdateMetricList(ctx, sourceMetrics()))
+		dst := repo.NewMemStorage()
+		require.NoError(t, dst.UpdateMetric(ctx, models.CounterType, "PollCount", int64(5)))
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{})
+		require.ErrorIs(t, err, migrate.ErrDestinationNotEmpty)
+		assert.Nil(t, report)
+
+		metric, err := dst.GetMetric(ctx, models.CounterType, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), metric.Value())
+	})
+
+	t.Run("TestMigrateUsecase_Run_overwrite", func(t *testing.T) {
+		src := repo.NewMemStorage()
+		require.NoError(t, src.UpdateMetricList(ctx, sourceMetrics()))
+		dst := repo.NewMemStorage()
+		require.NoError(t, dst.UpdateMetricList(ctx, []models.Metric{
+			models.NewCounter("PollCount", 5),
+			models.NewCounter("Other", 7),
+		}))
+
+		// Running the migration twice doesn't add the counters up.
+		for range 2 {
+			report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{Overwrite: true})
+			require.NoError(t, err)
+			assert.Equal(t, 3, report.Verified)
+		}
+
+		metric, err := dst.GetMetric(ctx, models.CounterType, "PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), metric.Value())
+
+		metric, err = dst.GetMetric(ctx, models.CounterType, "Other")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), metric.Value())
+	})
+
+	t.Run("TestMigrateUsecase_Run_dry_run", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		src := migrateMocks.NewMockMetricRanger(ctrl)
+		dst := migrateMocks.NewMockDestination(ctrl)
+
+		src.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(sourceMetrics()))
+		dst.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(sourceMetrics()[:1]))
+		dst.EXPECT().GetMetrics(gomock.Any(), gomock.Any()).Times(0)
+		dst.EXPECT().SetMetricList(gomock.Any(), gomock.Any()).Times(0)
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{DryRun: true, BatchSize: 2})
+		require.NoError(t, err)
+
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 1, report.Existing)
+		assert.Equal(t, 3, report.Total())
+		assert.Equal(t, 0, report.Written)
+		assert.Equal(t, 2, report.Batches)
+	})
+
+	t.Run("TestMigrateUsecase_Run_streams_batches", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		src := migrateMocks.NewMockMetricRanger(ctrl)
+		dst := migrateMocks.NewMockDestination(ctrl)
+		metrics := sourceMetrics()
+
+		// Every batch is written before the next metric is read.
+		var read int
+		src.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(
+			func(_ context.Context, fn func(models.Metric) error) error {
+				for _, metric := range metrics {
+					read++
+					if err := fn(metric); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		dst.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(nil))
+		dst.EXPECT().GetMetrics(ctx, gomock.Any()).Return(metrics[:2], nil)
+		dst.EXPECT().GetMetrics(ctx, gomock.Any()).Return(metrics[2:], nil)
+		gomock.InOrder(
+			dst.EXPECT().SetMetricList(ctx, metrics[:2]).Do(func(context.Context, []models.Metric) {
+				assert.Equal(t, 2, read)
+			}).Return(nil),
+			dst.EXPECT().SetMetricList(ctx, metrics[2:]).Do(func(context.Context, []models.Metric) {
+				assert.Equal(t, 3, read)
+			}).Return(nil),
+		)
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{BatchSize: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Batches)
+		assert.Equal(t, 3, report.Verified)
+	})
+
+	t.Run("TestMigrateUsecase_Run_write_error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		src := migrateMocks.NewMockMetricRanger(ctrl)
+		dst := migrateMocks.NewMockDestination(ctrl)
+		errWrite := errors.New("write failed")
+
+		src.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(sourceMetrics()))
+		dst.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(nil))
+		dst.EXPECT().SetMetricList(ctx, gomock.Any()).Return(errWrite)
+
+		_, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{})
+		assert.ErrorIs(t, err, errWrite)
+	})
+
+	t.Run("TestMigrateUsecase_Run_read_error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		src := migrateMocks.NewMockMetricRanger(ctrl)
+		dst := migrateMocks.NewMockDestination(ctrl)
+		errRead := errors.New("read failed")
+
+		src.EXPECT().RangeMetrics(ctx, gomock.Any()).Return(errRead)
+		dst.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(nil))
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{})
+		assert.ErrorIs(t, err, errRead)
+		assert.Nil(t, report)
+	})
+
+	t.Run("TestMigrateUsecase_Run_verification_mismatch", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		src := migrateMocks.NewMockMetricRanger(ctrl)
+		dst := migrateMocks.NewMockDestination(ctrl)
+
+		src.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(sourceMetrics()))
+		dst.EXPECT().RangeMetrics(ctx, gomock.Any()).DoAndReturn(rangeOver(nil))
+		gomock.InOrder(
+			dst.EXPECT().SetMetricList(ctx, gomock.Any()).Return(nil),
+			dst.EXPECT().GetMetrics(ctx, gomock.Any()).Return([]models.Metric{
+				models.NewGauge("Alloc", 124.2),
+				models.NewCounter("PollCount", 99),
+			}, nil),
+		)
+
+		report, err := migrate.NewMigrateUsecase(src, dst).Run(ctx, migrate.Options{})
+		require.ErrorIs(t, err, migrate.ErrVerificationFailed)
+
+		assert.Equal(t, 1, report.Verified)
+		assert.Len(t, report.Mismatches, 2)
+	})
+}
@@ -0,0 +1,462 @@
+package otlp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/cumulative"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+)
+
+const (
+	// BoundLabel is the label holding the upper bound of a histogram bucket.
+	BoundLabel = "le"
+	// MaxReportedErrors is the number of distinct errors in a partial
+	// success message.
+	MaxReportedErrors = 10
+)
+
+var (
+	ErrUnsupportedType        = errors.New("unsupported metric type")
+	ErrUnsupportedTemporality = errors.New("unsupported aggregation temporality")
+	ErrInvalidDataPoint       = errors.New("invalid data point")
+)
+
+// Result summarizes an export request.
+type Result struct {
+	// DataPoints is the number of stored data points.
+	DataPoints int
+	Gauges     int
+	Counters   int
+	// Rejected is the number of rejected data points, Errors are the
+	// first distinct reasons.
+	Rejected int64
+	Errors   []string
+}
+
+func (r *Result) reject(points int, metric string, err error) {
+	if points == 0 {
+		return
+	}
+	r.Rejected += int64(points)
+
+	msg := fmt.Sprintf("metric %q: %v", metric, err)
+	if len(r.Errors) >= MaxReportedErrors {
+		return
+	}
+	for _, reported := range r.Errors {
+		if reported == msg {
+			return
+		}
+	}
+	r.Errors = append(r.Errors, msg)
+}
+
+// Response returns the export response, with the partial success if any
+// data point was rejected.
+func (r *Result) Response() *otlppb.ExportMetricsServiceResponse {
+	resp := &otlppb.ExportMetricsServiceResponse{}
+	if r.Rejected > 0 {
+		resp.PartialSuccess = &otlppb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: r.Rejected,
+			ErrorMessage:       strings.Join(r.Errors, "; "),
+		}
+	}
+
+	return resp
+}
+
+// OTLPUsecase applies OTLP metrics export requests to the storage.
+//
+// The OTLP data points are mapped to the stored types as follows:
+//
+//   - Gauge and non-monotonic cumulative Sum: a gauge with the latest value;
+//   - monotonic Sum: a counter. Cumulative sums are turned into increases
+//     by a cumulative.Tracker, delta sums are added up. Double values are
+//     rounded;
+//   - Histogram: the NAME_count and NAME_bucket{le="..."} counters, as
+//     Prometheus histograms, and the NAME_sum, NAME_min and NAME_max gauges.
+//
+// The resource and data point attributes are the labels of a series, the
+// data point attributes win. Exponential histograms, summaries and delta
+// non-monotonic sums are rejected.
+type OTLPUsecase struct {
+	store MetricStore
+
+	// mutex serializes the exports, so the increases of a series are
+	// computed and stored in order.
+	mutex    sync.Mutex
+	counters *cumulative.Tracker
+}
+
+func NewOTLPUsecase(store MetricStore) *OTLPUsecase {
+	return &OTLPUsecase{
+		store:    store,
+		counters: cumulative.NewTracker(store, cumulative.DefaultTTL),
+	}
+}
+
+// Export converts the data points of the request into metrics and stores
+// them with a single UpdateMetricList call. Invalid and unsupported data
+// points are rejected and reported in the result; the error is returned
+// only if the metrics can't be stored, the request can be retried then.
+func (uc *OTLPUsecase) Export(ctx context.Context, req *otlppb.ExportMetricsServiceRequest) (*Result, error) {
+	b := newBuilder()
+
+	for _, rm := range req.GetResourceMetrics() {
+		resource := attributeLabels(nil, rm.GetResource().GetAttributes())
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				b.addMetric(resource, metric)
+			}
+		}
+	}
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	counters := uc.counters.NewBatch(ctx)
+	metrics := b.metrics(counters)
+
+	if len(metrics) > 0 {
+		if err := uc.store.UpdateMetricList(ctx, metrics); err != nil {
+			return nil, fmt.Errorf("failed to store metrics: %w", err)
+		}
+	}
+
+	// Remember the values only once they are stored, so a failed
+	// request retried by the exporter is applied again.
+	counters.Commit()
+
+	return b.result, nil
+}
+
+type seriesKind int
+
+const (
+	gaugeSeries seriesKind = iota
+	cumulativeSeries
+	deltaSeries
+)
+
+type timedValue struct {
+	time  uint64
+	value float64
+}
+
+// series collects the values of one stored metric over the request.
+type series struct {
+	id     string
+	kind   seriesKind
+	values []timedValue
+}
+
+type seriesKey struct {
+	kind seriesKind
+	id   string
+}
+
+type builder struct {
+	result *Result
+	series map[seriesKey]*series
+	order  []*series
+}
+
+func newBuilder() *builder {
+	return &builder{
+		result: &Result{},
+		series: make(map[seriesKey]*series),
+	}
+}
+
+func (b *builder) add(kind seriesKind, id string, value timedValue) {
+	key := seriesKey{kind: kind, id: id}
+
+	s, ok := b.series[key]
+	if !ok {
+		s = &series{id: id, kind: kind}
+		b.series[key] = s
+		b.order = append(b.order, s)
+	}
+	s.values = append(s.values, value)
+}
+
+func (b *builder) addMetric(resource models.Labels, metric *otlppb.Metric) {
+	name := metric.GetName()
+
+	switch data := metric.GetData().(type) {
+	case *otlppb.Metric_Gauge:
+		for _, point := range data.Gauge.GetDataPoints() {
+			b.addNumber(resource, name, gaugeSeries, point)
+		}
+
+	case *otlppb.Metric_Sum:
+		points := data.Sum.GetDataPoints()
+
+		kind, err := sumKind(data.Sum)
+		if err != nil {
+			b.result.reject(len(points), name, err)
+			return
+		}
+		for _, point := range points {
+			b.addNumber(resource, name, kind, point)
+		}
+
+	case *otlppb.Metric_Histogram:
+		points := data.Histogram.GetDataPoints()
+
+		kind, err := counterKind(data.Histogram.GetAggregationTemporality())
+		if err != nil {
+			b.result.reject(len(points), name, err)
+			return
+		}
+		for _, point := range points {
+			b.addHistogram(resource, name, kind, point)
+		}
+
+	case *otlppb.Metric_ExponentialHistogram:
+		b.result.reject(len(data.ExponentialHistogram.GetDataPoints()), name,
+			fmt.Errorf("%w: exponential histogram", ErrUnsupportedType))
+
+	case *otlppb.Metric_Summary:
+		b.result.reject(len(data.Summary.GetDataPoints()), name,
+			fmt.Errorf("%w: summary", ErrUnsupportedType))
+	}
+}
+
+func sumKind(sum *otlppb.Sum) (seriesKind, error) {
+	if sum.GetIsMonotonic() {
+		return counterKind(sum.GetAggregationTemporality())
+	}
+
+	// A cumulative non-monotonic sum is the current value of an up-down
+	// counter, a delta one would need the stored value to be changed.
+	if sum.GetAggregationTemporality() != otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		return 0, fmt.Errorf("%w: non-monotonic sum must be cumulative", ErrUnsupportedTemporality)
+	}
+
+	return gaugeSeries, nil
+}
+
+func counterKind(temporality otlppb.AggregationTemporality) (seriesKind, error) {
+	switch temporality {
+	case otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		return cumulativeSeries, nil
+	case otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		return deltaSeries, nil
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrUnsupportedTemporality, temporality)
+	}
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(otlppb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+func (b *builder) addNumber(resource models.Labels, name string, kind seriesKind, point *otlppb.NumberDataPoint) {
+	if noRecordedValue(point.GetFlags()) {
+		return
+	}
+
+	if name == "" {
+		b.result.reject(1, name, fmt.Errorf("%w: empty metric name", ErrInvalidDataPoint))
+		return
+	}
+
+	var value float64
+	switch v := point.GetValue().(type) {
+	case *otlppb.NumberDataPoint_AsDouble:
+		value = v.AsDouble
+	case *otlppb.NumberDataPoint_AsInt:
+		value = float64(v.AsInt)
+	default:
+		b.result.reject(1, name, fmt.Errorf("%w: no value", ErrInvalidDataPoint))
+		return
+	}
+
+	if err := checkValue(kind, value); err != nil {
+		b.result.reject(1, name, err)
+		return
+	}
+
+	labels := attributeLabels(resource, point.GetAttributes())
+	b.add(kind, models.JoinName(name, labels), timedValue{time: point.GetTimeUnixNano(), value: value})
+	b.result.DataPoints++
+}
+
+func (b *builder) addHistogram(resource models.Labels, name string, kind seriesKind, point *otlppb.HistogramDataPoint) {
+	if noRecordedValue(point.GetFlags()) {
+		return
+	}
+
+	if name == "" {
+		b.result.reject(1, name, fmt.Errorf("%w: empty metric name", ErrInvalidDataPoint))
+		return
+	}
+
+	bounds, counts := point.GetExplicitBounds(), point.GetBucketCounts()
+	if len(counts) != 0 && len(counts) != len(bounds)+1 {
+		b.result.reject(1, name, fmt.Errorf("%w: %d bucket counts for %d bounds", ErrInvalidDataPoint, len(counts), len(bounds)))
+		return
+	}
+
+	for _, value := range []float64{point.GetSum(), point.GetMin(), point.GetMax()} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			b.result.reject(1, name, fmt.Errorf("%w: invalid value %v", ErrInvalidDataPoint, value))
+			return
+		}
+	}
+
+	labels := attributeLabels(resource, point.GetAttributes())
+	at := point.GetTimeUnixNano()
+
+	b.add(kind, models.JoinName(name+"_count", labels), timedValue{time: at, value: float64(point.GetCount())})
+
+	var total uint64
+	for i, count := range counts {
+		total += count
+
+		bound := "+Inf"
+		if i < len(bounds) {
+			bound = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+
+		bucket := make(models.Labels, len(labels)+1)
+		for key, value := range labels {
+			bucket[key] = value
+		}
+		bucket[BoundLabel] = bound
+
+		b.add(kind, models.JoinName(name+"_bucket", bucket), timedValue{time: at, value: float64(total)})
+	}
+
+	if point.Sum != nil {
+		b.add(gaugeSeries, models.JoinName(name+"_sum", labels), timedValue{time: at, value: point.GetSum()})
+	}
+	if point.Min != nil {
+		b.add(gaugeSeries, models.JoinName(name+"_min", labels), timedValue{time: at, value: point.GetMin()})
+	}
+	if point.Max != nil {
+		b.add(gaugeSeries, models.JoinName(name+"_max", labels), timedValue{time: at, value: point.GetMax()})
+	}
+
+	b.result.DataPoints++
+}
+
+func checkValue(kind seriesKind, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: invalid value %v", ErrInvalidDataPoint, value)
+	}
+	if kind != gaugeSeries && value < 0 {
+		return fmt.Errorf("%w: negative counter value %v", ErrInvalidDataPoint, value)
+	}
+
+	return nil
+}
+
+// metrics returns the metrics of the collected series, in the order they
+// first appeared in the request.
+func (b *builder) metrics(counters *cumulative.Batch) []models.Metric {
+	metrics := make([]models.Metric, 0, len(b.order))
+
+	for _, s := range b.order {
+		sort.SliceStable(s.values, func(i, j int) bool {
+			return s.values[i].time < s.values[j].time
+		})
+
+		switch s.kind {
+		case gaugeSeries:
+			metrics = append(metrics, models.NewGauge(s.id, s.values[len(s.values)-1].value))
+			b.result.Gauges++
+			continue
+		case cumulativeSeries:
+			totals := make([]int64, len(s.values))
+			for i, v := range s.values {
+				totals[i] = int64(math.Round(v.value))
+			}
+			metrics = append(metrics, models.NewCounter(s.id, counters.Increase(s.id, totals)))
+		case deltaSeries:
+			var sum float64
+			for _, v := range s.values {
+				sum += v.value
+			}
+			metrics = append(metrics, models.NewCounter(s.id, int64(math.Round(sum))))
+		}
+		b.result.Counters++
+	}
+
+	return metrics
+}
+
+// attributeLabels returns the labels with the attributes added.
+func attributeLabels(labels models.Labels, attributes []*otlppb.KeyValue) models.Labels {
+	result := make(models.Labels, len(labels)+len(attributes))
+	for key, value := range labels {
+		result[key] = value
+	}
+
+	for _, attr := range attributes {
+		if attr.GetKey() == "" {
+			continue
+		}
+		result[attr.GetKey()] = attributeValue(attr.GetValue())
+	}
+
+	return result
+}
+
+// attributeValue renders an attribute value as a label value. Arrays and
+// key-value lists are rendered as JSON.
+func attributeValue(value *otlppb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *otlppb.AnyValue_StringValue:
+		return v.StringValue
+	case *otlppb.AnyValue_ArrayValue, *otlppb.AnyValue_KvlistValue:
+		data, err := json.Marshal(anyValue(value))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(anyValue(value))
+	}
+}
+
+func anyValue(value *otlppb.AnyValue) any {
+	switch v := value.GetValue().(type) {
+	case *otlppb.AnyValue_StringValue:
+		return v.StringValue
+	case *otlppb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *otlppb.AnyValue_IntValue:
+		return v.IntValue
+	case *otlppb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *otlppb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *otlppb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, anyValue(item))
+		}
+		return values
+	case *otlppb.AnyValue_KvlistValue:
+		values := make(map[string]any, len(v.KvlistValue.GetValues()))
+		for _, kv := range v.KvlistValue.GetValues() {
+			values[kv.GetKey()] = anyValue(kv.GetValue())
+		}
+		return values
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,191 @@
+package otlp_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/otlp"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/otlppb"
+	otlpMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/otlp"
+)
+
+func request(resource []*otlppb.KeyValue, metrics ...*otlppb.Metric) *otlppb.ExportMetricsServiceRequest {
+	return &otlppb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*otlppb.ResourceMetrics{{
+			Resource:     &otlppb.Resource{Attributes: resource},
+			ScopeMetrics: []*otlppb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func attr(key, value string) *otlppb.KeyValue {
+	return &otlppb.KeyValue{Key: key, Value: &otlppb.AnyValue{Value: &otlppb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intPoint(at uint64, value int64, attributes ...*otlppb.KeyValue) *otlppb.NumberDataPoint {
+	return &otlppb.NumberDataPoint{TimeUnixNano: at, Value: &otlppb.NumberDataPoint_AsInt{AsInt: value}, Attributes: attributes}
+}
+
+func doublePoint(at uint64, value float64, attributes ...*otlppb.KeyValue) *otlppb.NumberDataPoint {
+	return &otlppb.NumberDataPoint{TimeUnixNano: at, Value: &otlppb.NumberDataPoint_AsDouble{AsDouble: value}, Attributes: attributes}
+}
+
+func sum(name string, temporality otlppb.AggregationTemporality, monotonic bool, points ...*otlppb.NumberDataPoint) *otlppb.Metric {
+	return &otlppb.Metric{Name: name, Data: &otlppb.Metric_Sum{Sum: &otlppb.Sum{
+		AggregationTemporality: temporality,
+		IsMonotonic:            monotonic,
+		DataPoints:             points,
+	}}}
+}
+
+func gauge(name string, points ...*otlppb.NumberDataPoint) *otlppb.Metric {
+	return &otlppb.Metric{Name: name, Data: &otlppb.Metric_Gauge{Gauge: &otlppb.Gauge{DataPoints: points}}}
+}
+
+const (
+	delta      = otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	cumulative = otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+)
+
+func TestOTLPUsecase_Export(t *testing.T) {
+	ctx := context.Background()
+
+	value := func(t *testing.T, storage *repo.MemStorage, mType, name string) any {
+		t.Helper()
+
+		metric, err := storage.GetMetric(ctx, mType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	t.Run("TestOTLPUsecase_Export_types", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := otlp.NewOTLPUsecase(storage)
+
+		resource := []*otlppb.KeyValue{attr("service.name", "api"), attr("host", "resource")}
+
+		result, err := uc.Export(ctx, request(resource,
+			gauge("load", doublePoint(2, 0.7, attr("host", "web-1")), doublePoint(1, 0.5, attr("host", "web-1"))),
+			sum("requests", cumulative, true, intPoint(1, 5), intPoint(2, 8)),
+			sum("jobs", delta, true, doublePoint(1, 1.5), doublePoint(2, 1)),
+			sum("connections", cumulative, false, intPoint(1, -3)),
+			sum("idle", cumulative, true, &otlppb.NumberDataPoint{
+				Flags: uint32(otlppb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK),
+			}),
+		))
+		require.NoError(t, err)
+		assert.Equal(t, &otlp.Result{DataPoints: 7, Gauges: 2, Counters: 2}, result)
+		assert.Nil(t, result.Response().GetPartialSuccess())
+
+		// The data point attributes win over the resource ones.
+		assert.Equal(t, 0.7, value(t, storage, models.GaugeType, `load{host="web-1",service.name="api"}`))
+		assert.Equal(t, int64(8), value(t, storage, models.CounterType, `requests{host="resource",service.name="api"}`))
+		assert.Equal(t, int64(3), value(t, storage, models.CounterType, `jobs{host="resource",service.name="api"}`))
+		assert.Equal(t, -3.0, value(t, storage, models.GaugeType, `connections{host="resource",service.name="api"}`))
+
+		// A cumulative sum continues from the last value.
+		_, err = uc.Export(ctx, request(resource, sum("requests", cumulative, true, intPoint(3, 10))))
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), value(t, storage, models.CounterType, `requests{host="resource",service.name="api"}`))
+	})
+
+	t.Run("TestOTLPUsecase_Export_histogram", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := otlp.NewOTLPUsecase(storage)
+
+		sumValue, maxValue := 12.5, 9.0
+		result, err := uc.Export(ctx, request(nil, &otlppb.Metric{
+			Name: "duration",
+			Data: &otlppb.Metric_Histogram{Histogram: &otlppb.Histogram{
+				AggregationTemporality: cumulative,
+				DataPoints: []*otlppb.HistogramDataPoint{{
+					Attributes:     []*otlppb.KeyValue{attr("route", "/")},
+					Count:          4,
+					Sum:            &sumValue,
+					Max:            &maxValue,
+					ExplicitBounds: []float64{0.5, 1},
+					BucketCounts:   []uint64{1, 2, 1},
+				}},
+			}},
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, &otlp.Result{DataPoints: 1, Gauges: 2, Counters: 4}, result)
+
+		assert.Equal(t, int64(4), value(t, storage, models.CounterType, `duration_count{route="/"}`))
+		assert.Equal(t, int64(1), value(t, storage, models.CounterType, `duration_bucket{le="0.5",route="/"}`))
+		assert.Equal(t, int64(3), value(t, storage, models.CounterType, `duration_bucket{le="1",route="/"}`))
+		assert.Equal(t, int64(4), value(t, storage, models.CounterType, `duration_bucket{le="+Inf",route="/"}`))
+		assert.Equal(t, 12.5, value(t, storage, models.GaugeType, `duration_sum{route="/"}`))
+		assert.Equal(t, 9.0, value(t, storage, models.GaugeType, `duration_max{route="/"}`))
+	})
+
+	t.Run("TestOTLPUsecase_Export_partial_success", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := otlp.NewOTLPUsecase(storage)
+
+		result, err := uc.Export(ctx, request(nil,
+			gauge("ok", intPoint(1, 1)),
+			gauge("nan", doublePoint(1, math.NaN()), doublePoint(2, math.NaN())),
+			sum("queue", delta, false, intPoint(1, 1)),
+			sum("requests", cumulative, true, intPoint(1, -1)),
+			gauge("", intPoint(1, 1)),
+			&otlppb.Metric{Name: "summary", Data: &otlppb.Metric_Summary{Summary: &otlppb.Summary{
+				DataPoints: []*otlppb.UnsupportedDataPoint{{}},
+			}}},
+			&otlppb.Metric{Name: "buckets", Data: &otlppb.Metric_Histogram{Histogram: &otlppb.Histogram{
+				AggregationTemporality: delta,
+				DataPoints:             []*otlppb.HistogramDataPoint{{ExplicitBounds: []float64{1}, BucketCounts: []uint64{1}}},
+			}}},
+		))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.DataPoints)
+		assert.Equal(t, int64(7), result.Rejected)
+		// The rejected points of the same metric for the same reason are reported once.
+		assert.Len(t, result.Errors, 6)
+
+		partial := result.Response().GetPartialSuccess()
+		require.NotNil(t, partial)
+		assert.Equal(t, int64(7), partial.GetRejectedDataPoints())
+		assert.Contains(t, partial.GetErrorMessage(), `metric "summary": unsupported metric type: summary`)
+		assert.Contains(t, partial.GetErrorMessage(), `metric "queue": unsupported aggregation temporality`)
+
+		assert.Equal(t, 1.0, value(t, storage, models.GaugeType, "ok"))
+	})
+
+	t.Run("TestOTLPUsecase_Export_retry_after_failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := otlpMocks.NewMockMetricStore(ctrl)
+		uc := otlp.NewOTLPUsecase(store)
+
+		// The failed export didn't remember the value, so the retry reads
+		// the stored counter again.
+		store.EXPECT().GetMetric(ctx, models.CounterType, "requests").Return(nil, errors.New("not found")).Times(2)
+		gomock.InOrder(
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("requests", 5)}).Return(errors.New("db is down")),
+			// The failed export is retried with the same increase.
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("requests", 5)}).Return(nil),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("requests", 2)}).Return(nil),
+		)
+
+		req := request(nil, sum("requests", cumulative, true, intPoint(1, 5)))
+
+		_, err := uc.Export(ctx, req)
+		require.Error(t, err)
+
+		_, err = uc.Export(ctx, req)
+		require.NoError(t, err)
+
+		_, err = uc.Export(ctx, request(nil, sum("requests", cumulative, true, intPoint(2, 7))))
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,11 @@
+package query
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+type MetricGetter interface {
+	GetAllMetrics(ctx context.Context) ([]models.Metric, error)
+}
@@ -0,0 +1,425 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+// ValueType is the type of a query result.
+type ValueType string
+
+const (
+	ScalarType ValueType = "scalar"
+	VectorType ValueType = "vector"
+)
+
+// Sample is a value of a vector.
+type Sample struct {
+	// Name is the metric name without labels. It is empty for samples
+	// computed from several metrics: by an operation of two vectors or
+	// an aggregation not grouped by __name__.
+	Name   string
+	Labels models.Labels
+	Value  float64
+}
+
+// ID returns the metric name of the sample with its labels.
+func (s Sample) ID() string {
+	return models.JoinName(s.Name, s.Labels)
+}
+
+// Result is the value of a query.
+type Result struct {
+	Type ValueType
+	// Scalar is the value of a scalar result.
+	Scalar float64
+	// Vector are the samples of a vector result, ordered by ID, or by
+	// value from the highest one if the query is a topk.
+	Vector []Sample
+}
+
+// value is an intermediate result: a scalar, or a vector if isVector.
+type value struct {
+	isVector bool
+	scalar   float64
+	vector   []Sample
+}
+
+// eval evaluates the query over the metrics.
+func eval(expr Expr, metrics []models.Metric) (*Result, error) {
+	v, err := evaluate(expr, metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.isVector {
+		return &Result{Type: ScalarType, Scalar: v.scalar}, nil
+	}
+
+	if agg, ok := expr.(*aggregateExpr); !ok || agg.op != "topk" {
+		sort.SliceStable(v.vector, func(i, j int) bool {
+			return v.vector[i].ID() < v.vector[j].ID()
+		})
+	}
+	if v.vector == nil {
+		v.vector = []Sample{}
+	}
+
+	return &Result{Type: VectorType, Vector: v.vector}, nil
+}
+
+func evaluate(expr Expr, metrics []models.Metric) (value, error) {
+	switch e := expr.(type) {
+	case *numberExpr:
+		return value{scalar: e.value}, nil
+
+	case *selectorExpr:
+		return evalSelector(e, metrics)
+
+	case *unaryExpr:
+		v, err := evaluate(e.expr, metrics)
+		if err != nil {
+			return value{}, err
+		}
+		if !v.isVector {
+			return value{scalar: -v.scalar}, nil
+		}
+		for i := range v.vector {
+			v.vector[i].Value = -v.vector[i].Value
+		}
+		return v, nil
+
+	case *binaryExpr:
+		return evalBinary(e, metrics)
+
+	case *aggregateExpr:
+		return evalAggregate(e, metrics)
+
+	default:
+		return value{}, errorAt(expr.pos(), "unknown expression %s", expr)
+	}
+}
+
+func evalSelector(e *selectorExpr, metrics []models.Metric) (value, error) {
+	v := value{isVector: true}
+
+	for _, metric := range metrics {
+		base, labels := models.SplitName(metric.Name())
+		if e.name != "" {
+			if ok, _ := path.Match(e.name, base); !ok {
+				continue
+			}
+		}
+
+		matched := true
+		for _, m := range e.matchers {
+			if !m.matches(base, metric.Type(), labels) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		var sample float64
+		switch val := metric.Value().(type) {
+		case float64:
+			sample = val
+		case int64:
+			sample = float64(val)
+		default:
+			return value{}, fmt.Errorf("%w: %s has %T", models.ErrInvalidValueType, metric.Name(), val)
+		}
+
+		v.vector = append(v.vector, Sample{Name: base, Labels: labels, Value: sample})
+	}
+
+	return v, nil
+}
+
+// matches reports whether the metric matches. A label the metric doesn't
+// have matches no glob, so "!=" selects the metrics without it too.
+func (m matcher) matches(base, mType string, labels models.Labels) bool {
+	var (
+		label string
+		ok    bool
+	)
+	switch m.label {
+	case LabelName:
+		label, ok = base, true
+	case LabelType:
+		label, ok = mType, true
+	default:
+		label, ok = labels[m.label]
+	}
+
+	matched := false
+	if ok {
+		matched, _ = path.Match(m.pattern, label)
+	}
+
+	return matched != m.negate
+}
+
+// evalBinary applies an arithmetic operator.
+//
+// An operation of a vector and a scalar applies to every sample and keeps
+// its name and labels. An operation of two vectors applies to the samples
+// with the same labels, whatever their names, and drops the samples
+// without a pair; the result has the labels and no name.
+//
+// A division by zero is an error for scalars, while the samples whose
+// result is not a finite number are dropped from a vector.
+func evalBinary(e *binaryExpr, metrics []models.Metric) (value, error) {
+	lhs, err := evaluate(e.lhs, metrics)
+	if err != nil {
+		return value{}, err
+	}
+
+	rhs, err := evaluate(e.rhs, metrics)
+	if err != nil {
+		return value{}, err
+	}
+
+	switch {
+	case !lhs.isVector && !rhs.isVector:
+		if (e.op == tokenDiv || e.op == tokenMod) && rhs.scalar == 0 {
+			return value{}, errorAt(e.offset, "division by zero")
+		}
+
+		result := apply(e.op, lhs.scalar, rhs.scalar)
+		if !isFinite(result) {
+			return value{}, errorAt(e.offset, "result is not a finite number")
+		}
+		return value{scalar: result}, nil
+
+	case lhs.isVector && !rhs.isVector:
+		return mapVector(lhs.vector, func(s float64) float64 { return apply(e.op, s, rhs.scalar) }), nil
+
+	case !lhs.isVector && rhs.isVector:
+		return mapVector(rhs.vector, func(s float64) float64 { return apply(e.op, lhs.scalar, s) }), nil
+	}
+
+	right, err := indexByLabels(rhs.vector, e, "right")
+	if err != nil {
+		return value{}, err
+	}
+	if _, err := indexByLabels(lhs.vector, e, "left"); err != nil {
+		return value{}, err
+	}
+
+	result := value{isVector: true}
+	for _, l := range lhs.vector {
+		r, ok := right[models.JoinName("", l.Labels)]
+		if !ok {
+			continue
+		}
+
+		if s := apply(e.op, l.Value, r.Value); isFinite(s) {
+			result.vector = append(result.vector, Sample{Labels: l.Labels, Value: s})
+		}
+	}
+
+	return result, nil
+}
+
+// indexByLabels indexes the samples of an operand of two vectors by their
+// labels, which must identify them.
+func indexByLabels(samples []Sample, e *binaryExpr, side string) (map[string]Sample, error) {
+	index := make(map[string]Sample, len(samples))
+	for _, s := range samples {
+		key := models.JoinName("", s.Labels)
+		if other, ok := index[key]; ok {
+			return nil, errorAt(e.offset, "%s and %s on the %s side of %s have the same labels, aggregate them first",
+				other.ID(), s.ID(), side, e.op)
+		}
+		index[key] = s
+	}
+
+	return index, nil
+}
+
+func mapVector(samples []Sample, fn func(float64) float64) value {
+	result := value{isVector: true, vector: make([]Sample, 0, len(samples))}
+	for _, s := range samples {
+		if s.Value = fn(s.Value); isFinite(s.Value) {
+			result.vector = append(result.vector, s)
+		}
+	}
+
+	return result
+}
+
+func apply(op tokenKind, a, b float64) float64 {
+	switch op {
+	case tokenAdd:
+		return a + b
+	case tokenSub:
+		return a - b
+	case tokenMul:
+		return a * b
+	case tokenDiv:
+		return a / b
+	case tokenMod:
+		return math.Mod(a, b)
+	default:
+		return math.NaN()
+	}
+}
+
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// group is the samples of an aggregation with the same grouping labels.
+type group struct {
+	name    string
+	labels  models.Labels
+	samples []Sample
+}
+
+// evalAggregate aggregates the samples of every group: the ones with the
+// same values of the "by" labels, or of all the labels but the "without"
+// ones. Without a grouping all the samples are one group.
+//
+// sum, avg, min, max and count give a sample per group with the grouping
+// labels, and topk gives the k samples with the highest values per group
+// as they are.
+func evalAggregate(e *aggregateExpr, metrics []models.Metric) (value, error) {
+	v, err := evaluate(e.expr, metrics)
+	if err != nil {
+		return value{}, err
+	}
+	if !v.isVector {
+		return value{}, errorAt(e.expr.pos(), "%s expects a vector, got a scalar", e.op)
+	}
+
+	k := 0
+	if e.param != nil {
+		param, err := evaluate(e.param, metrics)
+		if err != nil {
+			return value{}, err
+		}
+		if param.isVector {
+			return value{}, errorAt(e.param.pos(), "the parameter of %s must be a scalar, got a vector", e.op)
+		}
+		k = int(param.scalar)
+	}
+
+	groups := groupSamples(e, v.vector)
+
+	result := value{isVector: true}
+	for _, g := range groups {
+		if e.op == "topk" {
+			result.vector = append(result.vector, topk(g.samples, k)...)
+			continue
+		}
+
+		result.vector = append(result.vector, Sample{
+			Name:   g.name,
+			Labels: g.labels,
+			Value:  aggregate(e.op, g.samples),
+		})
+	}
+
+	return result, nil
+}
+
+// groupSamples returns the groups of the aggregation ordered by their labels.
+func groupSamples(e *aggregateExpr, samples []Sample) []*group {
+	index := make(map[string]*group)
+	var groups []*group
+
+	for _, s := range samples {
+		var name string
+		labels := models.Labels{}
+
+		switch {
+		case e.without:
+			for key, val := range s.Labels {
+				labels[key] = val
+			}
+			for _, key := range e.grouping {
+				delete(labels, key)
+			}
+		default:
+			for _, key := range e.grouping {
+				if key == LabelName {
+					name = s.Name
+					continue
+				}
+				if val, ok := s.Labels[key]; ok {
+					labels[key] = val
+				}
+			}
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		id := models.JoinName(name, labels)
+		g, ok := index[id]
+		if !ok {
+			g = &group{name: name, labels: labels}
+			index[id] = g
+			groups = append(groups, g)
+		}
+		g.samples = append(g.samples, s)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return models.JoinName(groups[i].name, groups[i].labels) < models.JoinName(groups[j].name, groups[j].labels)
+	})
+
+	return groups
+}
+
+func aggregate(op string, samples []Sample) float64 {
+	result := samples[0].Value
+	for _, s := range samples[1:] {
+		switch op {
+		case "sum", "avg":
+			result += s.Value
+		case "min":
+			result = math.Min(result, s.Value)
+		case "max":
+			result = math.Max(result, s.Value)
+		}
+	}
+
+	switch op {
+	case "avg":
+		result /= float64(len(samples))
+	case "count":
+		result = float64(len(samples))
+	}
+
+	return result
+}
+
+// topk returns the k samples with the highest values, from the highest
+// one; samples with equal values are ordered by ID.
+func topk(samples []Sample, k int) []Sample {
+	if k <= 0 {
+		return nil
+	}
+
+	sorted := append([]Sample(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Value != sorted[j].Value {
+			return sorted[i].Value > sorted[j].Value
+		}
+		return sorted[i].ID() < sorted[j].ID()
+	})
+
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+
+	return sorted
+}
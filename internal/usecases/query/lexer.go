@@ -0,0 +1,231 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenName
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBrace
+	tokenRBrace
+	tokenComma
+	tokenEq
+	tokenNeq
+	tokenAdd
+	tokenSub
+	tokenMul
+	tokenDiv
+	tokenMod
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of query"
+	case tokenNumber:
+		return "number"
+	case tokenName:
+		return "name"
+	case tokenString:
+		return "string"
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenLBrace:
+		return `"{"`
+	case tokenRBrace:
+		return `"}"`
+	case tokenComma:
+		return `","`
+	case tokenEq:
+		return `"="`
+	case tokenNeq:
+		return `"!="`
+	case tokenAdd:
+		return `"+"`
+	case tokenSub:
+		return `"-"`
+	case tokenMul:
+		return `"*"`
+	case tokenDiv:
+		return `"/"`
+	case tokenMod:
+		return `"%"`
+	default:
+		return "unknown token"
+	}
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the byte offset of the token in the query.
+	pos int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenNumber, tokenName, tokenString:
+		return fmt.Sprintf("%s %s", t.kind, t.text)
+	default:
+		return t.kind.String()
+	}
+}
+
+// lex splits the query into tokens, the last one is tokenEOF.
+//
+// Names are globs: "*", "?" and "[...]" right after a name character are
+// part of the name, so "Heap*" is a single name and multiplication needs
+// a space before "*" if it follows a name.
+func lex(q string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(q); {
+		c := q[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+
+		case isDigit(c) || (c == '.' && i+1 < len(q) && isDigit(q[i+1])):
+			i = scanNumber(q, i)
+			if i < len(q) && isNameChar(q[i]) {
+				return nil, errorAt(start, "invalid number %q", q[start:i+1])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: q[start:i], pos: start})
+			continue
+
+		case isNameStart(c):
+			end, err := scanName(q, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			tokens = append(tokens, token{kind: tokenName, text: q[start:i], pos: start})
+			continue
+
+		case c == '"':
+			end, err := scanString(q, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			tokens = append(tokens, token{kind: tokenString, text: q[start:i], pos: start})
+			continue
+
+		case c == '!':
+			if i+1 < len(q) && q[i+1] == '=' {
+				tokens = append(tokens, token{kind: tokenNeq, text: "!=", pos: start})
+				i += 2
+				continue
+			}
+			return nil, errorAt(start, `unexpected character "!", did you mean "!="?`)
+		}
+
+		kind, ok := punctuation[c]
+		if !ok {
+			return nil, errorAt(start, "unexpected character %q", rune(c))
+		}
+		tokens = append(tokens, token{kind: kind, text: string(c), pos: start})
+		i++
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(q)}), nil
+}
+
+var punctuation = map[byte]tokenKind{
+	'(': tokenLParen,
+	')': tokenRParen,
+	'{': tokenLBrace,
+	'}': tokenRBrace,
+	',': tokenComma,
+	'=': tokenEq,
+	'+': tokenAdd,
+	'-': tokenSub,
+	'*': tokenMul,
+	'/': tokenDiv,
+	'%': tokenMod,
+}
+
+func scanNumber(q string, i int) int {
+	for i < len(q) && (isDigit(q[i]) || q[i] == '.') {
+		i++
+	}
+
+	if i < len(q) && (q[i] == 'e' || q[i] == 'E') {
+		j := i + 1
+		if j < len(q) && (q[j] == '+' || q[j] == '-') {
+			j++
+		}
+		if j < len(q) && isDigit(q[j]) {
+			i = j
+			for i < len(q) && isDigit(q[i]) {
+				i++
+			}
+		}
+	}
+
+	return i
+}
+
+// scanName returns the end of the name starting at i. A name can't start
+// with "*", the parser joins a leading "*" in place of an operand.
+func scanName(q string, i int) (int, error) {
+	for i < len(q) {
+		switch {
+		case q[i] == '[':
+			end := strings.IndexByte(q[i:], ']')
+			if end < 0 {
+				return 0, errorAt(i, `unterminated character class, missing "]"`)
+			}
+			i += end + 1
+		case isNameChar(q[i]):
+			i++
+		default:
+			return i, nil
+		}
+	}
+
+	return i, nil
+}
+
+func scanString(q string, i int) (int, error) {
+	start := i
+	for i++; i < len(q); i++ {
+		switch q[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+
+	return 0, errorAt(start, "unterminated string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isNameStart(c byte) bool {
+	return isLetter(c) || c == '?' || c == '['
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == ':' || c == '.' || c == '*' || c == '?' || c == '['
+}
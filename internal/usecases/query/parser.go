@@ -0,0 +1,510 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxQueryLength is the longest query Parse accepts, in bytes.
+	MaxQueryLength = 4096
+	// maxDepth limits the nesting of parentheses, aggregations and signs.
+	maxDepth = 64
+)
+
+// Label names with a special meaning in matchers and groupings.
+const (
+	// LabelName is the metric name without labels.
+	LabelName = "__name__"
+	// LabelType is the metric type, gauge or counter.
+	LabelType = "__type__"
+)
+
+// ErrInvalidQuery is wrapped by the errors of queries that can't be parsed
+// or evaluated, as opposed to the errors of reading the metrics.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Error is an error in a query.
+type Error struct {
+	// Pos is the 1-based byte position in the query the error refers to.
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v at position %d: %s", ErrInvalidQuery, e.Pos, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// errorAt returns an Error at the 0-based byte offset.
+func errorAt(offset int, format string, args ...any) *Error {
+	return &Error{Pos: offset + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Expr is a parsed query. String returns it with every binary operation
+// in parentheses, so the order of evaluation is explicit.
+type Expr interface {
+	fmt.Stringer
+	pos() int
+}
+
+type numberExpr struct {
+	offset int
+	value  float64
+}
+
+// selectorExpr selects the metrics whose name without labels matches
+// the name glob and whose labels match the matchers.
+type selectorExpr struct {
+	offset   int
+	name     string
+	matchers []matcher
+}
+
+type matcher struct {
+	label   string
+	pattern string
+	negate  bool
+}
+
+type unaryExpr struct {
+	offset int
+	expr   Expr
+}
+
+type binaryExpr struct {
+	offset int
+	op     tokenKind
+	lhs    Expr
+	rhs    Expr
+}
+
+type aggregateExpr struct {
+	offset int
+	op     string
+	// param is the k of topk.
+	param    Expr
+	grouping []string
+	without  bool
+	expr     Expr
+}
+
+func (e *numberExpr) pos() int    { return e.offset }
+func (e *selectorExpr) pos() int  { return e.offset }
+func (e *unaryExpr) pos() int     { return e.offset }
+func (e *binaryExpr) pos() int    { return e.offset }
+func (e *aggregateExpr) pos() int { return e.offset }
+
+func (e *numberExpr) String() string {
+	return strconv.FormatFloat(e.value, 'g', -1, 64)
+}
+
+func (e *selectorExpr) String() string {
+	if len(e.matchers) == 0 {
+		return e.name
+	}
+
+	parts := make([]string, 0, len(e.matchers))
+	for _, m := range e.matchers {
+		op := "="
+		if m.negate {
+			op = "!="
+		}
+		parts = append(parts, m.label+op+strconv.Quote(m.pattern))
+	}
+
+	return e.name + "{" + strings.Join(parts, ",") + "}"
+}
+
+func (e *unaryExpr) String() string {
+	return "-" + e.expr.String()
+}
+
+func (e *binaryExpr) String() string {
+	return "(" + e.lhs.String() + " " + strings.Trim(e.op.String(), `"`) + " " + e.rhs.String() + ")"
+}
+
+func (e *aggregateExpr) String() string {
+	var b strings.Builder
+	b.WriteString(e.op)
+	if e.grouping != nil {
+		if e.without {
+			b.WriteString(" without (")
+		} else {
+			b.WriteString(" by (")
+		}
+		b.WriteString(strings.Join(e.grouping, ", "))
+		b.WriteString(")")
+	}
+	b.WriteString(" (")
+	if e.param != nil {
+		b.WriteString(e.param.String())
+		b.WriteString(", ")
+	}
+	b.WriteString(e.expr.String())
+	b.WriteString(")")
+
+	return b.String()
+}
+
+// aggregations are the aggregation operators; the ones with a parameter
+// take it before the vector, as topk(3, Heap*).
+var aggregations = map[string]bool{
+	"sum":   false,
+	"avg":   false,
+	"min":   false,
+	"max":   false,
+	"count": false,
+	"topk":  true,
+}
+
+// Parse parses a query:
+//
+//	expr     = term { ("+" | "-") term }
+//	term     = unary { ("*" | "/" | "%") unary }
+//	unary    = "-" unary | primary
+//	primary  = number | "(" expr ")" | aggregate | selector
+//	aggregate = op [grouping] "(" [expr ","] expr ")" [grouping]
+//	grouping = ("by" | "without") "(" [label { "," label }] ")"
+//	selector = name ["{" matchers "}"] | "{" matchers "}"
+//	matchers = label ("=" | "!=") string { "," label ("=" | "!=") string }
+//
+// Names and the strings of matchers are globs, see path.Match. A name
+// that is an aggregation operator is a selector unless "(", "by" or
+// "without" follows it.
+func Parse(q string) (Expr, error) {
+	if len(q) > MaxQueryLength {
+		return nil, errorAt(MaxQueryLength, "query is longer than %d bytes", MaxQueryLength)
+	}
+
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, errorAt(0, "empty query")
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errorAt(t.pos, "unexpected %s, expected an operator", t)
+	}
+
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, context string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, errorAt(t.pos, "unexpected %s %s, expected %s", t, context, kind)
+	}
+
+	return t, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op.kind != tokenAdd && op.kind != tokenSub {
+			return lhs, nil
+		}
+		p.next()
+
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{offset: op.pos, op: op.kind, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *parser) parseTerm() (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op.kind != tokenMul && op.kind != tokenDiv && op.kind != tokenMod {
+			return lhs, nil
+		}
+		p.next()
+
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{offset: op.pos, op: op.kind, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > maxDepth {
+		return nil, errorAt(p.peek().pos, "query is nested deeper than %d levels", maxDepth)
+	}
+
+	if t := p.peek(); t.kind == tokenSub {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{offset: t.pos, expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorAt(t.pos, "invalid number %q", t.text)
+		}
+		return &numberExpr{offset: t.pos, value: value}, nil
+
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "in parentheses"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+
+	case tokenName:
+		if _, ok := aggregations[t.text]; ok {
+			after := p.tokens[p.i+1]
+			if after.kind == tokenLParen || (after.kind == tokenName && (after.text == "by" || after.text == "without")) {
+				return p.parseAggregate()
+			}
+		}
+		p.next()
+		return p.parseSelector(t.pos, t.text)
+
+	case tokenMul:
+		// A leading "*" of a name glob is lexed as the operator.
+		p.next()
+		name := "*"
+		if after := p.peek(); after.kind == tokenName && after.pos == t.pos+1 {
+			p.next()
+			name += after.text
+		}
+		return p.parseSelector(t.pos, name)
+
+	case tokenLBrace:
+		return p.parseSelector(t.pos, "")
+
+	default:
+		return nil, errorAt(t.pos, "unexpected %s, expected a number, a selector or an aggregation", t)
+	}
+}
+
+func (p *parser) parseSelector(offset int, name string) (Expr, error) {
+	if name != "" {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, errorAt(offset, "invalid name glob %q", name)
+		}
+	}
+
+	sel := &selectorExpr{offset: offset, name: name}
+	if p.peek().kind != tokenLBrace {
+		return sel, nil
+	}
+	brace := p.next()
+
+	for p.peek().kind != tokenRBrace {
+		m, err := p.parseMatcher()
+		if err != nil {
+			return nil, err
+		}
+		sel.matchers = append(sel.matchers, m)
+
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokenRBrace, "in label matchers"); err != nil {
+		return nil, err
+	}
+
+	if sel.name == "" && len(sel.matchers) == 0 {
+		return nil, errorAt(brace.pos, "selector needs a name or a label matcher")
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (matcher, error) {
+	label, err := p.parseLabel("in label matchers")
+	if err != nil {
+		return matcher{}, err
+	}
+
+	op := p.next()
+	if op.kind != tokenEq && op.kind != tokenNeq {
+		return matcher{}, errorAt(op.pos, `unexpected %s after label %s, expected "=" or "!="`, op, label)
+	}
+
+	t, err := p.expect(tokenString, "after "+label+op.text)
+	if err != nil {
+		return matcher{}, err
+	}
+
+	pattern, err := strconv.Unquote(t.text)
+	if err != nil {
+		return matcher{}, errorAt(t.pos, "invalid string %s", t.text)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return matcher{}, errorAt(t.pos, "invalid glob %s", t.text)
+	}
+
+	return matcher{label: label, pattern: pattern, negate: op.kind == tokenNeq}, nil
+}
+
+func (p *parser) parseLabel(context string) (string, error) {
+	t := p.next()
+	if t.kind != tokenName || !isLabel(t.text) {
+		return "", errorAt(t.pos, "unexpected %s %s, expected a label name", t, context)
+	}
+
+	return t.text, nil
+}
+
+func (p *parser) parseAggregate() (Expr, error) {
+	t := p.next()
+	agg := &aggregateExpr{offset: t.pos, op: t.text}
+
+	if err := p.parseGrouping(agg); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokenLParen, "after "+agg.op); err != nil {
+		return nil, err
+	}
+
+	if aggregations[agg.op] {
+		param, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		agg.param = param
+
+		if _, err := p.expect(tokenComma, "after the parameter of "+agg.op); err != nil {
+			return nil, err
+		}
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	agg.expr = expr
+
+	if _, err := p.expect(tokenRParen, "in "+agg.op); err != nil {
+		return nil, err
+	}
+
+	if agg.grouping == nil {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+// parseGrouping parses an optional "by (labels)" or "without (labels)".
+func (p *parser) parseGrouping(agg *aggregateExpr) error {
+	t := p.peek()
+	if t.kind != tokenName || (t.text != "by" && t.text != "without") {
+		return nil
+	}
+	p.next()
+
+	if _, err := p.expect(tokenLParen, "after "+t.text); err != nil {
+		return err
+	}
+
+	agg.without = t.text == "without"
+	agg.grouping = []string{}
+	for p.peek().kind != tokenRParen {
+		label, err := p.parseLabel("in " + t.text)
+		if err != nil {
+			return err
+		}
+		if label == LabelType {
+			return errorAt(p.tokens[p.i-1].pos, "%s can only be matched, not grouped by", LabelType)
+		}
+		agg.grouping = append(agg.grouping, label)
+
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(tokenRParen, "in "+t.text)
+
+	return err
+}
+
+func isLabel(s string) bool {
+	if s == "" || isDigit(s[0]) {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isLetter(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
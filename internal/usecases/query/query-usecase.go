@@ -0,0 +1,35 @@
+package query
+
+import (
+	"context"
+	"fmt"
+)
+
+// QueryUsecase evaluates queries over the stored metrics.
+type QueryUsecase struct {
+	getter MetricGetter
+}
+
+func NewQueryUsecase(getter MetricGetter) *QueryUsecase {
+	return &QueryUsecase{
+		getter: getter,
+	}
+}
+
+// Query parses q (see Parse) and evaluates it over the current metrics.
+//
+// Errors in the query wrap ErrInvalidQuery and are an *Error with the
+// position in the query; other errors come from reading the metrics.
+func (uc *QueryUsecase) Query(ctx context.Context, q string) (*Result, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := uc.getter.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics: %w", err)
+	}
+
+	return eval(expr, metrics)
+}
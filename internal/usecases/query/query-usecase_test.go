@@ -0,0 +1,205 @@
+package query_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/query"
+	queryMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/query"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "1 + 2 * 3", want: "(1 + (2 * 3))"},
+		{q: "(1 + 2) * 3", want: "((1 + 2) * 3)"},
+		{q: "-HeapAlloc / 1024", want: "(-HeapAlloc / 1024)"},
+		{q: "Heap* * 2", want: "(Heap* * 2)"},
+		{q: `*{host="a"}`, want: `*{host="a"}`},
+		{q: `cpu{host!="b*", core="[0-3]",}`, want: `cpu{host!="b*",core="[0-3]"}`},
+		{q: `{__name__="servers.web-1.cpu"}`, want: `{__name__="servers.web-1.cpu"}`},
+		{q: "sum(Heap*)", want: "sum (Heap*)"},
+		{q: "sum by (host) (cpu)", want: "sum by (host) (cpu)"},
+		{q: "avg(cpu) without (core)", want: "avg without (core) (cpu)"},
+		{q: "topk(2, cpu)", want: "topk (2, cpu)"},
+		{q: "count + 1", want: "(count + 1)"},
+		{q: "1e3 % .5", want: "(1000 % 0.5)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			expr, err := query.Parse(tt.q)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		q       string
+		wantPos int
+		wantMsg string
+	}{
+		{q: "", wantPos: 1, wantMsg: "empty query"},
+		{q: "1 +", wantPos: 4, wantMsg: "unexpected end of query"},
+		{q: "sum(cpu", wantPos: 8, wantMsg: `expected ")"`},
+		{q: "cpu )", wantPos: 5, wantMsg: `unexpected ")", expected an operator`},
+		{q: `cpu{host="a}`, wantPos: 10, wantMsg: "unterminated string"},
+		{q: `cpu{host=a}`, wantPos: 10, wantMsg: "expected string"},
+		{q: `cpu{host~"a"}`, wantPos: 9, wantMsg: `unexpected character '~'`},
+		{q: `cpu{1host="a"}`, wantPos: 5, wantMsg: "invalid number"},
+		{q: "cpu[0-3", wantPos: 4, wantMsg: "unterminated character class"},
+		{q: "{}", wantPos: 1, wantMsg: "needs a name or a label matcher"},
+		{q: "topk(cpu)", wantPos: 9, wantMsg: "after the parameter of topk"},
+		{q: "sum by (__type__) (cpu)", wantPos: 9, wantMsg: "can only be matched"},
+		{q: "1 ! 2", wantPos: 3, wantMsg: `did you mean "!="`},
+		{q: strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100), wantPos: 65, wantMsg: "nested deeper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			_, err := query.Parse(tt.q)
+			require.ErrorIs(t, err, query.ErrInvalidQuery)
+
+			var qerr *query.Error
+			require.ErrorAs(t, err, &qerr)
+			assert.Equal(t, tt.wantPos, qerr.Pos)
+			assert.Contains(t, qerr.Msg, tt.wantMsg)
+		})
+	}
+}
+
+func TestQueryUsecase_Query(t *testing.T) {
+	ctx := context.Background()
+
+	storage := repo.NewMemStorage()
+	require.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge("HeapAlloc", 100),
+		models.NewGauge("HeapIdle", 50),
+		models.NewGauge("HeapSys", 200),
+		models.NewCounter("errors", 5),
+		models.NewCounter("requests", 20),
+		models.NewGauge(`cpu{core="0",host="a"}`, 0.5),
+		models.NewGauge(`cpu{core="1",host="a"}`, 0.25),
+		models.NewGauge(`cpu{core="0",host="b"}`, 0.75),
+		models.NewCounter(`http{code="200"}`, 90),
+		models.NewCounter(`http{code="500"}`, 10),
+		models.NewGauge("requests", 1),
+	}))
+	uc := query.NewQueryUsecase(storage)
+
+	sample := func(name string, labels models.Labels, v float64) query.Sample {
+		return query.Sample{Name: name, Labels: labels, Value: v}
+	}
+
+	tests := []struct {
+		q          string
+		wantScalar *float64
+		want       []query.Sample
+	}{
+		{q: "sum(Heap*)", want: []query.Sample{sample("", nil, 350)}},
+		{q: `errors / requests{__type__="counter"}`, want: []query.Sample{sample("", nil, 0.25)}},
+		{q: "HeapAlloc / 1024 * 2048", want: []query.Sample{sample("HeapAlloc", nil, 200)}},
+		{q: "-HeapIdle", want: []query.Sample{sample("HeapIdle", nil, -50)}},
+		{q: `cpu{host="a"}`, want: []query.Sample{
+			sample("cpu", models.Labels{"core": "0", "host": "a"}, 0.5),
+			sample("cpu", models.Labels{"core": "1", "host": "a"}, 0.25),
+		}},
+		{q: `cpu{host!="a"}`, want: []query.Sample{sample("cpu", models.Labels{"core": "0", "host": "b"}, 0.75)}},
+		{q: "sum by (host) (cpu)", want: []query.Sample{
+			sample("", models.Labels{"host": "a"}, 0.75),
+			sample("", models.Labels{"host": "b"}, 0.75),
+		}},
+		{q: "max(cpu) without (host)", want: []query.Sample{
+			sample("", models.Labels{"core": "0"}, 0.75),
+			sample("", models.Labels{"core": "1"}, 0.25),
+		}},
+		{q: "avg(cpu{host=\"a\"})", want: []query.Sample{sample("", nil, 0.375)}},
+		{q: "min by (__name__) (Heap* / 2)", want: []query.Sample{
+			sample("HeapAlloc", nil, 50),
+			sample("HeapIdle", nil, 25),
+			sample("HeapSys", nil, 100),
+		}},
+		{q: "count(cpu)", want: []query.Sample{sample("", nil, 3)}},
+		{q: "topk(2, Heap*)", want: []query.Sample{
+			sample("HeapSys", nil, 200),
+			sample("HeapAlloc", nil, 100),
+		}},
+		{q: `http{code="5*"} / sum(http) * 100`, want: []query.Sample{}},
+		{q: "sum(http{code=\"5*\"}) / sum(http) * 100", want: []query.Sample{sample("", nil, 10)}},
+		{q: "HeapAlloc / 0", want: []query.Sample{}},
+		{q: "missing", want: []query.Sample{}},
+		{q: "sum(missing)", want: []query.Sample{}},
+		{q: "(1 + 2) * 3", wantScalar: ptr(9.0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			result, err := uc.Query(ctx, tt.q)
+			require.NoError(t, err)
+
+			if tt.wantScalar != nil {
+				assert.Equal(t, query.ScalarType, result.Type)
+				assert.InDelta(t, *tt.wantScalar, result.Scalar, 1e-9)
+				return
+			}
+
+			assert.Equal(t, query.VectorType, result.Type)
+			assert.Equal(t, tt.want, result.Vector)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			q       string
+			wantPos int
+			wantMsg string
+		}{
+			{q: "1 / (2 - 2)", wantPos: 3, wantMsg: "division by zero"},
+			{q: "sum(1)", wantPos: 5, wantMsg: "sum expects a vector"},
+			{q: "topk(HeapAlloc, cpu)", wantPos: 6, wantMsg: "must be a scalar"},
+			{q: "requests + 1 + HeapAlloc / requests", wantPos: 26, wantMsg: "have the same labels"},
+		}
+
+		for _, tt := range tests {
+			_, err := uc.Query(ctx, tt.q)
+			require.ErrorIs(t, err, query.ErrInvalidQuery, tt.q)
+
+			var qerr *query.Error
+			require.ErrorAs(t, err, &qerr)
+			assert.Equal(t, tt.wantPos, qerr.Pos, tt.q)
+			assert.Contains(t, qerr.Msg, tt.wantMsg, tt.q)
+		}
+	})
+}
+
+func TestQueryUsecase_StorageError(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+
+	getter := queryMocks.NewMockMetricGetter(ctrl)
+	getter.EXPECT().GetAllMetrics(ctx).Return(nil, errors.New("connection refused"))
+
+	_, err := query.NewQueryUsecase(getter).Query(ctx, "sum(cpu)")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, query.ErrInvalidQuery)
+
+	// An invalid query doesn't read the metrics.
+	_, err = query.NewQueryUsecase(getter).Query(ctx, "sum(")
+	assert.ErrorIs(t, err, query.ErrInvalidQuery)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,12 @@
+package remotewrite
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+type MetricStore interface {
+	GetMetric(ctx context.Context, mType, mName string) (models.Metric, error)
+	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
+}
@@ -0,0 +1,204 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/cumulative"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/prompb"
+)
+
+// DefaultCounterSuffixes are the name suffixes of the series stored as counters.
+const DefaultCounterSuffixes = "_total,_count,_bucket"
+
+// NameLabel is the label holding the metric name of a series.
+const NameLabel = "__name__"
+
+var ErrInvalidSeries = errors.New("invalid time series")
+
+// Convention decides whether a series is stored as a counter or as a gauge.
+//
+// The type from the request metadata wins; without metadata, a series
+// whose name ends with one of CounterSuffixes is a counter, any other
+// series is a gauge.
+type Convention struct {
+	CounterSuffixes []string
+}
+
+// ParseConvention parses a comma-separated list of counter suffixes.
+func ParseConvention(suffixes string) Convention {
+	var conv Convention
+	for _, suffix := range strings.Split(suffixes, ",") {
+		if suffix = strings.TrimSpace(suffix); suffix != "" {
+			conv.CounterSuffixes = append(conv.CounterSuffixes, suffix)
+		}
+	}
+
+	return conv
+}
+
+func (c Convention) metricType(name string, metadata map[string]string) string {
+	if mType, ok := metadata[name]; ok {
+		return mType
+	}
+
+	for _, suffix := range c.CounterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return models.CounterType
+		}
+	}
+
+	return models.GaugeType
+}
+
+// Result summarizes a write request.
+type Result struct {
+	Series   int
+	Samples  int
+	Gauges   int
+	Counters int
+}
+
+// RemoteWriteUsecase applies Prometheus remote write requests to the storage.
+//
+// A gauge takes the value of the latest sample of its series. Prometheus
+// counters are cumulative while stored counters add up deltas, so the
+// increases of counter series are computed by a cumulative.Tracker.
+//
+// Stored counters are integers, so the cumulative value of a counter
+// sample is rounded before its increase is computed. The rounding error
+// doesn't add up over the samples, but a counter growing by less than 1,
+// e.g. process_cpu_seconds_total, loses its fraction.
+type RemoteWriteUsecase struct {
+	store      MetricStore
+	convention Convention
+
+	// mutex serializes the writes, so the increases of a series are
+	// computed and stored in order.
+	mutex    sync.Mutex
+	counters *cumulative.Tracker
+}
+
+func NewRemoteWriteUsecase(store MetricStore, convention Convention) *RemoteWriteUsecase {
+	return &RemoteWriteUsecase{
+		store:      store,
+		convention: convention,
+		counters:   cumulative.NewTracker(store, cumulative.DefaultTTL),
+	}
+}
+
+// Write converts the series of the request into metrics and stores them
+// with a single UpdateMetricList call. NaN samples, including staleness
+// markers, are skipped.
+func (uc *RemoteWriteUsecase) Write(ctx context.Context, req *prompb.WriteRequest) (*Result, error) {
+	metadata := make(map[string]string, len(req.Metadata))
+	for _, meta := range req.Metadata {
+		switch meta.Type {
+		case prompb.MetricMetadata_COUNTER:
+			metadata[meta.MetricFamilyName] = models.CounterType
+		case prompb.MetricMetadata_GAUGE:
+			metadata[meta.MetricFamilyName] = models.GaugeType
+		}
+	}
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	result := &Result{}
+	metrics := make([]models.Metric, 0, len(req.Timeseries))
+	counters := uc.counters.NewBatch(ctx)
+
+	for i, ts := range req.Timeseries {
+		name, id, err := seriesName(ts)
+		if err != nil {
+			return nil, fmt.Errorf("series %d: %w", i, err)
+		}
+
+		values := sampleValues(ts.Samples)
+		if len(values) == 0 {
+			continue
+		}
+
+		result.Series++
+		result.Samples += len(values)
+
+		if uc.convention.metricType(name, metadata) == models.GaugeType {
+			metrics = append(metrics, models.NewGauge(id, values[len(values)-1]))
+			result.Gauges++
+			continue
+		}
+
+		totals := make([]int64, len(values))
+		for i, value := range values {
+			totals[i] = int64(math.Round(value))
+		}
+
+		metrics = append(metrics, models.NewCounter(id, counters.Increase(id, totals)))
+		result.Counters++
+	}
+
+	if len(metrics) == 0 {
+		return result, nil
+	}
+
+	if err := uc.store.UpdateMetricList(ctx, metrics); err != nil {
+		return nil, fmt.Errorf("failed to store metrics: %w", err)
+	}
+
+	// Remember the values only once they are stored, so a failed
+	// request retried by Prometheus is applied again.
+	counters.Commit()
+
+	return result, nil
+}
+
+// seriesName returns the metric name of the series and the stored metric
+// name with the other labels.
+func seriesName(ts *prompb.TimeSeries) (string, string, error) {
+	var name string
+	labels := make(models.Labels, len(ts.Labels))
+
+	for _, label := range ts.Labels {
+		if label.Name == NameLabel {
+			name = label.Value
+			continue
+		}
+		if label.Name == "" {
+			return "", "", fmt.Errorf("%w: empty label name", ErrInvalidSeries)
+		}
+		labels[label.Name] = label.Value
+	}
+
+	if name == "" {
+		return "", "", fmt.Errorf("%w: no %s label", ErrInvalidSeries, NameLabel)
+	}
+
+	return name, models.JoinName(name, labels), nil
+}
+
+// sampleValues returns the values of the samples ordered by timestamp.
+func sampleValues(samples []*prompb.Sample) []float64 {
+	sorted := make([]*prompb.Sample, 0, len(samples))
+	for _, sample := range samples {
+		if !math.IsNaN(sample.Value) {
+			sorted = append(sorted, sample)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	values := make([]float64, len(sorted))
+	for i, sample := range sorted {
+		values[i] = sample.Value
+	}
+
+	return values
+}
@@ -0,0 +1,124 @@
+package remotewrite_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/remotewrite"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/prompb"
+	remotewriteMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/remotewrite"
+)
+
+func series(name string, values ...float64) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{
+		Labels: []*prompb.Label{{Name: remotewrite.NameLabel, Value: name}, {Name: "job", Value: "node"}},
+	}
+	for i, value := range values {
+		ts.Samples = append(ts.Samples, &prompb.Sample{Value: value, Timestamp: int64(i) * 1000})
+	}
+	return ts
+}
+
+func counterValue(t *testing.T, storage *repo.MemStorage, name string) int64 {
+	t.Helper()
+
+	metric, err := storage.GetMetric(context.Background(), models.CounterType, name)
+	require.NoError(t, err)
+	return metric.Value().(int64)
+}
+
+func TestParseConvention(t *testing.T) {
+	assert.Equal(t, []string{"_total", "_count"}, remotewrite.ParseConvention(" _total, ,_count").CounterSuffixes)
+	assert.Empty(t, remotewrite.ParseConvention("").CounterSuffixes)
+}
+
+func TestRemoteWriteUsecase_Write(t *testing.T) {
+	ctx := context.Background()
+	convention := remotewrite.ParseConvention(remotewrite.DefaultCounterSuffixes)
+
+	t.Run("TestRemoteWriteUsecase_Write_types", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := remotewrite.NewRemoteWriteUsecase(storage, convention)
+
+		result, err := uc.Write(ctx, &prompb.WriteRequest{
+			Timeseries: []*prompb.TimeSeries{
+				series("load1", 0.5, 0.7),
+				series("requests_total", 5, 8),
+				series("cpu_seconds", 2.4),
+				series("stale", math.NaN()),
+			},
+			Metadata: []*prompb.MetricMetadata{
+				{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "cpu_seconds"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, &remotewrite.Result{Series: 3, Samples: 5, Gauges: 1, Counters: 2}, result)
+
+		gauge, err := storage.GetMetric(ctx, models.GaugeType, `load1{job="node"}`)
+		require.NoError(t, err)
+		assert.Equal(t, 0.7, gauge.Value())
+
+		assert.Equal(t, int64(8), counterValue(t, storage, `requests_total{job="node"}`))
+		assert.Equal(t, int64(2), counterValue(t, storage, `cpu_seconds{job="node"}`))
+
+		_, err = storage.GetMetric(ctx, models.GaugeType, `stale{job="node"}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("TestRemoteWriteUsecase_Write_cumulative_counters", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		// The counter stored before the restart of the server.
+		require.NoError(t, storage.UpdateMetric(ctx, models.CounterType, `requests_total{job="node"}`, int64(100)))
+		uc := remotewrite.NewRemoteWriteUsecase(storage, convention)
+
+		_, err := uc.Write(ctx, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("requests_total", 110, 120)}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(120), counterValue(t, storage, `requests_total{job="node"}`))
+
+		// A reset: the counter continues from 0.
+		_, err = uc.Write(ctx, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("requests_total", 125, 4)}})
+		require.NoError(t, err)
+		assert.Equal(t, int64(129), counterValue(t, storage, `requests_total{job="node"}`))
+
+	})
+
+	t.Run("TestRemoteWriteUsecase_Write_invalid_series", func(t *testing.T) {
+		uc := remotewrite.NewRemoteWriteUsecase(repo.NewMemStorage(), convention)
+
+		_, err := uc.Write(ctx, &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{
+			{Labels: []*prompb.Label{{Name: "job", Value: "node"}}, Samples: []*prompb.Sample{{Value: 1}}},
+		}})
+		assert.ErrorIs(t, err, remotewrite.ErrInvalidSeries)
+	})
+
+	t.Run("TestRemoteWriteUsecase_Write_retry_after_failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := remotewriteMocks.NewMockMetricStore(ctrl)
+		uc := remotewrite.NewRemoteWriteUsecase(store, convention)
+		req := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{series("requests_total", 7)}}
+
+		gomock.InOrder(
+			store.EXPECT().GetMetric(ctx, models.CounterType, `requests_total{job="node"}`).Return(nil, models.ErrMetricsNotFound),
+			store.EXPECT().UpdateMetricList(ctx, gomock.Any()).Return(errors.New("db is down")),
+			store.EXPECT().GetMetric(ctx, models.CounterType, `requests_total{job="node"}`).Return(nil, models.ErrMetricsNotFound),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter(`requests_total{job="node"}`, 7)}).Return(nil),
+		)
+
+		_, err := uc.Write(ctx, req)
+		require.Error(t, err)
+
+		// The failed request is applied in full when Prometheus retries it.
+		_, err = uc.Write(ctx, req)
+		require.NoError(t, err)
+	})
+}
@@ -16,6 +16,52 @@ type MetricUpdater interface {
 	UpdateMetricList(ctx context.Context, metrics []models.Metric) error
 }
 
+// MetricBatchGetter is implemented by storages that can look up many
+// metrics in one call. Metrics that are not found are left out of the result.
+type MetricBatchGetter interface {
+	GetMetrics(ctx context.Context, keys []MetricKey) ([]models.Metric, error)
+}
+
+// MetricRanger is implemented by storages that can call fn for every
+// metric without building the whole list, in no particular order. It
+// stops at the first error fn returns and returns it.
+type MetricRanger interface {
+	RangeMetrics(ctx context.Context, fn func(models.Metric) error) error
+}
+
+// MetricSetter is implemented by storages that can store metrics with
+// their values as they are: a counter is set to its value rather than
+// added to, so storing the same list twice changes nothing.
+type MetricSetter interface {
+	SetMetricList(ctx context.Context, metrics []models.Metric) error
+}
+
+// MetricApplier is implemented by storages that can update metrics like
+// UpdateMetricList and return their values after the update, without
+// reading them back: a counter is returned with its total, not with the
+// added delta. The values are returned in the order of the list, and
+// must not be shared with the storage.
+type MetricApplier interface {
+	ApplyMetricList(ctx context.Context, metrics []models.Metric) ([]models.Metric, error)
+}
+
+// MetricSnapshotter is implemented by storages whose GetAllMetrics
+// may observe concurrent updates, to get all metrics at a single point in time.
+type MetricSnapshotter interface {
+	Snapshot(ctx context.Context) ([]models.Metric, error)
+}
+
+// MetricReplacer is implemented by storages that can replace all metrics at once.
+type MetricReplacer interface {
+	ReplaceAll(ctx context.Context, metrics []models.Metric) error
+}
+
+// MetricVersioner is implemented by storages that keep a change version,
+// which grows with every write.
+type MetricVersioner interface {
+	Version(ctx context.Context) (Version, error)
+}
+
 type Closer interface {
 	Close() error
 }
@@ -25,3 +71,8 @@ type Collector interface {
 	MetricUpdater
 	Closer
 }
+
+// Tenants provides an isolated storage for every tenant.
+type Tenants interface {
+	ForTenant(tenant string) (Collector, error)
+}
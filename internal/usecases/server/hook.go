@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// UpdateHook is notified of the metrics stored through the usecase, by
+// every ingestion path. Updates are notified only by storages that
+// implement MetricApplier, so their values are never read back.
+type UpdateHook interface {
+	// Wants reports whether the hook has to be notified of the updates
+	// of the tenant.
+	Wants(tenant string) bool
+	// Updated is called after an update of the tenant is stored, with the
+	// values of the updated metrics after it. It must not block, and must
+	// copy what it keeps: the metrics may be shared with the caller.
+	Updated(tenant string, metrics []models.Metric)
+}
+
+// WithUpdateHook sets the hook notified of the stored updates.
+func (uc *MetricUsecase) WithUpdateHook(hook UpdateHook) *MetricUsecase {
+	uc.hook = hook
+	return uc
+}
+
+// applier returns the updater as a MetricApplier if the hook wants the
+// updates of the request tenant and the storage returns the stored values.
+func (uc *MetricUsecase) applier(ctx context.Context, updater MetricUpdater) (MetricApplier, bool) {
+	if uc.hook == nil || !uc.hook.Wants(tenant.FromContext(ctx)) {
+		return nil, false
+	}
+
+	applier, ok := updater.(MetricApplier)
+	return applier, ok
+}
+
+// notify passes the stored values of the updated metrics to the hook, a
+// counter with its total rather than the added delta. A metric listed
+// more than once is notified once, with its last value.
+func (uc *MetricUsecase) notify(ctx context.Context, stored []models.Metric) {
+	id := tenant.FromContext(ctx)
+	if uc.hook == nil || len(stored) == 0 || !uc.hook.Wants(id) {
+		return
+	}
+
+	uc.hook.Updated(id, lastValues(stored))
+}
+
+// lastValues returns the last value of every distinct metric, in the
+// order of their first occurrence.
+func lastValues(metrics []models.Metric) []models.Metric {
+	index := make(map[MetricKey]int, len(metrics))
+	values := make([]models.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		key := MetricKey{Type: metric.Type(), Name: metric.Name()}
+		if i, ok := index[key]; ok {
+			values[i] = metric
+			continue
+		}
+		index[key] = len(values)
+		values = append(values, metric)
+	}
+
+	return values
+}
+
+// newMetric returns the metric set by an update of the given type.
+func newMetric(mType, mName string, value any) (models.Metric, error) {
+	var metric models.Metric
+
+	switch mType {
+	case models.GaugeType:
+		metric = models.NewGauge(mName, 0)
+	case models.CounterType:
+		metric = models.NewCounter(mName, 0)
+	default:
+		return nil, models.ErrInvalidMetricsType
+	}
+
+	if err := metric.Update(value); err != nil {
+		return nil, err
+	}
+
+	return metric, nil
+}
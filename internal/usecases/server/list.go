@@ -0,0 +1,248 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+)
+
+const (
+	// DefaultListLimit is the page size of ListMetrics if no limit is given.
+	DefaultListLimit = 100
+	// MaxListLimit is the largest page size of ListMetrics.
+	MaxListLimit = 1000
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// MetricKey identifies a metric. ListMetrics returns metrics ordered
+// by type and then by name.
+type MetricKey struct {
+	Type string
+	Name string
+}
+
+func (k MetricKey) less(other MetricKey) bool {
+	if k.Type != other.Type {
+		return k.Type < other.Type
+	}
+
+	return k.Name < other.Name
+}
+
+// ListFilter selects metrics for ListMetrics.
+type ListFilter struct {
+	// Type is the metric type; empty matches all types.
+	Type string
+	// NamePattern is a glob (see path.Match) matched against the metric
+	// name without labels; empty matches all names.
+	NamePattern string
+	// Labels are globs the label values must match; "*" only requires the label.
+	Labels map[string]string
+	// After is the key of the last metric of the previous page.
+	After *MetricKey
+	// Limit is the page size, DefaultListLimit if zero.
+	Limit int
+}
+
+// Validate checks the filter and fills in the defaults.
+func (f *ListFilter) Validate() error {
+	switch f.Type {
+	case "", models.GaugeType, models.CounterType:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidFilter, f.Type)
+	}
+
+	if _, err := path.Match(f.NamePattern, ""); err != nil {
+		return fmt.Errorf("%w: name pattern %q: %v", ErrInvalidFilter, f.NamePattern, err)
+	}
+
+	for key, pattern := range f.Labels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: label %s pattern %q: %v", ErrInvalidFilter, key, pattern, err)
+		}
+	}
+
+	switch {
+	case f.Limit == 0:
+		f.Limit = DefaultListLimit
+	case f.Limit < 0 || f.Limit > MaxListLimit:
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+	}
+
+	return nil
+}
+
+// Match reports whether the metric matches the filter. The filter must
+// be valid, see Validate.
+func (f *ListFilter) Match(metric models.Metric) bool {
+	if f.Type != "" && metric.Type() != f.Type {
+		return false
+	}
+
+	if f.NamePattern == "" && len(f.Labels) == 0 {
+		return true
+	}
+
+	base, labels := models.SplitName(metric.Name())
+
+	if f.NamePattern != "" {
+		if ok, _ := path.Match(f.NamePattern, base); !ok {
+			return false
+		}
+	}
+
+	for key, pattern := range f.Labels {
+		value, ok := labels[key]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListPage is a page of metrics returned by ListMetrics.
+type ListPage struct {
+	Metrics []models.Metric
+	// Next is the key to pass as ListFilter.After to get the next page,
+	// nil on the last page.
+	Next *MetricKey
+}
+
+// ListMetrics returns a page of the metrics matching the filter.
+//
+// Pages are keyset-based: a page starts right after the After key,
+// so metrics added or removed between requests don't shift the pages.
+func (uc *MetricUsecase) ListMetrics(ctx context.Context, filter ListFilter) (*ListPage, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	matched, err := uc.matchMetrics(ctx, &filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ListPage{Metrics: matched}
+	if len(matched) > filter.Limit {
+		page.Metrics = matched[:filter.Limit]
+		last := page.Metrics[filter.Limit-1]
+		page.Next = &MetricKey{Type: last.Type(), Name: last.Name()}
+	}
+
+	return page, nil
+}
+
+// EachMetric calls fn for every metric matching the filter, in no
+// particular order, and stops at the first error fn returns. The filter's
+// Limit is ignored.
+//
+// Unlike ListMetrics it neither builds a page nor sorts: with a storage
+// implementing MetricRanger the metrics are passed to fn as the storage is
+// ranged over, so a caller writing them out holds one at a time.
+func (uc *MetricUsecase) EachMetric(ctx context.Context, filter ListFilter, fn func(models.Metric) error) error {
+	filter.Limit = 0
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	return uc.rangeMatches(ctx, &filter, fn)
+}
+
+// rangeMatches calls fn for every metric after the filter's After key that
+// matches the filter and may be read by the API token of the request.
+func (uc *MetricUsecase) rangeMatches(ctx context.Context, filter *ListFilter, fn func(models.Metric) error) error {
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	match := func(metric models.Metric) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key := MetricKey{Type: metric.Type(), Name: metric.Name()}
+		if filter.After != nil && !filter.After.less(key) {
+			return nil
+		}
+		if !filter.Match(metric) || !apitoken.AllowedName(ctx, metric.Name()) {
+			return nil
+		}
+
+		return fn(metric)
+	}
+
+	if ranger, ok := getter.(MetricRanger); ok {
+		return ranger.RangeMetrics(ctx, match)
+	}
+
+	metrics, err := getter.GetAllMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get all metrics: %w", err)
+	}
+
+	for _, metric := range metrics {
+		if err := match(metric); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchMetrics returns the first filter.Limit+1 metrics after the filter's
+// After key that match the filter, sorted by MetricKey. Only those are
+// kept while the storage is ranged over, so a page doesn't cost a copy and
+// a sort of all the metrics.
+func (uc *MetricUsecase) matchMetrics(ctx context.Context, filter *ListFilter) ([]models.Metric, error) {
+	first := &metricHeap{}
+	err := uc.rangeMatches(ctx, filter, func(metric models.Metric) error {
+		heap.Push(first, metric)
+		if first.Len() > filter.Limit+1 {
+			heap.Pop(first)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]models.Metric, first.Len())
+	for i := len(matched) - 1; i >= 0; i-- {
+		matched[i] = heap.Pop(first).(models.Metric)
+	}
+
+	return matched, nil
+}
+
+// metricHeap is a heap of metrics with the greatest MetricKey on top.
+type metricHeap []models.Metric
+
+func (h metricHeap) Len() int { return len(h) }
+
+func (h metricHeap) Less(i, j int) bool {
+	return MetricKey{Type: h[j].Type(), Name: h[j].Name()}.
+		less(MetricKey{Type: h[i].Type(), Name: h[i].Name()})
+}
+
+func (h metricHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *metricHeap) Push(x any) { *h = append(*h, x.(models.Metric)) }
+
+func (h *metricHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
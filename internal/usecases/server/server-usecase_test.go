@@ -2,12 +2,18 @@ package server_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
 	server "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
 	modelsMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/models"
 	serverMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/server"
 	"go.uber.org/mock/gomock"
@@ -168,3 +174,446 @@ func TestServerUsecase_UpdateMetricList(t *testing.T) {
 		assert.Equal(t, expectedMetrics, metrics)
 	})
 }
+
+func TestServerUsecase_Tenants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMetricGetter := serverMocks.NewMockMetricGetter(ctrl)
+	mockMetricUpdater := serverMocks.NewMockMetricUpdater(ctrl)
+	mockTenants := serverMocks.NewMockTenants(ctrl)
+	mockTenantCollector := serverMocks.NewMockCollector(ctrl)
+
+	uc := server.NewMetricUsecase(mockMetricGetter, mockMetricUpdater, nil).WithTenants(mockTenants)
+
+	t.Run("TestServerUsecase_Tenants_default", func(t *testing.T) {
+		ctx := context.Background()
+
+		mockMetricUpdater.EXPECT().UpdateMetric(ctx, "counter", "PollCount", int64(1)).Return(nil)
+		mockTenants.EXPECT().ForTenant(gomock.Any()).Times(0)
+
+		assert.NoError(t, uc.UpdateMetric(ctx, "counter", "PollCount", int64(1)))
+	})
+
+	t.Run("TestServerUsecase_Tenants_scoped", func(t *testing.T) {
+		ctx := tenant.WithTenant(context.Background(), "team-a")
+		metrics := []models.Metric{models.NewGauge("Alloc", 1.5)}
+
+		mockTenants.EXPECT().ForTenant("team-a").Return(mockTenantCollector, nil).Times(2)
+		mockTenantCollector.EXPECT().UpdateMetricList(ctx, metrics).Return(nil)
+		mockTenantCollector.EXPECT().GetAllMetrics(ctx).Return(metrics, nil)
+
+		assert.NoError(t, uc.UpdateMetricList(ctx, metrics))
+
+		got, err := uc.GetAllMetrics(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, metrics, got)
+	})
+
+	t.Run("TestServerUsecase_Tenants_storage_error", func(t *testing.T) {
+		ctx := tenant.WithTenant(context.Background(), "team-b")
+		errStorage := errors.New("storage failed")
+
+		mockTenants.EXPECT().ForTenant("team-b").Return(nil, errStorage)
+
+		_, err := uc.GetMetric(ctx, "gauge", "Alloc")
+		assert.ErrorIs(t, err, errStorage)
+	})
+}
+
+func TestServerUsecase_TokenPrefix(t *testing.T) {
+	storage := repo.NewMemStorage()
+	uc := server.NewMetricUsecase(storage, storage, storage)
+
+	ctx := apitoken.WithToken(context.Background(), apitoken.Token{ID: "1", Prefix: "host1."})
+	assert.NoError(t, storage.UpdateMetricList(context.Background(), []models.Metric{
+		models.NewGauge("host1.Alloc", 1.5),
+		models.NewGauge("host2.Alloc", 2.5),
+	}))
+
+	t.Run("TestServerUsecase_TokenPrefix_read", func(t *testing.T) {
+		metric, err := uc.GetMetric(ctx, models.GaugeType, "host1.Alloc")
+		assert.NoError(t, err)
+		assert.Equal(t, 1.5, metric.Value())
+
+		_, err = uc.GetMetric(ctx, models.GaugeType, "host2.Alloc")
+		assert.ErrorIs(t, err, models.ErrMetricsNotFound)
+
+		all, err := uc.GetAllMetrics(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Metric{models.NewGauge("host1.Alloc", 1.5)}, all)
+
+		snapshot, err := uc.Snapshot(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, snapshot, 1)
+
+		found, missing, err := uc.GetMetrics(ctx, []server.MetricKey{
+			{Type: models.GaugeType, Name: "host1.Alloc"},
+			{Type: models.GaugeType, Name: "host2.Alloc"},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, found, 1)
+		assert.Equal(t, []server.MetricKey{{Type: models.GaugeType, Name: "host2.Alloc"}}, missing)
+	})
+
+	t.Run("TestServerUsecase_TokenPrefix_write", func(t *testing.T) {
+		assert.NoError(t, uc.UpdateMetric(ctx, models.GaugeType, "host1.Alloc", 3.5))
+
+		err := uc.UpdateMetric(ctx, models.GaugeType, "host2.Alloc", 3.5)
+		assert.ErrorIs(t, err, apitoken.ErrForbidden)
+
+		// A batch is stored whole or not at all.
+		err = uc.UpdateMetricList(ctx, []models.Metric{
+			models.NewGauge("host1.Alloc", 4.5),
+			models.NewGauge("host2.Alloc", 4.5),
+		})
+		assert.ErrorIs(t, err, apitoken.ErrForbidden)
+
+		assert.ErrorIs(t, uc.Restore(ctx, nil, server.RestoreReplace), apitoken.ErrForbidden)
+
+		metric, err := storage.GetMetric(context.Background(), models.GaugeType, "host2.Alloc")
+		assert.NoError(t, err)
+		assert.Equal(t, 2.5, metric.Value())
+	})
+}
+
+func TestServerUsecase_SnapshotRestore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	metrics := []models.Metric{models.NewCounter("PollCount", 5)}
+
+	t.Run("TestServerUsecase_Snapshot_fallback", func(t *testing.T) {
+		mockMetricGetter := serverMocks.NewMockMetricGetter(ctrl)
+		uc := server.NewMetricUsecase(mockMetricGetter, nil, nil)
+
+		mockMetricGetter.EXPECT().GetAllMetrics(ctx).Return(metrics, nil)
+
+		got, err := uc.Snapshot(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, metrics, got)
+	})
+
+	t.Run("TestServerUsecase_Restore_merge", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		assert.NoError(t, storage.UpdateMetricList(ctx, []models.Metric{
+			models.NewCounter("PollCount", 3),
+			models.NewGauge("Alloc", 1.0),
+		}))
+		uc := server.NewMetricUsecase(storage, storage, storage)
+
+		// Restoring twice sets the counter to the snapshot value.
+		assert.NoError(t, uc.Restore(ctx, metrics, server.RestoreMerge))
+		assert.NoError(t, uc.Restore(ctx, metrics, server.RestoreMerge))
+
+		got, err := uc.GetAllMetrics(ctx)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []models.Metric{
+			models.NewCounter("PollCount", 5),
+			models.NewGauge("Alloc", 1.0),
+		}, got)
+	})
+
+	t.Run("TestServerUsecase_Restore_merge_unsupported", func(t *testing.T) {
+		uc := server.NewMetricUsecase(nil, serverMocks.NewMockMetricUpdater(ctrl), nil)
+
+		assert.ErrorIs(t, uc.Restore(ctx, metrics, server.RestoreMerge), server.ErrMergeUnsupported)
+	})
+
+	t.Run("TestServerUsecase_Restore_replace", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		assert.NoError(t, storage.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.0))
+		uc := server.NewMetricUsecase(storage, storage, storage)
+
+		assert.NoError(t, uc.Restore(ctx, metrics, server.RestoreReplace))
+
+		got, err := uc.Snapshot(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, metrics, got)
+	})
+
+	t.Run("TestServerUsecase_Restore_replace_unsupported", func(t *testing.T) {
+		uc := server.NewMetricUsecase(nil, serverMocks.NewMockMetricUpdater(ctrl), nil)
+
+		assert.ErrorIs(t, uc.Restore(ctx, metrics, server.RestoreReplace), server.ErrReplaceUnsupported)
+	})
+
+	t.Run("TestServerUsecase_Restore_invalid_mode", func(t *testing.T) {
+		uc := server.NewMetricUsecase(nil, nil, nil)
+
+		assert.ErrorIs(t, uc.Restore(ctx, metrics, "append"), server.ErrInvalidRestoreMode)
+	})
+}
+
+func TestServerUsecase_ListMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	metrics := []models.Metric{
+		models.NewGauge(models.JoinName("cpu", models.Labels{"host": "b"}), 2),
+		models.NewCounter("PollCount", 5),
+		models.NewGauge(models.JoinName("cpu", models.Labels{"host": "a"}), 1),
+		models.NewGauge("Alloc", 3),
+		models.NewGauge(models.JoinName("mem", models.Labels{"host": "a"}), 4),
+	}
+
+	names := func(page *server.ListPage) []string {
+		var got []string
+		for _, metric := range page.Metrics {
+			got = append(got, metric.Name())
+		}
+		return got
+	}
+
+	tests := []struct {
+		name    string
+		filter  server.ListFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "all sorted by type and name",
+			filter: server.ListFilter{},
+			want:   []string{"PollCount", "Alloc", `cpu{host="a"}`, `cpu{host="b"}`, `mem{host="a"}`},
+		},
+		{
+			name:   "by type",
+			filter: server.ListFilter{Type: models.CounterType},
+			want:   []string{"PollCount"},
+		},
+		{
+			name:   "by name glob",
+			filter: server.ListFilter{NamePattern: "c*"},
+			want:   []string{`cpu{host="a"}`, `cpu{host="b"}`},
+		},
+		{
+			name:   "by label",
+			filter: server.ListFilter{Labels: map[string]string{"host": "a"}},
+			want:   []string{`cpu{host="a"}`, `mem{host="a"}`},
+		},
+		{
+			name:   "by label presence",
+			filter: server.ListFilter{Type: models.GaugeType, Labels: map[string]string{"host": "*"}},
+			want:   []string{`cpu{host="a"}`, `cpu{host="b"}`, `mem{host="a"}`},
+		},
+		{
+			name:    "invalid type",
+			filter:  server.ListFilter{Type: "histogram"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name glob",
+			filter:  server.ListFilter{NamePattern: "cpu["},
+			wantErr: true,
+		},
+		{
+			name:    "limit too large",
+			filter:  server.ListFilter{Limit: server.MaxListLimit + 1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockMetricGetter := serverMocks.NewMockMetricGetter(ctrl)
+			uc := server.NewMetricUsecase(mockMetricGetter, nil, nil)
+
+			if !tt.wantErr {
+				mockMetricGetter.EXPECT().GetAllMetrics(ctx).Return(metrics, nil)
+			}
+
+			page, err := uc.ListMetrics(ctx, tt.filter)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, server.ErrInvalidFilter)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, names(page))
+			assert.Nil(t, page.Next)
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		assert.NoError(t, storage.UpdateMetricList(ctx, metrics))
+		uc := server.NewMetricUsecase(storage, storage, storage)
+
+		var got []string
+		filter := server.ListFilter{Limit: 2}
+		for pages := 1; ; pages++ {
+			page, err := uc.ListMetrics(ctx, filter)
+			assert.NoError(t, err)
+			got = append(got, names(page)...)
+
+			if page.Next == nil {
+				assert.Equal(t, 3, pages)
+				break
+			}
+
+			// A metric added before the cursor doesn't shift the next page.
+			assert.NoError(t, storage.UpdateMetric(ctx, models.CounterType, "AAA", int64(1)))
+			filter.After = page.Next
+		}
+
+		assert.Equal(t, []string{"PollCount", "Alloc", `cpu{host="a"}`, `cpu{host="b"}`, `mem{host="a"}`}, got)
+	})
+}
+
+func TestServerUsecase_EachMetric(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	for i := 0; i < server.MaxListLimit+1; i++ {
+		assert.NoError(t, storage.UpdateMetric(ctx, models.CounterType, fmt.Sprintf("c%04d", i), int64(i)))
+	}
+	assert.NoError(t, storage.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.5))
+
+	uc := server.NewMetricUsecase(storage, storage, storage)
+
+	t.Run("ignores limit", func(t *testing.T) {
+		want := make([]string, 0, server.MaxListLimit+1)
+		for i := 0; i < server.MaxListLimit+1; i++ {
+			want = append(want, fmt.Sprintf("c%04d", i))
+		}
+
+		var got []string
+		err := uc.EachMetric(ctx, server.ListFilter{Type: models.CounterType, Limit: 1}, func(metric models.Metric) error {
+			got = append(got, metric.Name())
+			return nil
+		})
+		assert.NoError(t, err)
+		// The metrics come in the order of the storage.
+		assert.ElementsMatch(t, want, got)
+	})
+
+	t.Run("without ranger", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		getter := serverMocks.NewMockMetricGetter(ctrl)
+		getter.EXPECT().GetAllMetrics(ctx).Return([]models.Metric{
+			models.NewGauge("Alloc", 1.5),
+			models.NewCounter("PollCount", 3),
+		}, nil)
+
+		uc := server.NewMetricUsecase(getter, storage, storage)
+
+		var got []models.Metric
+		err := uc.EachMetric(ctx, server.ListFilter{Type: models.CounterType}, func(metric models.Metric) error {
+			got = append(got, metric)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Metric{models.NewCounter("PollCount", 3)}, got)
+	})
+
+	t.Run("stops on error", func(t *testing.T) {
+		errStop := errors.New("stop")
+
+		var n int
+		err := uc.EachMetric(ctx, server.ListFilter{}, func(models.Metric) error {
+			n++
+			return errStop
+		})
+		assert.ErrorIs(t, err, errStop)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		err := uc.EachMetric(ctx, server.ListFilter{NamePattern: "["}, func(models.Metric) error {
+			return nil
+		})
+		assert.ErrorIs(t, err, server.ErrInvalidFilter)
+	})
+}
+
+func TestServerUsecase_GetMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	keys := []server.MetricKey{
+		{Type: models.CounterType, Name: "PollCount"},
+		{Type: models.GaugeType, Name: "unknown"},
+		{Type: models.GaugeType, Name: "Alloc"},
+	}
+	wantMetrics := []models.Metric{
+		models.NewCounter("PollCount", 5),
+		models.NewGauge("Alloc", 1.5),
+	}
+	wantMissing := []server.MetricKey{{Type: models.GaugeType, Name: "unknown"}}
+
+	t.Run("TestServerUsecase_GetMetrics_batch", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		assert.NoError(t, storage.UpdateMetricList(ctx, wantMetrics))
+		uc := server.NewMetricUsecase(storage, storage, storage)
+
+		metrics, missing, err := uc.GetMetrics(ctx, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, wantMetrics, metrics)
+		assert.Equal(t, wantMissing, missing)
+	})
+
+	t.Run("TestServerUsecase_GetMetrics_fallback", func(t *testing.T) {
+		mockMetricGetter := serverMocks.NewMockMetricGetter(ctrl)
+		uc := server.NewMetricUsecase(mockMetricGetter, nil, nil)
+
+		gomock.InOrder(
+			mockMetricGetter.EXPECT().GetMetric(ctx, models.CounterType, "PollCount").Return(wantMetrics[0], nil),
+			mockMetricGetter.EXPECT().GetMetric(ctx, models.GaugeType, "unknown").Return(nil, models.ErrMetricsNotFound),
+			mockMetricGetter.EXPECT().GetMetric(ctx, models.GaugeType, "Alloc").Return(wantMetrics[1], nil),
+		)
+
+		metrics, missing, err := uc.GetMetrics(ctx, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, wantMetrics, metrics)
+		assert.Equal(t, wantMissing, missing)
+	})
+
+	t.Run("TestServerUsecase_GetMetrics_error", func(t *testing.T) {
+		mockMetricGetter := serverMocks.NewMockMetricGetter(ctrl)
+		uc := server.NewMetricUsecase(mockMetricGetter, nil, nil)
+
+		mockMetricGetter.EXPECT().GetMetric(ctx, models.CounterType, "PollCount").Return(nil, errors.New("db is down"))
+
+		_, _, err := uc.GetMetrics(ctx, keys)
+		assert.Error(t, err)
+	})
+}
+
+func TestServerUsecase_Version(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	t.Run("TestServerUsecase_Version_unsupported", func(t *testing.T) {
+		uc := server.NewMetricUsecase(serverMocks.NewMockMetricGetter(ctrl), nil, nil)
+
+		_, err := uc.Version(ctx)
+		assert.ErrorIs(t, err, server.ErrVersionUnsupported)
+	})
+
+	t.Run("TestServerUsecase_Version_etag", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := server.NewMetricUsecase(storage, storage, storage)
+
+		version, err := uc.Version(ctx)
+		assert.NoError(t, err)
+
+		etag := version.ETag("json")
+		assert.NotEqual(t, etag, version.ETag("html"))
+		assert.True(t, server.MatchETag(etag, etag))
+		assert.True(t, server.MatchETag(`"a", `+strings.TrimPrefix(etag, "W/"), etag))
+		assert.True(t, server.MatchETag("*", etag))
+		assert.False(t, server.MatchETag("", etag))
+
+		assert.NoError(t, uc.UpdateMetric(ctx, models.GaugeType, "Alloc", 1.0))
+
+		updated, err := uc.Version(ctx)
+		assert.NoError(t, err)
+		assert.False(t, server.MatchETag(etag, updated.ETag("json")))
+	})
+}
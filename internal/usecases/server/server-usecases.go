@@ -2,15 +2,39 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+// RestoreMode defines how a snapshot is restored.
+type RestoreMode string
+
+const (
+	// RestoreMerge applies the snapshot on top of the current metrics:
+	// the metrics of the snapshot, counters included, are set to its values
+	// and the others are kept.
+	RestoreMerge RestoreMode = "merge"
+	// RestoreReplace drops the current metrics and stores the snapshot.
+	RestoreReplace RestoreMode = "replace"
+)
+
+var (
+	ErrInvalidRestoreMode = errors.New("invalid restore mode")
+	ErrReplaceUnsupported = errors.New("storage doesn't support replacing all metrics")
+	ErrMergeUnsupported   = errors.New("storage doesn't support setting metrics")
 )
 
 type MetricUsecase struct {
 	getter  MetricGetter
 	updater MetricUpdater
 	closer  Closer
+	tenants Tenants
+	hook    UpdateHook
 }
 
 func NewMetricUsecase(g MetricGetter, u MetricUpdater, c Closer) *MetricUsecase {
@@ -21,8 +45,69 @@ func NewMetricUsecase(g MetricGetter, u MetricUpdater, c Closer) *MetricUsecase
 	}
 }
 
+// WithTenants enables multi-tenancy: requests whose context carries a tenant
+// (see tenant.WithTenant) are served from the storage of that tenant.
+// Requests without a tenant keep using the storage passed to NewMetricUsecase.
+func (uc *MetricUsecase) WithTenants(t Tenants) *MetricUsecase {
+	uc.tenants = t
+	return uc
+}
+
+// storage returns the getter and updater of the request tenant.
+func (uc *MetricUsecase) storage(ctx context.Context) (MetricGetter, MetricUpdater, error) {
+	id := tenant.FromContext(ctx)
+	if id == tenant.Default || uc.tenants == nil {
+		return uc.getter, uc.updater, nil
+	}
+
+	collector, err := uc.tenants.ForTenant(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tenant %s storage: %w", id, err)
+	}
+
+	return collector, collector, nil
+}
+
+// allowed drops the metrics the API token of the request may not read
+// (see apitoken.WithToken).
+func allowed(ctx context.Context, metrics []models.Metric) []models.Metric {
+	if _, ok := apitoken.FromContext(ctx); !ok {
+		return metrics
+	}
+
+	visible := make([]models.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if apitoken.AllowedName(ctx, metric.Name()) {
+			visible = append(visible, metric)
+		}
+	}
+
+	return visible
+}
+
+// checkWrite checks that the API token of the request may write every metric.
+func checkWrite(ctx context.Context, names ...string) error {
+	for _, name := range names {
+		if !apitoken.AllowedName(ctx, name) {
+			return fmt.Errorf("%w: metric %s", apitoken.ErrForbidden, name)
+		}
+	}
+
+	return nil
+}
+
 func (uc *MetricUsecase) GetMetric(ctx context.Context, mType, mName string) (models.Metric, error) {
-	metric, err := uc.getter.GetMetric(ctx, mType, mName)
+	// A metric hidden from the token is not told apart from a missing one.
+	if !apitoken.AllowedName(ctx, mName) {
+		return nil, fmt.Errorf("metric not found: %w", models.ErrMetricsNotFound)
+	}
+
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metric, err := getter.GetMetric(ctx, mType, mName)
 	if err != nil {
 		return nil, fmt.Errorf("metric not found: %w", err)
 	}
@@ -31,26 +116,223 @@ func (uc *MetricUsecase) GetMetric(ctx context.Context, mType, mName string) (mo
 }
 
 func (uc *MetricUsecase) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
-	allMetrics, err := uc.getter.GetAllMetrics(ctx)
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allMetrics, err := getter.GetAllMetrics(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all metrics: %w", err)
 	}
 
-	return allMetrics, nil
+	return allowed(ctx, allMetrics), nil
+}
+
+// GetMetrics looks up the metrics with the given keys, in one storage call
+// if the storage supports it. The found metrics are returned in the order
+// of the keys, the keys of the metrics that were not found are returned as missing.
+func (uc *MetricUsecase) GetMetrics(ctx context.Context, keys []MetricKey) ([]models.Metric, []MetricKey, error) {
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The keys hidden from the API token are returned as missing.
+	requested := keys
+	if _, ok := apitoken.FromContext(ctx); ok {
+		requested = nil
+		for _, key := range keys {
+			if apitoken.AllowedName(ctx, key.Name) {
+				requested = append(requested, key)
+			}
+		}
+	}
+
+	var found []models.Metric
+	if batchGetter, ok := getter.(MetricBatchGetter); ok {
+		found, err = batchGetter.GetMetrics(ctx, requested)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get metrics: %w", err)
+		}
+	} else {
+		for _, key := range requested {
+			metric, err := getter.GetMetric(ctx, key.Type, key.Name)
+			if err != nil {
+				if errors.Is(err, models.ErrMetricsNotFound) || errors.Is(err, models.ErrInvalidMetricsType) {
+					continue
+				}
+				return nil, nil, fmt.Errorf("failed to get metric %s: %w", key.Name, err)
+			}
+			found = append(found, metric)
+		}
+	}
+
+	byKey := make(map[MetricKey]models.Metric, len(found))
+	for _, metric := range found {
+		byKey[MetricKey{Type: metric.Type(), Name: metric.Name()}] = metric
+	}
+
+	metrics := make([]models.Metric, 0, len(found))
+	var missing []MetricKey
+	for _, key := range keys {
+		if metric, ok := byKey[key]; ok {
+			metrics = append(metrics, metric)
+		} else {
+			missing = append(missing, key)
+		}
+	}
+
+	return metrics, missing, nil
 }
 
 func (uc *MetricUsecase) UpdateMetric(ctx context.Context, mType, mName string, value any) error {
-	if err := uc.updater.UpdateMetric(ctx, mType, mName, value); err != nil {
+	if err := checkWrite(ctx, mName); err != nil {
+		return err
+	}
+
+	_, updater, err := uc.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	applier, ok := uc.applier(ctx, updater)
+	if !ok {
+		if err := updater.UpdateMetric(ctx, mType, mName, value); err != nil {
+			return fmt.Errorf("failed to update metric: %w", err)
+		}
+
+		ratelimit.Charge(ctx, 1)
+		return nil
+	}
+
+	metric, err := newMetric(mType, mName, value)
+	if err != nil {
+		return fmt.Errorf("failed to update metric: %w", err)
+	}
+
+	stored, err := applier.ApplyMetricList(ctx, []models.Metric{metric})
+	if err != nil {
 		return fmt.Errorf("failed to update metric: %w", err)
 	}
 
+	ratelimit.Charge(ctx, 1)
+	uc.notify(ctx, stored)
+
 	return nil
 }
 
 func (uc *MetricUsecase) UpdateMetricList(ctx context.Context, metrics []models.Metric) error {
-	if err := uc.updater.UpdateMetricList(ctx, metrics); err != nil {
+	for _, metric := range metrics {
+		if err := checkWrite(ctx, metric.Name()); err != nil {
+			return err
+		}
+	}
+
+	_, updater, err := uc.storage(ctx)
+	if err != nil {
+		return err
+	}
+
+	applier, ok := uc.applier(ctx, updater)
+	if !ok {
+		if err := updater.UpdateMetricList(ctx, metrics); err != nil {
+			return fmt.Errorf("failed to update metric list: %w", err)
+		}
+
+		ratelimit.Charge(ctx, len(metrics))
+		return nil
+	}
+
+	stored, err := applier.ApplyMetricList(ctx, metrics)
+	if err != nil {
 		return fmt.Errorf("failed to update metric list: %w", err)
 	}
 
+	ratelimit.Charge(ctx, len(metrics))
+	uc.notify(ctx, stored)
+
 	return nil
 }
+
+// Snapshot returns all metrics at a single point in time, if the storage
+// supports it, or all metrics otherwise.
+func (uc *MetricUsecase) Snapshot(ctx context.Context) ([]models.Metric, error) {
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotter, ok := getter.(MetricSnapshotter)
+	if !ok {
+		return uc.GetAllMetrics(ctx)
+	}
+
+	metrics, err := snapshotter.Snapshot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take snapshot: %w", err)
+	}
+
+	return allowed(ctx, metrics), nil
+}
+
+// Restore stores the metrics of a snapshot according to the mode.
+func (uc *MetricUsecase) Restore(ctx context.Context, metrics []models.Metric, mode RestoreMode) error {
+	switch mode {
+	case RestoreMerge:
+		// A snapshot holds counter totals, adding them would double the
+		// counters restored twice.
+		for _, metric := range metrics {
+			if err := checkWrite(ctx, metric.Name()); err != nil {
+				return err
+			}
+		}
+
+		_, updater, err := uc.storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		setter, ok := updater.(MetricSetter)
+		if !ok {
+			return ErrMergeUnsupported
+		}
+
+		if err := setter.SetMetricList(ctx, metrics); err != nil {
+			return fmt.Errorf("failed to merge metrics: %w", err)
+		}
+
+		// The metrics are stored with their values, so they are notified as they are.
+		uc.notify(ctx, metrics)
+
+		return nil
+
+	case RestoreReplace:
+		// Replacing drops the metrics outside the prefix of a token too.
+		if t, ok := apitoken.FromContext(ctx); ok && t.Prefix != "" {
+			return fmt.Errorf("%w: replace with a name prefix", apitoken.ErrForbidden)
+		}
+
+		_, updater, err := uc.storage(ctx)
+		if err != nil {
+			return err
+		}
+
+		replacer, ok := updater.(MetricReplacer)
+		if !ok {
+			return ErrReplaceUnsupported
+		}
+
+		if err := replacer.ReplaceAll(ctx, metrics); err != nil {
+			return fmt.Errorf("failed to replace metrics: %w", err)
+		}
+
+		// Dropped metrics are not notified, only the restored ones.
+		uc.notify(ctx, metrics)
+
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidRestoreMode, mode)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrVersionUnsupported = errors.New("storage doesn't keep a change version")
+
+// Version is the change version of a storage. Seq grows with every write,
+// and may also grow on a write that didn't change any value.
+type Version struct {
+	Seq uint64
+	// Modified is the time of the last write, or of the storage creation.
+	Modified time.Time
+}
+
+// ETag returns a weak entity tag of the storage state for a representation:
+// variant tells apart the responses built from the same state, e.g. the
+// JSON and HTML pages or the values of different metrics.
+//
+// The tag includes the modification time, so the tags of a storage that
+// restarted its Seq after a restart don't collide with the old ones.
+func (v Version) ETag(variant string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(variant))
+
+	return fmt.Sprintf(`W/"%s-%s-%08x"`,
+		strconv.FormatUint(v.Seq, 36), strconv.FormatInt(v.Modified.UnixNano(), 36), h.Sum32())
+}
+
+// MatchETag reports whether an If-None-Match value matches etag, using
+// the weak comparison: "*" or any of the listed tags, with or without "W/".
+func MatchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Version returns the change version of the storage of the request tenant,
+// or ErrVersionUnsupported if the storage doesn't keep one.
+//
+// The version must be read before the data it describes: a write between
+// the two then only makes the tag older than the data, never newer.
+func (uc *MetricUsecase) Version(ctx context.Context) (Version, error) {
+	getter, _, err := uc.storage(ctx)
+	if err != nil {
+		return Version{}, err
+	}
+
+	versioner, ok := getter.(MetricVersioner)
+	if !ok {
+		return Version{}, ErrVersionUnsupported
+	}
+
+	version, err := versioner.Version(ctx)
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to get storage version: %w", err)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,278 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	statsdParser "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/statsd"
+)
+
+// MaxTimingSamples is the number of timings of a series kept between
+// flushes for the percentiles; count, min, max and mean use all timings.
+const MaxTimingSamples = 10000
+
+// Percentiles are the timing percentiles stored on flush.
+var Percentiles = []float64{50, 90, 99}
+
+type gauge struct {
+	value float64
+	// known reports whether value is absolute; otherwise it is the sum
+	// of the deltas received before the first absolute value.
+	known bool
+	dirty bool
+}
+
+type timing struct {
+	name  string
+	tags  models.Labels
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+	// n is the number of received timings, values are the first of them.
+	n      int
+	values []float64
+}
+
+func (t *timing) add(value, count float64) {
+	if t.n == 0 || value < t.min {
+		t.min = value
+	}
+	if t.n == 0 || value > t.max {
+		t.max = value
+	}
+	t.n++
+	t.sum += value
+	t.count += count
+
+	if len(t.values) < MaxTimingSamples {
+		t.values = append(t.values, value)
+	}
+}
+
+func (t *timing) merge(other *timing) {
+	if other.n == 0 {
+		return
+	}
+	if t.n == 0 || other.min < t.min {
+		t.min = other.min
+	}
+	if t.n == 0 || other.max > t.max {
+		t.max = other.max
+	}
+	t.n += other.n
+	t.sum += other.sum
+	t.count += other.count
+
+	room := max(MaxTimingSamples-len(t.values), 0)
+	t.values = append(t.values, other.values[:min(room, len(other.values))]...)
+}
+
+// StatsDUsecase aggregates StatsD samples in memory and flushes them into
+// the storage.
+//
+// Counters add up the received values divided by their sample rates; the
+// integer part is flushed, the fraction is kept for the next flush.
+// Gauges keep their last value; a delta changes the in-memory value, or the
+// stored one if the gauge has not been set since the start. A timing
+// series NAME is flushed as the counter NAME.count and the gauges
+// NAME.min, NAME.max, NAME.mean and NAME.pXX for every percentile.
+type StatsDUsecase struct {
+	store MetricStore
+
+	mutex    sync.Mutex
+	counters map[string]float64
+	gauges   map[string]*gauge
+	timings  map[string]*timing
+}
+
+func NewStatsDUsecase(store MetricStore) *StatsDUsecase {
+	return &StatsDUsecase{
+		store:    store,
+		counters: make(map[string]float64),
+		gauges:   make(map[string]*gauge),
+		timings:  make(map[string]*timing),
+	}
+}
+
+// HandleLine parses a line and adds its sample.
+func (uc *StatsDUsecase) HandleLine(line string) error {
+	sample, err := statsdParser.Parse(line)
+	if err != nil {
+		return err
+	}
+
+	uc.Add(sample)
+	return nil
+}
+
+// Add adds a sample to the aggregates.
+func (uc *StatsDUsecase) Add(sample *statsdParser.Sample) {
+	id := models.JoinName(sample.Name, sample.Tags)
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	switch sample.Type {
+	case statsdParser.Counter:
+		uc.counters[id] += sample.Value / sample.SampleRate
+	case statsdParser.Gauge:
+		g, ok := uc.gauges[id]
+		if !ok {
+			g = &gauge{}
+			uc.gauges[id] = g
+		}
+		if sample.Delta {
+			g.value += sample.Value
+		} else {
+			g.value, g.known = sample.Value, true
+		}
+		g.dirty = true
+	case statsdParser.Timing:
+		t, ok := uc.timings[id]
+		if !ok {
+			t = &timing{name: sample.Name, tags: sample.Tags}
+			uc.timings[id] = t
+		}
+		t.add(sample.Value, 1/sample.SampleRate)
+	}
+}
+
+// Flush stores the aggregates received since the last flush with a single
+// UpdateMetricList call. If it fails, the aggregates are kept for the next
+// flush.
+func (uc *StatsDUsecase) Flush(ctx context.Context) error {
+	uc.mutex.Lock()
+	counters := uc.counters
+	timings := uc.timings
+	uc.counters = make(map[string]float64)
+	uc.timings = make(map[string]*timing)
+
+	gauges := make(map[string]gauge)
+	for id, g := range uc.gauges {
+		if g.dirty {
+			gauges[id] = *g
+			g.dirty = false
+		}
+	}
+	uc.mutex.Unlock()
+
+	metrics := make([]models.Metric, 0, len(counters)+len(gauges)+len(timings)*(4+len(Percentiles)))
+	remainders := make(map[string]float64)
+	baselines := make(map[string]float64)
+
+	for id, value := range counters {
+		whole := math.Trunc(value)
+		if value != whole {
+			remainders[id] = value - whole
+		}
+		if whole != 0 {
+			metrics = append(metrics, models.NewCounter(id, int64(whole)))
+		}
+	}
+
+	for id, g := range gauges {
+		if !g.known {
+			baselines[id] = uc.storedGauge(ctx, id)
+			g.value += baselines[id]
+		}
+		metrics = append(metrics, models.NewGauge(id, g.value))
+	}
+
+	for _, t := range timings {
+		metrics = append(metrics, t.metrics()...)
+	}
+
+	if len(metrics) > 0 {
+		if err := uc.store.UpdateMetricList(ctx, metrics); err != nil {
+			uc.restore(counters, gauges, timings)
+			return fmt.Errorf("failed to flush statsd metrics: %w", err)
+		}
+	}
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	for id, value := range remainders {
+		uc.counters[id] += value
+	}
+
+	// A gauge whose baseline was read from the storage becomes absolute,
+	// unless it has been set meanwhile.
+	for id, baseline := range baselines {
+		if g := uc.gauges[id]; !g.known {
+			g.value += baseline
+			g.known = true
+		}
+	}
+
+	return nil
+}
+
+// restore puts the aggregates of a failed flush back.
+func (uc *StatsDUsecase) restore(counters map[string]float64, gauges map[string]gauge, timings map[string]*timing) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	for id, value := range counters {
+		uc.counters[id] += value
+	}
+
+	for id := range gauges {
+		uc.gauges[id].dirty = true
+	}
+
+	for id, t := range timings {
+		if current, ok := uc.timings[id]; ok {
+			t.merge(current)
+		}
+		uc.timings[id] = t
+	}
+}
+
+func (uc *StatsDUsecase) storedGauge(ctx context.Context, id string) float64 {
+	metric, err := uc.store.GetMetric(ctx, models.GaugeType, id)
+	if err != nil {
+		return 0
+	}
+
+	value, ok := metric.Value().(float64)
+	if !ok {
+		return 0
+	}
+
+	return value
+}
+
+func (t *timing) metrics() []models.Metric {
+	name := func(suffix string) string {
+		return models.JoinName(t.name+"."+suffix, t.tags)
+	}
+
+	metrics := []models.Metric{
+		models.NewCounter(name("count"), int64(math.Round(t.count))),
+		models.NewGauge(name("min"), t.min),
+		models.NewGauge(name("max"), t.max),
+		models.NewGauge(name("mean"), t.sum/float64(t.n)),
+	}
+
+	sorted := append([]float64(nil), t.values...)
+	sort.Float64s(sorted)
+
+	for _, p := range Percentiles {
+		metrics = append(metrics, models.NewGauge(name("p"+strconv.FormatFloat(p, 'f', -1, 64)), percentile(sorted, p)))
+	}
+
+	return metrics
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,116 @@
+package statsd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/statsd"
+	statsdMocks "github.com/rAch-kaplin/mipt-golang-course/MetricsService/test/mocks/usecase/statsd"
+)
+
+func TestStatsDUsecase_Flush(t *testing.T) {
+	ctx := context.Background()
+
+	handle := func(t *testing.T, uc *statsd.StatsDUsecase, lines ...string) {
+		t.Helper()
+
+		for _, line := range lines {
+			require.NoError(t, uc.HandleLine(line))
+		}
+	}
+
+	value := func(t *testing.T, storage *repo.MemStorage, mType, name string) any {
+		t.Helper()
+
+		metric, err := storage.GetMetric(ctx, mType, name)
+		require.NoError(t, err)
+		return metric.Value()
+	}
+
+	t.Run("TestStatsDUsecase_Flush_counters", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := statsd.NewStatsDUsecase(storage)
+
+		handle(t, uc, "requests:1|c", "requests:2|c", "sampled:1|c|@0.4", "tagged:1|c|#host:a")
+		require.NoError(t, uc.Flush(ctx))
+
+		assert.Equal(t, int64(3), value(t, storage, models.CounterType, "requests"))
+		assert.Equal(t, int64(2), value(t, storage, models.CounterType, "sampled"))
+		assert.Equal(t, int64(1), value(t, storage, models.CounterType, `tagged{host="a"}`))
+
+		// The fraction of 1/0.4 is carried over to the next flush.
+		handle(t, uc, "sampled:1|c|@0.4")
+		require.NoError(t, uc.Flush(ctx))
+		assert.Equal(t, int64(5), value(t, storage, models.CounterType, "sampled"))
+	})
+
+	t.Run("TestStatsDUsecase_Flush_gauges", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		require.NoError(t, storage.UpdateMetric(ctx, models.GaugeType, "connections", 10.0))
+		uc := statsd.NewStatsDUsecase(storage)
+
+		handle(t, uc, "queue:5|g", "queue:+3|g", "queue:-1|g", "connections:+2|g")
+		require.NoError(t, uc.Flush(ctx))
+
+		assert.Equal(t, 7.0, value(t, storage, models.GaugeType, "queue"))
+		// Deltas of a gauge not set since the start change the stored value.
+		assert.Equal(t, 12.0, value(t, storage, models.GaugeType, "connections"))
+
+		handle(t, uc, "connections:-4|g")
+		require.NoError(t, uc.Flush(ctx))
+		assert.Equal(t, 8.0, value(t, storage, models.GaugeType, "connections"))
+	})
+
+	t.Run("TestStatsDUsecase_Flush_timings", func(t *testing.T) {
+		storage := repo.NewMemStorage()
+		uc := statsd.NewStatsDUsecase(storage)
+
+		for _, line := range []string{"db:40|ms", "db:10|ms", "db:30|ms", "db:20|ms|@0.5"} {
+			handle(t, uc, line)
+		}
+		require.NoError(t, uc.Flush(ctx))
+
+		assert.Equal(t, int64(5), value(t, storage, models.CounterType, "db.count"))
+		assert.Equal(t, 10.0, value(t, storage, models.GaugeType, "db.min"))
+		assert.Equal(t, 40.0, value(t, storage, models.GaugeType, "db.max"))
+		assert.Equal(t, 25.0, value(t, storage, models.GaugeType, "db.mean"))
+		assert.Equal(t, 20.0, value(t, storage, models.GaugeType, "db.p50"))
+		assert.Equal(t, 40.0, value(t, storage, models.GaugeType, "db.p90"))
+		assert.Equal(t, 40.0, value(t, storage, models.GaugeType, "db.p99"))
+	})
+
+	t.Run("TestStatsDUsecase_Flush_retry_after_failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := statsdMocks.NewMockMetricStore(ctrl)
+		uc := statsd.NewStatsDUsecase(store)
+
+		gomock.InOrder(
+			store.EXPECT().UpdateMetricList(ctx, gomock.Any()).Return(errors.New("db is down")),
+			store.EXPECT().UpdateMetricList(ctx, []models.Metric{models.NewCounter("requests", 3)}).Return(nil),
+		)
+
+		handle(t, uc, "requests:1|c")
+		require.Error(t, uc.Flush(ctx))
+
+		// The failed flush is retried with the samples received since.
+		handle(t, uc, "requests:2|c")
+		require.NoError(t, uc.Flush(ctx))
+
+		// Nothing to flush.
+		require.NoError(t, uc.Flush(ctx))
+	})
+
+	t.Run("TestStatsDUsecase_HandleLine_invalid", func(t *testing.T) {
+		uc := statsd.NewStatsDUsecase(repo.NewMemStorage())
+		assert.Error(t, uc.HandleLine("requests:1|s"))
+	})
+}
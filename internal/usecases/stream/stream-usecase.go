@@ -0,0 +1,245 @@
+// Package stream fans the stored metric updates out to the subscribers of
+// the live update stream.
+package stream
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+)
+
+// DefaultBufferSize is the number of updates queued for a subscriber.
+const DefaultBufferSize = 256
+
+var (
+	// ErrSlowSubscriber ends a subscription that doesn't keep up with the
+	// updates. The subscriber has missed updates and should subscribe again
+	// to start from a fresh snapshot.
+	ErrSlowSubscriber = errors.New("subscriber too slow, updates dropped")
+	// ErrClosed ends the subscriptions when the hub is closed.
+	ErrClosed = errors.New("stream closed")
+)
+
+// Hub passes the updates of MetricUsecase to the subscriptions whose filter
+// they match. It is the usecase's UpdateHook.
+//
+// Publishing never blocks the update: every subscription has a queue of
+// updates, and a subscription whose queue is full is ended with
+// ErrSlowSubscriber instead of silently losing updates.
+type Hub struct {
+	bufferSize int
+
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	// tenants counts the subscriptions of every tenant.
+	tenants map[string]int
+	closed  bool
+}
+
+var _ srvUsecase.UpdateHook = (*Hub)(nil)
+
+func NewHub() *Hub {
+	return &Hub{
+		bufferSize:  DefaultBufferSize,
+		subscribers: make(map[*Subscription]struct{}),
+		tenants:     make(map[string]int),
+	}
+}
+
+// WithBufferSize sets the number of updates queued for a subscriber.
+func (h *Hub) WithBufferSize(size int) *Hub {
+	if size > 0 {
+		h.bufferSize = size
+	}
+	return h
+}
+
+// Wants reports whether the tenant has subscriptions.
+func (h *Hub) Wants(tenant string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.tenants[tenant] > 0
+}
+
+// Updated queues the metrics matching the filter of every subscription of the tenant.
+func (h *Hub) Updated(tenant string, metrics []models.Metric) {
+	// Converting copies the values, which may change after the call.
+	updates, err := converter.ConvertToSerialization(metrics)
+	if err != nil {
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for sub := range h.subscribers {
+		if sub.tenant == tenant {
+			sub.publish(metrics, updates)
+		}
+	}
+}
+
+// Subscribe starts a subscription to the updates of the tenant matching
+// the filter. The filter's After and Limit are ignored. The subscription
+// must be closed.
+func (h *Hub) Subscribe(tenant string, filter srvUsecase.ListFilter) (*Subscription, error) {
+	if err := validate(&filter); err != nil {
+		return nil, err
+	}
+
+	sub := &Subscription{
+		hub:     h,
+		tenant:  tenant,
+		filter:  filter,
+		updates: make(chan []serialize.Metric, h.bufferSize),
+		done:    make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+
+	h.subscribers[sub] = struct{}{}
+	h.tenants[tenant]++
+
+	return sub, nil
+}
+
+// Close ends all subscriptions with ErrClosed and rejects new ones.
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		sub.end(ErrClosed)
+	}
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(h.subscribers, sub)
+	if h.tenants[sub.tenant]--; h.tenants[sub.tenant] == 0 {
+		delete(h.tenants, sub.tenant)
+	}
+}
+
+// Subscription is a subscription to the updates of a tenant.
+type Subscription struct {
+	hub    *Hub
+	tenant string
+
+	filterMutex sync.RWMutex
+	filter      srvUsecase.ListFilter
+
+	updates chan []serialize.Metric
+
+	endOnce sync.Once
+	done    chan struct{}
+	err     error
+}
+
+// Updates returns the queue of updates, a batch per stored update.
+func (s *Subscription) Updates() <-chan []serialize.Metric {
+	return s.updates
+}
+
+// Done is closed when the subscription is ended by the hub, see Err.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription was ended: ErrSlowSubscriber, ErrClosed
+// or nil if it was closed by the subscriber.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Filter returns the filter of the subscription.
+func (s *Subscription) Filter() srvUsecase.ListFilter {
+	s.filterMutex.RLock()
+	defer s.filterMutex.RUnlock()
+
+	return s.filter
+}
+
+// SetFilter changes the filter of the subscription. The updates already
+// queued are not filtered again.
+func (s *Subscription) SetFilter(filter srvUsecase.ListFilter) error {
+	if err := validate(&filter); err != nil {
+		return err
+	}
+
+	s.filterMutex.Lock()
+	defer s.filterMutex.Unlock()
+
+	s.filter = filter
+	return nil
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.end(nil)
+	s.hub.remove(s)
+}
+
+func (s *Subscription) end(err error) {
+	s.endOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// publish queues the updates whose metrics match the filter.
+func (s *Subscription) publish(metrics []models.Metric, updates []serialize.Metric) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	s.filterMutex.RLock()
+	var matched []serialize.Metric
+	for i, metric := range metrics {
+		if s.filter.Match(metric) {
+			matched = append(matched, updates[i])
+		}
+	}
+	s.filterMutex.RUnlock()
+
+	if len(matched) == 0 {
+		return
+	}
+
+	select {
+	case s.updates <- matched:
+	default:
+		s.end(ErrSlowSubscriber)
+	}
+}
+
+func validate(filter *srvUsecase.ListFilter) error {
+	filter.After = nil
+	filter.Limit = 0
+
+	return filter.Validate()
+}
@@ -0,0 +1,113 @@
+package stream_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	repo "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/repository"
+	srvUsecase "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/stream"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+func ids(updates []serialize.Metric) []string {
+	var got []string
+	for _, m := range updates {
+		got = append(got, m.ID)
+	}
+	return got
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemStorage()
+	hub := stream.NewHub()
+	uc := srvUsecase.NewMetricUsecase(storage, storage, storage).WithUpdateHook(hub)
+
+	assert.False(t, hub.Wants(tenant.Default))
+
+	sub, err := hub.Subscribe(tenant.Default, srvUsecase.ListFilter{NamePattern: "cpu", Limit: 1})
+	require.NoError(t, err)
+	defer sub.Close()
+	assert.True(t, hub.Wants(tenant.Default))
+
+	require.NoError(t, uc.UpdateMetric(ctx, models.CounterType, "hits", int64(1)))
+	require.NoError(t, uc.UpdateMetricList(ctx, []models.Metric{
+		models.NewGauge(`cpu{host="a"}`, 0.5),
+		models.NewGauge("mem", 1),
+		models.NewGauge(`cpu{host="b"}`, 0.75),
+	}))
+
+	updates := <-sub.Updates()
+	assert.Equal(t, []string{`cpu{host="a"}`, `cpu{host="b"}`}, ids(updates))
+	assert.Equal(t, 0.5, *updates[0].Value)
+
+	t.Run("counters are sent with their total", func(t *testing.T) {
+		require.NoError(t, sub.SetFilter(srvUsecase.ListFilter{Type: models.CounterType}))
+
+		require.NoError(t, uc.UpdateMetric(ctx, models.CounterType, "hits", int64(2)))
+		require.NoError(t, uc.UpdateMetricList(ctx, []models.Metric{
+			models.NewCounter("hits", 3),
+			models.NewCounter("hits", 4),
+		}))
+
+		updates := <-sub.Updates()
+		require.Equal(t, []string{"hits"}, ids(updates))
+		assert.Equal(t, int64(3), *updates[0].Delta)
+
+		updates = <-sub.Updates()
+		require.Equal(t, []string{"hits"}, ids(updates))
+		assert.Equal(t, int64(10), *updates[0].Delta)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		assert.ErrorIs(t, sub.SetFilter(srvUsecase.ListFilter{Type: "histogram"}), srvUsecase.ErrInvalidFilter)
+
+		_, err := hub.Subscribe(tenant.Default, srvUsecase.ListFilter{NamePattern: "["})
+		assert.ErrorIs(t, err, srvUsecase.ErrInvalidFilter)
+	})
+
+	t.Run("other tenants are not sent", func(t *testing.T) {
+		assert.False(t, hub.Wants("team-a"))
+
+		hub.Updated("team-a", []models.Metric{models.NewCounter("hits", 1)})
+		assert.Empty(t, sub.Updates())
+	})
+
+	assert.NoError(t, sub.Err())
+	sub.Close()
+	assert.False(t, hub.Wants(tenant.Default))
+}
+
+func TestHub_slowSubscriber(t *testing.T) {
+	hub := stream.NewHub().WithBufferSize(2)
+
+	slow, err := hub.Subscribe(tenant.Default, srvUsecase.ListFilter{})
+	require.NoError(t, err)
+	defer slow.Close()
+
+	fast, err := hub.Subscribe(tenant.Default, srvUsecase.ListFilter{})
+	require.NoError(t, err)
+	defer fast.Close()
+
+	for i := range 3 {
+		hub.Updated(tenant.Default, []models.Metric{models.NewGauge("a", float64(i))})
+		<-fast.Updates()
+	}
+
+	<-slow.Done()
+	assert.ErrorIs(t, slow.Err(), stream.ErrSlowSubscriber)
+	assert.NoError(t, fast.Err())
+
+	hub.Close()
+	<-fast.Done()
+	assert.ErrorIs(t, fast.Err(), stream.ErrClosed)
+
+	_, err = hub.Subscribe(tenant.Default, srvUsecase.ListFilter{})
+	assert.ErrorIs(t, err, stream.ErrClosed)
+}
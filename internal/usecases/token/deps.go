@@ -0,0 +1,18 @@
+package token
+
+import (
+	"context"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+)
+
+// Store is implemented by storages that keep API tokens.
+type Store interface {
+	CreateToken(ctx context.Context, t apitoken.Token) error
+	// TokenByHash returns the token of the secret hash, or
+	// apitoken.ErrNotFound.
+	TokenByHash(ctx context.Context, hash string) (apitoken.Token, error)
+	ListTokens(ctx context.Context) ([]apitoken.Token, error)
+	// RevokeToken deletes the token of id, or returns apitoken.ErrNotFound.
+	RevokeToken(ctx context.Context, id string) error
+}
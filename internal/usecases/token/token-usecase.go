@@ -0,0 +1,100 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+)
+
+// MaxNameLength is the maximum length of a token name.
+const MaxNameLength = 128
+
+// ErrInvalidName is returned for an empty or too long token name.
+var ErrInvalidName = errors.New("invalid token name")
+
+type TokenUsecase struct {
+	store Store
+}
+
+func NewTokenUsecase(store Store) *TokenUsecase {
+	return &TokenUsecase{store: store}
+}
+
+// Create stores a new token and returns its secret, which is not stored
+// and can't be shown again.
+func (uc *TokenUsecase) Create(ctx context.Context, name string, scopes []string, prefix string) (string, apitoken.Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxNameLength {
+		return "", apitoken.Token{}, fmt.Errorf("%w: must have 1 to %d characters", ErrInvalidName, MaxNameLength)
+	}
+
+	parsed, err := apitoken.ParseScopes(scopes)
+	if err != nil {
+		return "", apitoken.Token{}, err
+	}
+
+	id, err := apitoken.NewID()
+	if err != nil {
+		return "", apitoken.Token{}, err
+	}
+
+	secret, err := apitoken.NewSecret()
+	if err != nil {
+		return "", apitoken.Token{}, err
+	}
+
+	t := apitoken.Token{
+		ID:      id,
+		Name:    name,
+		Hash:    apitoken.Hash(secret),
+		Scopes:  parsed,
+		Prefix:  prefix,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if err := uc.store.CreateToken(ctx, t); err != nil {
+		return "", apitoken.Token{}, fmt.Errorf("failed to create token: %w", err)
+	}
+
+	return secret, t, nil
+}
+
+// Authenticate returns the token of secret, or apitoken.ErrInvalidToken.
+func (uc *TokenUsecase) Authenticate(ctx context.Context, secret string) (apitoken.Token, error) {
+	if !apitoken.IsSecret(secret) {
+		return apitoken.Token{}, apitoken.ErrInvalidToken
+	}
+
+	t, err := uc.store.TokenByHash(ctx, apitoken.Hash(secret))
+	if errors.Is(err, apitoken.ErrNotFound) {
+		return apitoken.Token{}, apitoken.ErrInvalidToken
+	}
+	if err != nil {
+		return apitoken.Token{}, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return t, nil
+}
+
+// List returns all tokens.
+func (uc *TokenUsecase) List(ctx context.Context) ([]apitoken.Token, error) {
+	tokens, err := uc.store.ListTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// Revoke deletes the token of id, so its secret is rejected from now on.
+func (uc *TokenUsecase) Revoke(ctx context.Context, id string) error {
+	if err := uc.store.RevokeToken(ctx, id); err != nil {
+		return fmt.Errorf("failed to revoke token %s: %w", id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package admission provides the limits the server admits requests with:
+// the size of a request body before and after decompression, the number of
+// metrics in a batch, and the number of requests served at once.
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultMaxBodySize         = 32 << 20
+	DefaultMaxDecompressedSize = 64 << 20
+	DefaultMaxBatchSize        = 10000
+	DefaultMaxInFlight         = 0
+)
+
+var (
+	// ErrTooLarge is returned by the readers of LimitReader past the limit.
+	ErrTooLarge = errors.New("request body too large")
+	// ErrBatchTooLarge is returned by CheckBatch.
+	ErrBatchTooLarge = errors.New("too many metrics in a batch")
+)
+
+// Limits are the limits of requests. A zero limit doesn't limit.
+type Limits struct {
+	// MaxBodySize is the largest request body in bytes, as it is sent,
+	// or the largest gRPC message.
+	MaxBodySize int64
+	// MaxDecompressedSize is the largest request body in bytes after it
+	// is decompressed.
+	MaxDecompressedSize int64
+	// MaxBatchSize is the largest number of metrics in a batch update.
+	MaxBatchSize int
+	// MaxInFlight is the largest number of requests served at once, the
+	// requests over it are shed.
+	MaxInFlight int
+}
+
+// DefaultLimits returns the default limits.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodySize:         DefaultMaxBodySize,
+		MaxDecompressedSize: DefaultMaxDecompressedSize,
+		MaxBatchSize:        DefaultMaxBatchSize,
+		MaxInFlight:         DefaultMaxInFlight,
+	}
+}
+
+// CheckBatch checks that a batch of n metrics is within MaxBatchSize.
+func (l Limits) CheckBatch(n int) error {
+	if l.MaxBatchSize > 0 && n > l.MaxBatchSize {
+		return fmt.Errorf("%w: %d metrics, at most %d", ErrBatchTooLarge, n, l.MaxBatchSize)
+	}
+
+	return nil
+}
+
+// LimitReader returns a reader of r that fails with ErrTooLarge if r has
+// more than n bytes, e.g. a decompressed body. It returns r if n is not
+// positive.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	if n <= 0 {
+		return r
+	}
+
+	return &limitedReader{r: r, left: n, limit: n}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	left  int64
+	limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, l.err()
+	}
+
+	// Read a byte past the limit to tell a body of exactly the limit from
+	// a larger one.
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		n = int(l.left)
+		l.left = -1
+		return n, l.err()
+	}
+	l.left -= int64(n)
+
+	return n, err
+}
+
+func (l *limitedReader) err() error {
+	return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, l.limit)
+}
+
+// Gate counts the requests served at once. A nil *Gate admits everything.
+type Gate struct {
+	slots chan struct{}
+}
+
+// NewGate returns a gate admitting n requests at once, or nil if n is not
+// positive.
+func NewGate(n int) *Gate {
+	if n <= 0 {
+		return nil
+	}
+
+	return &Gate{slots: make(chan struct{}, n)}
+}
+
+// Enter admits a request if less than n are served, without waiting.
+// An admitted request must Leave.
+func (g *Gate) Enter() bool {
+	if g == nil {
+		return true
+	}
+
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Leave ends a request admitted by Enter.
+func (g *Gate) Leave() {
+	if g == nil {
+		return
+	}
+
+	<-g.slots
+}
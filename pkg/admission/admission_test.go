@@ -0,0 +1,86 @@
+package admission_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+)
+
+func TestLimitReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr bool
+	}{
+		{name: "below", body: "abc", limit: 4},
+		{name: "exact", body: "abcd", limit: 4},
+		{name: "above", body: "abcde", limit: 4, wantErr: true},
+		{name: "unlimited", body: "abcde", limit: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := io.ReadAll(admission.LimitReader(strings.NewReader(tt.body), tt.limit))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, admission.ErrTooLarge)
+				assert.Len(t, data, int(tt.limit))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(data))
+		})
+	}
+}
+
+func TestLimitReader_GzipBomb(t *testing.T) {
+	// 64 MiB of zeros compress to about 64 KiB.
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	zeros := make([]byte, 1<<20)
+	for range 64 {
+		_, err := gz.Write(zeros)
+		require.NoError(t, err)
+	}
+	require.NoError(t, gz.Close())
+	require.Less(t, buf.Len(), 1<<20)
+
+	r, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+
+	n, err := io.Copy(io.Discard, admission.LimitReader(r, 1<<20))
+	assert.ErrorIs(t, err, admission.ErrTooLarge)
+	assert.Equal(t, int64(1<<20), n)
+}
+
+func TestLimits_CheckBatch(t *testing.T) {
+	limits := admission.Limits{MaxBatchSize: 2}
+
+	assert.NoError(t, limits.CheckBatch(2))
+	assert.ErrorIs(t, limits.CheckBatch(3), admission.ErrBatchTooLarge)
+	assert.NoError(t, admission.Limits{}.CheckBatch(1000))
+}
+
+func TestGate(t *testing.T) {
+	g := admission.NewGate(2)
+
+	require.True(t, g.Enter())
+	require.True(t, g.Enter())
+	assert.False(t, g.Enter())
+
+	g.Leave()
+	assert.True(t, g.Enter())
+
+	var unlimited *admission.Gate
+	assert.Nil(t, admission.NewGate(0))
+	assert.True(t, unlimited.Enter())
+	unlimited.Leave()
+}
@@ -0,0 +1,144 @@
+// Package apitoken provides the API tokens of the clients of the server:
+// the scopes a token grants, the generation and hashing of token secrets,
+// and passing the token of a request through context.Context.
+//
+// A token is sent as "Authorization: Bearer <secret>". Only the SHA-256 of
+// the secret is stored, so a leaked storage doesn't leak the secrets.
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scope is a permission granted by a token.
+type Scope string
+
+const (
+	// ScopeRead allows reading metrics.
+	ScopeRead Scope = "metrics:read"
+	// ScopeWrite allows storing metrics.
+	ScopeWrite Scope = "metrics:write"
+	// ScopeAdmin allows the admin endpoints, and everything else.
+	ScopeAdmin Scope = "admin"
+)
+
+// SecretPrefix starts every token secret, which tells API tokens from the
+// admin token and the tenant secrets sent in the same header.
+const SecretPrefix = "mst_"
+
+var (
+	// ErrInvalidToken is returned for a secret of no token.
+	ErrInvalidToken = errors.New("invalid api token")
+	// ErrForbidden is returned for a request the token doesn't allow.
+	ErrForbidden = errors.New("forbidden by api token")
+	// ErrInvalidScope is returned for an unknown scope.
+	ErrInvalidScope = errors.New("invalid api token scope")
+	// ErrNotFound is returned for an unknown token ID.
+	ErrNotFound = errors.New("api token not found")
+)
+
+// Token is a stored API token.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex SHA-256 of the secret.
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+	// Prefix restricts the token to the metrics whose names start with
+	// it, all metrics if empty.
+	Prefix  string    `json:"prefix,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Allows reports whether t grants scope.
+func (t Token) Allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// AllowsName reports whether t may access the metric name.
+func (t Token) AllowsName(name string) bool {
+	return strings.HasPrefix(name, t.Prefix)
+}
+
+// ParseScopes validates scopes and returns them without duplicates.
+func ParseScopes(scopes []string) ([]Scope, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: no scopes", ErrInvalidScope)
+	}
+
+	var parsed []Scope
+	for _, s := range scopes {
+		scope := Scope(strings.TrimSpace(s))
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, s)
+		}
+
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+
+	return parsed, nil
+}
+
+// NewSecret returns a random token secret.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// NewID returns a random token ID.
+func NewID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Hash returns the hex SHA-256 of secret, as it is stored.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsSecret reports whether the bearer token looks like an API token.
+func IsSecret(bearer string) bool {
+	return strings.HasPrefix(bearer, SecretPrefix)
+}
+
+type ctxKey struct{}
+
+// WithToken returns a copy of ctx carrying the token of the request.
+func WithToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns the token of ctx, if the request has one.
+func FromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(ctxKey{}).(Token)
+	return t, ok
+}
+
+// AllowedName reports whether the request of ctx may access the metric
+// name: requests without a token may access every metric.
+func AllowedName(ctx context.Context, name string) bool {
+	t, ok := FromContext(ctx)
+	return !ok || t.AllowsName(name)
+}
@@ -0,0 +1,88 @@
+package apitoken_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/apitoken"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []apitoken.Scope
+		wantErr bool
+	}{
+		{name: "read", scopes: []string{"metrics:read"}, want: []apitoken.Scope{apitoken.ScopeRead}},
+		{
+			name:   "duplicates and spaces",
+			scopes: []string{"metrics:write", " metrics:write", "admin"},
+			want:   []apitoken.Scope{apitoken.ScopeWrite, apitoken.ScopeAdmin},
+		},
+		{name: "none", scopes: nil, wantErr: true},
+		{name: "unknown", scopes: []string{"metrics:delete"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, err := apitoken.ParseScopes(tt.scopes)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, apitoken.ErrInvalidScope)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, scopes)
+		})
+	}
+}
+
+func TestToken_Allows(t *testing.T) {
+	writer := apitoken.Token{Scopes: []apitoken.Scope{apitoken.ScopeWrite}, Prefix: "host1."}
+	assert.True(t, writer.Allows(apitoken.ScopeWrite))
+	assert.False(t, writer.Allows(apitoken.ScopeRead))
+	assert.False(t, writer.Allows(apitoken.ScopeAdmin))
+	assert.True(t, writer.AllowsName("host1.cpu"))
+	assert.False(t, writer.AllowsName("host2.cpu"))
+
+	admin := apitoken.Token{Scopes: []apitoken.Scope{apitoken.ScopeAdmin}}
+	assert.True(t, admin.Allows(apitoken.ScopeRead))
+	assert.True(t, admin.Allows(apitoken.ScopeWrite))
+	assert.True(t, admin.AllowsName("anything"))
+}
+
+func TestSecret(t *testing.T) {
+	secret, err := apitoken.NewSecret()
+	require.NoError(t, err)
+	assert.True(t, apitoken.IsSecret(secret))
+	assert.True(t, strings.HasPrefix(secret, apitoken.SecretPrefix))
+
+	other, err := apitoken.NewSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	assert.Len(t, apitoken.Hash(secret), 64)
+	assert.Equal(t, apitoken.Hash(secret), apitoken.Hash(secret))
+	assert.NotEqual(t, apitoken.Hash(secret), apitoken.Hash(other))
+
+	assert.False(t, apitoken.IsSecret("team-secret"))
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+
+	_, ok := apitoken.FromContext(ctx)
+	assert.False(t, ok)
+	assert.True(t, apitoken.AllowedName(ctx, "cpu"), "no token")
+
+	ctx = apitoken.WithToken(ctx, apitoken.Token{ID: "1", Prefix: "host1."})
+	token, ok := apitoken.FromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "1", token.ID)
+	assert.True(t, apitoken.AllowedName(ctx, "host1.cpu"))
+	assert.False(t, apitoken.AllowedName(ctx, "cpu"))
+}
@@ -0,0 +1,118 @@
+// Package bulk encodes and decodes metrics in the tabular formats used to
+// move them in and out of the server in bulk:
+//
+//   - CSV, with the header "id,type,value" and one metric per row;
+//   - NDJSON, one JSON metric of the /update API per line:
+//     {"id":"requests","type":"counter","delta":5}.
+//
+// The id is the metric name with its labels, e.g. `cpu{host="a"}`.
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+)
+
+// Format is a bulk format.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// CSV columns.
+const (
+	ColumnID    = "id"
+	ColumnType  = "type"
+	ColumnValue = "value"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown bulk format")
+	ErrInvalidHeader = errors.New("invalid csv header")
+	ErrInvalidRow    = errors.New("invalid row")
+)
+
+// ParseFormat parses "csv" or "ndjson".
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case CSV, NDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w %q: want %s or %s", ErrUnknownFormat, s, CSV, NDJSON)
+	}
+}
+
+// FormatFromContentType returns the format of a media type, text/csv or
+// application/x-ndjson.
+func FormatFromContentType(contentType string) (Format, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	switch mediaType {
+	case "text/csv":
+		return CSV, true
+	case "application/x-ndjson":
+		return NDJSON, true
+	default:
+		return "", false
+	}
+}
+
+// ContentType returns the content type of the format.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+
+	return "application/x-ndjson"
+}
+
+// RowError is a row that can't be decoded. The rows after it can still be.
+type RowError struct {
+	// Line is the 1-based line of the row in the input.
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// newMetric creates a metric from its decoded fields.
+func newMetric(id, mType string, value any) (models.Metric, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: empty id", ErrInvalidRow)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return models.NewGauge(id, v), nil
+	case int64:
+		return models.NewCounter(id, v), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown metric type %q", ErrInvalidRow, mType)
+	}
+}
+
+// parseMetric creates a metric from its decoded fields, with the value
+// as text.
+func parseMetric(id, mType, value string) (models.Metric, error) {
+	v, err := converter.ConvertByType(mType, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRow, err)
+	}
+
+	return newMetric(id, mType, v)
+}
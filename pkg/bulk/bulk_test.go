@@ -0,0 +1,179 @@
+package bulk_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/bulk"
+)
+
+func testMetrics() []models.Metric {
+	return []models.Metric{
+		models.NewGauge(`cpu{host="a,b"}`, 0.5),
+		models.NewCounter("requests", 42),
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	tests := []struct {
+		format bulk.Format
+		want   string
+	}{
+		{
+			format: bulk.CSV,
+			want: "id,type,value\n" +
+				"\"cpu{host=\"\"a,b\"\"}\",gauge,0.5\n" +
+				"requests,counter,42\n",
+		},
+		{
+			format: bulk.NDJSON,
+			want: `{"id":"cpu{host=\"a,b\"}","type":"gauge","value":0.5}` + "\n" +
+				`{"id":"requests","type":"counter","delta":42}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := bulk.NewEncoder(&buf, tt.format)
+			for _, metric := range testMetrics() {
+				require.NoError(t, enc.Encode(metric))
+			}
+			require.NoError(t, enc.Flush())
+
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestEncoder_emptyCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, bulk.NewEncoder(&buf, bulk.CSV).Flush())
+
+	assert.Equal(t, "id,type,value\n", buf.String())
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []bulk.Format{bulk.CSV, bulk.NDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := bulk.NewEncoder(&buf, format)
+			for _, metric := range testMetrics() {
+				require.NoError(t, enc.Encode(metric))
+			}
+			require.NoError(t, enc.Flush())
+
+			assert.Equal(t, testMetrics(), decodeAll(t, bulk.NewDecoder(&buf, format)))
+		})
+	}
+}
+
+func decodeAll(t *testing.T, dec *bulk.Decoder) []models.Metric {
+	t.Helper()
+
+	var metrics []models.Metric
+	for {
+		metric, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			return metrics
+		}
+		require.NoError(t, err)
+		metrics = append(metrics, metric)
+	}
+}
+
+func TestDecoder_rowErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    bulk.Format
+		input     string
+		wantLines []int
+	}{
+		{
+			name:   "csv",
+			format: bulk.CSV,
+			input: "value,id,type,comment\n" +
+				"1,a,gauge,ok\n" +
+				"x,b,gauge,bad value\n" +
+				"1,c,histogram,bad type\n" +
+				"1,,counter,no id\n" +
+				"1.5,d,counter,bad delta\n" +
+				"2,e,counter\n",
+			wantLines: []int{3, 4, 5, 6},
+		},
+		{
+			name:   "ndjson",
+			format: bulk.NDJSON,
+			input: `{"id":"a","type":"gauge","value":1}` + "\n" +
+				"\n" +
+				`{"id":"b","type":"gauge"` + "\n" +
+				`{"id":"c","type":"counter","value":1}` + "\n" +
+				`{"id":"d","type":"summary","value":1}` + "\n" +
+				`{"id":"e","type":"counter","delta":2}`,
+			wantLines: []int{3, 4, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := bulk.NewDecoder(strings.NewReader(tt.input), tt.format)
+
+			var (
+				metrics []models.Metric
+				lines   []int
+			)
+			for {
+				metric, err := dec.Decode()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				var rowErr *bulk.RowError
+				if errors.As(err, &rowErr) {
+					assert.ErrorIs(t, err, bulk.ErrInvalidRow)
+					lines = append(lines, rowErr.Line)
+					continue
+				}
+				require.NoError(t, err)
+				metrics = append(metrics, metric)
+			}
+
+			assert.Equal(t, []models.Metric{models.NewGauge("a", 1), models.NewCounter("e", 2)}, metrics)
+			assert.Equal(t, tt.wantLines, lines)
+		})
+	}
+}
+
+func TestDecoder_invalidHeader(t *testing.T) {
+	dec := bulk.NewDecoder(strings.NewReader("name,type,value\na,gauge,1\n"), bulk.CSV)
+
+	_, err := dec.Decode()
+	assert.ErrorIs(t, err, bulk.ErrInvalidHeader)
+}
+
+func TestDecoder_empty(t *testing.T) {
+	for _, format := range []bulk.Format{bulk.CSV, bulk.NDJSON} {
+		_, err := bulk.NewDecoder(strings.NewReader(""), format).Decode()
+		assert.ErrorIs(t, err, io.EOF, format)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := bulk.ParseFormat("ndjson")
+	require.NoError(t, err)
+	assert.Equal(t, bulk.NDJSON, format)
+
+	_, err = bulk.ParseFormat("xml")
+	assert.ErrorIs(t, err, bulk.ErrUnknownFormat)
+
+	format, ok := bulk.FormatFromContentType("text/csv; charset=utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, bulk.CSV, format)
+}
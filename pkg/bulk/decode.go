@@ -0,0 +1,157 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mailru/easyjson"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+)
+
+// MaxLineSize is the longest NDJSON line.
+const MaxLineSize = 1 << 20
+
+// Decoder reads metrics row by row.
+type Decoder struct {
+	format Format
+
+	csv     *csv.Reader
+	columns map[string]int
+
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewDecoder(r io.Reader, format Format) *Decoder {
+	dec := &Decoder{format: format}
+
+	if format == CSV {
+		dec.csv = csv.NewReader(r)
+		dec.csv.FieldsPerRecord = -1
+		dec.csv.TrimLeadingSpace = true
+		dec.csv.ReuseRecord = true
+	} else {
+		dec.scanner = bufio.NewScanner(r)
+		dec.scanner.Buffer(make([]byte, 0, 4096), MaxLineSize)
+	}
+
+	return dec
+}
+
+// Decode returns the next metric, or io.EOF at the end of the input.
+//
+// A row that can't be decoded is returned as a *RowError, and Decode may
+// be called again for the next row. Any other error ends the decoding.
+func (d *Decoder) Decode() (models.Metric, error) {
+	if d.format == CSV {
+		return d.decodeCSV()
+	}
+
+	return d.decodeNDJSON()
+}
+
+func (d *Decoder) decodeCSV() (models.Metric, error) {
+	if d.columns == nil {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	record, err := d.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &RowError{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", ErrInvalidRow, parseErr.Err)}
+		}
+		return nil, err
+	}
+
+	line, _ := d.csv.FieldPos(0)
+
+	field := func(column string) string {
+		if i := d.columns[column]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	metric, err := parseMetric(field(ColumnID), field(ColumnType), field(ColumnValue))
+	if err != nil {
+		return nil, &RowError{Line: line, Err: err}
+	}
+
+	return metric, nil
+}
+
+// readHeader reads the header row, which must have the id, type and value
+// columns in any order. Other columns are ignored.
+func (d *Decoder) readHeader() error {
+	header, err := d.csv.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// A spreadsheet may start the file with a byte order mark.
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{ColumnID, ColumnType, ColumnValue} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("%w: no %q column", ErrInvalidHeader, required)
+		}
+	}
+
+	d.columns = columns
+	return nil
+}
+
+func (d *Decoder) decodeNDJSON() (models.Metric, error) {
+	for d.scanner.Scan() {
+		d.line++
+
+		line := strings.TrimSpace(d.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var m serialize.Metric
+		if err := easyjson.Unmarshal([]byte(line), &m); err != nil {
+			return nil, &RowError{Line: d.line, Err: fmt.Errorf("%w: %v", ErrInvalidRow, err)}
+		}
+
+		var value any
+		switch {
+		case m.MType == models.GaugeType && m.Value != nil:
+			value = *m.Value
+		case m.MType == models.CounterType && m.Delta != nil:
+			value = *m.Delta
+		case m.MType == models.GaugeType || m.MType == models.CounterType:
+			return nil, &RowError{Line: d.line, Err: fmt.Errorf("%w: no %s value", ErrInvalidRow, m.MType)}
+		}
+
+		metric, err := newMetric(m.ID, m.MType, value)
+		if err != nil {
+			return nil, &RowError{Line: d.line, Err: err}
+		}
+
+		return metric, nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
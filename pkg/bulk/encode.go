@@ -0,0 +1,98 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mailru/easyjson"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+)
+
+// Encoder writes metrics one by one, so a large export is streamed
+// without building it in memory. The output is buffered until Flush.
+type Encoder struct {
+	format Format
+	bw     *bufio.Writer
+	csv    *csv.Writer
+
+	headerWritten bool
+}
+
+func NewEncoder(w io.Writer, format Format) *Encoder {
+	enc := &Encoder{
+		format: format,
+		bw:     bufio.NewWriter(w),
+	}
+	if format == CSV {
+		enc.csv = csv.NewWriter(enc.bw)
+	}
+
+	return enc
+}
+
+// Encode writes the metric. The CSV header is written before the first
+// metric, or by Flush if there are none.
+func (e *Encoder) Encode(metric models.Metric) error {
+	if e.format == CSV {
+		return e.encodeCSV(metric)
+	}
+
+	jsonMetric, err := converter.ConvertToSerialization([]models.Metric{metric})
+	if err != nil {
+		return fmt.Errorf("failed to convert metric %s: %w", metric.Name(), err)
+	}
+
+	if _, err := easyjson.MarshalToWriter(&jsonMetric[0], e.bw); err != nil {
+		return fmt.Errorf("failed to encode metric %s: %w", metric.Name(), err)
+	}
+
+	return e.bw.WriteByte('\n')
+}
+
+func (e *Encoder) encodeCSV(metric models.Metric) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	var value string
+	switch v := metric.Value().(type) {
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("failed to encode metric %s: invalid value %v", metric.Name(), v)
+	}
+
+	return e.csv.Write([]string{metric.Name(), metric.Type(), value})
+}
+
+func (e *Encoder) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+
+	return e.csv.Write([]string{ColumnID, ColumnType, ColumnValue})
+}
+
+// Flush writes the buffered output.
+func (e *Encoder) Flush() error {
+	if e.format == CSV {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return e.bw.Flush()
+}
@@ -0,0 +1,310 @@
+// Package compression is the registry of the codecs of request and response
+// bodies: gzip, deflate, zstd and br (Brotli). A codec is named by its
+// Content-Encoding token, and the server and the agent look codecs up by it.
+//
+// zstd is the recommended codec for large batches: it compresses metrics
+// about as well as gzip at a fraction of the CPU time.
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The names of the registered codecs.
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+	Zstd    = "zstd"
+	Brotli  = "br"
+	// Identity is the Content-Encoding of an uncompressed body.
+	Identity = "identity"
+)
+
+// Levels understood by every codec besides its own levels.
+const (
+	// DefaultLevel is the default level of the codec.
+	DefaultLevel = 0
+	// FastestLevel is the fastest level of the codec.
+	FastestLevel = -1
+)
+
+// maxZstdWindow bounds the memory of a zstd decoder: the window is declared
+// by the sender, so a hostile frame could otherwise ask for gigabytes.
+const maxZstdWindow = 8 << 20
+
+var (
+	// ErrUnsupported is returned for a Content-Encoding without a codec.
+	ErrUnsupported = errors.New("unsupported content encoding")
+	// ErrInvalidLevel is returned for a level the codec doesn't have.
+	ErrInvalidLevel = errors.New("invalid compression level")
+)
+
+// Codec compresses and decompresses bodies.
+type Codec interface {
+	// Name returns the Content-Encoding token of the codec.
+	Name() string
+	// NewWriter returns a writer compressing to w at level, which is
+	// DefaultLevel, FastestLevel or a level of the codec. Close flushes it.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader returns a reader decompressing r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{}
+	// preference orders the codecs when the client likes several of them
+	// as much: the first registered is preferred.
+	preference []string
+)
+
+func init() {
+	Register(zstdCodec{})
+	Register(brotliCodec{})
+	Register(gzipCodec{})
+	Register(deflateCodec{})
+}
+
+// Register adds c to the registry, replacing a codec of the same name.
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	name := strings.ToLower(c.Name())
+	if _, ok := codecs[name]; !ok {
+		preference = append(preference, name)
+	}
+	codecs[name] = c
+}
+
+// Lookup returns the codec named name, case-insensitively.
+func Lookup(name string) (Codec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok := codecs[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+// Names returns the names of the registered codecs, preferred first.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return slices.Clone(preference)
+}
+
+// ValidateLevel checks that the codec named name exists and has level.
+func ValidateLevel(name string, level int) error {
+	c, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupported, name)
+	}
+
+	w, err := c.NewWriter(io.Discard, level)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// NewReader returns a reader decompressing r by the Content-Encoding
+// encoding. An empty or identity encoding returns r as is.
+func NewReader(r io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	encoding = strings.TrimSpace(encoding)
+	if encoding == "" || strings.EqualFold(encoding, Identity) {
+		return r, nil
+	}
+
+	c, ok := Lookup(encoding)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, encoding)
+	}
+
+	return c.NewReader(r)
+}
+
+// Negotiate returns the codec for the response to a request with the
+// Accept-Encoding header values accept: the codec with the highest weight,
+// the preferred one of equal weights. It returns false if the client
+// accepts no codec, and then the response is not compressed.
+func Negotiate(accept ...string) (Codec, bool) {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			name, q, ok := parseCoding(part)
+			if !ok {
+				continue
+			}
+			if name == "*" {
+				wildcard = q
+				continue
+			}
+			weights[name] = q
+		}
+	}
+
+	var (
+		best  Codec
+		bestQ float64
+	)
+
+	for _, name := range Names() {
+		q, ok := weights[name]
+		if !ok {
+			q = wildcard
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		best, _ = Lookup(name)
+		bestQ = q
+	}
+
+	return best, best != nil
+}
+
+// parseCoding parses a coding of Accept-Encoding, e.g. "gzip;q=0.5".
+func parseCoding(s string) (string, float64, bool) {
+	name, params, _ := strings.Cut(s, ";")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", 0, false
+	}
+
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+
+	return name, q, true
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return Gzip }
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	level, err := flateLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateCodec is the "deflate" Content-Encoding, which is the zlib format
+// (RFC 1950) rather than a raw deflate stream.
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string { return Deflate }
+
+func (deflateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	level, err := flateLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return zlib.NewWriterLevel(w, level)
+}
+
+func (deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// flateLevel maps a level to a level of gzip and zlib, 1 to 9.
+func flateLevel(level int) (int, error) {
+	switch {
+	case level == DefaultLevel:
+		return gzip.DefaultCompression, nil
+	case level == FastestLevel:
+		return gzip.BestSpeed, nil
+	case level >= gzip.BestSpeed && level <= gzip.BestCompression:
+		return level, nil
+	default:
+		return 0, fmt.Errorf("%w: %d, want 1 to 9", ErrInvalidLevel, level)
+	}
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return Zstd }
+
+// NewWriter maps the zstd levels 1 to 22 to the levels of the encoder.
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	var encoderLevel zstd.EncoderLevel
+	switch {
+	case level == DefaultLevel:
+		encoderLevel = zstd.SpeedDefault
+	case level == FastestLevel:
+		encoderLevel = zstd.SpeedFastest
+	case level >= 1 && level <= 22:
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	default:
+		return nil, fmt.Errorf("%w: %d, want 1 to 22", ErrInvalidLevel, level)
+	}
+
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxWindow(maxZstdWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	return d.IOReadCloser(), nil
+}
+
+type brotliCodec struct{}
+
+func (brotliCodec) Name() string { return Brotli }
+
+func (brotliCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	switch {
+	case level == DefaultLevel:
+		level = brotli.DefaultCompression
+	case level == FastestLevel:
+		level = brotli.BestSpeed
+	case level >= 1 && level <= brotli.BestCompression:
+	default:
+		return nil, fmt.Errorf("%w: %d, want 1 to 11", ErrInvalidLevel, level)
+	}
+
+	return brotli.NewWriterLevel(w, level), nil
+}
+
+func (brotliCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
@@ -0,0 +1,121 @@
+package compression_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+)
+
+func compress(t *testing.T, c compression.Codec, level int, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf, level)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestCodecs(t *testing.T) {
+	data := []byte(strings.Repeat(`{"id":"Alloc","type":"gauge","value":1.5},`, 100))
+
+	for _, name := range []string{compression.Zstd, compression.Brotli, compression.Gzip, compression.Deflate} {
+		c, ok := compression.Lookup(name)
+		require.True(t, ok, name)
+		assert.Equal(t, name, c.Name())
+
+		for _, level := range []int{compression.DefaultLevel, compression.FastestLevel, 5} {
+			t.Run(fmt.Sprintf("%s/%d", name, level), func(t *testing.T) {
+				compressed := compress(t, c, level, data)
+				assert.Less(t, len(compressed), len(data))
+
+				r, err := compression.NewReader(io.NopCloser(bytes.NewReader(compressed)), strings.ToUpper(name))
+				require.NoError(t, err)
+				defer r.Close()
+
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, data, got)
+			})
+		}
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	assert.NoError(t, compression.ValidateLevel(compression.Zstd, 22))
+	assert.NoError(t, compression.ValidateLevel(compression.Brotli, 11))
+	assert.ErrorIs(t, compression.ValidateLevel(compression.Gzip, 10), compression.ErrInvalidLevel)
+	assert.ErrorIs(t, compression.ValidateLevel(compression.Zstd, -5), compression.ErrInvalidLevel)
+	assert.ErrorIs(t, compression.ValidateLevel("lz4", 1), compression.ErrUnsupported)
+}
+
+func TestNewReader(t *testing.T) {
+	body := io.NopCloser(strings.NewReader("plain"))
+
+	for _, encoding := range []string{"", "identity", " Identity "} {
+		r, err := compression.NewReader(body, encoding)
+		require.NoError(t, err)
+		assert.Equal(t, body, r)
+	}
+
+	_, err := compression.NewReader(body, "lz4")
+	assert.ErrorIs(t, err, compression.ErrUnsupported)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept []string
+		want   string
+	}{
+		{name: "none"},
+		{name: "gzip only", accept: []string{"gzip"}, want: compression.Gzip},
+		{name: "browser", accept: []string{"gzip, deflate, br, zstd"}, want: compression.Zstd},
+		{name: "weights", accept: []string{"zstd;q=0.5, gzip;q=0.8, br;q=0.1"}, want: compression.Gzip},
+		{name: "several headers", accept: []string{"deflate;q=0.5", "br"}, want: compression.Brotli},
+		{name: "wildcard", accept: []string{"*"}, want: compression.Zstd},
+		{name: "wildcard but zstd", accept: []string{"*, zstd;q=0"}, want: compression.Brotli},
+		{name: "refused", accept: []string{"gzip;q=0"}},
+		{name: "unknown", accept: []string{"lz4, identity"}},
+		{name: "invalid weight", accept: []string{"zstd;q=2, deflate"}, want: compression.Deflate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := compression.Negotiate(tt.accept...)
+			if tt.want == "" {
+				assert.False(t, ok)
+				return
+			}
+
+			require.True(t, ok)
+			assert.Equal(t, tt.want, c.Name())
+		})
+	}
+}
+
+func TestZstdBomb(t *testing.T) {
+	// 64 MiB of zeros compress to a few KiB.
+	zstd, _ := compression.Lookup(compression.Zstd)
+	data := compress(t, zstd, compression.DefaultLevel, make([]byte, 64<<20))
+	require.Less(t, len(data), 1<<20)
+
+	r, err := zstd.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer r.Close()
+
+	n, err := io.Copy(io.Discard, admission.LimitReader(r, 1<<20))
+	assert.ErrorIs(t, err, admission.ErrTooLarge)
+	assert.Equal(t, int64(1<<20), n)
+}
@@ -0,0 +1,66 @@
+// Package grpcencoding registers the codecs of the compression package as
+// gRPC compressors, so that a gRPC server decompresses the messages of
+// every codec and a client may call with grpc.UseCompressor(compression.Zstd).
+// It is imported for its side effect:
+//
+//	import _ "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression/grpcencoding"
+package grpcencoding
+
+import (
+	"io"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+)
+
+func init() {
+	for _, name := range compression.Names() {
+		codec, _ := compression.Lookup(name)
+		encoding.RegisterCompressor(compressor{codec: codec})
+	}
+}
+
+// compressor adapts a codec to encoding.Compressor. Messages are
+// compressed at the default level of the codec.
+type compressor struct {
+	codec compression.Codec
+}
+
+func (c compressor) Name() string {
+	return c.codec.Name()
+}
+
+func (c compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return c.codec.NewWriter(w, compression.DefaultLevel)
+}
+
+// Decompress returns a reader that releases the decoder once the message
+// is read, since gRPC never closes it.
+func (c compressor) Decompress(r io.Reader) (io.Reader, error) {
+	rc, err := c.codec.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &closingReader{ReadCloser: rc}, nil
+}
+
+type closingReader struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.EOF
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.closed = true
+		_ = r.ReadCloser.Close()
+	}
+
+	return n, err
+}
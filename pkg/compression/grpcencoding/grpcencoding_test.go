@@ -0,0 +1,42 @@
+package grpcencoding_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression"
+	_ "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/compression/grpcencoding"
+)
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte("metric"), 1000)
+
+	for _, name := range compression.Names() {
+		t.Run(name, func(t *testing.T) {
+			c := encoding.GetCompressor(name)
+			require.NotNil(t, c)
+
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := c.Decompress(&buf)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			n, err := r.Read(make([]byte, 1))
+			assert.Zero(t, n)
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
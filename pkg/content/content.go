@@ -0,0 +1,179 @@
+// Package content negotiates the representation of a metrics response by
+// the Accept header and encodes the metrics in it:
+//
+//   - Text, text/plain: the bare value of a metric, or "id\ttype\tvalue"
+//     lines for a list;
+//   - JSON, application/json: the metrics of the /update API;
+//   - Protobuf, application/x-protobuf: pb.Metric, and the messages of the
+//     gRPC API for lists;
+//   - MsgPack, application/msgpack: the JSON documents in MessagePack;
+//   - Prometheus and OpenMetrics: the exposition formats (see exposition);
+//   - HTML, text/html: the dashboard, rendered by the caller.
+//
+// Every endpoint offers the formats it can represent its response in.
+package content
+
+import (
+	"errors"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/exposition"
+)
+
+// Format is a response representation.
+type Format string
+
+const (
+	Text        Format = "text"
+	JSON        Format = "json"
+	Protobuf    Format = "protobuf"
+	MsgPack     Format = "msgpack"
+	Prometheus  Format = "prometheus"
+	OpenMetrics Format = "openmetrics"
+	HTML        Format = "html"
+)
+
+var (
+	// ErrNotAcceptable is returned by Negotiate if the Accept header
+	// excludes all the offered formats.
+	ErrNotAcceptable = errors.New("none of the offered formats is acceptable")
+	// ErrUnsupported is returned for a response the format can't represent.
+	ErrUnsupported = errors.New("format doesn't support the response")
+)
+
+// mediaType is a media type with its distinguishing parameters.
+type mediaType struct {
+	name    string
+	version string
+}
+
+// mediaTypes are the media types of the formats, the first one is sent.
+var mediaTypes = map[Format][]mediaType{
+	Text:        {{name: "text/plain"}},
+	JSON:        {{name: "application/json"}},
+	Protobuf:    {{name: "application/x-protobuf"}, {name: "application/protobuf"}},
+	MsgPack:     {{name: "application/msgpack"}, {name: "application/x-msgpack"}, {name: "application/vnd.msgpack"}},
+	Prometheus:  {{name: "text/plain", version: "0.0.4"}},
+	OpenMetrics: {{name: "application/openmetrics-text", version: "1.0.0"}},
+	HTML:        {{name: "text/html"}},
+}
+
+// ContentType returns the content type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case Text:
+		return "text/plain; charset=utf-8"
+	case Prometheus:
+		return exposition.ContentTypeText
+	case OpenMetrics:
+		return exposition.ContentTypeOpenMetrics
+	case HTML:
+		return "text/html; charset=utf-8"
+	}
+
+	if types, ok := mediaTypes[f]; ok {
+		return types[0].name
+	}
+
+	return "application/octet-stream"
+}
+
+// mediaRange is a media range of the Accept header.
+type mediaRange struct {
+	name    string
+	version string
+	q       float64
+}
+
+// matches returns the specificity of the range for the media type,
+// or -1 if the range doesn't match it.
+func (r mediaRange) matches(t mediaType) int {
+	if r.version != "" && r.version != t.version {
+		return -1
+	}
+
+	switch {
+	case r.name == t.name && r.version != "":
+		return 3
+	case r.name == t.name:
+		return 2
+	case strings.HasSuffix(r.name, "/*") && strings.HasPrefix(t.name, strings.TrimSuffix(r.name, "*")):
+		return 1
+	case r.name == "*/*":
+		return 0
+	default:
+		return -1
+	}
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		name, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		r := mediaRange{name: name, version: params["version"], q: 1}
+		if q, ok := params["q"]; ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			if err != nil || weight < 0 || weight > 1 {
+				continue
+			}
+			r.q = weight
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// Negotiate returns the offered format preferred by the Accept header.
+//
+// Every offered format gets the quality of the most specific range that
+// matches it; the format with the highest quality wins, then the one
+// matched more specifically, then the earlier offer. An empty or invalid
+// Accept header accepts the first offer. Only the version parameter tells media types
+// apart: "text/plain; version=0.0.4" is Prometheus, while "text/plain"
+// is Text, or Prometheus if Text is not offered before it.
+func Negotiate(accept string, offers ...Format) (Format, error) {
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], nil
+	}
+
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return offers[0], nil
+	}
+
+	var (
+		best            Format
+		bestQ           float64
+		bestSpecificity = -1
+	)
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, t := range mediaTypes[offer] {
+			for _, r := range ranges {
+				if s := r.matches(t); s > specificity {
+					q, specificity = r.q, s
+				}
+			}
+		}
+
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+
+	if bestQ == 0 {
+		return "", ErrNotAcceptable
+	}
+
+	return best, nil
+}
@@ -0,0 +1,151 @@
+package content_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/content"
+	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+)
+
+func TestNegotiate(t *testing.T) {
+	all := []content.Format{content.JSON, content.Text, content.Protobuf, content.MsgPack, content.Prometheus, content.OpenMetrics}
+
+	tests := []struct {
+		name    string
+		accept  string
+		offers  []content.Format
+		want    content.Format
+		wantErr error
+	}{
+		{name: "empty accepts the default", accept: "", offers: all, want: content.JSON},
+		{name: "any", accept: "*/*", offers: all, want: content.JSON},
+		{name: "invalid accepts the default", accept: ";;", offers: all, want: content.JSON},
+		{name: "exact", accept: "application/msgpack", offers: all, want: content.MsgPack},
+		{name: "alias", accept: "application/x-msgpack", offers: all, want: content.MsgPack},
+		{name: "protobuf", accept: "application/protobuf", offers: all, want: content.Protobuf},
+		{name: "text before prometheus", accept: "text/plain", offers: all, want: content.Text},
+		{name: "prometheus version", accept: "text/plain; version=0.0.4", offers: all, want: content.Prometheus},
+		{name: "type wildcard", accept: "text/*", offers: all, want: content.Text},
+		{name: "quality", accept: "application/json;q=0.5, application/x-protobuf", offers: all, want: content.Protobuf},
+		{name: "specific beats wildcard", accept: "*/*;q=0.1, application/json;q=0.1, text/plain;q=0.1", offers: all, want: content.JSON},
+		{name: "excluded by zero quality", accept: "application/json;q=0, */*", offers: all, want: content.Text},
+		{name: "not acceptable", accept: "image/png", offers: all, wantErr: content.ErrNotAcceptable},
+		{name: "no offers", accept: "*/*", wantErr: content.ErrNotAcceptable},
+		{
+			name:   "exposition empty",
+			accept: "", offers: []content.Format{content.Prometheus, content.OpenMetrics}, want: content.Prometheus,
+		},
+		{
+			name:   "exposition text",
+			accept: "text/plain", offers: []content.Format{content.Prometheus, content.OpenMetrics}, want: content.Prometheus,
+		},
+		{
+			name:   "exposition openmetrics",
+			accept: "application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			offers: []content.Format{content.Prometheus, content.OpenMetrics}, want: content.OpenMetrics,
+		},
+		{
+			name:   "exposition openmetrics excluded",
+			accept: "application/openmetrics-text;q=0",
+			offers: []content.Format{content.Prometheus, content.OpenMetrics}, wantErr: content.ErrNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := content.Negotiate(tt.accept, tt.offers...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func testMetrics() []models.Metric {
+	return []models.Metric{
+		models.NewGauge("cpu", 0.5),
+		models.NewCounter("requests", 42),
+	}
+}
+
+func TestWriteMetric(t *testing.T) {
+	tests := []struct {
+		format content.Format
+		want   string
+	}{
+		{format: content.Text, want: "0.5"},
+		{format: content.JSON, want: `{"id":"cpu","type":"gauge","value":0.5}`},
+		{format: content.Prometheus, want: "# TYPE cpu gauge\ncpu 0.5\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, content.WriteMetric(&buf, tt.format, testMetrics()[0]))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestWriteMetrics_Text(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, content.WriteMetrics(&buf, content.Text, testMetrics()))
+	assert.Equal(t, "cpu\tgauge\t0.5\nrequests\tcounter\t42\n", buf.String())
+}
+
+func TestWriteMetrics_MsgPack(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, content.WriteMetrics(&buf, content.MsgPack, testMetrics()))
+
+	var got []map[string]any
+	require.NoError(t, msgpack.Unmarshal(buf.Bytes(), &got))
+	require.Len(t, got, 2)
+	assert.Equal(t, "cpu", got[0]["id"])
+	assert.Equal(t, "gauge", got[0]["type"])
+	assert.InDelta(t, 0.5, got[0]["value"], 0)
+	assert.NotContains(t, got[0], "delta")
+	assert.EqualValues(t, 42, got[1]["delta"])
+}
+
+func TestWriteMetrics_Protobuf(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, content.WriteMetrics(&buf, content.Protobuf, testMetrics()))
+
+	var got pb.GetAllMetricsResponse
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), &got))
+	require.Len(t, got.GetMetrics(), 2)
+	assert.Equal(t, "cpu", got.GetMetrics()[0].GetId())
+	assert.InDelta(t, 0.5, got.GetMetrics()[0].GetValue(), 0)
+	assert.Equal(t, int64(42), got.GetMetrics()[1].GetDelta())
+}
+
+func TestWriteBatch(t *testing.T) {
+	missing := []serialize.Metric{{ID: "gone", MType: models.GaugeType}}
+
+	var buf bytes.Buffer
+	require.NoError(t, content.WriteBatch(&buf, content.Protobuf, testMetrics()[:1], missing))
+
+	var got pb.GetMetricsResponse
+	require.NoError(t, proto.Unmarshal(buf.Bytes(), &got))
+	require.Len(t, got.GetMetrics(), 1)
+	require.Len(t, got.GetMissing(), 1)
+	assert.Equal(t, "gone", got.GetMissing()[0].GetId())
+
+	buf.Reset()
+	require.NoError(t, content.WriteBatch(&buf, content.JSON, nil, nil))
+	assert.Equal(t, `{"metrics":[],"missing":[]}`, buf.String())
+
+	assert.ErrorIs(t, content.WriteBatch(&buf, content.Text, nil, nil), content.ErrUnsupported)
+}
@@ -0,0 +1,175 @@
+package content
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mailru/easyjson"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/exposition"
+	pb "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/grpc-metrics"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+)
+
+// WriteMetric writes a single metric in the format; Text is its bare value.
+func WriteMetric(w io.Writer, f Format, metric models.Metric) error {
+	switch f {
+	case Text:
+		value, err := formatValue(metric.Value())
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, value)
+		return err
+
+	case Protobuf:
+		metrics, err := converter.ConvertToProtoMetrics([]models.Metric{metric})
+		if err != nil {
+			return err
+		}
+		return writeProto(w, metrics[0])
+
+	case JSON, MsgPack:
+		metrics, err := converter.ConvertToSerialization([]models.Metric{metric})
+		if err != nil {
+			return err
+		}
+		return WriteValue(w, f, &metrics[0])
+
+	default:
+		return WriteMetrics(w, f, []models.Metric{metric})
+	}
+}
+
+// WriteMetrics writes a list of metrics in the format. Protobuf writes
+// the GetAllMetricsResponse of the gRPC API.
+func WriteMetrics(w io.Writer, f Format, metrics []models.Metric) error {
+	switch f {
+	case Text:
+		bw := bufio.NewWriter(w)
+		for _, metric := range metrics {
+			value, err := formatValue(metric.Value())
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(bw, "%s\t%s\t%s\n", metric.Name(), metric.Type(), value); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+
+	case Protobuf:
+		pbMetrics, err := converter.ConvertToProtoMetrics(metrics)
+		if err != nil {
+			return err
+		}
+		return writeProto(w, &pb.GetAllMetricsResponse{Metrics: pbMetrics})
+
+	case JSON, MsgPack:
+		jsonMetrics, err := converter.ConvertToSerialization(metrics)
+		if err != nil {
+			return err
+		}
+		return WriteValue(w, f, serialize.MetricsList(jsonMetrics))
+
+	case Prometheus:
+		return exposition.Write(w, metrics, exposition.FormatText)
+
+	case OpenMetrics:
+		return exposition.Write(w, metrics, exposition.FormatOpenMetrics)
+
+	default:
+		return fmt.Errorf("%w: metrics in %s", ErrUnsupported, f)
+	}
+}
+
+// WriteBatch writes the result of a batch lookup: the found metrics and
+// the ones that were not found, with their id and type only. Protobuf
+// writes the GetMetricsResponse of the gRPC API.
+func WriteBatch(w io.Writer, f Format, metrics []models.Metric, missing []serialize.Metric) error {
+	switch f {
+	case Protobuf:
+		pbMetrics, err := converter.ConvertToProtoMetrics(metrics)
+		if err != nil {
+			return err
+		}
+
+		resp := &pb.GetMetricsResponse{
+			Metrics: pbMetrics,
+			Missing: make([]*pb.GetMetricRequest, 0, len(missing)),
+		}
+		for _, m := range missing {
+			resp.Missing = append(resp.Missing, &pb.GetMetricRequest{Id: m.ID, Type: m.MType})
+		}
+		return writeProto(w, resp)
+
+	case JSON, MsgPack:
+		found, err := converter.ConvertToSerialization(metrics)
+		if err != nil {
+			return err
+		}
+
+		batch := serialize.MetricsBatch{Metrics: found, Missing: missing}
+		if batch.Missing == nil {
+			batch.Missing = serialize.MetricsList{}
+		}
+		return WriteValue(w, f, &batch)
+
+	default:
+		return fmt.Errorf("%w: batch in %s", ErrUnsupported, f)
+	}
+}
+
+// WriteValue writes a document of the JSON API in JSON or, with the same
+// field names, in MessagePack. A proto.Message can be written in Protobuf.
+func WriteValue(w io.Writer, f Format, v any) error {
+	switch f {
+	case JSON:
+		if m, ok := v.(easyjson.Marshaler); ok {
+			_, err := easyjson.MarshalToWriter(m, w)
+			return err
+		}
+		return json.NewEncoder(w).Encode(v)
+
+	case MsgPack:
+		// The json tags, with their omitempty, name the fields as in JSON.
+		enc := msgpack.NewEncoder(w)
+		enc.SetCustomStructTag("json")
+		return enc.Encode(v)
+
+	case Protobuf:
+		if m, ok := v.(proto.Message); ok {
+			return writeProto(w, m)
+		}
+	}
+
+	return fmt.Errorf("%w: %T in %s", ErrUnsupported, v, f)
+}
+
+func writeProto(w io.Writer, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", fmt.Errorf("%w: %T", models.ErrInvalidValueType, v)
+	}
+}
@@ -0,0 +1,257 @@
+// Package exposition renders metrics in the Prometheus text exposition
+// format and in the OpenMetrics format, so Prometheus can scrape them.
+//
+// Labels are taken from the metric names (see models.SplitName). Metrics
+// with the same name and different labels form one metric family.
+package exposition
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+)
+
+// Format is an exposition format.
+type Format int
+
+const (
+	// FormatText is the Prometheus text format 0.0.4.
+	FormatText Format = iota
+	// FormatOpenMetrics is the OpenMetrics text format 1.0.0.
+	FormatOpenMetrics
+)
+
+const (
+	// ContentTypeText is the content type of FormatText.
+	ContentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+	// ContentTypeOpenMetrics is the content type of FormatOpenMetrics.
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// ContentType returns the content type of the format.
+func (f Format) ContentType() string {
+	if f == FormatOpenMetrics {
+		return ContentTypeOpenMetrics
+	}
+
+	return ContentTypeText
+}
+
+// Negotiate returns the format requested by the Accept header:
+// OpenMetrics if it is accepted, the text format otherwise.
+//
+// Deprecated: use content.Negotiate, which honours the quality values.
+func Negotiate(accept string) Format {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != "application/openmetrics-text" {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+
+		return FormatOpenMetrics
+	}
+
+	return FormatText
+}
+
+// SanitizeName turns a metric name into a valid Prometheus metric name,
+// replacing invalid characters with underscores.
+func SanitizeName(name string) string {
+	return sanitize(name, true)
+}
+
+// SanitizeLabelName turns a label name into a valid Prometheus label name,
+// replacing invalid characters with underscores.
+func SanitizeLabelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, colons bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', colons && c == ':':
+			b.WriteByte(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	return b.String()
+}
+
+type sample struct {
+	series string
+	value  string
+}
+
+type family struct {
+	name     string
+	mType    string
+	original string
+	samples  []sample
+}
+
+// Write renders the metrics in the format into w.
+//
+// Families are sorted by name and samples by series, so equal storages
+// give equal output. A metric whose sanitized name is taken by a family
+// of another type, or by the same series, is left out. If a name had to be
+// sanitized, the family gets a HELP line with the original name.
+func Write(w io.Writer, metrics []models.Metric, format Format) error {
+	families := make(map[string]*family)
+	seen := make(map[string]struct{}, len(metrics))
+
+	// Process metrics in a fixed order, so the same metric wins conflicts.
+	sorted := make([]models.Metric, len(metrics))
+	copy(sorted, metrics)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name() != sorted[j].Name() {
+			return sorted[i].Name() < sorted[j].Name()
+		}
+		return sorted[i].Type() < sorted[j].Type()
+	})
+
+	for _, metric := range sorted {
+		base, labels := models.SplitName(metric.Name())
+		name := SanitizeName(base)
+		sampleName := name
+
+		value, err := formatValue(metric.Value())
+		if err != nil {
+			return fmt.Errorf("metric %s: %w", metric.Name(), err)
+		}
+
+		if metric.Type() == models.CounterType && format == FormatOpenMetrics {
+			name = strings.TrimSuffix(name, "_total")
+			sampleName = name + "_total"
+		}
+
+		fam, ok := families[name]
+		if !ok {
+			fam = &family{name: name, mType: metric.Type()}
+			families[name] = fam
+		}
+		if fam.mType != metric.Type() {
+			continue
+		}
+
+		series := models.JoinName(sampleName, sanitizeLabels(labels))
+		if _, ok := seen[series]; ok {
+			continue
+		}
+		seen[series] = struct{}{}
+
+		if fam.original == "" && base != fam.name && base != sampleName {
+			fam.original = base
+		}
+		fam.samples = append(fam.samples, sample{series: series, value: value})
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+
+	for _, name := range names {
+		fam := families[name]
+		sort.Slice(fam.samples, func(i, j int) bool {
+			return fam.samples[i].series < fam.samples[j].series
+		})
+
+		if fam.original != "" {
+			fmt.Fprintf(bw, "# HELP %s Metric %s.\n", fam.name, escapeHelp(fam.original, format))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", fam.name, fam.mType)
+
+		for _, s := range fam.samples {
+			bw.WriteString(s.series)
+			bw.WriteByte(' ')
+			bw.WriteString(s.value)
+			bw.WriteByte('\n')
+		}
+	}
+
+	if format == FormatOpenMetrics {
+		bw.WriteString("# EOF\n")
+	}
+
+	return bw.Flush()
+}
+
+func sanitizeLabels(labels models.Labels) models.Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sanitized := make(models.Labels, len(labels))
+	for _, key := range keys {
+		name := SanitizeLabelName(key)
+		if _, ok := sanitized[name]; !ok {
+			sanitized[name] = labels[key]
+		}
+	}
+
+	return sanitized
+}
+
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "+Inf", nil
+		case math.IsInf(v, -1):
+			return "-Inf", nil
+		case math.IsNaN(v):
+			return "NaN", nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	default:
+		return "", fmt.Errorf("%w: %T", models.ErrInvalidValueType, value)
+	}
+}
+
+// escapeHelp escapes a HELP text, OpenMetrics also escapes double quotes.
+func escapeHelp(help string, format Format) string {
+	if format == FormatOpenMetrics {
+		return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(help)
+	}
+
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,115 @@
+package exposition_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/exposition"
+)
+
+func TestWrite(t *testing.T) {
+	metrics := []models.Metric{
+		models.NewGauge(`cpu{host="b",core.id="1"}`, 0.25),
+		models.NewCounter("PollCount", 10),
+		models.NewGauge("heap.alloc", 1.5e9),
+		models.NewGauge(`cpu{host="a",core.id="0"}`, 0.5),
+		models.NewCounter("http_requests_total", 7),
+		models.NewGauge("inf", math.Inf(1)),
+		// Conflicts with the gauge heap.alloc and is left out.
+		models.NewCounter("heap_alloc", 3),
+	}
+
+	tests := []struct {
+		name   string
+		format exposition.Format
+		want   string
+	}{
+		{
+			name:   "text",
+			format: exposition.FormatText,
+			want: `# TYPE PollCount counter
+PollCount 10
+# TYPE cpu gauge
+cpu{core_id="0",host="a"} 0.5
+cpu{core_id="1",host="b"} 0.25
+# HELP heap_alloc Metric heap.alloc.
+# TYPE heap_alloc gauge
+heap_alloc 1.5e+09
+# TYPE http_requests_total counter
+http_requests_total 7
+# TYPE inf gauge
+inf +Inf
+`,
+		},
+		{
+			name:   "openmetrics",
+			format: exposition.FormatOpenMetrics,
+			want: `# TYPE PollCount counter
+PollCount_total 10
+# TYPE cpu gauge
+cpu{core_id="0",host="a"} 0.5
+cpu{core_id="1",host="b"} 0.25
+# HELP heap_alloc Metric heap.alloc.
+# TYPE heap_alloc gauge
+heap_alloc 1.5e+09
+# TYPE http_requests counter
+http_requests_total 7
+# TYPE inf gauge
+inf +Inf
+# EOF
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, exposition.Write(&buf, metrics, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      string
+		wantLabel string
+	}{
+		{name: "Alloc", want: "Alloc", wantLabel: "Alloc"},
+		{name: "go:gc", want: "go:gc", wantLabel: "go_gc"},
+		{name: "heap.alloc-bytes", want: "heap_alloc_bytes", wantLabel: "heap_alloc_bytes"},
+		{name: "1st", want: "_1st", wantLabel: "_1st"},
+		{name: "", want: "_", wantLabel: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exposition.SanitizeName(tt.name))
+			assert.Equal(t, tt.wantLabel, exposition.SanitizeLabelName(tt.name))
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   exposition.Format
+	}{
+		{accept: "", want: exposition.FormatText},
+		{accept: "text/plain", want: exposition.FormatText},
+		{accept: "application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5", want: exposition.FormatOpenMetrics},
+		{accept: "application/openmetrics-text;q=0", want: exposition.FormatText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.want, exposition.Negotiate(tt.accept))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Package graphite parses the Graphite (carbon) protocols: the plaintext
+// protocol, one "path value [timestamp]" line per sample, and the pickle
+// protocol, length-prefixed pickled lists of (path, (timestamp, value)).
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidLine = errors.New("invalid graphite line")
+
+// Sample is a received sample.
+type Sample struct {
+	Path  string
+	Value float64
+	// Time is zero if the sample has no timestamp.
+	Time time.Time
+}
+
+// ParseLine parses a plaintext line. A missing timestamp, or -1, means
+// the time of receiving.
+func ParseLine(line string) (*Sample, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("%w %q: want \"path value [timestamp]\"", ErrInvalidLine, line)
+	}
+
+	value, err := parseFloat(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: invalid value", ErrInvalidLine, line)
+	}
+
+	sample := &Sample{Path: fields[0], Value: value}
+
+	if len(fields) == 3 {
+		ts, err := parseFloat(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: invalid timestamp", ErrInvalidLine, line)
+		}
+		sample.Time = unixTime(ts)
+	}
+
+	return sample, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return v, nil
+}
+
+// unixTime converts a carbon timestamp in seconds; -1 and 0 mean no timestamp.
+func unixTime(ts float64) time.Time {
+	if ts <= 0 {
+		return time.Time{}
+	}
+
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
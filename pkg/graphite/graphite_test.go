@@ -0,0 +1,117 @@
+package graphite_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/graphite"
+)
+
+func TestParseLine(t *testing.T) {
+	sample, err := graphite.ParseLine("servers.web-1.cpu.load 0.5 1760860800")
+	require.NoError(t, err)
+	assert.Equal(t, &graphite.Sample{Path: "servers.web-1.cpu.load", Value: 0.5, Time: time.Unix(1760860800, 0)}, sample)
+
+	sample, err = graphite.ParseLine("servers.web-1.cpu.load\t42 -1\r")
+	require.NoError(t, err)
+	assert.Equal(t, &graphite.Sample{Path: "servers.web-1.cpu.load", Value: 42}, sample)
+
+	sample, err = graphite.ParseLine("servers.web-1.cpu.load 42")
+	require.NoError(t, err)
+	assert.True(t, sample.Time.IsZero())
+
+	for _, line := range []string{"", "path", "path value", "path 1 now", "path nan 1", "path 1 2 3"} {
+		_, err := graphite.ParseLine(line)
+		assert.ErrorIs(t, err, graphite.ErrInvalidLine, line)
+	}
+}
+
+// Payloads of pickle.dumps([
+//
+//	('servers.web-1.cpu.load', (1760860800, 0.5)),
+//	('servers.web-1.mem.used', (1760860800.0, 1024)),
+//	(u'stats.requests', ('1760860800', '42.5')),
+//	('big', (1760860800, 2**70)),
+//
+// ], protocol=N).
+var pickles = map[string]string{
+	"protocol 0": "286c70300a2856736572766572732e7765622d312e6370752e6c6f61640a70310a2849313736303836303830300a46302e350a7470320a7470330a612856736572766572732e7765622d312e6d656d2e757365640a70340a2846313736303836303830302e300a49313032340a7470350a7470360a61285673746174732e72657175657374730a70370a2856313736303836303830300a70380a5634322e350a70390a747031300a747031310a6128566269670a7031320a2849313736303836303830300a4c313138303539313632303731373431313330333432344c0a747031330a747031340a612e",
+	"protocol 2": "80025d7100285816000000736572766572732e7765622d312e6370752e6c6f616471014a809af468473fe00000000000008671028671035816000000736572766572732e7765622d312e6d656d2e7573656471044741da3d26a00000004d0004867105867106580e00000073746174732e72657175657374737107580a000000313736303836303830307108580400000034322e35710986710a86710b5803000000626967710c4a809af4688a0900000000000000004086710d86710e652e",
+	"protocol 4": "8004959c000000000000005d94288c16736572766572732e7765622d312e6370752e6c6f6164944a809af468473fe0000000000000869486948c16736572766572732e7765622d312e6d656d2e75736564944741da3d26a00000004d0004869486948c0e73746174732e7265717565737473948c0a31373630383630383030948c0434322e3594869486948c03626967944a809af4688a0900000000000000004086948694652e",
+}
+
+func TestDecodePickle(t *testing.T) {
+	ts := time.Unix(1760860800, 0)
+	want := []graphite.Sample{
+		{Path: "servers.web-1.cpu.load", Value: 0.5, Time: ts},
+		{Path: "servers.web-1.mem.used", Value: 1024, Time: ts},
+		{Path: "stats.requests", Value: 42.5, Time: ts},
+		{Path: "big", Value: 1180591620717411303424, Time: ts},
+	}
+
+	for name, payload := range pickles {
+		t.Run(name, func(t *testing.T) {
+			data, err := hex.DecodeString(payload)
+			require.NoError(t, err)
+
+			samples, err := graphite.DecodePickle(data)
+			require.NoError(t, err)
+			assert.Equal(t, want, samples)
+		})
+	}
+}
+
+func TestDecodePickle_errors(t *testing.T) {
+	for name, payload := range map[string]string{
+		"empty":          "",
+		"no stop":        "80025d71",
+		"not a list":     "80024b012e",
+		"global":         "80026352756e74696d650a2e", // GLOBAL, constructs an object
+		"invalid item":   "80025d4b01612e",
+		"unbalanced":     "80025d4b012e",
+		"missing memo":   "80026801",
+		"invalid sample": "80025d580100000061580100000062865d86612e",
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := hex.DecodeString(payload)
+			require.NoError(t, err)
+
+			_, err = graphite.DecodePickle(data)
+			assert.ErrorIs(t, err, graphite.ErrInvalidPickle)
+		})
+	}
+}
+
+func TestReadPickle(t *testing.T) {
+	data, err := hex.DecodeString(pickles["protocol 2"])
+	require.NoError(t, err)
+
+	var stream bytes.Buffer
+	for range 2 {
+		require.NoError(t, binary.Write(&stream, binary.BigEndian, uint32(len(data))))
+		stream.Write(data)
+	}
+
+	for range 2 {
+		samples, err := graphite.ReadPickle(&stream)
+		require.NoError(t, err)
+		assert.Len(t, samples, 4)
+	}
+
+	_, err = graphite.ReadPickle(&stream)
+	assert.True(t, errors.Is(err, io.EOF))
+
+	_, err = graphite.ReadPickle(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.ErrorIs(t, err, graphite.ErrInvalidPickle)
+
+	_, err = graphite.ReadPickle(bytes.NewReader([]byte{0, 0, 0, 10, 0x80}))
+	assert.ErrorIs(t, err, graphite.ErrInvalidPickle)
+}
@@ -0,0 +1,537 @@
+package graphite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxPickleSize is the largest accepted pickle payload.
+const MaxPickleSize = 1 << 20
+
+var ErrInvalidPickle = errors.New("invalid graphite pickle")
+
+// ReadPickle reads a length-prefixed pickle payload from r and decodes its
+// samples. It returns io.EOF if r ends before the length prefix.
+func ReadPickle(r io.Reader) ([]Sample, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: truncated length", ErrInvalidPickle)
+		}
+		return nil, err
+	}
+
+	if size > MaxPickleSize {
+		return nil, fmt.Errorf("%w: payload of %d bytes is too large", ErrInvalidPickle, size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: truncated payload: %w", ErrInvalidPickle, err)
+	}
+
+	return DecodePickle(payload)
+}
+
+// DecodePickle decodes the samples of a pickle payload, a list of
+// (path, (timestamp, value)) tuples.
+//
+// Only the opcodes of lists, tuples, strings and numbers of the pickle
+// protocols 0-5 are supported, so a payload can't construct objects.
+func DecodePickle(payload []byte) ([]Sample, error) {
+	value, err := unpickle(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPickle, err)
+	}
+
+	items, ok := value.(*pickleList)
+	if !ok {
+		return nil, fmt.Errorf("%w: payload is %T, not a list", ErrInvalidPickle, value)
+	}
+
+	samples := make([]Sample, 0, len(items.items))
+	for i, item := range items.items {
+		sample, err := pickleSample(item)
+		if err != nil {
+			return nil, fmt.Errorf("%w: item %d: %w", ErrInvalidPickle, i, err)
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, nil
+}
+
+func pickleSample(item any) (Sample, error) {
+	pair, ok := sequence(item)
+	if !ok || len(pair) != 2 {
+		return Sample{}, errors.New("not a (path, (timestamp, value)) pair")
+	}
+
+	path, ok := pair[0].(string)
+	if !ok || path == "" {
+		return Sample{}, errors.New("invalid path")
+	}
+
+	point, ok := sequence(pair[1])
+	if !ok || len(point) != 2 {
+		return Sample{}, errors.New("not a (timestamp, value) pair")
+	}
+
+	ts, err := number(point[0])
+	if err != nil {
+		return Sample{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	value, err := number(point[1])
+	if err != nil {
+		return Sample{}, fmt.Errorf("invalid value: %w", err)
+	}
+
+	return Sample{Path: path, Value: value, Time: unixTime(ts)}, nil
+}
+
+func sequence(v any) ([]any, bool) {
+	switch s := v.(type) {
+	case pickleTuple:
+		return s, true
+	case *pickleList:
+		return s.items, true
+	default:
+		return nil, false
+	}
+}
+
+// number converts a pickled number, or a string holding one, like carbon does.
+func number(v any) (float64, error) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), nil
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return 0, fmt.Errorf("invalid number %v", n)
+		}
+		return n, nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, nil
+	case string:
+		return parseFloat(strings.TrimSpace(n))
+	default:
+		return 0, fmt.Errorf("%T is not a number", v)
+	}
+}
+
+// pickleList is a list; it is a pointer, so appends to a memoized list
+// are seen by its other references.
+type pickleList struct {
+	items []any
+}
+
+type pickleTuple []any
+
+type pickleMark struct{}
+
+type unpickler struct {
+	r     *bufio.Reader
+	stack []any
+	memo  map[int]any
+}
+
+func unpickle(payload []byte) (any, error) {
+	u := &unpickler{
+		r:    bufio.NewReader(bytes.NewReader(payload)),
+		memo: make(map[int]any),
+	}
+
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, errors.New("missing STOP opcode")
+		}
+
+		if op == '.' {
+			if len(u.stack) != 1 {
+				return nil, fmt.Errorf("%d values on the stack at STOP", len(u.stack))
+			}
+			return u.stack[0], nil
+		}
+
+		if err := u.exec(op); err != nil {
+			return nil, fmt.Errorf("opcode %q: %w", op, err)
+		}
+	}
+}
+
+func (u *unpickler) exec(op byte) error {
+	switch op {
+	case 0x80: // PROTO
+		_, err := u.read(1)
+		return err
+	case 0x95: // FRAME
+		_, err := u.read(8)
+		return err
+	case '(': // MARK
+		u.push(pickleMark{})
+	case '0': // POP
+		_, err := u.pop()
+		return err
+	case '1': // POP_MARK
+		_, err := u.popMark()
+		return err
+	case '2': // DUP
+		v, err := u.top()
+		if err != nil {
+			return err
+		}
+		u.push(v)
+	case 'N': // NONE
+		u.push(nil)
+	case 0x88: // NEWTRUE
+		u.push(int64(1))
+	case 0x89: // NEWFALSE
+		u.push(int64(0))
+	case 'I': // INT
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return err
+		}
+		u.push(n)
+	case 'L': // LONG
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		n, ok := new(big.Int).SetString(strings.TrimSuffix(line, "L"), 10)
+		if !ok {
+			return fmt.Errorf("invalid long %q", line)
+		}
+		u.pushInt(n)
+	case 'J': // BININT
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case 'K': // BININT1
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		u.push(int64(b[0]))
+	case 'M': // BININT2
+		b, err := u.read(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case 0x8a, 0x8b: // LONG1, LONG4
+		size, err := u.size(op == 0x8a)
+		if err != nil {
+			return err
+		}
+		b, err := u.read(size)
+		if err != nil {
+			return err
+		}
+		u.pushInt(littleEndianInt(b))
+	case 'F': // FLOAT
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return err
+		}
+		u.push(f)
+	case 'G': // BINFLOAT
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case 'S': // STRING
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		s, err := unquote(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case 'V': // UNICODE
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		u.push(line)
+	case 'T', 'X', 'B': // BINSTRING, BINUNICODE, BINBYTES
+		return u.pushString(4)
+	case 'U', 0x8c, 'C': // SHORT_BINSTRING, SHORT_BINUNICODE, SHORT_BINBYTES
+		return u.pushString(1)
+	case 0x8d, 0x8e: // BINUNICODE8, BINBYTES8
+		return u.pushString(8)
+	case ']': // EMPTY_LIST
+		u.push(&pickleList{})
+	case 'l': // LIST
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&pickleList{items: items})
+	case 'a': // APPEND
+		v, err := u.pop()
+		if err != nil {
+			return err
+		}
+		list, err := u.topList()
+		if err != nil {
+			return err
+		}
+		list.items = append(list.items, v)
+	case 'e': // APPENDS
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		list, err := u.topList()
+		if err != nil {
+			return err
+		}
+		list.items = append(list.items, items...)
+	case ')': // EMPTY_TUPLE
+		u.push(pickleTuple{})
+	case 't': // TUPLE
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(pickleTuple(items))
+	case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+		n := int(op-0x85) + 1
+		if len(u.stack) < n {
+			return errors.New("stack underflow")
+		}
+		items := append(pickleTuple(nil), u.stack[len(u.stack)-n:]...)
+		u.stack = u.stack[:len(u.stack)-n]
+		u.push(items)
+	case 'p', 'g': // PUT, GET
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		return u.memoOp(op == 'p', idx)
+	case 'q', 'h': // BINPUT, BINGET
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		return u.memoOp(op == 'q', int(b[0]))
+	case 'r', 'j': // LONG_BINPUT, LONG_BINGET
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.memoOp(op == 'r', int(binary.LittleEndian.Uint32(b)))
+	case 0x94: // MEMOIZE
+		return u.memoOp(true, len(u.memo))
+	default:
+		return errors.New("unsupported opcode")
+	}
+
+	return nil
+}
+
+func (u *unpickler) push(v any) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pushInt(n *big.Int) {
+	if n.IsInt64() {
+		u.push(n.Int64())
+		return
+	}
+	u.push(n)
+}
+
+func (u *unpickler) pushString(sizeBytes int) error {
+	var size int
+	switch sizeBytes {
+	case 1:
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		size = int(b[0])
+	case 4:
+		n, err := u.size(false)
+		if err != nil {
+			return err
+		}
+		size = n
+	case 8:
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		n := binary.LittleEndian.Uint64(b)
+		if n > MaxPickleSize {
+			return fmt.Errorf("string of %d bytes is too large", n)
+		}
+		size = int(n)
+	}
+
+	b, err := u.read(size)
+	if err != nil {
+		return err
+	}
+	u.push(string(b))
+	return nil
+}
+
+func (u *unpickler) pop() (any, error) {
+	v, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+func (u *unpickler) top() (any, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	v := u.stack[len(u.stack)-1]
+	if _, ok := v.(pickleMark); ok {
+		return nil, errors.New("unexpected mark")
+	}
+	return v, nil
+}
+
+func (u *unpickler) topList() (*pickleList, error) {
+	v, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.(*pickleList)
+	if !ok {
+		return nil, fmt.Errorf("append to %T", v)
+	}
+	return list, nil
+}
+
+// popMark pops the values up to the topmost mark.
+func (u *unpickler) popMark() ([]any, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(pickleMark); ok {
+			items := append([]any(nil), u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, errors.New("missing mark")
+}
+
+func (u *unpickler) memoOp(put bool, idx int) error {
+	if put {
+		v, err := u.top()
+		if err != nil {
+			return err
+		}
+		u.memo[idx] = v
+		return nil
+	}
+
+	v, ok := u.memo[idx]
+	if !ok {
+		return fmt.Errorf("missing memo %d", idx)
+	}
+	u.push(v)
+	return nil
+}
+
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n > MaxPickleSize {
+		return nil, fmt.Errorf("%d bytes are too many", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		return nil, errors.New("unexpected end of payload")
+	}
+	return b, nil
+}
+
+// size reads a 1-byte or a 4-byte little-endian size.
+func (u *unpickler) size(short bool) (int, error) {
+	if short {
+		b, err := u.read(1)
+		if err != nil {
+			return 0, err
+		}
+		return int(b[0]), nil
+	}
+
+	b, err := u.read(4)
+	if err != nil {
+		return 0, err
+	}
+	n := binary.LittleEndian.Uint32(b)
+	if n > MaxPickleSize {
+		return 0, fmt.Errorf("size %d is too large", n)
+	}
+	return int(n), nil
+}
+
+func (u *unpickler) line() (string, error) {
+	line, err := u.r.ReadString('\n')
+	if err != nil {
+		return "", errors.New("unexpected end of payload")
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// littleEndianInt decodes a little-endian two's complement integer.
+func littleEndianInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+
+	n := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+// unquote unquotes the Python repr of a protocol 0 string.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("invalid string %q", s)
+	}
+
+	inner := strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`)
+	if s[0] == '\'' {
+		inner = strings.ReplaceAll(inner, `"`, `\"`)
+	}
+
+	return strconv.Unquote(`"` + inner + `"`)
+}
@@ -218,6 +218,104 @@ func (x *GetMetricResponse) GetMetric() *Metric {
 	return nil
 }
 
+type GetMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*GetMetricRequest    `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricsRequest) Reset() {
+	*x = GetMetricsRequest{}
+	mi := &file_api_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricsRequest) ProtoMessage() {}
+
+func (x *GetMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricsRequest.ProtoReflect.Descriptor instead.
+func (*GetMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetMetricsRequest) GetMetrics() []*GetMetricRequest {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// GetMetricsResponse holds the found metrics, in the order of the request,
+// and the requested metrics that were not found.
+type GetMetricsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	Missing       []*GetMetricRequest    `protobuf:"bytes,2,rep,name=missing,proto3" json:"missing,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricsResponse) Reset() {
+	*x = GetMetricsResponse{}
+	mi := &file_api_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricsResponse) ProtoMessage() {}
+
+func (x *GetMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricsResponse.ProtoReflect.Descriptor instead.
+func (*GetMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetMetricsResponse) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *GetMetricsResponse) GetMissing() []*GetMetricRequest {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
 type GetAllMetricsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
@@ -227,7 +325,7 @@ type GetAllMetricsResponse struct {
 
 func (x *GetAllMetricsResponse) Reset() {
 	*x = GetAllMetricsResponse{}
-	mi := &file_api_proto_msgTypes[3]
+	mi := &file_api_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -239,7 +337,7 @@ func (x *GetAllMetricsResponse) String() string {
 func (*GetAllMetricsResponse) ProtoMessage() {}
 
 func (x *GetAllMetricsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_msgTypes[3]
+	mi := &file_api_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -252,7 +350,7 @@ func (x *GetAllMetricsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetAllMetricsResponse.ProtoReflect.Descriptor instead.
 func (*GetAllMetricsResponse) Descriptor() ([]byte, []int) {
-	return file_api_proto_rawDescGZIP(), []int{3}
+	return file_api_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *GetAllMetricsResponse) GetMetrics() []*Metric {
@@ -271,7 +369,7 @@ type UpdateMetricRequest struct {
 
 func (x *UpdateMetricRequest) Reset() {
 	*x = UpdateMetricRequest{}
-	mi := &file_api_proto_msgTypes[4]
+	mi := &file_api_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -283,7 +381,7 @@ func (x *UpdateMetricRequest) String() string {
 func (*UpdateMetricRequest) ProtoMessage() {}
 
 func (x *UpdateMetricRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_msgTypes[4]
+	mi := &file_api_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -296,7 +394,7 @@ func (x *UpdateMetricRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdateMetricRequest.ProtoReflect.Descriptor instead.
 func (*UpdateMetricRequest) Descriptor() ([]byte, []int) {
-	return file_api_proto_rawDescGZIP(), []int{4}
+	return file_api_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *UpdateMetricRequest) GetMetric() *Metric {
@@ -315,7 +413,7 @@ type UpdateMetricsRequest struct {
 
 func (x *UpdateMetricsRequest) Reset() {
 	*x = UpdateMetricsRequest{}
-	mi := &file_api_proto_msgTypes[5]
+	mi := &file_api_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -327,7 +425,7 @@ func (x *UpdateMetricsRequest) String() string {
 func (*UpdateMetricsRequest) ProtoMessage() {}
 
 func (x *UpdateMetricsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_proto_msgTypes[5]
+	mi := &file_api_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -340,7 +438,7 @@ func (x *UpdateMetricsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdateMetricsRequest.ProtoReflect.Descriptor instead.
 func (*UpdateMetricsRequest) Descriptor() ([]byte, []int) {
-	return file_api_proto_rawDescGZIP(), []int{5}
+	return file_api_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *UpdateMetricsRequest) GetMetrics() []*Metric {
@@ -350,6 +448,175 @@ func (x *UpdateMetricsRequest) GetMetrics() []*Metric {
 	return nil
 }
 
+type QueryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_api_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *QueryRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+// QuerySample is a sample of a vector result. id is the metric name with
+// the labels, name and labels are its parts; both are empty for samples
+// computed from several metrics.
+type QuerySample struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Value         float64                `protobuf:"fixed64,4,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QuerySample) Reset() {
+	*x = QuerySample{}
+	mi := &file_api_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuerySample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuerySample) ProtoMessage() {}
+
+func (x *QuerySample) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuerySample.ProtoReflect.Descriptor instead.
+func (*QuerySample) Descriptor() ([]byte, []int) {
+	return file_api_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *QuerySample) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *QuerySample) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *QuerySample) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *QuerySample) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+// QueryResponse is the result of a query: "vector", or "scalar" with
+// a single sample without a name.
+type QueryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Samples       []*QuerySample         `protobuf:"bytes,2,rep,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	mi := &file_api_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *QueryResponse) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *QueryResponse) GetSamples() []*QuerySample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
 var File_api_proto protoreflect.FileDescriptor
 
 const file_api_proto_rawDesc = "" +
@@ -365,19 +632,40 @@ const file_api_proto_rawDesc = "" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04type\x18\x02 \x01(\tR\x04type\"B\n" +
 	"\x11GetMetricResponse\x12-\n" +
-	"\x06metric\x18\x01 \x01(\v2\x15.MetricsServer.MetricR\x06metric\"H\n" +
+	"\x06metric\x18\x01 \x01(\v2\x15.MetricsServer.MetricR\x06metric\"N\n" +
+	"\x11GetMetricsRequest\x129\n" +
+	"\ametrics\x18\x01 \x03(\v2\x1f.MetricsServer.GetMetricRequestR\ametrics\"\x80\x01\n" +
+	"\x12GetMetricsResponse\x12/\n" +
+	"\ametrics\x18\x01 \x03(\v2\x15.MetricsServer.MetricR\ametrics\x129\n" +
+	"\amissing\x18\x02 \x03(\v2\x1f.MetricsServer.GetMetricRequestR\amissing\"H\n" +
 	"\x15GetAllMetricsResponse\x12/\n" +
 	"\ametrics\x18\x01 \x03(\v2\x15.MetricsServer.MetricR\ametrics\"D\n" +
 	"\x13UpdateMetricRequest\x12-\n" +
 	"\x06metric\x18\x01 \x01(\v2\x15.MetricsServer.MetricR\x06metric\"G\n" +
 	"\x14UpdateMetricsRequest\x12/\n" +
-	"\ametrics\x18\x01 \x03(\v2\x15.MetricsServer.MetricR\ametrics2\xb0\x04\n" +
+	"\ametrics\x18\x01 \x03(\v2\x15.MetricsServer.MetricR\ametrics\"$\n" +
+	"\fQueryRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"\xc2\x01\n" +
+	"\vQuerySample\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12>\n" +
+	"\x06labels\x18\x03 \x03(\v2&.MetricsServer.QuerySample.LabelsEntryR\x06labels\x12\x14\n" +
+	"\x05value\x18\x04 \x01(\x01R\x05value\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"Y\n" +
+	"\rQueryResponse\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x124\n" +
+	"\asamples\x18\x02 \x03(\v2\x1a.MetricsServer.QuerySampleR\asamples2\xfa\x05\n" +
 	"\x0eMetricsService\x12q\n" +
-	"\tGetMetric\x12\x1f.MetricsServer.GetMetricRequest\x1a .MetricsServer.GetMetricResponse\"!\x82\xd3\xe4\x93\x02\x1b\x12\x19/api/v1/value/{type}/{id}\x12_\n" +
+	"\tGetMetric\x12\x1f.MetricsServer.GetMetricRequest\x1a .MetricsServer.GetMetricResponse\"!\x82\xd3\xe4\x93\x02\x1b\x12\x19/api/v1/value/{type}/{id}\x12m\n" +
+	"\n" +
+	"GetMetrics\x12 .MetricsServer.GetMetricsRequest\x1a!.MetricsServer.GetMetricsResponse\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/api/v1/values/\x12_\n" +
 	"\rGetAllMetrics\x12\x16.google.protobuf.Empty\x1a$.MetricsServer.GetAllMetricsResponse\"\x10\x82\xd3\xe4\x93\x02\n" +
 	"\x12\b/api/v1/\x12\x90\x01\n" +
 	"\fUpdateMetric\x12\".MetricsServer.UpdateMetricRequest\x1a\x16.google.protobuf.Empty\"D\x82\xd3\xe4\x93\x02>:\x01*\"9/api/v1/update/{metric.m_type}/{metric.id}/{metric_value}\x12i\n" +
-	"\rUpdateMetrics\x12#.MetricsServer.UpdateMetricsRequest\x1a\x16.google.protobuf.Empty\"\x1b\x82\xd3\xe4\x93\x02\x15:\x01*\"\x10/api/v1/updates/\x12L\n" +
+	"\rUpdateMetrics\x12#.MetricsServer.UpdateMetricsRequest\x1a\x16.google.protobuf.Empty\"\x1b\x82\xd3\xe4\x93\x02\x15:\x01*\"\x10/api/v1/updates/\x12Y\n" +
+	"\x05Query\x12\x1b.MetricsServer.QueryRequest\x1a\x1c.MetricsServer.QueryResponse\"\x15\x82\xd3\xe4\x93\x02\x0f\x12\r/api/v1/query\x12L\n" +
 	"\x04Ping\x12\x16.google.protobuf.Empty\x1a\x16.google.protobuf.Empty\"\x14\x82\xd3\xe4\x93\x02\x0e\x12\f/api/v1/pingB\x12Z\x10pkg/grpc-metricsb\x06proto3"
 
 var (
@@ -392,36 +680,51 @@ func file_api_proto_rawDescGZIP() []byte {
 	return file_api_proto_rawDescData
 }
 
-var file_api_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_api_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_api_proto_goTypes = []any{
 	(*Metric)(nil),                // 0: MetricsServer.Metric
 	(*GetMetricRequest)(nil),      // 1: MetricsServer.GetMetricRequest
 	(*GetMetricResponse)(nil),     // 2: MetricsServer.GetMetricResponse
-	(*GetAllMetricsResponse)(nil), // 3: MetricsServer.GetAllMetricsResponse
-	(*UpdateMetricRequest)(nil),   // 4: MetricsServer.UpdateMetricRequest
-	(*UpdateMetricsRequest)(nil),  // 5: MetricsServer.UpdateMetricsRequest
-	(*empty.Empty)(nil),           // 6: google.protobuf.Empty
+	(*GetMetricsRequest)(nil),     // 3: MetricsServer.GetMetricsRequest
+	(*GetMetricsResponse)(nil),    // 4: MetricsServer.GetMetricsResponse
+	(*GetAllMetricsResponse)(nil), // 5: MetricsServer.GetAllMetricsResponse
+	(*UpdateMetricRequest)(nil),   // 6: MetricsServer.UpdateMetricRequest
+	(*UpdateMetricsRequest)(nil),  // 7: MetricsServer.UpdateMetricsRequest
+	(*QueryRequest)(nil),          // 8: MetricsServer.QueryRequest
+	(*QuerySample)(nil),           // 9: MetricsServer.QuerySample
+	(*QueryResponse)(nil),         // 10: MetricsServer.QueryResponse
+	nil,                           // 11: MetricsServer.QuerySample.LabelsEntry
+	(*empty.Empty)(nil),           // 12: google.protobuf.Empty
 }
 var file_api_proto_depIdxs = []int32{
-	0, // 0: MetricsServer.GetMetricResponse.metric:type_name -> MetricsServer.Metric
-	0, // 1: MetricsServer.GetAllMetricsResponse.metrics:type_name -> MetricsServer.Metric
-	0, // 2: MetricsServer.UpdateMetricRequest.metric:type_name -> MetricsServer.Metric
-	0, // 3: MetricsServer.UpdateMetricsRequest.metrics:type_name -> MetricsServer.Metric
-	1, // 4: MetricsServer.MetricsService.GetMetric:input_type -> MetricsServer.GetMetricRequest
-	6, // 5: MetricsServer.MetricsService.GetAllMetrics:input_type -> google.protobuf.Empty
-	4, // 6: MetricsServer.MetricsService.UpdateMetric:input_type -> MetricsServer.UpdateMetricRequest
-	5, // 7: MetricsServer.MetricsService.UpdateMetrics:input_type -> MetricsServer.UpdateMetricsRequest
-	6, // 8: MetricsServer.MetricsService.Ping:input_type -> google.protobuf.Empty
-	2, // 9: MetricsServer.MetricsService.GetMetric:output_type -> MetricsServer.GetMetricResponse
-	3, // 10: MetricsServer.MetricsService.GetAllMetrics:output_type -> MetricsServer.GetAllMetricsResponse
-	6, // 11: MetricsServer.MetricsService.UpdateMetric:output_type -> google.protobuf.Empty
-	6, // 12: MetricsServer.MetricsService.UpdateMetrics:output_type -> google.protobuf.Empty
-	6, // 13: MetricsServer.MetricsService.Ping:output_type -> google.protobuf.Empty
-	9, // [9:14] is the sub-list for method output_type
-	4, // [4:9] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	0,  // 0: MetricsServer.GetMetricResponse.metric:type_name -> MetricsServer.Metric
+	1,  // 1: MetricsServer.GetMetricsRequest.metrics:type_name -> MetricsServer.GetMetricRequest
+	0,  // 2: MetricsServer.GetMetricsResponse.metrics:type_name -> MetricsServer.Metric
+	1,  // 3: MetricsServer.GetMetricsResponse.missing:type_name -> MetricsServer.GetMetricRequest
+	0,  // 4: MetricsServer.GetAllMetricsResponse.metrics:type_name -> MetricsServer.Metric
+	0,  // 5: MetricsServer.UpdateMetricRequest.metric:type_name -> MetricsServer.Metric
+	0,  // 6: MetricsServer.UpdateMetricsRequest.metrics:type_name -> MetricsServer.Metric
+	11, // 7: MetricsServer.QuerySample.labels:type_name -> MetricsServer.QuerySample.LabelsEntry
+	9,  // 8: MetricsServer.QueryResponse.samples:type_name -> MetricsServer.QuerySample
+	1,  // 9: MetricsServer.MetricsService.GetMetric:input_type -> MetricsServer.GetMetricRequest
+	3,  // 10: MetricsServer.MetricsService.GetMetrics:input_type -> MetricsServer.GetMetricsRequest
+	12, // 11: MetricsServer.MetricsService.GetAllMetrics:input_type -> google.protobuf.Empty
+	6,  // 12: MetricsServer.MetricsService.UpdateMetric:input_type -> MetricsServer.UpdateMetricRequest
+	7,  // 13: MetricsServer.MetricsService.UpdateMetrics:input_type -> MetricsServer.UpdateMetricsRequest
+	8,  // 14: MetricsServer.MetricsService.Query:input_type -> MetricsServer.QueryRequest
+	12, // 15: MetricsServer.MetricsService.Ping:input_type -> google.protobuf.Empty
+	2,  // 16: MetricsServer.MetricsService.GetMetric:output_type -> MetricsServer.GetMetricResponse
+	4,  // 17: MetricsServer.MetricsService.GetMetrics:output_type -> MetricsServer.GetMetricsResponse
+	5,  // 18: MetricsServer.MetricsService.GetAllMetrics:output_type -> MetricsServer.GetAllMetricsResponse
+	12, // 19: MetricsServer.MetricsService.UpdateMetric:output_type -> google.protobuf.Empty
+	12, // 20: MetricsServer.MetricsService.UpdateMetrics:output_type -> google.protobuf.Empty
+	10, // 21: MetricsServer.MetricsService.Query:output_type -> MetricsServer.QueryResponse
+	12, // 22: MetricsServer.MetricsService.Ping:output_type -> google.protobuf.Empty
+	16, // [16:23] is the sub-list for method output_type
+	9,  // [9:16] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
 }
 
 func init() { file_api_proto_init() }
@@ -439,7 +742,7 @@ func file_api_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_rawDesc), len(file_api_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -8,6 +8,7 @@ package grpc_metrics
 
 import (
 	context "context"
+
 	empty "github.com/golang/protobuf/ptypes/empty"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
@@ -21,9 +22,11 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	MetricsService_GetMetric_FullMethodName     = "/MetricsServer.MetricsService/GetMetric"
+	MetricsService_GetMetrics_FullMethodName    = "/MetricsServer.MetricsService/GetMetrics"
 	MetricsService_GetAllMetrics_FullMethodName = "/MetricsServer.MetricsService/GetAllMetrics"
 	MetricsService_UpdateMetric_FullMethodName  = "/MetricsServer.MetricsService/UpdateMetric"
 	MetricsService_UpdateMetrics_FullMethodName = "/MetricsServer.MetricsService/UpdateMetrics"
+	MetricsService_Query_FullMethodName         = "/MetricsServer.MetricsService/Query"
 	MetricsService_Ping_FullMethodName          = "/MetricsServer.MetricsService/Ping"
 )
 
@@ -32,9 +35,11 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricsServiceClient interface {
 	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error)
+	GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error)
 	GetAllMetrics(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetAllMetricsResponse, error)
 	UpdateMetric(ctx context.Context, in *UpdateMetricRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	UpdateMetrics(ctx context.Context, in *UpdateMetricsRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
 	Ping(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error)
 }
 
@@ -56,6 +61,16 @@ func (c *metricsServiceClient) GetMetric(ctx context.Context, in *GetMetricReque
 	return out, nil
 }
 
+func (c *metricsServiceClient) GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricsService_GetMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *metricsServiceClient) GetAllMetrics(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetAllMetricsResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GetAllMetricsResponse)
@@ -86,6 +101,16 @@ func (c *metricsServiceClient) UpdateMetrics(ctx context.Context, in *UpdateMetr
 	return out, nil
 }
 
+func (c *metricsServiceClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, MetricsService_Query_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *metricsServiceClient) Ping(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*empty.Empty, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(empty.Empty)
@@ -101,9 +126,11 @@ func (c *metricsServiceClient) Ping(ctx context.Context, in *empty.Empty, opts .
 // for forward compatibility.
 type MetricsServiceServer interface {
 	GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error)
+	GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error)
 	GetAllMetrics(context.Context, *empty.Empty) (*GetAllMetricsResponse, error)
 	UpdateMetric(context.Context, *UpdateMetricRequest) (*empty.Empty, error)
 	UpdateMetrics(context.Context, *UpdateMetricsRequest) (*empty.Empty, error)
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
 	Ping(context.Context, *empty.Empty) (*empty.Empty, error)
 	mustEmbedUnimplementedMetricsServiceServer()
 }
@@ -118,6 +145,9 @@ type UnimplementedMetricsServiceServer struct{}
 func (UnimplementedMetricsServiceServer) GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
 }
+func (UnimplementedMetricsServiceServer) GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetrics not implemented")
+}
 func (UnimplementedMetricsServiceServer) GetAllMetrics(context.Context, *empty.Empty) (*GetAllMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAllMetrics not implemented")
 }
@@ -127,6 +157,9 @@ func (UnimplementedMetricsServiceServer) UpdateMetric(context.Context, *UpdateMe
 func (UnimplementedMetricsServiceServer) UpdateMetrics(context.Context, *UpdateMetricsRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetrics not implemented")
 }
+func (UnimplementedMetricsServiceServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
 func (UnimplementedMetricsServiceServer) Ping(context.Context, *empty.Empty) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
@@ -169,6 +202,24 @@ func _MetricsService_GetMetric_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsService_GetMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServiceServer).GetMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsService_GetMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServiceServer).GetMetrics(ctx, req.(*GetMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _MetricsService_GetAllMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(empty.Empty)
 	if err := dec(in); err != nil {
@@ -223,6 +274,24 @@ func _MetricsService_UpdateMetrics_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsService_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServiceServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsService_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServiceServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _MetricsService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(empty.Empty)
 	if err := dec(in); err != nil {
@@ -252,6 +321,10 @@ var MetricsService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetMetric",
 			Handler:    _MetricsService_GetMetric_Handler,
 		},
+		{
+			MethodName: "GetMetrics",
+			Handler:    _MetricsService_GetMetrics_Handler,
+		},
 		{
 			MethodName: "GetAllMetrics",
 			Handler:    _MetricsService_GetAllMetrics_Handler,
@@ -264,6 +337,10 @@ var MetricsService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetrics",
 			Handler:    _MetricsService_UpdateMetrics_Handler,
 		},
+		{
+			MethodName: "Query",
+			Handler:    _MetricsService_Query_Handler,
+		},
 		{
 			MethodName: "Ping",
 			Handler:    _MetricsService_Ping_Handler,
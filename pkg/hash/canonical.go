@@ -0,0 +1,245 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The request headers, and the gRPC metadata keys, of a canonical signature.
+const (
+	// SignatureHeader is the hex HMAC of the canonical request, by the
+	// algorithm in AlgorithmHeader.
+	SignatureHeader = "X-Signature"
+	// TimestampHeader is the time of signing in Unix seconds.
+	TimestampHeader = "X-Signature-Timestamp"
+	// NonceHeader is a value unique to the request.
+	NonceHeader = "X-Signature-Nonce"
+)
+
+// DefaultMaxSkew is how far the timestamp of a signature may be from the
+// clock of the server.
+const DefaultMaxSkew = 5 * time.Minute
+
+// maxNonceLen bounds the nonces the verifier remembers.
+const maxNonceLen = 64
+
+var (
+	// ErrNotSigned is returned for a request without a canonical signature.
+	ErrNotSigned = errors.New("request is not signed")
+	// ErrInvalidSignature is returned for a signature of another request
+	// or key, or a malformed one.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrClockSkew is returned for a timestamp outside the skew window.
+	ErrClockSkew = errors.New("signature timestamp outside the clock skew window")
+	// ErrReplay is returned for a nonce already seen in the skew window.
+	ErrReplay = errors.New("signature nonce already used")
+)
+
+// Request is the signed part of a request. Its canonical form is
+//
+//	METHOD \n PATH \n QUERY \n hex(SHA256(BODY)) \n TIMESTAMP \n NONCE
+//
+// where QUERY is sorted by key and value. The body is hashed as it is
+// sent, compressed or not.
+//
+// A gRPC request is signed as a POST of the full method name, e.g.
+// "/MetricsServer.MetricsService/UpdateMetrics", with the marshalled request as
+// the body and no query.
+//
+// Algorithm is the HMAC of the signature, HMACSHA256 if empty; it is not
+// part of the canonical form.
+type Request struct {
+	Method    string
+	Path      string
+	Query     url.Values
+	Body      []byte
+	Timestamp time.Time
+	Nonce     string
+	Algorithm string
+}
+
+// Canonical returns the canonical form of the request.
+func (r Request) Canonical() []byte {
+	bodyHash := sha256.Sum256(r.Body)
+
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(r.Method))
+	b.WriteByte('\n')
+	b.WriteString(r.Path)
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(r.Query))
+	b.WriteByte('\n')
+	b.WriteString(hex.EncodeToString(bodyHash[:]))
+	b.WriteByte('\n')
+	b.WriteString(strconv.FormatInt(r.Timestamp.Unix(), 10))
+	b.WriteByte('\n')
+	b.WriteString(r.Nonce)
+
+	return []byte(b.String())
+}
+
+// canonicalQuery encodes query sorted by key and then by value.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		slices.Sort(values)
+		for _, value := range values {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// SignRequest returns the hex HMAC of the canonical request by r.Algorithm.
+func SignRequest(key []byte, r Request) (string, error) {
+	sum, err := Sum(r.Algorithm, key, r.Canonical())
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum), nil
+}
+
+// NewNonce returns a random nonce.
+func NewNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// ParseTimestamp parses a TimestampHeader value.
+func ParseTimestamp(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: timestamp %q", ErrInvalidSignature, value)
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+// sweepInterval is how often the expired nonces are dropped.
+const sweepInterval = time.Minute
+
+// Verifier verifies canonical signatures: the timestamp must be within
+// maxSkew of the clock, and the nonce must not have been used by another
+// request with a valid signature in the skew window.
+//
+// It also remembers the keys of the valid signatures, see Signed.
+type Verifier struct {
+	maxSkew time.Duration
+
+	mu sync.Mutex
+	// nonces are the nonces seen, until their timestamps leave the window.
+	nonces    map[string]time.Time
+	lastSweep time.Time
+	// signers are the SHA-256 of the keys of the valid signatures.
+	signers map[[sha256.Size]byte]struct{}
+}
+
+// NewVerifier returns a verifier of the skew window maxSkew, DefaultMaxSkew
+// if it is not positive.
+func NewVerifier(maxSkew time.Duration) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+
+	return &Verifier{
+		maxSkew: maxSkew,
+		nonces:  make(map[string]time.Time),
+		signers: make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+// Verify checks the hex signature of r with key, and remembers its nonce.
+func (v *Verifier) Verify(key []byte, r Request, signature string) error {
+	now := time.Now()
+	if r.Timestamp.Before(now.Add(-v.maxSkew)) || r.Timestamp.After(now.Add(v.maxSkew)) {
+		return fmt.Errorf("%w: %s", ErrClockSkew, r.Timestamp.UTC().Format(time.RFC3339))
+	}
+
+	if r.Nonce == "" || len(r.Nonce) > maxNonceLen {
+		return fmt.Errorf("%w: nonce must have 1 to %d characters", ErrInvalidSignature, maxNonceLen)
+	}
+
+	expected, err := Sum(r.Algorithm, key, r.Canonical())
+	if err != nil {
+		return err
+	}
+
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, decoded) {
+		return ErrInvalidSignature
+	}
+
+	// The nonce is remembered only for a valid signature, so that others
+	// can't use up the nonces of a client.
+	if err := v.useNonce(r.Nonce, r.Timestamp.Add(v.maxSkew), now); err != nil {
+		return err
+	}
+
+	if len(key) > 0 {
+		v.mu.Lock()
+		v.signers[sha256.Sum256(key)] = struct{}{}
+		v.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Signed reports whether a valid canonical signature with key was verified.
+// The clients of key sign their requests that way, so a request with only
+// a body hash by key may be one of them with the signature stripped.
+func (v *Verifier) Signed(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	_, ok := v.signers[sha256.Sum256(key)]
+	return ok
+}
+
+// useNonce remembers nonce until expires, or fails if it is remembered.
+func (v *Verifier) useNonce(nonce string, expires, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastSweep) >= sweepInterval {
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	if exp, ok := v.nonces[nonce]; ok && !now.After(exp) {
+		return ErrReplay
+	}
+	v.nonces[nonce] = expires
+
+	return nil
+}
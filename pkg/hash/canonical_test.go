@@ -0,0 +1,125 @@
+package hash_test
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+)
+
+func TestRequest_Canonical(t *testing.T) {
+	r := hash.Request{
+		Method:    "post",
+		Path:      "/update/gauge/Alloc/1.5",
+		Query:     url.Values{"b": {"2", "1"}, "a": {"x y"}},
+		Timestamp: time.Unix(1760860800, 0),
+		Nonce:     "abc",
+	}
+
+	assert.Equal(t, "POST\n/update/gauge/Alloc/1.5\na=x+y&b=1&b=2\n"+
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n1760860800\nabc",
+		string(r.Canonical()))
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key := []byte("secret")
+	v := hash.NewVerifier(time.Minute)
+
+	newRequest := func(t *testing.T) hash.Request {
+		nonce, err := hash.NewNonce()
+		require.NoError(t, err)
+
+		return hash.Request{
+			Method:    "POST",
+			Path:      "/updates/",
+			Body:      []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`),
+			Timestamp: time.Now(),
+			Nonce:     nonce,
+		}
+	}
+
+	sign := func(t *testing.T, r hash.Request) string {
+		signature, err := hash.SignRequest(key, r)
+		require.NoError(t, err)
+		return signature
+	}
+
+	t.Run("valid and replayed", func(t *testing.T) {
+		r := newRequest(t)
+		signature := sign(t, r)
+
+		require.NoError(t, v.Verify(key, r, signature))
+		assert.ErrorIs(t, v.Verify(key, r, signature), hash.ErrReplay)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		r := newRequest(t)
+		signature := sign(t, r)
+
+		for _, tampered := range []func(*hash.Request){
+			func(r *hash.Request) { r.Method = "DELETE" },
+			func(r *hash.Request) { r.Path = "/update/" },
+			func(r *hash.Request) { r.Query = url.Values{"mode": {"replace"}} },
+			func(r *hash.Request) { r.Body = []byte("[]") },
+			func(r *hash.Request) { r.Timestamp = r.Timestamp.Add(time.Second) },
+			func(r *hash.Request) { r.Nonce += "0" },
+		} {
+			other := r
+			tampered(&other)
+			assert.ErrorIs(t, v.Verify(key, other, signature), hash.ErrInvalidSignature)
+		}
+
+		assert.ErrorIs(t, v.Verify([]byte("other"), r, signature), hash.ErrInvalidSignature)
+		assert.ErrorIs(t, v.Verify(key, r, "not hex"), hash.ErrInvalidSignature)
+
+		// The failures don't use up the nonce.
+		assert.NoError(t, v.Verify(key, r, signature))
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		for _, skew := range []time.Duration{-2 * time.Minute, 2 * time.Minute} {
+			r := newRequest(t)
+			r.Timestamp = r.Timestamp.Add(skew)
+			assert.ErrorIs(t, v.Verify(key, r, sign(t, r)), hash.ErrClockSkew)
+		}
+	})
+
+	t.Run("nonce", func(t *testing.T) {
+		for _, nonce := range []string{"", string(make([]byte, 65))} {
+			r := newRequest(t)
+			r.Nonce = nonce
+			assert.ErrorIs(t, v.Verify(key, r, sign(t, r)), hash.ErrInvalidSignature)
+		}
+	})
+
+	t.Run("signed keys", func(t *testing.T) {
+		v := hash.NewVerifier(time.Minute)
+		assert.False(t, v.Signed(key))
+
+		// An invalid signature doesn't mark the key.
+		r := newRequest(t)
+		assert.ErrorIs(t, v.Verify(key, r, sign(t, newRequest(t))), hash.ErrInvalidSignature)
+		assert.False(t, v.Signed(key))
+
+		require.NoError(t, v.Verify(key, r, sign(t, r)))
+		assert.True(t, v.Signed(key))
+		assert.False(t, v.Signed([]byte("other")))
+		assert.False(t, v.Signed(nil))
+	})
+}
+
+func TestParseTimestamp(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	ts, err := hash.ParseTimestamp(strconv.FormatInt(now.Unix(), 10))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(ts))
+
+	_, err = hash.ParseTimestamp("yesterday")
+	assert.ErrorIs(t, err, hash.ErrInvalidSignature)
+}
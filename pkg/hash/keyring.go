@@ -0,0 +1,217 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The request headers, and the gRPC metadata keys, naming the key and the
+// algorithm of a signature.
+const (
+	// KeyIDHeader is the ID of the key of the signature, the key of
+	// the empty ID if absent.
+	KeyIDHeader = "X-Key-ID"
+	// AlgorithmHeader is the algorithm of the canonical signature,
+	// HMACSHA256 if absent. A "HashSHA256" body hash is always HMACSHA256.
+	AlgorithmHeader = "X-Signature-Algorithm"
+)
+
+// The signature algorithms.
+const (
+	HMACSHA256 = "hmac-sha256"
+	HMACSHA512 = "hmac-sha512"
+)
+
+var (
+	// ErrUnknownKey is returned for a key ID not in the keyring.
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrKeyDeprecated is returned for a key past its deprecation date.
+	ErrKeyDeprecated = errors.New("key is deprecated")
+	// ErrUnsupportedAlgorithm is returned for an unknown algorithm, or one
+	// the key is not allowed to sign with.
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+)
+
+// newHash returns the hash function of algorithm, HMACSHA256 if empty.
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "", HMACSHA256:
+		return sha256.New, nil
+	case HMACSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// ValidateAlgorithm checks that algorithm is a signature algorithm.
+func ValidateAlgorithm(algorithm string) error {
+	_, err := newHash(algorithm)
+	return err
+}
+
+// Sum returns the HMAC of data with key by algorithm, HMACSHA256 if empty.
+func Sum(algorithm string, key, data []byte) ([]byte, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(h, key)
+	mac.Write(data)
+
+	return mac.Sum(nil), nil
+}
+
+// Key is a signing key of a Keyring.
+type Key struct {
+	// ID is sent in KeyIDHeader by the clients signing with the key.
+	ID string `json:"id"`
+	// Secret is the HMAC key.
+	Secret string `json:"secret"`
+	// Algorithm is the only algorithm of the canonical signatures with the
+	// key, any if empty.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Deprecated is when the key stops being accepted, never if zero.
+	Deprecated time.Time `json:"deprecated,omitzero"`
+	// Canonical requires canonical signatures with the key: a request with
+	// only a "HashSHA256" body hash is rejected, since it could be a signed
+	// request with the signature stripped, and replayed.
+	Canonical bool `json:"canonical,omitempty"`
+}
+
+// Allows checks that canonical signatures by algorithm may be made with k.
+func (k Key) Allows(algorithm string) error {
+	if err := ValidateAlgorithm(algorithm); err != nil {
+		return err
+	}
+
+	if k.Algorithm != "" && normalizeAlgorithm(k.Algorithm) != normalizeAlgorithm(algorithm) {
+		return fmt.Errorf("%w: key %q requires %s", ErrUnsupportedAlgorithm, k.ID, k.Algorithm)
+	}
+
+	return nil
+}
+
+// normalizeAlgorithm returns the name of algorithm, HMACSHA256 if empty.
+func normalizeAlgorithm(algorithm string) string {
+	if algorithm == "" {
+		return HMACSHA256
+	}
+
+	return strings.ToLower(algorithm)
+}
+
+// Keyring is the set of keys accepted at once, so that a key can be
+// rotated without a coordinated restart of the agents: the new key is
+// added, the agents move to it, and the old key is deprecated and removed.
+//
+// The keys are read from a JSON file, an array of Key, besides the keys
+// given in code, and Reload re-reads the file. A nil *Keyring holds only
+// the empty key of the empty ID.
+type Keyring struct {
+	path  string
+	fixed []Key
+
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+// NewKeyring returns a keyring of keys.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	return LoadKeyring("", keys...)
+}
+
+// LoadKeyring returns a keyring of keys and of the keys in the JSON file
+// path, if path is not empty. The IDs must be unique.
+func LoadKeyring(path string, keys ...Key) (*Keyring, error) {
+	k := &Keyring{path: path, fixed: keys}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload re-reads the keyring file. The keyring is unchanged if the file
+// is invalid.
+func (k *Keyring) Reload() error {
+	keys := k.fixed
+	if k.path != "" {
+		data, err := os.ReadFile(k.path)
+		if err != nil {
+			return fmt.Errorf("failed to read keyring: %w", err)
+		}
+
+		var loaded []Key
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("failed to parse keyring %s: %w", k.path, err)
+		}
+		keys = append(keys[:len(keys):len(keys)], loaded...)
+	}
+
+	byID := make(map[string]Key, len(keys))
+	for _, key := range keys {
+		if key.Secret == "" {
+			return fmt.Errorf("key %q has no secret", key.ID)
+		}
+		if err := ValidateAlgorithm(key.Algorithm); err != nil {
+			return fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		if _, ok := byID[key.ID]; ok {
+			return fmt.Errorf("duplicate key %q", key.ID)
+		}
+		byID[key.ID] = key
+	}
+
+	k.mu.Lock()
+	k.keys = byID
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Len returns the number of keys.
+func (k *Keyring) Len() int {
+	if k == nil {
+		return 0
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return len(k.keys)
+}
+
+// Lookup returns the key of id, if it is not deprecated.
+func (k *Keyring) Lookup(id string) (Key, error) {
+	if k == nil {
+		if id == "" {
+			return Key{}, nil
+		}
+		return Key{}, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	if !key.Deprecated.IsZero() && !time.Now().Before(key.Deprecated) {
+		return Key{}, fmt.Errorf("%w: %q since %s", ErrKeyDeprecated, id, key.Deprecated.UTC().Format(time.RFC3339))
+	}
+
+	return key, nil
+}
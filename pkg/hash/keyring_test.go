@@ -0,0 +1,111 @@
+package hash_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+)
+
+func TestSum(t *testing.T) {
+	sha256, err := hash.Sum(hash.HMACSHA256, []byte("key"), []byte("data"))
+	require.NoError(t, err)
+	legacy, err := hash.GetHash([]byte("key"), []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, legacy, sha256)
+
+	byDefault, err := hash.Sum("", []byte("key"), []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, sha256, byDefault)
+
+	sha512, err := hash.Sum("HMAC-SHA512", []byte("key"), []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "3c5953a18f7303ec653ba170ae334fafa08e3846f2efe317b87efce82376253cb52a8c31ddcde5a3a2eee183c2b34cb91f85e64ddbc325f7692b199473579c58",
+		hex.EncodeToString(sha512))
+
+	_, err = hash.Sum("md5", []byte("key"), []byte("data"))
+	assert.ErrorIs(t, err, hash.ErrUnsupportedAlgorithm)
+}
+
+func TestKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "2025", "secret": "old", "deprecated": "2000-01-01T00:00:00Z"},
+		{"id": "2026", "secret": "new", "algorithm": "hmac-sha512"}
+	]`), 0o600))
+
+	keys, err := hash.LoadKeyring(path, hash.Key{Secret: "default"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, keys.Len())
+
+	key, err := keys.Lookup("")
+	require.NoError(t, err)
+	assert.Equal(t, "default", key.Secret)
+
+	key, err = keys.Lookup("2026")
+	require.NoError(t, err)
+	assert.Equal(t, "new", key.Secret)
+	assert.NoError(t, key.Allows(hash.HMACSHA512))
+	assert.ErrorIs(t, key.Allows(hash.HMACSHA256), hash.ErrUnsupportedAlgorithm)
+	assert.ErrorIs(t, key.Allows(""), hash.ErrUnsupportedAlgorithm)
+
+	_, err = keys.Lookup("2025")
+	assert.ErrorIs(t, err, hash.ErrKeyDeprecated)
+
+	_, err = keys.Lookup("2027")
+	assert.ErrorIs(t, err, hash.ErrUnknownKey)
+
+	t.Run("reload", func(t *testing.T) {
+		deprecated := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		require.NoError(t, os.WriteFile(path, []byte(`[
+			{"id": "2026", "secret": "new", "deprecated": "`+deprecated+`"},
+			{"id": "2027", "secret": "newer"}
+		]`), 0o600))
+		require.NoError(t, keys.Reload())
+
+		_, err := keys.Lookup("2025")
+		assert.ErrorIs(t, err, hash.ErrUnknownKey)
+
+		key, err := keys.Lookup("2026")
+		require.NoError(t, err, "deprecated in the future")
+		assert.NoError(t, key.Allows(hash.HMACSHA256))
+
+		_, err = keys.Lookup("2027")
+		assert.NoError(t, err)
+
+		// An invalid file keeps the keys.
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id": "2027", "secret": ""}]`), 0o600))
+		assert.Error(t, keys.Reload())
+		_, err = keys.Lookup("2027")
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := hash.NewKeyring(hash.Key{ID: "a", Secret: "1"}, hash.Key{ID: "a", Secret: "2"})
+		assert.Error(t, err, "duplicate")
+
+		_, err = hash.NewKeyring(hash.Key{ID: "a", Secret: "1", Algorithm: "md5"})
+		assert.ErrorIs(t, err, hash.ErrUnsupportedAlgorithm)
+
+		_, err = hash.LoadKeyring(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var keys *hash.Keyring
+		assert.Zero(t, keys.Len())
+
+		key, err := keys.Lookup("")
+		require.NoError(t, err)
+		assert.Empty(t, key.Secret)
+
+		_, err = keys.Lookup("2026")
+		assert.ErrorIs(t, err, hash.ErrUnknownKey)
+	})
+}
@@ -0,0 +1,272 @@
+// Package lineprotocol parses the InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Field values are floats (1.5), signed integers (1i), unsigned integers
+// (1u), booleans (t, false) or double-quoted strings ("text").
+package lineprotocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLine      = errors.New("invalid line")
+	ErrInvalidPrecision = errors.New("invalid precision")
+)
+
+// Precision is the unit of the line timestamps.
+type Precision time.Duration
+
+const (
+	Nanosecond  = Precision(time.Nanosecond)
+	Microsecond = Precision(time.Microsecond)
+	Millisecond = Precision(time.Millisecond)
+	Second      = Precision(time.Second)
+	Minute      = Precision(time.Minute)
+	Hour        = Precision(time.Hour)
+)
+
+// ParsePrecision parses the precision query parameter of InfluxDB 1.x and
+// 2.x; an empty string is nanoseconds.
+func ParsePrecision(s string) (Precision, error) {
+	switch s {
+	case "", "n", "ns":
+		return Nanosecond, nil
+	case "u", "us", "µ", "µs":
+		return Microsecond, nil
+	case "ms":
+		return Millisecond, nil
+	case "s":
+		return Second, nil
+	case "m":
+		return Minute, nil
+	case "h":
+		return Hour, nil
+	default:
+		return 0, fmt.Errorf("%w %q", ErrInvalidPrecision, s)
+	}
+}
+
+// Field is a field of a point. Value is a float64, int64, uint64, bool or string.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Point is a parsed line.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	// Time is zero if the line has no timestamp.
+	Time time.Time
+}
+
+// Parse parses a line with timestamps in the precision.
+// Trailing whitespace, such as the carriage return of CRLF, is ignored.
+func Parse(line string, precision Precision) (*Point, error) {
+	p := &parser{line: strings.TrimRight(line, " \t\r")}
+
+	point, err := p.point(precision)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLine, err)
+	}
+
+	return point, nil
+}
+
+type parser struct {
+	line string
+	pos  int
+}
+
+func (p *parser) point(precision Precision) (*Point, error) {
+	point := &Point{}
+
+	point.Measurement = p.token(", ", ", \\")
+	if point.Measurement == "" {
+		return nil, errors.New("missing measurement")
+	}
+
+	for p.peek() == ',' {
+		p.pos++
+
+		key := p.token(",= ", ",= \\")
+		if key == "" {
+			return nil, fmt.Errorf("missing tag key at %d", p.pos)
+		}
+		if p.peek() != '=' {
+			return nil, fmt.Errorf("missing tag value of %q", key)
+		}
+		p.pos++
+
+		value := p.token(", ", ",= \\")
+		if value == "" {
+			return nil, fmt.Errorf("missing tag value of %q", key)
+		}
+
+		if point.Tags == nil {
+			point.Tags = make(map[string]string)
+		}
+		point.Tags[key] = value
+	}
+
+	if p.peek() != ' ' {
+		return nil, errors.New("missing fields")
+	}
+	p.skipSpaces()
+
+	for {
+		key := p.token(",= ", ",= \\")
+		if key == "" {
+			return nil, fmt.Errorf("missing field key at %d", p.pos)
+		}
+		if p.peek() != '=' {
+			return nil, fmt.Errorf("missing field value of %q", key)
+		}
+		p.pos++
+
+		value, err := p.fieldValue()
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		point.Fields = append(point.Fields, Field{Key: key, Value: value})
+
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	if p.pos < len(p.line) && p.peek() != ' ' {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek(), p.pos)
+	}
+	p.skipSpaces()
+
+	if p.pos == len(p.line) {
+		return point, nil
+	}
+
+	rest := p.line[p.pos:]
+	ts, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q", rest)
+	}
+	ns := ts * int64(precision)
+	if ts != 0 && ns/ts != int64(precision) {
+		return nil, fmt.Errorf("timestamp %d out of range", ts)
+	}
+	point.Time = time.Unix(0, ns)
+
+	return point, nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.line) {
+		return 0
+	}
+
+	return p.line[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.line) && p.line[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// token reads up to the first unescaped stop byte. A backslash escapes
+// the bytes of escapable, before any other byte it is kept as is.
+func (p *parser) token(stops, escapable string) string {
+	var b strings.Builder
+
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+		if c == '\\' && p.pos+1 < len(p.line) && strings.IndexByte(escapable, p.line[p.pos+1]) >= 0 {
+			b.WriteByte(p.line[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+
+	return b.String()
+}
+
+func (p *parser) fieldValue() (any, error) {
+	if p.peek() == '"' {
+		return p.stringValue()
+	}
+
+	start := p.pos
+	for p.pos < len(p.line) && p.line[p.pos] != ',' && p.line[p.pos] != ' ' {
+		p.pos++
+	}
+
+	return parseValue(p.line[start:p.pos])
+}
+
+func (p *parser) stringValue() (string, error) {
+	var b strings.Builder
+
+	for p.pos++; p.pos < len(p.line); p.pos++ {
+		c := p.line[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.line) && (p.line[p.pos+1] == '"' || p.line[p.pos+1] == '\\'):
+			p.pos++
+			b.WriteByte(p.line[p.pos])
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", errors.New("unterminated string")
+}
+
+func parseValue(s string) (any, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return v, nil
+	case 'u':
+		v, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		return v, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,107 @@
+package lineprotocol_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/lineprotocol"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		precision lineprotocol.Precision
+		want      *lineprotocol.Point
+	}{
+		{
+			name:      "telegraf cpu",
+			line:      "cpu,cpu=cpu-total,host=web-1 usage_idle=97.5,usage_user=1.25 1760860800000000000",
+			precision: lineprotocol.Nanosecond,
+			want: &lineprotocol.Point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"cpu": "cpu-total", "host": "web-1"},
+				Fields: []lineprotocol.Field{
+					{Key: "usage_idle", Value: 97.5},
+					{Key: "usage_user", Value: 1.25},
+				},
+				Time: time.Unix(1760860800, 0),
+			},
+		},
+		{
+			name:      "value types without timestamp",
+			line:      `net bytes_recv=42i,drops=3u,up=t,iface="eth0 \"main\"",load=-1e3`,
+			precision: lineprotocol.Nanosecond,
+			want: &lineprotocol.Point{
+				Measurement: "net",
+				Fields: []lineprotocol.Field{
+					{Key: "bytes_recv", Value: int64(42)},
+					{Key: "drops", Value: uint64(3)},
+					{Key: "up", Value: true},
+					{Key: "iface", Value: `eth0 "main"`},
+					{Key: "load", Value: -1e3},
+				},
+			},
+		},
+		{
+			name:      "escapes and precision",
+			line:      `disk\ io,path=C:\\,mount\=point=/mnt\,a free\ space=1 1760860800` + "\r",
+			precision: lineprotocol.Second,
+			want: &lineprotocol.Point{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": `C:\`, "mount=point": "/mnt,a"},
+				Fields:      []lineprotocol.Field{{Key: "free space", Value: 1.0}},
+				Time:        time.Unix(1760860800, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			point, err := lineprotocol.Parse(tt.line, tt.precision)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.Measurement, point.Measurement)
+			assert.Equal(t, tt.want.Tags, point.Tags)
+			assert.Equal(t, tt.want.Fields, point.Fields)
+			assert.True(t, tt.want.Time.Equal(point.Time), "time %v, want %v", point.Time, tt.want.Time)
+		})
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"cpu",
+		"cpu,host usage=1",
+		"cpu,host= usage=1",
+		"cpu usage",
+		"cpu usage=",
+		"cpu usage=abc",
+		"cpu usage=NaN",
+		"cpu usage=1x",
+		`cpu name="unterminated`,
+		"cpu usage=1 not-a-time",
+		"cpu usage=1 9223372036854775807",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := lineprotocol.Parse(line, lineprotocol.Second)
+			assert.ErrorIs(t, err, lineprotocol.ErrInvalidLine)
+		})
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	precision, err := lineprotocol.ParsePrecision("ms")
+	require.NoError(t, err)
+	assert.Equal(t, lineprotocol.Millisecond, precision)
+
+	precision, err = lineprotocol.ParsePrecision("")
+	require.NoError(t, err)
+	assert.Equal(t, lineprotocol.Nanosecond, precision)
+
+	_, err = lineprotocol.ParsePrecision("days")
+	assert.ErrorIs(t, err, lineprotocol.ErrInvalidPrecision)
+}
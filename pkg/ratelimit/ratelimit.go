@@ -0,0 +1,292 @@
+// Package ratelimit provides per-client rate limits for the server: token
+// buckets keyed by client identity, and the quota of requests and stored
+// metrics per second that the REST middleware and the gRPC interceptor
+// enforce.
+//
+// The metrics of a request are charged after they are stored, as the
+// number of metrics in a request isn't known before its body is decoded.
+// A client that exceeds its metric rate owes the excess, and its requests
+// are rejected until the bucket refills.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// AgentHeader is the request header, and the gRPC metadata key, with
+	// the ID of the agent sending the request.
+	AgentHeader = "X-Agent-ID"
+	// RetryAfterHeader is the response header, and the gRPC trailer key,
+	// with the number of seconds to wait before retrying a rejected request.
+	RetryAfterHeader = "Retry-After"
+)
+
+// Client identities the limits are kept for.
+const (
+	// KeyIP limits every client address.
+	KeyIP = "ip"
+	// KeyAgent limits every agent: its API token, its agent ID if it is
+	// trusted, its tenant, or its address.
+	KeyAgent = "agent"
+	// KeyTenant limits every tenant, and every API token or address for
+	// the requests of the default tenant.
+	KeyTenant = "tenant"
+)
+
+var ErrInvalidKey = errors.New("invalid rate limit key")
+
+// ValidateKey checks that key is one of the client identities.
+func ValidateKey(key string) error {
+	switch key {
+	case KeyIP, KeyAgent, KeyTenant:
+		return nil
+	default:
+		return fmt.Errorf("%w %q, expected %q, %q or %q", ErrInvalidKey, key, KeyIP, KeyAgent, KeyTenant)
+	}
+}
+
+// Client identifies the client of a request by what it can't set at will:
+// the X-Real-IP and X-Agent-ID headers, which any client can send, are
+// taken only from the connections of the trusted subnet.
+type Client struct {
+	// IP is the address of the connection, or the X-Real-IP of a request
+	// from the trusted subnet.
+	IP string
+	// Agent is the X-Agent-ID of a request from the trusted subnet.
+	Agent string
+	// Token is the ID of the API token of the request.
+	Token string
+	// Tenant is the tenant of the request, empty for the default one.
+	Tenant string
+}
+
+// ClientKey returns the limiter key of a client by the identity,
+// preferring its authenticated identity to its address (see KeyIP,
+// KeyAgent and KeyTenant).
+func ClientKey(identity string, client Client) string {
+	switch {
+	case identity == KeyIP:
+		return "ip:" + client.IP
+	case identity == KeyTenant && client.Tenant != "":
+		return "tenant:" + client.Tenant
+	case client.Token != "":
+		return "token:" + client.Token
+	case identity == KeyAgent && client.Agent != "":
+		return "agent:" + client.Agent
+	case identity == KeyAgent && client.Tenant != "":
+		return "tenant:" + client.Tenant
+	default:
+		return "ip:" + client.IP
+	}
+}
+
+// sweepInterval is how often the buckets that refilled are dropped.
+const sweepInterval = time.Minute
+
+// Limiter is a set of token buckets, one per key. Every bucket holds up to
+// burst tokens and refills at rate tokens per second.
+//
+// A nil *Limiter allows everything.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	// tokens is negative if the key owes tokens charged over the limit.
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of rate tokens per second with buckets of
+// burst tokens, at least one. It returns nil if rate is not positive.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:      rate,
+		burst:     math.Max(float64(burst), 1),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token of key. If there is none, it returns false and how
+// long to wait for one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, time.Now())
+	if b.tokens < 1 {
+		return false, l.wait(1 - b.tokens)
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// Owes returns how long key has to wait to pay the tokens charged over the
+// limit, or zero if it owes nothing.
+func (l *Limiter) Owes(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, time.Now())
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return l.wait(-b.tokens)
+}
+
+// Charge takes n tokens of key, even if it doesn't have them.
+func (l *Limiter) Charge(key string, n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket(key, time.Now()).tokens -= float64(n)
+}
+
+// bucket returns the bucket of key refilled up to now.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	return b
+}
+
+// sweep drops the buckets that refilled, a new bucket is the same.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// wait returns the time to refill the tokens.
+func (l *Limiter) wait(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// Quota limits the requests and the stored metrics of every client.
+// A nil *Quota allows everything.
+type Quota struct {
+	requests *Limiter
+	metrics  *Limiter
+}
+
+// NewQuota returns the quota of requestRate requests and metricRate metrics
+// per second, each with a burst of one second of its rate. A zero rate
+// doesn't limit. It returns nil if neither is limited.
+func NewQuota(requestRate, metricRate float64) *Quota {
+	if requestRate <= 0 && metricRate <= 0 {
+		return nil
+	}
+
+	return &Quota{
+		requests: NewLimiter(requestRate, int(math.Ceil(requestRate))),
+		metrics:  NewLimiter(metricRate, int(math.Ceil(metricRate))),
+	}
+}
+
+// Admit checks a request of the client with the key. It returns how long
+// the client has to wait if the request is rejected, or the context to
+// serve the request with, which charges the stored metrics to the client.
+func (q *Quota) Admit(ctx context.Context, key string) (context.Context, time.Duration) {
+	if q == nil {
+		return ctx, 0
+	}
+
+	if wait := q.metrics.Owes(key); wait > 0 {
+		return ctx, wait
+	}
+
+	if ok, wait := q.requests.Allow(key); !ok {
+		return ctx, wait
+	}
+
+	if q.metrics == nil {
+		return ctx, 0
+	}
+
+	return context.WithValue(ctx, ctxKey{}, charge{limiter: q.metrics, key: key}), 0
+}
+
+type ctxKey struct{}
+
+type charge struct {
+	limiter *Limiter
+	key     string
+}
+
+// Charge charges n stored metrics to the client of ctx, if its request was
+// admitted by a quota limiting metrics.
+func Charge(ctx context.Context, n int) {
+	if c, ok := ctx.Value(ctxKey{}).(charge); ok {
+		c.limiter.Charge(c.key, n)
+	}
+}
+
+// RetryAfter formats the wait as the value of the Retry-After header:
+// whole seconds, at least one.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// ParseRetryAfter parses the value of the Retry-After header, a number of
+// seconds or an HTTP date.
+func ParseRetryAfter(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(0, time.Until(date)), true
+}
@@ -0,0 +1,147 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/ratelimit"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := ratelimit.NewLimiter(1, 2)
+
+	for range 2 {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, wait, float64(50*time.Millisecond))
+
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "every key has its own bucket")
+}
+
+func TestLimiter_Refill(t *testing.T) {
+	l := ratelimit.NewLimiter(100, 1)
+
+	ok, _ := l.Allow("a")
+	require.True(t, ok)
+	ok, _ = l.Allow("a")
+	require.False(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestLimiter_Charge(t *testing.T) {
+	l := ratelimit.NewLimiter(10, 10)
+
+	l.Charge("a", 5)
+	assert.Zero(t, l.Owes("a"))
+
+	l.Charge("a", 25)
+	assert.InDelta(t, 2*time.Second, l.Owes("a"), float64(50*time.Millisecond))
+	assert.Zero(t, l.Owes("b"))
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	l := ratelimit.NewLimiter(0, 10)
+	require.Nil(t, l)
+
+	l.Charge("a", 100)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	assert.Zero(t, l.Owes("a"))
+}
+
+func TestQuota_Admit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("metrics", func(t *testing.T) {
+		q := ratelimit.NewQuota(0, 4)
+
+		reqCtx, wait := q.Admit(ctx, "a")
+		require.Zero(t, wait)
+		ratelimit.Charge(reqCtx, 12)
+
+		_, wait = q.Admit(ctx, "a")
+		assert.InDelta(t, 2*time.Second, wait, float64(50*time.Millisecond))
+
+		_, wait = q.Admit(ctx, "b")
+		assert.Zero(t, wait)
+	})
+
+	t.Run("requests", func(t *testing.T) {
+		q := ratelimit.NewQuota(1, 0)
+
+		reqCtx, wait := q.Admit(ctx, "a")
+		require.Zero(t, wait)
+		ratelimit.Charge(reqCtx, 1000)
+
+		_, wait = q.Admit(ctx, "a")
+		assert.Positive(t, wait)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		q := ratelimit.NewQuota(0, 0)
+		require.Nil(t, q)
+
+		reqCtx, wait := q.Admit(ctx, "a")
+		assert.Zero(t, wait)
+		assert.Equal(t, ctx, reqCtx)
+	})
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		identity string
+		agent    string
+		token    string
+		tenantID string
+		want     string
+	}{
+		{identity: ratelimit.KeyIP, agent: "host-1", token: "t1", tenantID: "team-a", want: "ip:10.0.0.1"},
+		{identity: ratelimit.KeyAgent, agent: "host-1", want: "agent:host-1"},
+		{identity: ratelimit.KeyAgent, agent: "host-1", token: "t1", want: "token:t1"},
+		{identity: ratelimit.KeyAgent, tenantID: "team-a", want: "tenant:team-a"},
+		{identity: ratelimit.KeyAgent, want: "ip:10.0.0.1"},
+		{identity: ratelimit.KeyTenant, tenantID: "team-a", token: "t1", want: "tenant:team-a"},
+		{identity: ratelimit.KeyTenant, token: "t1", want: "token:t1"},
+		{identity: ratelimit.KeyTenant, agent: "host-1", want: "ip:10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		client := ratelimit.Client{IP: "10.0.0.1", Agent: tt.agent, Token: tt.token, Tenant: tt.tenantID}
+		assert.Equal(t, tt.want, ratelimit.ClientKey(tt.identity, client))
+	}
+
+	assert.NoError(t, ratelimit.ValidateKey(ratelimit.KeyTenant))
+	assert.ErrorIs(t, ratelimit.ValidateKey("user"), ratelimit.ErrInvalidKey)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", ratelimit.RetryAfter(10*time.Millisecond))
+	assert.Equal(t, "3", ratelimit.RetryAfter(2100*time.Millisecond))
+
+	wait, ok := ratelimit.ParseRetryAfter("7")
+	require.True(t, ok)
+	assert.Equal(t, 7*time.Second, wait)
+
+	wait, ok = ratelimit.ParseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.True(t, ok)
+	assert.InDelta(t, time.Hour, wait, float64(2*time.Second))
+
+	for _, value := range []string{"", "-1", "soon"} {
+		_, ok = ratelimit.ParseRetryAfter(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -16,6 +16,15 @@ type Metric struct {
 //easyjson:json
 type MetricsList []Metric
 
+// MetricsBatch is the result of a batch read: the found metrics with their
+// values and the requested metrics that were not found, without values.
+//
+//easyjson:json
+type MetricsBatch struct {
+	Metrics MetricsList `json:"metrics"`
+	Missing MetricsList `json:"missing"`
+}
+
 func (mtr *Metric) SetValue(value any) error {
 	switch mtr.MType {
 	case models.GaugeType:
@@ -83,7 +83,80 @@ func (v *MetricsList) UnmarshalJSON(data []byte) error {
 func (v *MetricsList) UnmarshalEasyJSON(l *jlexer.Lexer) {
 	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization(l, v)
 }
-func easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(in *jlexer.Lexer, out *Metric) {
+func easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(in *jlexer.Lexer, out *MetricsBatch) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "metrics":
+			(out.Metrics).UnmarshalEasyJSON(in)
+		case "missing":
+			(out.Missing).UnmarshalEasyJSON(in)
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(out *jwriter.Writer, in MetricsBatch) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"metrics\":"
+		out.RawString(prefix[1:])
+		(in.Metrics).MarshalEasyJSON(out)
+	}
+	{
+		const prefix string = ",\"missing\":"
+		out.RawString(prefix)
+		(in.Missing).MarshalEasyJSON(out)
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v MetricsBatch) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v MetricsBatch) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *MetricsBatch) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *MetricsBatch) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(l, v)
+}
+func easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(in *jlexer.Lexer, out *Metric) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -136,7 +209,7 @@ func easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgS
 		in.Consumed()
 	}
 }
-func easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(out *jwriter.Writer, in Metric) {
+func easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(out *jwriter.Writer, in Metric) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -166,23 +239,23 @@ func easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgS
 // MarshalJSON supports json.Marshaler interface
 func (v Metric) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{}
-	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(&w, v)
+	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(&w, v)
 	return w.Buffer.BuildBytes(), w.Error
 }
 
 // MarshalEasyJSON supports easyjson.Marshaler interface
 func (v Metric) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(w, v)
+	easyjson91d3f04aEncodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(w, v)
 }
 
 // UnmarshalJSON supports json.Unmarshaler interface
 func (v *Metric) UnmarshalJSON(data []byte) error {
 	r := jlexer.Lexer{Data: data}
-	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(&r, v)
+	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(&r, v)
 	return r.Error()
 }
 
 // UnmarshalEasyJSON supports easyjson.Unmarshaler interface
 func (v *Metric) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization1(l, v)
+	easyjson91d3f04aDecodeGithubComRAchKaplinMiptGolangCourseMetricsServicePkgSerialization2(l, v)
 }
@@ -0,0 +1,145 @@
+// Package snapshot encodes and decodes metric snapshots.
+//
+// A snapshot is a gzip-compressed JSON list of metrics in the same format
+// as the file storage, so an unpacked snapshot can be used as FILE_STORAGE_PATH
+// and a storage file can be restored as a snapshot.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mailru/easyjson"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/converter"
+	serialize "github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/serialization"
+)
+
+const (
+	// ContentType is the content type of a snapshot.
+	ContentType = "application/gzip"
+	// FileExt is the file extension of a snapshot.
+	FileExt = ".json.gz"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var errNotList = errors.New("not a list of metrics")
+
+// Write encodes the metrics as a snapshot into w.
+//
+// Metrics are encoded one by one, so the snapshot is streamed
+// without building the whole JSON document in memory.
+func Write(w io.Writer, metrics []models.Metric) error {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+
+	bw := bufio.NewWriter(gz)
+
+	if err := bw.WriteByte('['); err != nil {
+		return err
+	}
+
+	for i, metric := range metrics {
+		if i > 0 {
+			if err := bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+
+		jsonMetric, err := converter.ConvertToSerialization([]models.Metric{metric})
+		if err != nil {
+			return fmt.Errorf("failed to convert metric %s: %w", metric.Name(), err)
+		}
+
+		if _, err := easyjson.MarshalToWriter(&jsonMetric[0], bw); err != nil {
+			return fmt.Errorf("failed to encode metric %s: %w", metric.Name(), err)
+		}
+	}
+
+	if err := bw.WriteByte(']'); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// Read decodes a snapshot from r.
+//
+// Both compressed snapshots and plain JSON lists (e.g. a storage file) are accepted.
+func Read(r io.Reader) ([]models.Metric, error) {
+	return ReadLimit(r, 0)
+}
+
+// ReadLimit decodes a snapshot from r, like Read, failing with
+// admission.ErrTooLarge if the JSON list has more than max bytes once
+// decompressed. A non-positive max doesn't limit.
+//
+// The list is decoded metric by metric, so only the decoded metrics are
+// held in memory, not the JSON document.
+func ReadLimit(r io.Reader, max int64) ([]models.Metric, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var src io.Reader = br
+	if bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip snapshot: %w", err)
+		}
+		defer func() {
+			_ = gz.Close()
+		}()
+		src = gz
+	}
+
+	dec := json.NewDecoder(admission.LimitReader(src, max))
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if token == nil {
+		return []models.Metric{}, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("invalid snapshot: %w", errNotList)
+	}
+
+	metrics := []models.Metric{}
+	for dec.More() {
+		var jsonMetric serialize.Metric
+		if err := dec.Decode(&jsonMetric); err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+
+		metric, err := converter.ConvertMetrics(serialize.MetricsList{jsonMetric})
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot metrics: %w", err)
+		}
+
+		metrics = append(metrics, metric[0])
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,110 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/admission"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/snapshot"
+)
+
+func TestWriteRead(t *testing.T) {
+	metrics := []models.Metric{
+		models.NewGauge("Alloc", 124.2),
+		models.NewCounter("PollCount", 100),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, snapshot.Write(&buf, metrics))
+
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id":"Alloc","type":"gauge","value":124.2},{"id":"PollCount","type":"counter","delta":100}]`, string(data))
+
+	got, err := snapshot.Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, got)
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []models.Metric
+		wantErr bool
+	}{
+		{
+			name:  "plain json",
+			input: `[{"id":"PollCount","type":"counter","delta":5}]`,
+			want:  []models.Metric{models.NewCounter("PollCount", 5)},
+		},
+		{
+			name:  "empty list",
+			input: `[]`,
+			want:  []models.Metric{},
+		},
+		{
+			name:    "invalid json",
+			input:   `[{"id":`,
+			wantErr: true,
+		},
+		{
+			name:    "not a list",
+			input:   `{"id":"PollCount","type":"counter","delta":5}`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated list",
+			input:   `[{"id":"PollCount","type":"counter","delta":5}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid metric",
+			input:   `[{"id":"Alloc","type":"gauge"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "broken gzip",
+			input:   "\x1f\x8b\x00",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := snapshot.Read(strings.NewReader(tt.input))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	metrics := make([]models.Metric, 0, 100)
+	for i := range 100 {
+		metrics = append(metrics, models.NewCounter("PollCount"+strings.Repeat("x", i), int64(i)))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, snapshot.Write(&buf, metrics))
+
+	_, err := snapshot.ReadLimit(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.ErrorIs(t, err, admission.ErrTooLarge)
+
+	got, err := snapshot.ReadLimit(bytes.NewReader(buf.Bytes()), 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, got)
+}
@@ -0,0 +1,112 @@
+// Package statsd parses the StatsD protocol:
+//
+//	name:value|type[|@sample_rate][|#tag:value,...]
+//
+// A packet holds one or more newline-separated lines. The supported types
+// are counters (c), gauges (g) and timings (ms). A gauge value with a
+// leading sign, "+5" or "-5", changes the gauge by the value. Tags are the
+// DogStatsD extension.
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidLine = errors.New("invalid statsd line")
+
+// Type is the type of a sample.
+type Type string
+
+const (
+	Counter Type = "c"
+	Gauge   Type = "g"
+	Timing  Type = "ms"
+)
+
+// Sample is a parsed line.
+type Sample struct {
+	Name  string
+	Type  Type
+	Value float64
+	// Delta reports whether a gauge value is a signed change.
+	Delta bool
+	// SampleRate is the fraction of the events sent, 1 if not set.
+	SampleRate float64
+	Tags       map[string]string
+}
+
+// Parse parses a line.
+func Parse(line string) (*Sample, error) {
+	sample, err := parse(strings.TrimRight(line, "\r"))
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidLine, line, err)
+	}
+
+	return sample, nil
+}
+
+func parse(line string) (*Sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, errors.New("missing name")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return nil, errors.New("missing type")
+	}
+
+	sample := &Sample{Name: name, Type: Type(parts[1]), SampleRate: 1}
+
+	switch sample.Type {
+	case Counter, Gauge, Timing:
+	default:
+		return nil, fmt.Errorf("unsupported type %q", parts[1])
+	}
+
+	raw := parts[0]
+	if sample.Type == Gauge && (strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")) {
+		sample.Delta = true
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid value %q", raw)
+	}
+	sample.Value = value
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", part)
+			}
+			sample.SampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			sample.Tags = parseTags(part[1:])
+		default:
+			return nil, fmt.Errorf("unexpected %q", part)
+		}
+	}
+
+	return sample, nil
+}
+
+// parseTags parses "key:value,key". A tag without a value gets an empty value.
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, ":")
+		tags[key] = value
+	}
+
+	return tags
+}
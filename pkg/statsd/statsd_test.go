@@ -0,0 +1,76 @@
+package statsd_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/statsd"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want *statsd.Sample
+	}{
+		{
+			line: "page.views:1|c",
+			want: &statsd.Sample{Name: "page.views", Type: statsd.Counter, Value: 1, SampleRate: 1},
+		},
+		{
+			line: "page.views:3|c|@0.1\r",
+			want: &statsd.Sample{Name: "page.views", Type: statsd.Counter, Value: 3, SampleRate: 0.1},
+		},
+		{
+			line: "queue.size:42|g",
+			want: &statsd.Sample{Name: "queue.size", Type: statsd.Gauge, Value: 42, SampleRate: 1},
+		},
+		{
+			line: "queue.size:-5|g",
+			want: &statsd.Sample{Name: "queue.size", Type: statsd.Gauge, Value: -5, Delta: true, SampleRate: 1},
+		},
+		{
+			line: "queue.size:+2.5|g",
+			want: &statsd.Sample{Name: "queue.size", Type: statsd.Gauge, Value: 2.5, Delta: true, SampleRate: 1},
+		},
+		{
+			line: "db.query:320|ms|@0.5|#host:web-1,canary",
+			want: &statsd.Sample{
+				Name:       "db.query",
+				Type:       statsd.Timing,
+				Value:      320,
+				SampleRate: 0.5,
+				Tags:       map[string]string{"host": "web-1", "canary": ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			sample, err := statsd.Parse(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sample)
+		})
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"page.views",
+		":1|c",
+		"page.views:1",
+		"page.views:x|c",
+		"page.views:1|s",
+		"page.views:1|c|@0",
+		"page.views:1|c|@2",
+		"page.views:1|c|oops",
+		"page.views:NaN|g",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := statsd.Parse(line)
+			assert.ErrorIs(t, err, statsd.ErrInvalidLine)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Package tenant provides tenant identifiers for the multi-tenant server:
+// validation of tenant IDs, passing the tenant of a request through
+// context.Context, and the keyring that maps tenant secrets to tenants.
+//
+// A tenant secret is used both as the HMAC key of the tenant's signed requests
+// and as its bearer token.
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+)
+
+// Default is the tenant of requests that carry no tenant identity.
+// It uses the storage of a single-tenant server.
+const Default = ""
+
+// MaxIDLength is the maximum length of a tenant ID.
+const MaxIDLength = 48
+
+var (
+	ErrInvalidID    = errors.New("invalid tenant id")
+	ErrUnknown      = errors.New("unknown tenant")
+	ErrInvalidSpec  = errors.New("invalid tenants specification")
+	ErrTenantDenied = errors.New("tenant mismatch")
+	// ErrUntrusted is returned for a tenant claimed without credentials
+	// while the server has no trusted subnet the claim could come from.
+	ErrUntrusted = errors.New("tenant header requires a trusted subnet")
+	// ErrTooMany is returned when a new tenant would exceed the largest
+	// number of tenants of the server.
+	ErrTooMany = errors.New("too many tenants")
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ValidateID checks that id can be used as a tenant ID.
+//
+// Tenant IDs are part of storage names (file names, table names), so only
+// letters, digits, '_' and '-' are allowed.
+func ValidateID(id string) error {
+	if len(id) == 0 || len(id) > MaxIDLength || !idPattern.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	return nil
+}
+
+type ctxKey struct{}
+
+type identity struct {
+	id  string
+	key []byte
+	// verified is set if the canonical signature of the request was
+	// verified with key.
+	verified bool
+}
+
+// WithTenant returns a copy of ctx carrying the tenant ID.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, identity{id: id})
+}
+
+// WithTenantKey returns a copy of ctx carrying the tenant ID and the tenant
+// secret the request was authenticated with, so responses can be signed with it.
+func WithTenantKey(ctx context.Context, id string, key []byte) context.Context {
+	return context.WithValue(ctx, ctxKey{}, identity{id: id, key: key})
+}
+
+// WithVerifiedKey is WithTenantKey for a request whose canonical signature
+// (see hash.Request) was verified with the tenant secret. Its nonce is used
+// up, so the signature must not be verified again.
+func WithVerifiedKey(ctx context.Context, id string, key []byte) context.Context {
+	return context.WithValue(ctx, ctxKey{}, identity{id: id, key: key, verified: true})
+}
+
+// FromContext returns the tenant ID of ctx or Default.
+func FromContext(ctx context.Context) string {
+	ident, _ := ctx.Value(ctxKey{}).(identity)
+	return ident.id
+}
+
+// KeyFromContext returns the tenant secret of ctx, if the request
+// was authenticated with one.
+func KeyFromContext(ctx context.Context) ([]byte, bool) {
+	ident, ok := ctx.Value(ctxKey{}).(identity)
+	if !ok || ident.key == nil {
+		return nil, false
+	}
+
+	return ident.key, true
+}
+
+// SignatureVerified reports whether the canonical signature of the request
+// of ctx was verified with its tenant secret, see WithVerifiedKey.
+func SignatureVerified(ctx context.Context) bool {
+	ident, _ := ctx.Value(ctxKey{}).(identity)
+	return ident.verified
+}
+
+// Keyring maps tenant secrets to tenant IDs.
+type Keyring struct {
+	keys map[string][]byte
+}
+
+// ParseKeyring parses the tenants specification "tenant:secret,tenant:secret".
+// An empty specification gives an empty keyring.
+func ParseKeyring(spec string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string][]byte)}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || secret == "" {
+			return nil, fmt.Errorf("%w: %q must be tenant:secret", ErrInvalidSpec, pair)
+		}
+		if err := ValidateID(id); err != nil {
+			return nil, err
+		}
+		if _, dup := kr.keys[id]; dup {
+			return nil, fmt.Errorf("%w: duplicate tenant %q", ErrInvalidSpec, id)
+		}
+
+		kr.keys[id] = []byte(secret)
+	}
+
+	return kr, nil
+}
+
+// Len returns the number of tenants in the keyring.
+func (kr *Keyring) Len() int {
+	if kr == nil {
+		return 0
+	}
+
+	return len(kr.keys)
+}
+
+// IDs returns the sorted tenant IDs of the keyring.
+func (kr *Keyring) IDs() []string {
+	if kr == nil {
+		return nil
+	}
+
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Has reports whether the tenant is in the keyring.
+func (kr *Keyring) Has(id string) bool {
+	if kr == nil {
+		return false
+	}
+
+	_, ok := kr.keys[id]
+	return ok
+}
+
+// ByToken returns the tenant whose secret equals token.
+//
+// All secrets are compared in constant time.
+func (kr *Keyring) ByToken(token string) (string, []byte, bool) {
+	if kr == nil {
+		return "", nil, false
+	}
+
+	var (
+		found string
+		key   []byte
+	)
+	for id, secret := range kr.keys {
+		if subtle.ConstantTimeCompare(secret, []byte(token)) == 1 {
+			found, key = id, secret
+		}
+	}
+
+	return found, key, key != nil
+}
+
+// BySignature returns the tenant whose secret produces the HMAC signature of data.
+func (kr *Keyring) BySignature(data, signature []byte) (string, []byte, bool) {
+	if kr == nil {
+		return "", nil, false
+	}
+
+	for id, secret := range kr.keys {
+		if hash.CheckHash(secret, data, signature) {
+			return id, secret, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// ByRequest returns the tenant whose secret produces the hex canonical
+// signature of r (see hash.Request): the tenant keyID if it names one, or
+// any tenant if keyID is empty. It checks neither the timestamp nor the
+// nonce of r, hash.Verifier does.
+func (kr *Keyring) ByRequest(keyID string, r hash.Request, signature string) (string, []byte, bool) {
+	if kr == nil {
+		return "", nil, false
+	}
+
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", nil, false
+	}
+
+	for id, secret := range kr.keys {
+		if keyID != "" && id != keyID {
+			continue
+		}
+
+		expected, err := hash.Sum(r.Algorithm, secret, r.Canonical())
+		if err == nil && hmac.Equal(expected, decoded) {
+			return id, secret, true
+		}
+	}
+
+	return "", nil, false
+}
@@ -0,0 +1,146 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/hash"
+	"github.com/rAch-kaplin/mipt-golang-course/MetricsService/pkg/tenant"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{name: "letters and digits", id: "team42"},
+		{name: "dash and underscore", id: "team-a_1"},
+		{name: "empty", id: "", wantErr: true},
+		{name: "path", id: "../team", wantErr: true},
+		{name: "quote", id: `team"a`, wantErr: true},
+		{name: "too long", id: string(make([]byte, tenant.MaxIDLength+1)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tenant.ValidateID(tt.id)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, tenant.ErrInvalidID)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, tenant.Default, tenant.FromContext(ctx))
+
+	ctx = tenant.WithTenant(ctx, "team-a")
+	assert.Equal(t, "team-a", tenant.FromContext(ctx))
+	_, ok := tenant.KeyFromContext(ctx)
+	assert.False(t, ok)
+
+	ctx = tenant.WithTenantKey(ctx, "team-b", []byte("secret"))
+	assert.Equal(t, "team-b", tenant.FromContext(ctx))
+	key, ok := tenant.KeyFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("secret"), key)
+	assert.False(t, tenant.SignatureVerified(ctx))
+
+	ctx = tenant.WithVerifiedKey(ctx, "team-c", []byte("secret-c"))
+	assert.Equal(t, "team-c", tenant.FromContext(ctx))
+	key, ok = tenant.KeyFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("secret-c"), key)
+	assert.True(t, tenant.SignatureVerified(ctx))
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantIDs []string
+		wantErr error
+	}{
+		{name: "empty", spec: "", wantIDs: []string{}},
+		{name: "two tenants", spec: "team-b:s2, team-a:s1", wantIDs: []string{"team-a", "team-b"}},
+		{name: "secret with colon", spec: "team-a:s:1", wantIDs: []string{"team-a"}},
+		{name: "no secret", spec: "team-a", wantErr: tenant.ErrInvalidSpec},
+		{name: "empty secret", spec: "team-a:", wantErr: tenant.ErrInvalidSpec},
+		{name: "duplicate", spec: "team-a:s1,team-a:s2", wantErr: tenant.ErrInvalidSpec},
+		{name: "invalid id", spec: "team a:s1", wantErr: tenant.ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr, err := tenant.ParseKeyring(tt.spec)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIDs, kr.IDs())
+		})
+	}
+}
+
+func TestKeyring_Lookup(t *testing.T) {
+	kr, err := tenant.ParseKeyring("team-a:secret-a,team-b:secret-b")
+	require.NoError(t, err)
+
+	id, key, ok := kr.ByToken("secret-b")
+	assert.True(t, ok)
+	assert.Equal(t, "team-b", id)
+	assert.Equal(t, []byte("secret-b"), key)
+
+	_, _, ok = kr.ByToken("secret")
+	assert.False(t, ok)
+
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	signature, err := hash.GetHash([]byte("secret-a"), body)
+	require.NoError(t, err)
+
+	id, _, ok = kr.BySignature(body, signature)
+	assert.True(t, ok)
+	assert.Equal(t, "team-a", id)
+
+	_, _, ok = kr.BySignature([]byte("other body"), signature)
+	assert.False(t, ok)
+
+	r := hash.Request{Method: "POST", Path: "/update/gauge/Alloc/1", Timestamp: time.Now(), Nonce: "abc"}
+	canonical, err := hash.SignRequest([]byte("secret-b"), r)
+	require.NoError(t, err)
+
+	id, key, ok = kr.ByRequest("", r, canonical)
+	assert.True(t, ok)
+	assert.Equal(t, "team-b", id)
+	assert.Equal(t, []byte("secret-b"), key)
+
+	id, _, ok = kr.ByRequest("team-b", r, canonical)
+	assert.True(t, ok)
+	assert.Equal(t, "team-b", id)
+
+	_, _, ok = kr.ByRequest("team-a", r, canonical)
+	assert.False(t, ok, "signed by another tenant")
+
+	_, _, ok = kr.ByRequest("", r, "not hex")
+	assert.False(t, ok)
+
+	other := r
+	other.Path = "/update/gauge/Alloc/2"
+	_, _, ok = kr.ByRequest("", other, canonical)
+	assert.False(t, ok, "other request")
+
+	var empty *tenant.Keyring
+	_, _, ok = empty.ByRequest("", r, canonical)
+	assert.False(t, ok)
+	assert.Equal(t, 0, empty.Len())
+	assert.False(t, empty.Has("team-a"))
+}
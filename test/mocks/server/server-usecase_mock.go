@@ -14,6 +14,7 @@ import (
 	reflect "reflect"
 
 	models "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	server "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -123,6 +124,276 @@ func (mr *MockMetricUpdaterMockRecorder) UpdateMetricList(ctx, metrics any) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetricList", reflect.TypeOf((*MockMetricUpdater)(nil).UpdateMetricList), ctx, metrics)
 }
 
+// MockMetricBatchGetter is a mock of MetricBatchGetter interface.
+type MockMetricBatchGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricBatchGetterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricBatchGetterMockRecorder is the mock recorder for MockMetricBatchGetter.
+type MockMetricBatchGetterMockRecorder struct {
+	mock *MockMetricBatchGetter
+}
+
+// NewMockMetricBatchGetter creates a new mock instance.
+func NewMockMetricBatchGetter(ctrl *gomock.Controller) *MockMetricBatchGetter {
+	mock := &MockMetricBatchGetter{ctrl: ctrl}
+	mock.recorder = &MockMetricBatchGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricBatchGetter) EXPECT() *MockMetricBatchGetterMockRecorder {
+	return m.recorder
+}
+
+// GetMetrics mocks base method.
+func (m *MockMetricBatchGetter) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetrics", ctx, keys)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetrics indicates an expected call of GetMetrics.
+func (mr *MockMetricBatchGetterMockRecorder) GetMetrics(ctx, keys any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockMetricBatchGetter)(nil).GetMetrics), ctx, keys)
+}
+
+// MockMetricRanger is a mock of MetricRanger interface.
+type MockMetricRanger struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricRangerMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricRangerMockRecorder is the mock recorder for MockMetricRanger.
+type MockMetricRangerMockRecorder struct {
+	mock *MockMetricRanger
+}
+
+// NewMockMetricRanger creates a new mock instance.
+func NewMockMetricRanger(ctrl *gomock.Controller) *MockMetricRanger {
+	mock := &MockMetricRanger{ctrl: ctrl}
+	mock.recorder = &MockMetricRangerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricRanger) EXPECT() *MockMetricRangerMockRecorder {
+	return m.recorder
+}
+
+// RangeMetrics mocks base method.
+func (m *MockMetricRanger) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RangeMetrics", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RangeMetrics indicates an expected call of RangeMetrics.
+func (mr *MockMetricRangerMockRecorder) RangeMetrics(ctx, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RangeMetrics", reflect.TypeOf((*MockMetricRanger)(nil).RangeMetrics), ctx, fn)
+}
+
+// MockMetricSetter is a mock of MetricSetter interface.
+type MockMetricSetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricSetterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricSetterMockRecorder is the mock recorder for MockMetricSetter.
+type MockMetricSetterMockRecorder struct {
+	mock *MockMetricSetter
+}
+
+// NewMockMetricSetter creates a new mock instance.
+func NewMockMetricSetter(ctrl *gomock.Controller) *MockMetricSetter {
+	mock := &MockMetricSetter{ctrl: ctrl}
+	mock.recorder = &MockMetricSetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricSetter) EXPECT() *MockMetricSetterMockRecorder {
+	return m.recorder
+}
+
+// SetMetricList mocks base method.
+func (m *MockMetricSetter) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetricList", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetricList indicates an expected call of SetMetricList.
+func (mr *MockMetricSetterMockRecorder) SetMetricList(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetricList", reflect.TypeOf((*MockMetricSetter)(nil).SetMetricList), ctx, metrics)
+}
+
+// MockMetricApplier is a mock of MetricApplier interface.
+type MockMetricApplier struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricApplierMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricApplierMockRecorder is the mock recorder for MockMetricApplier.
+type MockMetricApplierMockRecorder struct {
+	mock *MockMetricApplier
+}
+
+// NewMockMetricApplier creates a new mock instance.
+func NewMockMetricApplier(ctrl *gomock.Controller) *MockMetricApplier {
+	mock := &MockMetricApplier{ctrl: ctrl}
+	mock.recorder = &MockMetricApplierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricApplier) EXPECT() *MockMetricApplierMockRecorder {
+	return m.recorder
+}
+
+// ApplyMetricList mocks base method.
+func (m *MockMetricApplier) ApplyMetricList(ctx context.Context, metrics []models.Metric) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyMetricList", ctx, metrics)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyMetricList indicates an expected call of ApplyMetricList.
+func (mr *MockMetricApplierMockRecorder) ApplyMetricList(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyMetricList", reflect.TypeOf((*MockMetricApplier)(nil).ApplyMetricList), ctx, metrics)
+}
+
+// MockMetricSnapshotter is a mock of MetricSnapshotter interface.
+type MockMetricSnapshotter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricSnapshotterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricSnapshotterMockRecorder is the mock recorder for MockMetricSnapshotter.
+type MockMetricSnapshotterMockRecorder struct {
+	mock *MockMetricSnapshotter
+}
+
+// NewMockMetricSnapshotter creates a new mock instance.
+func NewMockMetricSnapshotter(ctrl *gomock.Controller) *MockMetricSnapshotter {
+	mock := &MockMetricSnapshotter{ctrl: ctrl}
+	mock.recorder = &MockMetricSnapshotterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricSnapshotter) EXPECT() *MockMetricSnapshotterMockRecorder {
+	return m.recorder
+}
+
+// Snapshot mocks base method.
+func (m *MockMetricSnapshotter) Snapshot(ctx context.Context) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Snapshot", ctx)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Snapshot indicates an expected call of Snapshot.
+func (mr *MockMetricSnapshotterMockRecorder) Snapshot(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Snapshot", reflect.TypeOf((*MockMetricSnapshotter)(nil).Snapshot), ctx)
+}
+
+// MockMetricReplacer is a mock of MetricReplacer interface.
+type MockMetricReplacer struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricReplacerMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricReplacerMockRecorder is the mock recorder for MockMetricReplacer.
+type MockMetricReplacerMockRecorder struct {
+	mock *MockMetricReplacer
+}
+
+// NewMockMetricReplacer creates a new mock instance.
+func NewMockMetricReplacer(ctrl *gomock.Controller) *MockMetricReplacer {
+	mock := &MockMetricReplacer{ctrl: ctrl}
+	mock.recorder = &MockMetricReplacerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricReplacer) EXPECT() *MockMetricReplacerMockRecorder {
+	return m.recorder
+}
+
+// ReplaceAll mocks base method.
+func (m *MockMetricReplacer) ReplaceAll(ctx context.Context, metrics []models.Metric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceAll", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceAll indicates an expected call of ReplaceAll.
+func (mr *MockMetricReplacerMockRecorder) ReplaceAll(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceAll", reflect.TypeOf((*MockMetricReplacer)(nil).ReplaceAll), ctx, metrics)
+}
+
+// MockMetricVersioner is a mock of MetricVersioner interface.
+type MockMetricVersioner struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricVersionerMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricVersionerMockRecorder is the mock recorder for MockMetricVersioner.
+type MockMetricVersionerMockRecorder struct {
+	mock *MockMetricVersioner
+}
+
+// NewMockMetricVersioner creates a new mock instance.
+func NewMockMetricVersioner(ctrl *gomock.Controller) *MockMetricVersioner {
+	mock := &MockMetricVersioner{ctrl: ctrl}
+	mock.recorder = &MockMetricVersionerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricVersioner) EXPECT() *MockMetricVersionerMockRecorder {
+	return m.recorder
+}
+
+// Version mocks base method.
+func (m *MockMetricVersioner) Version(ctx context.Context) (server.Version, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Version", ctx)
+	ret0, _ := ret[0].(server.Version)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Version indicates an expected call of Version.
+func (mr *MockMetricVersionerMockRecorder) Version(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Version", reflect.TypeOf((*MockMetricVersioner)(nil).Version), ctx)
+}
+
 // MockCloser is a mock of Closer interface.
 type MockCloser struct {
 	ctrl     *gomock.Controller
@@ -256,3 +527,42 @@ func (mr *MockCollectorMockRecorder) UpdateMetricList(ctx, metrics any) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetricList", reflect.TypeOf((*MockCollector)(nil).UpdateMetricList), ctx, metrics)
 }
+
+// MockTenants is a mock of Tenants interface.
+type MockTenants struct {
+	ctrl     *gomock.Controller
+	recorder *MockTenantsMockRecorder
+	isgomock struct{}
+}
+
+// MockTenantsMockRecorder is the mock recorder for MockTenants.
+type MockTenantsMockRecorder struct {
+	mock *MockTenants
+}
+
+// NewMockTenants creates a new mock instance.
+func NewMockTenants(ctrl *gomock.Controller) *MockTenants {
+	mock := &MockTenants{ctrl: ctrl}
+	mock.recorder = &MockTenantsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTenants) EXPECT() *MockTenantsMockRecorder {
+	return m.recorder
+}
+
+// ForTenant mocks base method.
+func (m *MockTenants) ForTenant(tenant string) (server.Collector, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForTenant", tenant)
+	ret0, _ := ret[0].(server.Collector)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ForTenant indicates an expected call of ForTenant.
+func (mr *MockTenantsMockRecorder) ForTenant(tenant any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForTenant", reflect.TypeOf((*MockTenants)(nil).ForTenant), tenant)
+}
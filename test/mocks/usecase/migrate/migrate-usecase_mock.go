@@ -0,0 +1,201 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/usecases/migrate/deps.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/usecases/migrate/deps.go -destination=test/mocks/usecase/migrate/migrate-usecase_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	models "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	server "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/usecases/server"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMetricRanger is a mock of MetricRanger interface.
+type MockMetricRanger struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricRangerMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricRangerMockRecorder is the mock recorder for MockMetricRanger.
+type MockMetricRangerMockRecorder struct {
+	mock *MockMetricRanger
+}
+
+// NewMockMetricRanger creates a new mock instance.
+func NewMockMetricRanger(ctrl *gomock.Controller) *MockMetricRanger {
+	mock := &MockMetricRanger{ctrl: ctrl}
+	mock.recorder = &MockMetricRangerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricRanger) EXPECT() *MockMetricRangerMockRecorder {
+	return m.recorder
+}
+
+// RangeMetrics mocks base method.
+func (m *MockMetricRanger) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RangeMetrics", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RangeMetrics indicates an expected call of RangeMetrics.
+func (mr *MockMetricRangerMockRecorder) RangeMetrics(ctx, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RangeMetrics", reflect.TypeOf((*MockMetricRanger)(nil).RangeMetrics), ctx, fn)
+}
+
+// MockMetricBatchGetter is a mock of MetricBatchGetter interface.
+type MockMetricBatchGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricBatchGetterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricBatchGetterMockRecorder is the mock recorder for MockMetricBatchGetter.
+type MockMetricBatchGetterMockRecorder struct {
+	mock *MockMetricBatchGetter
+}
+
+// NewMockMetricBatchGetter creates a new mock instance.
+func NewMockMetricBatchGetter(ctrl *gomock.Controller) *MockMetricBatchGetter {
+	mock := &MockMetricBatchGetter{ctrl: ctrl}
+	mock.recorder = &MockMetricBatchGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricBatchGetter) EXPECT() *MockMetricBatchGetterMockRecorder {
+	return m.recorder
+}
+
+// GetMetrics mocks base method.
+func (m *MockMetricBatchGetter) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetrics", ctx, keys)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetrics indicates an expected call of GetMetrics.
+func (mr *MockMetricBatchGetterMockRecorder) GetMetrics(ctx, keys any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockMetricBatchGetter)(nil).GetMetrics), ctx, keys)
+}
+
+// MockMetricSetter is a mock of MetricSetter interface.
+type MockMetricSetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricSetterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricSetterMockRecorder is the mock recorder for MockMetricSetter.
+type MockMetricSetterMockRecorder struct {
+	mock *MockMetricSetter
+}
+
+// NewMockMetricSetter creates a new mock instance.
+func NewMockMetricSetter(ctrl *gomock.Controller) *MockMetricSetter {
+	mock := &MockMetricSetter{ctrl: ctrl}
+	mock.recorder = &MockMetricSetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricSetter) EXPECT() *MockMetricSetterMockRecorder {
+	return m.recorder
+}
+
+// SetMetricList mocks base method.
+func (m *MockMetricSetter) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetricList", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetricList indicates an expected call of SetMetricList.
+func (mr *MockMetricSetterMockRecorder) SetMetricList(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetricList", reflect.TypeOf((*MockMetricSetter)(nil).SetMetricList), ctx, metrics)
+}
+
+// MockDestination is a mock of Destination interface.
+type MockDestination struct {
+	ctrl     *gomock.Controller
+	recorder *MockDestinationMockRecorder
+	isgomock struct{}
+}
+
+// MockDestinationMockRecorder is the mock recorder for MockDestination.
+type MockDestinationMockRecorder struct {
+	mock *MockDestination
+}
+
+// NewMockDestination creates a new mock instance.
+func NewMockDestination(ctrl *gomock.Controller) *MockDestination {
+	mock := &MockDestination{ctrl: ctrl}
+	mock.recorder = &MockDestinationMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDestination) EXPECT() *MockDestinationMockRecorder {
+	return m.recorder
+}
+
+// GetMetrics mocks base method.
+func (m *MockDestination) GetMetrics(ctx context.Context, keys []server.MetricKey) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetrics", ctx, keys)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetrics indicates an expected call of GetMetrics.
+func (mr *MockDestinationMockRecorder) GetMetrics(ctx, keys any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockDestination)(nil).GetMetrics), ctx, keys)
+}
+
+// RangeMetrics mocks base method.
+func (m *MockDestination) RangeMetrics(ctx context.Context, fn func(models.Metric) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RangeMetrics", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RangeMetrics indicates an expected call of RangeMetrics.
+func (mr *MockDestinationMockRecorder) RangeMetrics(ctx, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RangeMetrics", reflect.TypeOf((*MockDestination)(nil).RangeMetrics), ctx, fn)
+}
+
+// SetMetricList mocks base method.
+func (m *MockDestination) SetMetricList(ctx context.Context, metrics []models.Metric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMetricList", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMetricList indicates an expected call of SetMetricList.
+func (mr *MockDestinationMockRecorder) SetMetricList(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMetricList", reflect.TypeOf((*MockDestination)(nil).SetMetricList), ctx, metrics)
+}
@@ -0,0 +1,71 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/usecases/otlp/deps.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/usecases/otlp/deps.go -destination=test/mocks/usecase/otlp/otlp-usecase_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	models "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMetricStore is a mock of MetricStore interface.
+type MockMetricStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricStoreMockRecorder is the mock recorder for MockMetricStore.
+type MockMetricStoreMockRecorder struct {
+	mock *MockMetricStore
+}
+
+// NewMockMetricStore creates a new mock instance.
+func NewMockMetricStore(ctrl *gomock.Controller) *MockMetricStore {
+	mock := &MockMetricStore{ctrl: ctrl}
+	mock.recorder = &MockMetricStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricStore) EXPECT() *MockMetricStoreMockRecorder {
+	return m.recorder
+}
+
+// GetMetric mocks base method.
+func (m *MockMetricStore) GetMetric(ctx context.Context, mType, mName string) (models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetric", ctx, mType, mName)
+	ret0, _ := ret[0].(models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetric indicates an expected call of GetMetric.
+func (mr *MockMetricStoreMockRecorder) GetMetric(ctx, mType, mName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetric", reflect.TypeOf((*MockMetricStore)(nil).GetMetric), ctx, mType, mName)
+}
+
+// UpdateMetricList mocks base method.
+func (m *MockMetricStore) UpdateMetricList(ctx context.Context, metrics []models.Metric) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMetricList", ctx, metrics)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateMetricList indicates an expected call of UpdateMetricList.
+func (mr *MockMetricStoreMockRecorder) UpdateMetricList(ctx, metrics any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetricList", reflect.TypeOf((*MockMetricStore)(nil).UpdateMetricList), ctx, metrics)
+}
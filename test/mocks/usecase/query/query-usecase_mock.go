@@ -0,0 +1,57 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/usecases/query/deps.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/usecases/query/deps.go -destination=test/mocks/usecase/query/query-usecase_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	models "github.com/rAch-kaplin/mipt-golang-course/MetricsService/internal/models"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMetricGetter is a mock of MetricGetter interface.
+type MockMetricGetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricGetterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricGetterMockRecorder is the mock recorder for MockMetricGetter.
+type MockMetricGetterMockRecorder struct {
+	mock *MockMetricGetter
+}
+
+// NewMockMetricGetter creates a new mock instance.
+func NewMockMetricGetter(ctrl *gomock.Controller) *MockMetricGetter {
+	mock := &MockMetricGetter{ctrl: ctrl}
+	mock.recorder = &MockMetricGetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricGetter) EXPECT() *MockMetricGetterMockRecorder {
+	return m.recorder
+}
+
+// GetAllMetrics mocks base method.
+func (m *MockMetricGetter) GetAllMetrics(ctx context.Context) ([]models.Metric, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllMetrics", ctx)
+	ret0, _ := ret[0].([]models.Metric)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllMetrics indicates an expected call of GetAllMetrics.
+func (mr *MockMetricGetterMockRecorder) GetAllMetrics(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllMetrics", reflect.TypeOf((*MockMetricGetter)(nil).GetAllMetrics), ctx)
+}